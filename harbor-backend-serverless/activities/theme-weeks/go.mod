@@ -5,6 +5,13 @@ go 1.15
 require (
 	github.com/aws/aws-lambda-go v1.24.0
 	github.com/go-redis/redis/v8 v8.8.3
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
+	github.com/helloharbor/harbor-backend-serverless/households/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 )
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
+	github.com/helloharbor/harbor-backend-serverless/households/lib => ../../households/lib
+)
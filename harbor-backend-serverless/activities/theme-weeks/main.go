@@ -9,6 +9,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -115,13 +116,10 @@ func parseThemes(idx int, results []*Theme, isFirstCycle bool) (*Theme, []*Theme
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
-
-	// admin can request info about a user
-	onBehalfOf, ok := req.QueryStringParameters["userID"]
-	if ok && len(onBehalfOf) != 0 && isAdmin(userID) {
-		userID = onBehalfOf
+	// admins can request info about a user
+	userID, onBehalfOf := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
+	if onBehalfOf {
 		oStr = getOwnershipsStr(userID)
 	}
 
@@ -6,7 +6,10 @@ require (
 	github.com/aws/aws-lambda-go v1.27.0
 	github.com/aws/aws-sdk-go v1.42.18
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.4
 	github.com/mmcloughlin/geohash v0.10.0
 )
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
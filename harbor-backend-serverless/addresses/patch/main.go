@@ -14,6 +14,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	lambdaSVC "github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/hashicorp/go-retryablehttp"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/mmcloughlin/geohash"
@@ -49,8 +50,8 @@ type HazardResponse struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
+	userID, _ := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
 
 	var body ReqBody
 	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
@@ -1,7 +1,8 @@
 require (
-	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-lambda-go v1.24.0
 	github.com/aws/aws-sdk-go v1.40.46
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/lib/pq v1.8.0
 	github.com/mmcloughlin/geohash v0.10.0
@@ -12,3 +13,5 @@ require (
 module addresses-update
 
 go 1.15
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	lambdaSVC "github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/hashicorp/go-retryablehttp"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -34,7 +35,7 @@ type ReqBody struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 
 	var body ReqBody
 	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
@@ -5,6 +5,9 @@ go 1.15
 require (
 	github.com/aws/aws-lambda-go v1.24.0
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 )
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../auth/lib
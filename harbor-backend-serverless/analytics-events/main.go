@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/hashicorp/go-retryablehttp"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -29,7 +30,7 @@ values ($1, $2, $3)`
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 
 	if req.Path == "/analytics-events/begin-session" {
 		sIP := req.RequestContext.Identity.SourceIP
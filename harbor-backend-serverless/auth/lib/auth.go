@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// keys of the authorizer context, api gateway passes the values on
+// to the handlers as strings
+const (
+	ContextUserID     = "userID"
+	ContextRoles      = "roles"
+	ContextOwnerships = "allUserOwnershipsJSON"
+)
+
+// the query parameter admins act on behalf of a user with
+const onBehalfOfParam = "userID"
+
+// Roles returns the roles the authorizer resolved for the user
+func Roles(req events.APIGatewayProxyRequest) []string {
+	rolesStr, _ := req.RequestContext.Authorizer[ContextRoles].(string)
+	if rolesStr == "" {
+		return nil
+	}
+	return strings.Split(rolesStr, ",")
+}
+
+// HasRole reports whether the authorizer granted the user role
+func HasRole(req events.APIGatewayProxyRequest, role string) bool {
+	for _, r := range Roles(req) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ActingUserID returns the user the request acts for, the caller
+// unless an admin reads on behalf of the user in the userID query
+// parameter. Both are logged, requests of other users for someone else
+// and writes on behalf of someone else are ignored. Every handler gets
+// its user from here, the authorizer's decisions are cached per token
+// so this is the check made on every request. Handlers acting on
+// behalf of a user look up that user's ownerships, the ones in the
+// context are the caller's.
+func ActingUserID(req events.APIGatewayProxyRequest) (string, bool) {
+	userID := fmt.Sprint(req.RequestContext.Authorizer[ContextUserID])
+
+	onBehalfOf := req.QueryStringParameters[onBehalfOfParam]
+	if onBehalfOf == "" || onBehalfOf == userID {
+		return userID, false
+	}
+
+	if req.HTTPMethod != http.MethodGet {
+		fmt.Printf("user(%s) can only read on behalf of user(%s): %s %s\n",
+			userID, onBehalfOf, req.HTTPMethod, req.Path)
+		return userID, false
+	}
+	if !HasRole(req, RoleAdmin) {
+		fmt.Printf("user(%s) is not allowed to act on behalf of user(%s): %s %s\n",
+			userID, onBehalfOf, req.HTTPMethod, req.Path)
+		return userID, false
+	}
+
+	fmt.Printf("admin(%s) acting on behalf of user(%s): %s %s\n",
+		userID, onBehalfOf, req.HTTPMethod, req.Path)
+	return onBehalfOf, true
+}
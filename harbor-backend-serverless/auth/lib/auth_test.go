@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func request(userID, roles, onBehalfOf string) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/today",
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{
+				ContextUserID: userID,
+				ContextRoles:  roles,
+			},
+		},
+	}
+	if onBehalfOf != "" {
+		req.QueryStringParameters = map[string]string{"userID": onBehalfOf}
+	}
+	return req
+}
+
+func TestActingUserID(t *testing.T) {
+	cases := []struct {
+		name       string
+		req        events.APIGatewayProxyRequest
+		userID     string
+		onBehalfOf bool
+	}{
+		{"own user", request("7", "user", ""), "7", false},
+		{"admin on behalf", request("1", "user,admin", "7"), "7", true},
+		{"admin on own behalf", request("1", "user,admin", "1"), "1", false},
+		{"user on behalf", request("7", "user", "8"), "7", false},
+		// ids are not admins, only the resolved role is
+		{"user one without role", request("1", "user", "8"), "1", false},
+		{"no roles", request("7", "", "8"), "7", false},
+		{"admin write on behalf", func() events.APIGatewayProxyRequest {
+			req := request("1", "user,admin", "7")
+			req.HTTPMethod = "PUT"
+			return req
+		}(), "1", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			userID, onBehalfOf := ActingUserID(tc.req)
+			if userID != tc.userID || onBehalfOf != tc.onBehalfOf {
+				t.Errorf("expected %s/%t, got %s/%t", tc.userID, tc.onBehalfOf, userID, onBehalfOf)
+			}
+		})
+	}
+}
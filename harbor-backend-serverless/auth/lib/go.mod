@@ -0,0 +1,5 @@
+module github.com/helloharbor/harbor-backend-serverless/auth/lib
+
+go 1.15
+
+require github.com/aws/aws-lambda-go v1.24.0
@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -19,7 +20,11 @@ func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
 	chapterID := req.PathParameters["id"]
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
+	userID, onBehalfOf := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
+	if onBehalfOf {
+		oStr = getOwnershipsStr(userID)
+	}
 
 	var result string
 	err := pgDB.Get(&result, query, oStr, chapterID, chapterID)
@@ -27,8 +32,8 @@ func handler(req events.APIGatewayProxyRequest) (
 		if err == sql.ErrNoRows {
 			return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
 		}
-		tmplt := "error getting chapter(%s) for user(%v): %s"
-		panic(fmt.Errorf(tmplt, chapterID, req.RequestContext.Authorizer["userID"], err))
+		tmplt := "error getting chapter(%s) for user(%s): %s"
+		panic(fmt.Errorf(tmplt, chapterID, userID, err))
 	}
 
 	return &events.APIGatewayProxyResponse{
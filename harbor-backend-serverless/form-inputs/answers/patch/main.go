@@ -12,6 +12,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/go-retryablehttp"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -33,7 +34,7 @@ func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
 	answerID := req.PathParameters["answerID"]
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 	hhID := hhLib.GetCurrentHouseholdID(userID, rDB, pgDB)
 
 	var reqBody ReqBody
@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/go-retryablehttp"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -48,7 +49,7 @@ func handler(req events.APIGatewayProxyRequest) (
 		}, nil
 	}
 
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 	hhID := hhLib.GetCurrentHouseholdID(userID, rDB, pgDB)
 
 	maxVersion := "1"
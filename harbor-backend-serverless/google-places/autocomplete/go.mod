@@ -7,8 +7,11 @@ require (
 	github.com/aws/aws-sdk-go v1.41.8 // indirect
 	github.com/go-redis/redis/v8 v8.11.4
 	github.com/google/uuid v1.3.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.3
 	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/helloharbor/harbor-backend-serverless/google-places/lib v0.0.0-20211203165253-29b82ae36137
 )
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
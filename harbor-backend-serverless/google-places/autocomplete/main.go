@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-redis/redis/v8"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/helloharbor/harbor-backend-serverless/google-places/lib"
 )
 
@@ -35,7 +36,7 @@ type AutoCompletionResponse struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 
 	text := req.QueryStringParameters["text"]
 	if text == "" {
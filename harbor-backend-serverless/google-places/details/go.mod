@@ -5,4 +5,7 @@ go 1.15
 require (
 	github.com/aws/aws-lambda-go v1.27.0
 	github.com/go-redis/redis/v8 v8.11.4
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 )
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
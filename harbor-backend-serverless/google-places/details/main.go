@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-redis/redis/v8"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 )
 
 const geoURL = "https://maps.googleapis.com/maps/api/place/details/json"
@@ -39,7 +40,7 @@ type PlaceDetailsResp struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 
 	id := req.PathParameters["id"]
 	if id == "" {
@@ -4,5 +4,8 @@ go 1.15
 
 require (
 	github.com/aws/aws-lambda-go v1.27.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/helloharbor/harbor-backend-serverless/google-places/lib v0.0.0-20211203165253-29b82ae36137
 )
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
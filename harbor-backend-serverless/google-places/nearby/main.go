@@ -14,6 +14,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/helloharbor/harbor-backend-serverless/google-places/lib"
 )
 
@@ -41,7 +42,7 @@ type NearbyPlaces struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, _ := authLib.ActingUserID(req)
 
 	getReq, _ := http.NewRequest("GET", geoURL, nil)
 	q := getReq.URL.Query()
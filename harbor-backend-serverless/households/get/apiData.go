@@ -14,6 +14,7 @@ func getDocsAPIData(
 	respBody *RespBody,
 	wg *sync.WaitGroup,
 	req *events.APIGatewayProxyRequest,
+	userID string,
 ) {
 	defer wg.Done()
 
@@ -22,7 +23,6 @@ func getDocsAPIData(
 
 	resp, err := retryClient.Do(docsReq)
 	if err != nil {
-		userID := req.RequestContext.Authorizer["userID"].(string)
 		panic(fmt.Sprintf("documents request for user(%s) failed: %s", userID, err))
 	}
 	defer resp.Body.Close()
@@ -31,7 +31,6 @@ func getDocsAPIData(
 		Results []map[string]interface{} `json:"results"`
 	}
 	if err = json.NewDecoder(resp.Body).Decode(&docsResp); err != nil {
-		userID := req.RequestContext.Authorizer["userID"].(string)
 		panic(fmt.Sprintf("unable to parse documents request for user(%s): %s", userID, err))
 	}
 
@@ -46,6 +45,7 @@ func getSafeLocationsAPIData(
 	respBody *RespBody,
 	wg *sync.WaitGroup,
 	req *events.APIGatewayProxyRequest,
+	userID string,
 ) {
 	defer wg.Done()
 
@@ -54,14 +54,12 @@ func getSafeLocationsAPIData(
 
 	resp, err := retryClient.Do(safeLocationsReq)
 	if err != nil {
-		userID := req.RequestContext.Authorizer["userID"].(string)
 		panic(fmt.Sprintf("documents request for user(%s) failed: %s", userID, err))
 	}
 	defer resp.Body.Close()
 
 	safeLocationsResp := []map[string]interface{}{}
 	if err = json.NewDecoder(resp.Body).Decode(&safeLocationsResp); err != nil {
-		userID := req.RequestContext.Authorizer["userID"].(string)
 		panic(fmt.Sprintf("unable to parse safe locations request for user(%s): %s", userID, err))
 	}
 
@@ -4,17 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
-
-	"github.com/aws/aws-lambda-go/events"
 )
 
 func getDBData(
 	respBody *RespBody,
 	wg *sync.WaitGroup,
-	req *events.APIGatewayProxyRequest,
+	userID string,
 ) {
 	defer wg.Done()
-	userID := req.RequestContext.Authorizer["userID"].(string)
 
 	// initialize these, the client does not expect `null`
 	respBody.Contacts = []map[string]interface{}{}
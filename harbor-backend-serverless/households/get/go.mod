@@ -1,6 +1,7 @@
 require (
-	github.com/aws/aws-lambda-go v1.22.0
+	github.com/aws/aws-lambda-go v1.24.0
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.1
 	github.com/lib/pq v1.9.0
 )
@@ -8,3 +9,5 @@ require (
 module households-get
 
 go 1.15
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
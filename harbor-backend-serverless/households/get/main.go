@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/hashicorp/go-retryablehttp"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -32,17 +33,26 @@ type RespBody struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
+	userID, onBehalfOf := authLib.ActingUserID(req)
+
 	var respBody RespBody
 	var wg sync.WaitGroup
 
-	wg.Add(1)
-	go getDocsAPIData(&respBody, &wg, &req)
+	// the documents and safe locations apis serve the owner of the
+	// token, an admin acting on behalf of a user only gets the db data
+	if onBehalfOf {
+		respBody.Docs = []map[string]interface{}{}
+		respBody.SafeLocations = []map[string]interface{}{}
+	} else {
+		wg.Add(1)
+		go getDocsAPIData(&respBody, &wg, &req, userID)
 
-	wg.Add(1)
-	go getSafeLocationsAPIData(&respBody, &wg, &req)
+		wg.Add(1)
+		go getSafeLocationsAPIData(&respBody, &wg, &req, userID)
+	}
 
 	wg.Add(1)
-	go getDBData(&respBody, &wg, &req)
+	go getDBData(&respBody, &wg, userID)
 
 	wg.Wait()
 
@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// the ownerships and current household of a user are cached under
+// their membership version. Whoever changes household memberships
+// bumps the version of every member of the household with
+// InvalidateMemberships. The stale entries are then never read again
+// and expire on their own.
+//
+//	{userID}:membershipVersion
+//	allUserOwnerships:{userID}:v{version}
+//	{userID}:currentHousehold:v{version}
+const (
+	redisTTL = time.Hour * 24 * 14
+	localTTL = time.Minute * 5
+)
+
+func membershipVersionKey(userID string) string {
+	return fmt.Sprintf("%s:membershipVersion", userID)
+}
+
+func ownershipsKey(userID string, version int64) string {
+	return fmt.Sprintf("allUserOwnerships:%s:v%d", userID, version)
+}
+
+func currentHouseholdKey(userID string, version int64) string {
+	return fmt.Sprintf("%s:currentHousehold:v%d", userID, version)
+}
+
+// InvalidateMemberships drops the cached ownerships and current
+// household of the users, to be called when they join or leave a
+// household. Ownerships span households, so every member of the
+// households involved should be invalidated.
+func InvalidateMemberships(rDB *redis.Client, userIDs ...string) error {
+	pipe := rDB.TxPipeline()
+	for _, userID := range userIDs {
+		pipe.Incr(ctx, membershipVersionKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("unable to invalidate memberships of users(%v): %s", userIDs, err)
+	}
+	return nil
+}
+
+// MembershipVersion returns the user's current membership version, 0
+// until their memberships first change
+func MembershipVersion(rDB *redis.Client, userID string) (int64, error) {
+	version, err := rDB.Get(ctx, membershipVersionKey(userID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// localCache holds values of the current lambda container. An entry
+// is only served while its membership version is the current one, the
+// version is read from redis on every lookup, and for localTTL at most.
+type localCache struct {
+	mu      sync.Mutex
+	entries map[string]localEntry
+
+	// overridden by tests
+	now func() time.Time
+}
+
+type localEntry struct {
+	value     interface{}
+	version   int64
+	expiresAt time.Time
+}
+
+func newLocalCache() *localCache {
+	return &localCache{entries: map[string]localEntry{}, now: time.Now}
+}
+
+// get returns the entry of the user cached at version, nothing when
+// the current version is unknown (-1)
+func (c *localCache) get(userID string, version int64) (interface{}, bool) {
+	if version < 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	if e.version != version || !c.now().Before(e.expiresAt) {
+		delete(c.entries, userID)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *localCache) set(userID string, version int64, value interface{}) {
+	if version < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[userID] = localEntry{
+		value:     value,
+		version:   version,
+		expiresAt: c.now().Add(localTTL),
+	}
+}
+
+// currentVersion is the user's membership version, -1 when redis can
+// not be read so nothing cached is trusted
+func currentVersion(rDB *redis.Client, userID string) int64 {
+	if rDB == nil {
+		return -1
+	}
+	version, err := MembershipVersion(rDB, userID)
+	if err != nil {
+		fmt.Printf("unable to get membership version for user(%s): %s\n", userID, err)
+		return -1
+	}
+	return version
+}
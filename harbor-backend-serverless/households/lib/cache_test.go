@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func setup(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+	ownershipsCache = newLocalCache()
+	currentHouseholdCache = newLocalCache()
+
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()}), mr
+}
+
+// the values are cached in redis only, pg is never reached
+func TestInvalidateMemberships(t *testing.T) {
+	rDB, mr := setup(t)
+	mr.Set(ownershipsKey("7", 0), "[1]")
+	mr.Set(currentHouseholdKey("7", 0), "10")
+
+	if got, err := GetOwnershipsJSON("7", rDB, nil); err != nil || got != "[1]" {
+		t.Fatalf("expected the cached ownerships, got %s: %v", got, err)
+	}
+	if got := GetCurrentHouseholdID("7", rDB, nil); got != 10 {
+		t.Fatalf("expected the cached household, got %d", got)
+	}
+
+	// the user joined household 20, whose owner has ownership 2
+	if err := InvalidateMemberships(rDB, "7", "8"); err != nil {
+		t.Fatalf("failed invalidating: %s", err)
+	}
+	mr.Set(ownershipsKey("7", 1), "[1,2]")
+	mr.Set(currentHouseholdKey("7", 1), "20")
+
+	if got, _ := GetOwnershipsJSON("7", rDB, nil); got != "[1,2]" {
+		t.Errorf("expected the local cache to honour the new version, got %s", got)
+	}
+	if got := GetCurrentHouseholdID("7", rDB, nil); got != 20 {
+		t.Errorf("expected the local cache to honour the new version, got %d", got)
+	}
+	if v, _ := MembershipVersion(rDB, "8"); v != 1 {
+		t.Errorf("expected every member to be invalidated, got version %d", v)
+	}
+
+	// without redis the version is unknown, nothing cached is served
+	mr.Close()
+	if _, ok := ownershipsCache.get("7", currentVersion(rDB, "7")); ok {
+		t.Errorf("expected no local entry without redis")
+	}
+}
+
+func TestLocalCacheVersion(t *testing.T) {
+	c := newLocalCache()
+
+	c.set("7", 3, "[1]")
+	if _, ok := c.get("7", 3); !ok {
+		t.Fatalf("expected the entry")
+	}
+	if _, ok := c.get("7", -1); ok {
+		t.Errorf("expected no entry when the version is unknown")
+	}
+	if _, ok := c.get("7", 4); ok {
+		t.Errorf("expected an outdated version to be dropped")
+	}
+	if _, ok := c.get("7", 3); ok {
+		t.Errorf("expected the outdated entry to be gone")
+	}
+
+	c.set("8", -1, "[2]")
+	if _, ok := c.entries["8"]; ok {
+		t.Errorf("expected nothing to be cached at an unknown version")
+	}
+}
+
+func TestLocalCacheExpiry(t *testing.T) {
+	now := time.Now()
+	c := newLocalCache()
+	c.now = func() time.Time { return now }
+
+	c.set("7", 3, "[1]")
+	now = now.Add(localTTL - time.Second)
+	if _, ok := c.get("7", 3); !ok {
+		t.Fatalf("expected the entry before it expires")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.get("7", 3); ok {
+		t.Errorf("expected the entry to expire after %s", localTTL)
+	}
+	if _, ok := c.entries["7"]; ok {
+		t.Errorf("expected the expired entry to be gone")
+	}
+}
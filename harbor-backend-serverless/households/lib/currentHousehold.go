@@ -3,10 +3,9 @@ package lib
 import (
 	"context"
 	"fmt"
-	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
 )
 
 const currentHouseholdQuery = `
@@ -22,7 +21,7 @@ where u.id = $1`
 
 var (
 	ctx                   = context.Background()
-	currentHouseholdCache = map[string]int64{}
+	currentHouseholdCache = newLocalCache()
 )
 
 func GetCurrentHouseholdID(
@@ -30,33 +29,36 @@ func GetCurrentHouseholdID(
 	rDB *redis.Client,
 	pgDB *sqlx.DB,
 ) int64 {
-	hhID, ok := currentHouseholdCache[userID]
-	if ok && hhID != 0 {
-		return hhID
+	version := currentVersion(rDB, userID)
+	if hhID, ok := currentHouseholdCache.get(userID, version); ok {
+		return hhID.(int64)
 	}
 
-	key := fmt.Sprintf("%s:currentHousehold", userID)
-	hhID, err := rDB.Get(ctx, key).Int64()
-	if err == nil {
-		currentHouseholdCache[userID] = hhID
-		return hhID
-	} else if err != redis.Nil {
-		fmt.Printf("error fetching %s for user(%s): %s\n", key, userID, err)
+	key := currentHouseholdKey(userID, version)
+	if version >= 0 {
+		hhID, err := rDB.Get(ctx, key).Int64()
+		if err == nil {
+			currentHouseholdCache.set(userID, version, hhID)
+			return hhID
+		} else if err != redis.Nil {
+			fmt.Printf("error fetching %s for user(%s): %s\n", key, userID, err)
+		}
 	}
 
 	var result int64
-	err = pgDB.Get(&result, currentHouseholdQuery, userID)
+	err := pgDB.Get(&result, currentHouseholdQuery, userID)
 	if err != nil {
 		panic(fmt.Errorf("unable to get household for user(%s): %s", userID, err))
 	} else if result == 0 {
 		panic(fmt.Errorf("invalid householdID for user(%s)", userID))
 	}
 
-	currentHouseholdCache[userID] = result
+	currentHouseholdCache.set(userID, version, result)
 
-	twoWeeks := time.Hour * 24 * 14
-	if err := rDB.Set(ctx, key, result, twoWeeks).Err(); err != nil {
-		fmt.Printf("unable to set household for user(%s): %s\n", userID, err)
+	if version >= 0 {
+		if err := rDB.Set(ctx, key, result, redisTTL).Err(); err != nil {
+			fmt.Printf("unable to set household for user(%s): %s\n", userID, err)
+		}
 	}
 
 	return result
@@ -1,3 +1,9 @@
 module github.com/helloharbor/harbor-backend-serverless/households/lib
 
 go 1.15
+
+require (
+	github.com/alicebob/miniredis/v2 v2.14.3
+	github.com/go-redis/redis/v8 v8.8.3
+	github.com/jmoiron/sqlx v1.3.4
+)
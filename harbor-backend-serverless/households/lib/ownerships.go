@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+)
+
+const ownershipsQuery = `
+select json_agg(distinct o.id)
+from household_users
+inner join households h on household_users.household_id = h.id
+inner join household_users ownerAndJoinedHouseholdUsers
+	on ownerAndJoinedHouseholdUsers.household_id = h.id
+inner join household_users other_hh
+	on ownerAndJoinedHouseholdUsers.user_id = other_hh.user_id
+inner join ownerships o
+	on o.household_user_id = household_users.id
+    or (other_hh.id = o.household_user_id and o.ownership_type_id = 2)
+where household_users.user_id = $1`
+
+var ownershipsCache = newLocalCache()
+
+// GetOwnershipsJSON returns the json array of the ids of the
+// ownerships the user can see, of their households and the
+// households they joined
+func GetOwnershipsJSON(
+	userID string,
+	rDB *redis.Client,
+	pgDB *sqlx.DB,
+) (string, error) {
+	version := currentVersion(rDB, userID)
+	if idsJSON, ok := ownershipsCache.get(userID, version); ok {
+		return idsJSON.(string), nil
+	}
+
+	key := ownershipsKey(userID, version)
+	if version >= 0 {
+		v, err := rDB.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			fmt.Printf("unable to get ownerships for user(%s): %s\n", userID, err)
+		} else if len(v) != 0 {
+			ownershipsCache.set(userID, version, v)
+			return v, nil
+		}
+	}
+
+	var idsJSON *string
+	if err := pgDB.Get(&idsJSON, ownershipsQuery, userID); err != nil {
+		return "", fmt.Errorf("unable to select ownerships for user(%s): %s", userID, err)
+	}
+	if idsJSON == nil {
+		return "", fmt.Errorf("no ownerships found for user(%s)", userID)
+	}
+
+	if version >= 0 {
+		if err := rDB.Set(ctx, key, *idsJSON, redisTTL).Err(); err != nil {
+			fmt.Printf("unable to set ownerships for user(%s): %s\n", userID, err)
+		}
+	}
+
+	ownershipsCache.set(userID, version, *idsJSON)
+	return *idsJSON, nil
+}
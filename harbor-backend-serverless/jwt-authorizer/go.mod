@@ -3,10 +3,18 @@ module github.com/helloharbor/harbor-backend-serverless/jwt-authorizer
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/aws/aws-lambda-go v1.24.0
 	github.com/aws/aws-sdk-go v1.38.47
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-redis/redis/v8 v8.8.3
+	github.com/golang-jwt/jwt/v4 v4.3.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
+	github.com/helloharbor/harbor-backend-serverless/households/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 )
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../auth/lib
+	github.com/helloharbor/harbor-backend-serverless/households/lib => ../households/lib
+)
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the only algorithms tokens are accepted with, hmac and none are
+// rejected before a key is looked up
+var validMethods = []string{"RS256", "ES256"}
+
+type signingKey struct {
+	Alg string
+	Key interface{}
+}
+
+// keySet caches the signing keys of the JWKS at URL by kid. The set is
+// refetched once older than TTL, or when a token is signed with an
+// unknown kid so rotated keys are picked up without a deploy. Unknown
+// kids refetch at most once per minRefetch.
+type keySet struct {
+	URL  string
+	HTTP *http.Client
+	TTL  time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]signingKey
+	fetchedAt time.Time
+
+	// overridden by tests
+	now func() time.Time
+}
+
+const (
+	defaultKeysTTL = time.Hour
+	minRefetch     = time.Minute
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// rsa
+	N string `json:"n"`
+	E string `json:"e"`
+	// ec
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// key returns the signing key of kid, a failed refetch keeps the
+// previous keys
+func (s *keySet) key(kid string) (signingKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultKeysTTL
+	}
+
+	k, ok := s.keys[kid]
+	age := now.Sub(s.fetchedAt)
+	if (ok && age < ttl) || (!ok && s.keys != nil && age < minRefetch) {
+		if !ok {
+			return signingKey{}, fmt.Errorf("unknown kid %s", kid)
+		}
+		return k, nil
+	}
+
+	s.fetchedAt = now
+	keys, err := s.fetch()
+	if err != nil {
+		if ok {
+			fmt.Printf("using previous signing keys: %s\n", err)
+			return k, nil
+		}
+		return signingKey{}, err
+	}
+	s.keys = keys
+
+	if k, ok = s.keys[kid]; !ok {
+		return signingKey{}, fmt.Errorf("unknown kid %s", kid)
+	}
+	return k, nil
+}
+
+func (s *keySet) fetch() (map[string]signingKey, error) {
+	resp, err := s.HTTP.Get(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching jwks: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed fetching jwks: status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading jwks: %s", err)
+	}
+
+	return parseJWKS(body)
+}
+
+// parseJWKS returns the RS256 and ES256 signing keys of the set by
+// kid, other keys are skipped
+func parseJWKS(body []byte) (map[string]signingKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("malformed jwks: %s", err)
+	}
+
+	keys := map[string]signingKey{}
+	for _, k := range set.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := k.signingKey()
+		if err != nil {
+			fmt.Printf("skipping key(%s): %s\n", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks has no usable signing keys")
+	}
+
+	return keys, nil
+}
+
+func (k jwk) signingKey() (signingKey, error) {
+	switch {
+	case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return signingKey{}, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return signingKey{}, err
+		}
+		if n.BitLen() < 2048 {
+			return signingKey{}, fmt.Errorf("rsa key of %d bits is too short", n.BitLen())
+		}
+		return signingKey{Alg: "RS256", Key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+
+	case k.Kty == "EC" && k.Crv == "P-256" && (k.Alg == "" || k.Alg == "ES256"):
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return signingKey{}, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return signingKey{}, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return signingKey{}, fmt.Errorf("ec point is not on P-256")
+		}
+		return signingKey{Alg: "ES256", Key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	}
+
+	return signingKey{}, fmt.Errorf("unsupported key %s/%s/%s", k.Kty, k.Crv, k.Alg)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("malformed key parameter %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -1,31 +1,47 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 )
 
 var (
-	snsSvc *sns.SNS
-	rDB    *redis.Client
-	PEPPER = os.Getenv("PEPPER")
+	snsSvc      *sns.SNS
+	rDB         *redis.Client
+	signingKeys *keySet
+
+	parser = jwt.NewParser(jwt.WithValidMethods(validMethods))
 )
 
 type MyCustomClaims struct {
 	RefreshTokenID int64 `json:"refreshTokenId,omitempty"`
 	UserID         int64 `json:"userId,omitempty"`
-	jwt.StandardClaims
+	jwt.RegisteredClaims
+}
+
+// Valid requires an expiry on top of the exp, nbf and iat checks of
+// the registered claims
+func (c *MyCustomClaims) Valid() error {
+	if c.ExpiresAt == nil {
+		return fmt.Errorf("token has no expiry")
+	}
+	return c.RegisteredClaims.Valid()
 }
 
 func generatePolicy(
@@ -36,27 +52,19 @@ func generatePolicy(
 	authResponse := events.APIGatewayCustomAuthorizerResponse{
 		PrincipalID: "apigateway.amazonaws.com",
 	}
+	roles := getRoles(claims.UserID)
 	authResponse.PolicyDocument = events.APIGatewayCustomAuthorizerPolicy{
-		Version: "2012-10-17",
-		Statement: []events.IAMPolicyStatement{
-			{
-				Action:   []string{"execute-api:Invoke"},
-				Effect:   "Allow",
-				Resource: []string{resource},
-			},
-		},
-	}
-
-	if claims == nil {
-		return &authResponse
+		Version:   "2012-10-17",
+		Statement: policyStatements(resource, roles),
 	}
 
 	b, _ := json.Marshal(getOwnerships(claims.UserID))
 
 	authResponse.Context = map[string]interface{}{
-		"userID":                claims.UserID,
-		"allUserOwnershipsJSON": string(b),
-		"accessToken":           accessToken,
+		authLib.ContextUserID:     claims.UserID,
+		authLib.ContextRoles:      strings.Join(roles, ","),
+		authLib.ContextOwnerships: string(b),
+		"accessToken":             accessToken,
 	}
 
 	return &authResponse
@@ -67,27 +75,70 @@ func handler(request events.APIGatewayCustomAuthorizerRequest) (
 ) {
 	accessToken := strings.Replace(request.AuthorizationToken, "Bearer ", "", 1)
 
-	token, err := jwt.ParseWithClaims(
-		accessToken,
-		&MyCustomClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(PEPPER), nil
-		},
-	)
+	claims, err := verify(accessToken)
 	if err != nil {
-		fmt.Printf("error parsing token(%s): %s\n", request.AuthorizationToken, err)
+		fmt.Printf("invalid token(%s): %s\n", tokenFingerprint(accessToken), err)
 		return nil, errors.New("Unauthorized")
 	}
 
-	if claims, ok := token.Claims.(*MyCustomClaims); ok && token.Valid {
-		return generatePolicy(request.MethodArn, accessToken, claims), nil
+	return generatePolicy(request.MethodArn, accessToken, claims), nil
+}
+
+// tokenFingerprint identifies a token in the logs without making the
+// logs a source of working tokens
+func tokenFingerprint(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// verify checks the signature against the key of the token kid and
+// the claims, then that the token has not been revoked
+func verify(accessToken string) (*MyCustomClaims, error) {
+	token, err := parser.ParseWithClaims(accessToken, &MyCustomClaims{}, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*MyCustomClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid claims")
+	}
+	if err := checkRevoked(claims); err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("invalid token(%s)\n", request.AuthorizationToken)
-	return nil, errors.New("Unauthorized")
+	return claims, nil
+}
+
+// keyFunc pins the algorithm to the one of the key, a token can not
+// pick how its signature is checked
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid")
+	}
+
+	key, err := signingKeys.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("token alg %s does not match key(%s) alg %s", token.Method.Alg(), kid, key.Alg)
+	}
+
+	return key.Key, nil
 }
 
 func init() {
+	if os.Getenv("TESTING") == "1" {
+		return
+	}
+
+	signingKeys = &keySet{
+		URL:  os.Getenv("JWKS_URL"),
+		HTTP: &http.Client{Timeout: 5 * time.Second},
+	}
+
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(os.Getenv("AWS_REGION")),
 	}))
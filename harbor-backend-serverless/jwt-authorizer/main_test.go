@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "alg": "RS256", "use": "sig",
+		"n": b64(key.N), "e": b64(big.NewInt(int64(key.E)))}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X), "y": b64(key.Y)}
+}
+
+// setup serves the jwks returned by keys and a fresh redis, fetches
+// counts the jwks requests
+func setup(t *testing.T, keys func() []map[string]string) (*miniredis.Miniredis, *int) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys()})
+	}))
+	t.Cleanup(srv.Close)
+	signingKeys = &keySet{URL: srv.URL, HTTP: srv.Client()}
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+	rDB = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return mr, &fetches
+}
+
+func claims(mod func(c *MyCustomClaims)) *MyCustomClaims {
+	now := time.Now()
+	c := &MyCustomClaims{
+		RefreshTokenID: 7,
+		UserID:         42,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+	if mod != nil {
+		mod(c)
+	}
+	return c
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, c *MyCustomClaims) string {
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed signing: %s", err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	mr, _ := setup(t, func() []map[string]string {
+		return []map[string]string{rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey)}
+	})
+	mr.Set(revokedRefreshTokenKey(8), "1")
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	cases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"rs256", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil)), true},
+		{"es256", sign(t, jwt.SigningMethodES256, "ec-1", ecKey, claims(nil)), true},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c *MyCustomClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second))
+		})), false},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c *MyCustomClaims) {
+			c.ExpiresAt = nil
+		})), false},
+		{"not yet valid", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c *MyCustomClaims) {
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		})), false},
+		{"revoked", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c *MyCustomClaims) {
+			c.RefreshTokenID = 8
+		})), false},
+		// the public key used as an hmac secret
+		{"hs256 confusion", sign(t, jwt.SigningMethodHS256, "rsa-1", rsaPub, claims(nil)), false},
+		{"none", sign(t, jwt.SigningMethodNone, "rsa-1", jwt.UnsafeAllowNoneSignatureType, claims(nil)), false},
+		// a valid signature with the alg of another key
+		{"alg of another key", sign(t, jwt.SigningMethodES256, "rsa-1", ecKey, claims(nil)), false},
+		{"no kid", sign(t, jwt.SigningMethodRS256, "", rsaKey, claims(nil)), false},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, claims(nil)), false},
+		{"wrong key", sign(t, jwt.SigningMethodES256, "ec-1", func() *ecdsa.PrivateKey {
+			k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			return k
+		}(), claims(nil)), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := verify(tc.token)
+			if tc.valid && (err != nil || c.UserID != 42) {
+				t.Errorf("expected a valid token, got %v: %v", c, err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestTokenFingerprint(t *testing.T) {
+	token := sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil))
+	fp := tokenFingerprint(token)
+	if fp != tokenFingerprint(token) || fp == tokenFingerprint(token+"x") {
+		t.Errorf("expected a stable fingerprint per token, got %s", fp)
+	}
+}
+
+func TestVerifyRevokedBefore(t *testing.T) {
+	mr, _ := setup(t, func() []map[string]string {
+		return []map[string]string{rsaJWK("rsa-1", rsaKey)}
+	})
+	token := sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil))
+
+	// a password change revokes every token issued before it
+	mr.Set(tokensRevokedBeforeKey(42), strconv.FormatInt(time.Now().Add(-30*time.Second).Unix(), 10))
+	if _, err := verify(token); err == nil {
+		t.Errorf("expected the token issued before the password change to be rejected")
+	}
+
+	newer := sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(func(c *MyCustomClaims) {
+		c.IssuedAt = jwt.NewNumericDate(time.Now())
+	}))
+	if _, err := verify(newer); err != nil {
+		t.Errorf("expected the newer token to be valid, got %s", err)
+	}
+
+	// revocations can not be checked without redis
+	mr.Close()
+	if _, err := verify(newer); err == nil {
+		t.Errorf("expected the token to be rejected without redis")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Now()
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	published := []map[string]string{rsaJWK("rsa-1", rsaKey)}
+	_, fetches := setup(t, func() []map[string]string { return published })
+	signingKeys.now = func() time.Time { return now }
+
+	if _, err := verify(sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil))); err != nil {
+		t.Fatalf("expected a valid token, got %s", err)
+	}
+
+	// the unknown kid refetches the set once
+	published = append(published, rsaJWK("rsa-2", rotated))
+	next := sign(t, jwt.SigningMethodRS256, "rsa-2", rotated, claims(nil))
+	now = now.Add(2 * minRefetch)
+	if _, err := verify(next); err != nil || *fetches != 2 {
+		t.Fatalf("expected the rotated key after 2 fetches, got %d: %v", *fetches, err)
+	}
+
+	// unknown kids do not refetch more than once per minRefetch
+	for i := 0; i < 3; i++ {
+		verify(sign(t, jwt.SigningMethodRS256, "rsa-3", rotated, claims(nil)))
+	}
+	if *fetches != 2 {
+		t.Errorf("expected unknown kids to be throttled, got %d fetches", *fetches)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	short, _ := rsa.GenerateKey(rand.Reader, 1024)
+	body, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		rsaJWK("rsa-1", rsaKey),
+		rsaJWK("short", short),
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "EC", "kid": "off-curve", "crv": "P-256", "x": b64(big.NewInt(1)), "y": b64(big.NewInt(2))},
+	}})
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		t.Fatalf("failed parsing: %s", err)
+	}
+	if len(keys) != 1 || keys["rsa-1"].Alg != "RS256" {
+		t.Errorf("expected only the rs256 key, got %v", keys)
+	}
+}
+
+// allows matches arn against the allowed resources the way iam does,
+// a wildcard spans path segments
+func allows(statements []events.IAMPolicyStatement, arn string) bool {
+	for _, st := range statements {
+		if st.Effect != "Allow" {
+			continue
+		}
+		for _, res := range st.Resource {
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(res), `\*`, ".*")
+			if regexp.MustCompile("^" + pattern + "$").MatchString(arn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestPolicyStatements(t *testing.T) {
+	base := "arn:aws:execute-api:us-west-1:123456789012:abc123/production/"
+
+	user := policyStatements(base+"GET/today", []string{authLib.RoleUser})
+	for _, route := range []string{"GET/today", "GET/risks/12", "PATCH/plans/3/form-input-answers/4", "PUT/notification-preferences"} {
+		if !allows(user, base+route) {
+			t.Errorf("expected users to be allowed %s, got %+v", route, user)
+		}
+	}
+	for _, route := range []string{"GET/admin/users", "DELETE/households", "PUT/today", "GET/unknown"} {
+		if allows(user, base+route) {
+			t.Errorf("expected users to be denied %s", route)
+		}
+	}
+	if allows(user, "arn:aws:execute-api:us-west-1:123456789012:abc123/staging/GET/today") {
+		t.Errorf("expected the policy to be limited to the stage")
+	}
+
+	admin := policyStatements(base+"GET/today", []string{authLib.RoleUser, authLib.RoleAdmin})
+	if !allows(admin, base+"GET/admin/users") || !allows(admin, base+"GET/today") {
+		t.Errorf("expected admins to be allowed the admin and user routes, got %+v", admin)
+	}
+}
+
+// every route of the api behind the authorizer has to be allowed for
+// users, otherwise it is denied once deployed
+func TestRoleRoutesCoverTemplate(t *testing.T) {
+	template, err := ioutil.ReadFile("../template.yaml")
+	if err != nil {
+		t.Fatalf("failed reading template: %s", err)
+	}
+
+	type route struct {
+		method, path string
+		public       bool
+	}
+	var routes []route
+	var cur *route
+	for _, line := range strings.Split(string(template), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Type:"):
+			cur = nil
+			if line == "Type: Api" {
+				routes = append(routes, route{})
+				cur = &routes[len(routes)-1]
+			}
+		case cur == nil:
+		case strings.HasPrefix(line, "Method:"):
+			cur.method = strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(line, "Method:")))
+		case strings.HasPrefix(line, "Path:"):
+			cur.path = strings.TrimSpace(strings.TrimPrefix(line, "Path:"))
+		case line == "Authorizer: NONE":
+			cur.public = true
+		}
+	}
+	if len(routes) < 20 {
+		t.Fatalf("expected the api routes of the template, found %d", len(routes))
+	}
+
+	base := "arn:aws:execute-api:us-west-1:123456789012:abc123/production/"
+	user := policyStatements(base+"GET/today", []string{authLib.RoleUser})
+	params := regexp.MustCompile(`{[^}]+}`)
+	for _, r := range routes {
+		if r.public {
+			continue
+		}
+		arn := base + r.method + params.ReplaceAllString(r.path, "1")
+		if !allows(user, arn) {
+			t.Errorf("route %s %s is not allowed for users", r.method, r.path)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
+)
+
+// routes of the api by role as "METHOD/path" of the method arn, path
+// parameters are wildcards. Routes added to template.yaml behind the
+// authorizer must be listed here, see TestRoleRoutesCoverTemplate.
+// The policy covers every route of the roles rather than the invoked
+// one so it stays valid if api gateway caches it.
+var roleRoutes = map[string][]string{
+	authLib.RoleUser: {
+		"POST/analytics-events",
+		"POST/analytics-events/*",
+		"GET/activities/*",
+		"PATCH/addresses/*",
+		"GET/chapters/*",
+		"GET/emergency-guides",
+		"POST/form-input-answers",
+		"PATCH/form-input-answers/*",
+		"GET/households",
+		"GET/inventories/*",
+		"GET/notification-preferences",
+		"PUT/notification-preferences",
+		"POST/otp/*",
+		"GET/places/*",
+		"PATCH/plans/*/form-input-answers/*",
+		"GET/risks/*",
+		"GET/supplies/*",
+		"GET/themes/*",
+		"GET/today",
+		"GET/weather-events/*",
+		"PUT/weekly-schedules",
+	},
+	authLib.RoleAdmin: {"*/admin/*"},
+}
+
+// policyStatements allows the routes of the roles on the api and
+// stage of methodArn
+func policyStatements(methodArn string, roles []string) []events.IAMPolicyStatement {
+	// arn:aws:execute-api:{region}:{account}:{api}/{stage}/{method}/{path}
+	parts := strings.SplitN(methodArn, "/", 3)
+	if len(parts) < 2 {
+		return []events.IAMPolicyStatement{{
+			Action:   []string{"execute-api:Invoke"},
+			Effect:   "Allow",
+			Resource: []string{methodArn},
+		}}
+	}
+	base := parts[0] + "/" + parts[1] + "/"
+
+	var allowed []string
+	for _, role := range roles {
+		for _, route := range roleRoutes[role] {
+			allowed = append(allowed, base+route)
+		}
+	}
+
+	return []events.IAMPolicyStatement{{
+		Action:   []string{"execute-api:Invoke"},
+		Effect:   "Allow",
+		Resource: allowed,
+	}}
+}
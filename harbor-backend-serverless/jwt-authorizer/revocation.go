@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// the auth service sets these on logout and password change, until
+// the revoked tokens would have expired:
+//
+//	revokedRefreshTokens:{refreshTokenId}  revoked refresh token
+//	tokensRevokedBefore:{userId}           unix time tokens issued before are revoked
+func revokedRefreshTokenKey(refreshTokenID int64) string {
+	return fmt.Sprintf("revokedRefreshTokens:%d", refreshTokenID)
+}
+
+func tokensRevokedBeforeKey(userID int64) string {
+	return fmt.Sprintf("tokensRevokedBefore:%d", userID)
+}
+
+// checkRevoked fails when the refresh token of the access token was
+// revoked, or the user revoked every token issued before it. Redis
+// errors fail closed.
+func checkRevoked(claims *MyCustomClaims) error {
+	if rDB == nil {
+		return fmt.Errorf("no redis connection to check revocations")
+	}
+
+	pipe := rDB.Pipeline()
+	revoked := pipe.Exists(ctx, revokedRefreshTokenKey(claims.RefreshTokenID))
+	before := pipe.Get(ctx, tokensRevokedBeforeKey(claims.UserID))
+	pipe.Exec(ctx)
+
+	if err := revoked.Err(); err != nil {
+		return fmt.Errorf("failed checking revoked refresh token(%d): %s", claims.RefreshTokenID, err)
+	}
+	if claims.RefreshTokenID != 0 && revoked.Val() > 0 {
+		return fmt.Errorf("refresh token(%d) is revoked", claims.RefreshTokenID)
+	}
+
+	if err := before.Err(); err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed checking revocations of user(%d): %s", claims.UserID, err)
+	}
+	revokedBefore, err := before.Int64()
+	if err != nil {
+		return fmt.Errorf("malformed revocation for user(%d): %s", claims.UserID, err)
+	}
+	if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(time.Unix(revokedBefore, 0)) {
+		return fmt.Errorf("tokens of user(%d) issued before %d are revoked", claims.UserID, revokedBefore)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
+	"github.com/jmoiron/sqlx"
+)
+
+var pgDB *sqlx.DB
+
+const rolesQuery = `select coalesce(role, '') from users where id = $1`
+
+// getDB connects to postgres once per container
+func getDB() *sqlx.DB {
+	if pgDB != nil {
+		return pgDB
+	}
+
+	db, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		msg := fmt.Sprintf("JWTAuth unable to establish PG connection: %s", err)
+		maybeAlertTom("pgConnError", msg)
+		panic(msg)
+	}
+	pgDB = db
+	return pgDB
+}
+
+// getRoles returns the roles of the user, every user has the user
+// role. Failed lookups only grant the user role.
+func getRoles(userID int64) []string {
+	roles := []string{authLib.RoleUser}
+
+	var role string
+	if err := getDB().Get(&role, rolesQuery, userID); err != nil {
+		fmt.Printf("unable to get role for user(%d): %s\n", userID, err)
+		return roles
+	}
+	role = strings.TrimSpace(role)
+	if role != "" && role != authLib.RoleUser {
+		roles = append(roles, role)
+	}
+
+	return roles
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	pgDB *sqlx.DB
+)
+
+// alert categories that map to events, NONE alerts are always sent
+var alertCategories = []string{
+	"Earthquake", "Floods", "Heatwaves", "Hurricanes", "Tornadoes",
+	"Tsunamis", "Volcano", "Wildfire", "Winter Storms",
+}
+
+type PreferencesRow struct {
+	HasPreferences bool           `db:"has_preferences"`
+	MinLevel       *string        `db:"min_level"`
+	QuietStart     *string        `db:"quiet_start"`
+	QuietEnd       *string        `db:"quiet_end"`
+	Timezone       *string        `db:"timezone"`
+	Channels       pq.StringArray `db:"channels"`
+	Language       *string        `db:"language"`
+	Categories     pq.StringArray `db:"categories"`
+}
+
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+type Preferences struct {
+	MinLevel   string      `json:"minLevel"`
+	Categories []string    `json:"categories"`
+	QuietHours *QuietHours `json:"quietHours"`
+	Channels   []string    `json:"channels"`
+	Language   string      `json:"language,omitempty"`
+	IsDefault  bool        `json:"isDefault"`
+}
+
+func handler(req events.APIGatewayProxyRequest) (
+	*events.APIGatewayProxyResponse, error,
+) {
+	userID, _ := authLib.ActingUserID(req)
+
+	var row PreferencesRow
+	if err := pgDB.Get(&row, query, userID, pq.Array(alertCategories)); err != nil {
+		panic(fmt.Errorf("unable to get notification preferences for user(%s): %s", userID, err))
+	}
+
+	b, _ := json.Marshal(getPreferences(row))
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(b),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// users that never saved preferences are notified of every
+// alert on push, see preferences.Default in the alert notifier
+func getPreferences(row PreferencesRow) Preferences {
+	if !row.HasPreferences {
+		return Preferences{
+			MinLevel:   "AWARE",
+			Categories: alertCategories,
+			Channels:   []string{"push"},
+			IsDefault:  true,
+		}
+	}
+
+	p := Preferences{
+		MinLevel:   "AWARE",
+		Categories: []string(row.Categories),
+		Channels:   []string(row.Channels),
+	}
+	if p.Categories == nil {
+		p.Categories = []string{}
+	}
+	if p.Channels == nil {
+		p.Channels = []string{}
+	}
+	if row.MinLevel != nil {
+		p.MinLevel = *row.MinLevel
+	}
+	if row.Language != nil {
+		p.Language = *row.Language
+	}
+	if row.QuietStart != nil && row.QuietEnd != nil && row.Timezone != nil {
+		p.QuietHours = &QuietHours{Start: *row.QuietStart, End: *row.QuietEnd, Timezone: *row.Timezone}
+	}
+
+	return p
+}
+
+func init() {
+	d, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		panic(err)
+	}
+	pgDB = d
+}
+
+func main() {
+	lambda.Start(handler)
+}
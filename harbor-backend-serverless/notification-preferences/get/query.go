@@ -0,0 +1,22 @@
+package main
+
+// categories are the subscribed events that alerts are sent for,
+// see AlertCategory in harbor-workers/ipaws/shared/models
+const query = `
+select
+	np.user_id is not null as has_preferences,
+	np.min_level,
+	to_char(np.quiet_start, 'HH24:MI') as quiet_start,
+	to_char(np.quiet_end, 'HH24:MI') as quiet_end,
+	np.timezone,
+	np.channels,
+	np.language,
+	array(
+		select e.name
+		from events_subscriptions es
+		join events e on e.id = es.event_id
+		where es.user_id = $1 and e.name = any($2)
+		order by e.name
+	) as categories
+from (select $1::int as user_id) u
+left join notification_preferences np on np.user_id = u.user_id`
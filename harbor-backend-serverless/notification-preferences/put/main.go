@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+	// lambda images do not reliably ship a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+var (
+	pgDB execer
+)
+
+// alert categories that map to events, NONE alerts are always sent
+var alertCategories = []string{
+	"Earthquake", "Floods", "Heatwaves", "Hurricanes", "Tornadoes",
+	"Tsunamis", "Volcano", "Wildfire", "Winter Storms",
+}
+
+var alertLevels = []string{"AWARE", "ON WATCH", "WARNING", "DANGEROUS"}
+
+var channels = []string{"push", "email", "sms"}
+
+// a language with an optional region, like "es" or "es-US"
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+type ReqBody struct {
+	MinLevel   string      `json:"minLevel"`
+	Categories []string    `json:"categories"`
+	QuietHours *QuietHours `json:"quietHours"`
+	Channels   []string    `json:"channels"`
+	Language   string      `json:"language"`
+}
+
+func handler(req events.APIGatewayProxyRequest) (
+	*events.APIGatewayProxyResponse, error,
+) {
+	userID, _ := authLib.ActingUserID(req)
+
+	var body ReqBody
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		fmt.Printf("unable to parse payload(%s) for user(%s): %s\n", req.Body, userID, err)
+		return &events.APIGatewayProxyResponse{StatusCode: 400}, nil
+	}
+	if err := validate(body); err != nil {
+		fmt.Printf("invalid notification preferences for user(%s): %s\n", userID, err)
+		return badRequest(err), nil
+	}
+
+	var quietStart, quietEnd, timezone, language interface{}
+	if body.QuietHours != nil {
+		quietStart = body.QuietHours.Start
+		quietEnd = body.QuietHours.End
+		timezone = body.QuietHours.Timezone
+	}
+	if body.Language != "" {
+		language = body.Language
+	}
+	if body.Channels == nil {
+		body.Channels = []string{}
+	}
+	if body.Categories == nil {
+		body.Categories = []string{}
+	}
+
+	_, err := pgDB.Exec(
+		query,
+		userID,
+		body.MinLevel,
+		quietStart,
+		quietEnd,
+		timezone,
+		pq.Array(body.Channels),
+		pq.Array(body.Categories),
+		pq.Array(alertCategories),
+		language,
+	)
+	if err != nil {
+		panic(fmt.Errorf("unable to save notification preferences(%+v) for user(%s): %s", body, userID, err))
+	}
+
+	return &events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+func validate(body ReqBody) error {
+	if !contains(alertLevels, body.MinLevel) {
+		return fmt.Errorf("unknown minLevel %s", body.MinLevel)
+	}
+	for _, c := range body.Categories {
+		if !contains(alertCategories, c) {
+			return fmt.Errorf("unknown category %s", c)
+		}
+	}
+	for _, c := range body.Channels {
+		if !contains(channels, c) {
+			return fmt.Errorf("unknown channel %s", c)
+		}
+	}
+
+	if body.Language != "" && !languageTag.MatchString(body.Language) {
+		return fmt.Errorf("malformed language %s", body.Language)
+	}
+
+	if qh := body.QuietHours; qh != nil {
+		if _, err := time.Parse("15:04", qh.Start); err != nil {
+			return fmt.Errorf("malformed quiet hours start %s", qh.Start)
+		}
+		if _, err := time.Parse("15:04", qh.End); err != nil {
+			return fmt.Errorf("malformed quiet hours end %s", qh.End)
+		}
+		if _, err := time.LoadLocation(qh.Timezone); err != nil || qh.Timezone == "" {
+			return fmt.Errorf("unknown timezone %s", qh.Timezone)
+		}
+	}
+
+	return nil
+}
+
+func badRequest(err error) *events.APIGatewayProxyResponse {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Body:       string(b),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+func contains(arr []string, val string) bool {
+	for _, v := range arr {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	if os.Getenv("TESTING") == "1" {
+		return
+	}
+
+	d, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		panic(err)
+	}
+	pgDB = d
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/lib/pq"
+)
+
+type fakeDB struct {
+	args []interface{}
+}
+
+func (db *fakeDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	db.args = args
+	return nil, nil
+}
+
+func TestValidate(t *testing.T) {
+	quiet := func(start, end, timezone string) *QuietHours {
+		return &QuietHours{Start: start, End: end, Timezone: timezone}
+	}
+
+	tests := []struct {
+		name string
+		body ReqBody
+		err  string
+	}{
+		{"defaults", ReqBody{MinLevel: "AWARE"}, ""},
+		{"everything", ReqBody{
+			MinLevel:   "WARNING",
+			Categories: []string{"Floods", "Winter Storms"},
+			QuietHours: quiet("22:00", "07:00", "America/Chicago"),
+			Channels:   []string{"push", "sms"},
+			Language:   "es-US",
+		}, ""},
+		{"unknown level", ReqBody{MinLevel: "SEVERE"}, "unknown minLevel"},
+		{"no level", ReqBody{}, "unknown minLevel"},
+		{"unknown category", ReqBody{MinLevel: "AWARE", Categories: []string{"Thunderstorms"}}, "unknown category"},
+		{"unknown channel", ReqBody{MinLevel: "AWARE", Channels: []string{"fax"}}, "unknown channel"},
+		{"malformed start", ReqBody{MinLevel: "AWARE", QuietHours: quiet("10pm", "07:00", "UTC")}, "quiet hours start"},
+		{"malformed end", ReqBody{MinLevel: "AWARE", QuietHours: quiet("22:00", "25:00", "UTC")}, "quiet hours end"},
+		{"unknown timezone", ReqBody{MinLevel: "AWARE", QuietHours: quiet("22:00", "07:00", "Mars/Olympus")}, "unknown timezone"},
+		{"no timezone", ReqBody{MinLevel: "AWARE", QuietHours: quiet("22:00", "07:00", "")}, "unknown timezone"},
+		{"malformed language", ReqBody{MinLevel: "AWARE", Language: "spanish please"}, "malformed language"},
+	}
+
+	for _, tt := range tests {
+		err := validate(tt.body)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	put := func(body string) (*events.APIGatewayProxyResponse, *fakeDB) {
+		db := &fakeDB{}
+		pgDB = db
+		res, err := handler(events.APIGatewayProxyRequest{
+			Body: body,
+			RequestContext: events.APIGatewayProxyRequestContext{
+				Authorizer: map[string]interface{}{"userID": "42"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return res, db
+	}
+
+	t.Run("malformed body", func(t *testing.T) {
+		if res, db := put(`{"minLevel":`); res.StatusCode != 400 || db.args != nil {
+			t.Errorf("expected a 400 without saving, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("invalid preferences", func(t *testing.T) {
+		res, db := put(`{"minLevel": "AWARE", "channels": ["fax"]}`)
+		if res.StatusCode != 400 || db.args != nil {
+			t.Errorf("expected a 400 without saving, got %d", res.StatusCode)
+		}
+		if !strings.Contains(res.Body, "unknown channel fax") {
+			t.Errorf("expected the validation error in the body, got %s", res.Body)
+		}
+	})
+
+	t.Run("quiet hours", func(t *testing.T) {
+		res, db := put(`{"minLevel": "WARNING", "categories": ["Floods"], "channels": ["push"],
+			"quietHours": {"start": "22:00", "end": "07:00", "timezone": "America/Chicago"}, "language": "es"}`)
+		if res.StatusCode != 204 {
+			t.Fatalf("expected a 204, got %d", res.StatusCode)
+		}
+		if db.args[0] != "42" || db.args[1] != "WARNING" || db.args[2] != "22:00" || db.args[3] != "07:00" ||
+			db.args[4] != "America/Chicago" || db.args[8] != "es" {
+			t.Errorf("unexpected query args %v", db.args)
+		}
+	})
+
+	t.Run("no quiet hours", func(t *testing.T) {
+		res, db := put(`{"minLevel": "AWARE"}`)
+		if res.StatusCode != 204 {
+			t.Fatalf("expected a 204, got %d", res.StatusCode)
+		}
+		if db.args[2] != nil || db.args[3] != nil || db.args[4] != nil || db.args[8] != nil {
+			t.Errorf("expected quiet hours and language to be cleared, got %v", db.args)
+		}
+		// missing lists are saved empty, not null
+		if channels, ok := db.args[5].(*pq.StringArray); !ok || channels == nil || len(*channels) != 0 {
+			t.Errorf("expected no channels, got %#v", db.args[5])
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+// notification_preferences (
+//   user_id     int primary key references users (id),
+//   min_level   text not null default 'AWARE',
+//   quiet_start time,
+//   quiet_end   time,
+//   timezone    text,
+//   channels    text[] not null default '{push}',
+//   language    text,
+//   updated_at  timestamptz not null default now()
+// )
+//
+// subscribed categories are stored as events_subscriptions, only
+// events named after an alert category ($8) are touched
+const query = `
+with upserted as (
+	insert into notification_preferences (
+		user_id,
+		min_level,
+		quiet_start,
+		quiet_end,
+		timezone,
+		channels,
+		language,
+		updated_at
+	)
+	values ($1, $2, $3, $4, $5, $6, $9, now())
+	on conflict (user_id) do update set
+		min_level = excluded.min_level,
+		quiet_start = excluded.quiet_start,
+		quiet_end = excluded.quiet_end,
+		timezone = excluded.timezone,
+		channels = excluded.channels,
+		language = excluded.language,
+		updated_at = excluded.updated_at
+	returning user_id
+), category_events as (
+	select id, name
+	from events
+	where name = any($8)
+), unsubscribed as (
+	delete from events_subscriptions
+	where user_id = (select user_id from upserted)
+	and event_id in (select id from category_events where not (name = any($7)))
+), ownership as (
+	select o.id
+	from household_users hu
+	inner join ownerships o on o.household_user_id = hu.id
+	where hu.user_id = (select user_id from upserted)
+	and o.ownership_type_id = 1
+)
+insert into events_subscriptions (
+	event_id,
+	ownership_id,
+	user_id
+)
+select
+	id,
+	(select id from ownership),
+	(select user_id from upserted)
+from category_events
+where name = any($7)
+on conflict(user_id, event_id) do nothing`
@@ -1,17 +1,20 @@
 require (
 	github.com/aws/aws-lambda-go v1.13.3
-	github.com/aws/aws-sdk-go v1.36.0
+	github.com/aws/aws-sdk-go v1.36.24
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/helloharbor/harbor-backend-serverless/otp/lib v0.0.0
 	github.com/kevinburke/go-types v0.0.0-20201208005256-aee49f568a20 // indirect
 	github.com/kevinburke/go.uuid v1.2.0 // indirect
 	github.com/kevinburke/rest v0.0.0-20210506044642-5611499aa33c // indirect
 	github.com/kevinburke/twilio-go v0.0.0-20210327194925-1623146bcf73
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	github.com/ttacon/builder v0.0.0-20170518171403-c099f663e1c2 // indirect
-	github.com/ttacon/libphonenumber v1.2.1 // indirect
+	github.com/ttacon/libphonenumber v1.2.1
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 )
 
+replace github.com/helloharbor/harbor-backend-serverless/otp/lib => ../lib
+
 module otp-sms-generation
 
 go 1.15
@@ -7,7 +7,6 @@ import (
 	"math/big"
 	"os"
 	"strconv"
-	_ "strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -15,15 +14,21 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	twilio "github.com/kevinburke/twilio-go"
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib"
 	uuid "github.com/nu7hatch/gouuid"
 )
 
-const TOKENS_TABLE = "tokens"
+const (
+	OTP_TTL = 5 * time.Minute
+
+	CHANNEL_SMS   = "sms"
+	CHANNEL_VOICE = "voice"
+)
 
 var (
-	db          *dynamodb.DynamoDB
+	tokens      *lib.Tokens
+	sender      SMSSender
+	templates   map[string]Templates
 	twilioSID   = os.Getenv("TWILIO_SID")
 	twilioToken = os.Getenv("TWILIO_TOKEN")
 )
@@ -33,13 +38,11 @@ type ErrorBody struct {
 }
 
 type OtpSMSGenerationRequest struct {
+	// PhoneNumber in E.164, or a US number
 	PhoneNumber string `json:"phone_number"`
-}
-
-type Token struct {
-	Name  string `json:"tokenName"`
-	Value string `json:"tokenValue"`
-	TTL   int64  `json:"ttl"`
+	// Channel is sms or voice, landlines are called by default
+	Channel string `json:"channel"`
+	Locale  string `json:"locale"`
 }
 
 func errorResponse(status int, msg string) (*events.APIGatewayProxyResponse, error) {
@@ -52,6 +55,12 @@ func errorResponse(status int, msg string) (*events.APIGatewayProxyResponse, err
 	}, nil
 }
 
+func rateLimited(retryAfter time.Duration) (*events.APIGatewayProxyResponse, error) {
+	res, err := errorResponse(429, "Too Many Requests")
+	res.Headers["Retry-After"] = strconv.Itoa(int(retryAfter.Round(time.Second).Seconds()))
+	return res, err
+}
+
 func generateOTP() (string, error) {
 	nBig, err := rand.Int(rand.Reader, big.NewInt(899999))
 	if err != nil {
@@ -60,6 +69,39 @@ func generateOTP() (string, error) {
 	return strconv.FormatInt(nBig.Int64()+100000, 10), nil
 }
 
+// deliver sends the code over the channel in the templates of the
+// locale
+func deliver(to, channel, locale, otp string) error {
+	t := templatesFor(templates, locale)
+
+	if channel == CHANNEL_VOICE {
+		message, err := render(t.Voice, otp)
+		if err != nil {
+			return fmt.Errorf("unable to render voice template: %s", err)
+		}
+		return sender.(VoiceCaller).Call(to, message, t.VoiceLanguage)
+	}
+
+	body, err := render(t.SMS, otp)
+	if err != nil {
+		return fmt.Errorf("unable to render sms template: %s", err)
+	}
+	return sender.SendSMS(to, body)
+}
+
+// releaseSends gives back the sends counted for a code that was never
+// delivered, so a provider failure does not use up the user's limits
+func releaseSends(ip, phone string) {
+	if ip != "" {
+		if err := tokens.ReleaseSend(lib.IPLimit, ip); err != nil {
+			fmt.Printf("%s\n", err)
+		}
+	}
+	if err := tokens.ReleaseSend(lib.PhoneLimit, phone); err != nil {
+		fmt.Printf("%s\n", err)
+	}
+}
+
 func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 	var o OtpSMSGenerationRequest
 	if err := json.Unmarshal([]byte(req.Body), &o); err != nil {
@@ -73,81 +115,94 @@ func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse
 		return errorResponse(500, "Internal Server Error")
 	}
 
-	var phoneNumber string
-	for _, c := range o.PhoneNumber {
-		sC := string(c)
-		_, err := strconv.Atoi(sC)
-		if err != nil {
-			continue
-		} else if len(phoneNumber) == 0 && sC == "1" {
-			continue
+	p, err := parsePhone(o.PhoneNumber)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return errorResponse(400, fmt.Sprintf("invalid phone number: %s", o.PhoneNumber))
+	}
+
+	channel := o.Channel
+	if channel == "" {
+		channel = CHANNEL_SMS
+		if p.Landline {
+			channel = CHANNEL_VOICE
 		}
-		phoneNumber = phoneNumber + sC
+	}
+	if channel != CHANNEL_SMS && channel != CHANNEL_VOICE {
+		return errorResponse(400, fmt.Sprintf("invalid channel: %s", o.Channel))
+	} else if _, ok := sender.(VoiceCaller); channel == CHANNEL_VOICE && !ok {
+		return errorResponse(400, "voice calls are unavailable")
 	}
 
-	if len(phoneNumber) != 10 {
-		msg := fmt.Sprintf("invalid phone number: %s", o.PhoneNumber)
-		return errorResponse(400, msg)
+	// the ip limit keeps one client from cycling through numbers
+	ip := req.RequestContext.Identity.SourceIP
+	if ip != "" {
+		retryAfter, err := tokens.AllowSend(lib.IPLimit, ip)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return errorResponse(500, "Internal Server Error")
+		} else if retryAfter > 0 {
+			fmt.Printf("otp sends from ip(%s) are throttled\n", ip)
+			return rateLimited(retryAfter)
+		}
 	}
 
-	i, _ := dynamodbattribute.MarshalMap(Token{
-		Name:  phoneNumber,
-		Value: otp,
-		TTL:   time.Now().Add(5 * time.Minute).Unix(),
-	})
-	_, err = db.PutItem(&dynamodb.PutItemInput{
-		Item:      i,
-		TableName: aws.String(TOKENS_TABLE),
-	})
+	retryAfter, err := tokens.AllowSend(lib.PhoneLimit, p.E164)
 	if err != nil {
-		fmt.Printf("could not set otp: %s", err)
+		fmt.Printf("%s\n", err)
+		return errorResponse(500, "Internal Server Error")
+	} else if retryAfter > 0 {
+		fmt.Printf("otp sends to phone(%s) are throttled\n", p.E164)
+		return rateLimited(retryAfter)
+	}
+
+	if err = tokens.Put(p.E164, otp, OTP_TTL); err != nil {
+		fmt.Printf("%s\n", err)
 		return errorResponse(500, "Internal Server Error")
 	}
 
 	u4, err := uuid.NewV4()
-	nonce := u4.String()
 	if err != nil {
-		fmt.Printf("unable to generate nonce: %s", err)
+		fmt.Printf("unable to generate nonce: %s\n", err)
 		return errorResponse(500, "Internal Server Error")
 	}
+	nonce := u4.String()
 
-	i, _ = dynamodbattribute.MarshalMap(Token{
-		Name:  nonce,
-		Value: phoneNumber,
-		TTL:   time.Now().Add(5 * time.Minute).Unix(),
-	})
-	_, err = db.PutItem(&dynamodb.PutItemInput{
-		Item:      i,
-		TableName: aws.String(TOKENS_TABLE),
-	})
-	if err != nil {
-		fmt.Printf("could not store nonce: %s", err)
+	if err = tokens.Put(nonce, p.E164, OTP_TTL); err != nil {
+		fmt.Printf("%s\n", err)
 		return errorResponse(500, "Internal Server Error")
 	}
 
-	client := twilio.NewClient(twilioSID, twilioToken, nil)
-	_, err = client.Messages.SendMessage(
-		"+17755427267",
-		"+1"+phoneNumber,
-		fmt.Sprintf("Your harbor code is %s", otp),
-		nil,
-	)
-	if err != nil {
-		panic(fmt.Errorf("error sending SMS(%s): %s", phoneNumber, err))
+	if err = deliver(p.E164, channel, o.Locale, otp); err != nil {
+		fmt.Printf("error sending otp(%s) by %s: %s\n", p.E164, channel, err)
+		releaseSends(ip, p.E164)
+		return errorResponse(502, "Bad Gateway")
 	}
 
 	return &events.APIGatewayProxyResponse{
 		StatusCode: 201,
 		Headers:    map[string]string{"Content-Type": "application/json"},
-		Body:       fmt.Sprintf(`{"nonce": "%s"}`, nonce),
+		Body:       fmt.Sprintf(`{"nonce": "%s", "channel": "%s"}`, nonce, channel),
 	}, nil
 }
 
 func init() {
+	if os.Getenv("TESTING") == "1" {
+		return
+	}
+
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(os.Getenv("AWS_REGION")),
 	}))
-	db = dynamodb.New(sess)
+	tokens = &lib.Tokens{DB: dynamodb.New(sess)}
+
+	var err error
+	if sender, err = newSender(os.Getenv("SMS_PROVIDER"), os.Getenv("OTP_SENDER"), sess); err != nil {
+		panic(err)
+	}
+	if templates, err = loadTemplates(os.Getenv("OTP_TEMPLATES")); err != nil {
+		panic(err)
+	}
 }
 
 func main() {
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib"
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib/dynamotest"
+)
+
+// setup returns the sender, the clock is advanced by tests
+func setup() (*fakeSender, *time.Time) {
+	now := time.Date(2021, 12, 1, 12, 0, 0, 0, time.UTC)
+	tokens = &lib.Tokens{DB: dynamotest.New(), Now: func() time.Time { return now }}
+	templates = defaultTemplates
+
+	fake := &fakeSender{}
+	sender = fake
+	return fake, &now
+}
+
+func generate(t *testing.T, phone, ip string) *events.APIGatewayProxyResponse {
+	return request(t, fmt.Sprintf(`{"phone_number": "%s"}`, phone), ip)
+}
+
+func request(t *testing.T, body, ip string) *events.APIGatewayProxyResponse {
+	req := events.APIGatewayProxyRequest{Body: body}
+	req.RequestContext.Identity.SourceIP = ip
+	res, err := handler(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return res
+}
+
+func TestGenerate(t *testing.T) {
+	fake, _ := setup()
+
+	res := generate(t, "1 (415) 555-0100", "10.0.0.1")
+	if res.StatusCode != 201 || !strings.Contains(res.Body, `"channel": "sms"`) {
+		t.Fatalf("expected the otp to be sent, got %d: %s", res.StatusCode, res.Body)
+	}
+	if len(fake.Sent) != 1 || fake.Sent[0].To != "+14155550100" || !strings.HasPrefix(fake.Sent[0].Body, "Your harbor code is ") {
+		t.Errorf("unexpected messages %+v", fake.Sent)
+	}
+
+	otp, _ := tokens.Get("+14155550100")
+	if otp == nil || !strings.HasSuffix(fake.Sent[0].Body, otp.Value) {
+		t.Errorf("expected the sent otp to be stored, got %+v", otp)
+	}
+}
+
+func TestGenerateInternational(t *testing.T) {
+	fake, _ := setup()
+
+	if res := generate(t, "+44 7911 123456", ""); res.StatusCode != 201 {
+		t.Fatalf("expected the otp to be sent, got %d: %s", res.StatusCode, res.Body)
+	}
+	if len(fake.Sent) != 1 || fake.Sent[0].To != "+447911123456" || fake.Sent[0].Voice {
+		t.Errorf("expected an sms to the uk mobile, got %+v", fake.Sent)
+	}
+
+	for _, phone := range []string{"555-0100", "+44 7911", "+1 (800) 555-0100", "not a number"} {
+		if res := generate(t, phone, ""); res.StatusCode != 400 {
+			t.Errorf("expected %s to be refused, got %d", phone, res.StatusCode)
+		}
+	}
+}
+
+func TestGenerateVoice(t *testing.T) {
+	fake, _ := setup()
+
+	// a london landline is called
+	res := request(t, `{"phone_number": "+44 20 7946 0000", "locale": "es-MX"}`, "")
+	if res.StatusCode != 201 || !strings.Contains(res.Body, `"channel": "voice"`) {
+		t.Fatalf("expected a call, got %d: %s", res.StatusCode, res.Body)
+	}
+	otp, _ := tokens.Get("+442079460000")
+	spoken := strings.Join(strings.Split(otp.Value, ""), ", ")
+	if len(fake.Sent) != 1 || !fake.Sent[0].Voice || fake.Sent[0].Language != "es-US" ||
+		fake.Sent[0].Body != fmt.Sprintf("Tu código de harbor es %s. Otra vez, tu código es %s.", spoken, spoken) {
+		t.Errorf("unexpected call %+v", fake.Sent)
+	}
+
+	// requested for a mobile
+	if res := request(t, `{"phone_number": "4155550100", "channel": "voice"}`, ""); res.StatusCode != 201 {
+		t.Errorf("expected a requested call, got %d", res.StatusCode)
+	}
+	if res := request(t, `{"phone_number": "4155550101", "channel": "fax"}`, ""); res.StatusCode != 400 {
+		t.Errorf("expected an unknown channel to be refused, got %d", res.StatusCode)
+	}
+
+	// sns only sends sms
+	sender = &snsSender{}
+	if res := request(t, `{"phone_number": "+44 20 7946 0001"}`, ""); res.StatusCode != 400 {
+		t.Errorf("expected calls to be unavailable, got %d", res.StatusCode)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	loaded, err := loadTemplates(`{"FR": {"sms": "Votre code harbor est {{.Code}}", "voice": "{{.SpokenCode}}", "voice_language": "fr-FR"}}`)
+	if err != nil {
+		t.Fatalf("failed loading templates: %s", err)
+	}
+
+	for locale, expected := range map[string]string{
+		"fr_CA": "Votre code harbor est 123456",
+		"es":    "Tu código de harbor es 123456",
+		"de":    "Your harbor code is 123456",
+		"":      "Your harbor code is 123456",
+	} {
+		if got, _ := render(templatesFor(loaded, locale).SMS, "123456"); got != expected {
+			t.Errorf("expected %q for %s, got %q", expected, locale, got)
+		}
+	}
+
+	if _, err := loadTemplates(`{"fr": {"sms": "{{.Code", "voice": "{{.SpokenCode}}", "voice_language": "fr-FR"}}`); err == nil {
+		t.Errorf("expected an invalid template to be refused")
+	}
+
+	// a new locale needs every field, calls would be silent otherwise
+	if _, err := loadTemplates(`{"fr": {"sms": "Votre code harbor est {{.Code}}"}}`); err == nil {
+		t.Errorf("expected a locale without voice to be refused")
+	}
+
+	// known locales keep the fields that are not overridden
+	loaded, err = loadTemplates(`{"es": {"sms": "Código harbor: {{.Code}}"}}`)
+	if err != nil {
+		t.Fatalf("failed loading templates: %s", err)
+	}
+	if es := loaded["es"]; es.SMS != "Código harbor: {{.Code}}" || es.Voice != defaultTemplates["es"].Voice ||
+		es.VoiceLanguage != "es-US" {
+		t.Errorf("expected the override to be merged, got %+v", es)
+	}
+}
+
+func TestNewSender(t *testing.T) {
+	if _, err := newSender("twilio", "", nil); err == nil {
+		t.Errorf("expected twilio to require a sender number")
+	}
+	if _, err := newSender("", "", nil); err == nil {
+		t.Errorf("expected the default provider to require a sender number")
+	}
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-1")}))
+	if _, err := newSender("sns", "", sess); err != nil {
+		t.Errorf("expected sns to send without a number, got %s", err)
+	}
+}
+
+func TestGeneratePhoneLimit(t *testing.T) {
+	fake, now := setup()
+
+	generate(t, "4155550100", "10.0.0.1")
+	res := generate(t, "4155550100", "10.0.0.2")
+	if res.StatusCode != 429 || res.Headers["Retry-After"] != "30" {
+		t.Fatalf("expected the resend cooldown, got %d %v", res.StatusCode, res.Headers)
+	}
+
+	for i := 1; i < lib.PhoneLimit.Max; i++ {
+		*now = now.Add(lib.PhoneLimit.Cooldown)
+		if res := generate(t, "4155550100", "10.0.0.1"); res.StatusCode != 201 {
+			t.Fatalf("expected send %d, got %d", i+1, res.StatusCode)
+		}
+	}
+
+	*now = now.Add(lib.PhoneLimit.Cooldown)
+	if res := generate(t, "4155550100", "10.0.0.1"); res.StatusCode != 429 {
+		t.Errorf("expected the hourly limit, got %d", res.StatusCode)
+	}
+	if len(fake.Sent) != lib.PhoneLimit.Max {
+		t.Errorf("expected %d messages, got %d", lib.PhoneLimit.Max, len(fake.Sent))
+	}
+}
+
+func TestGenerateIPLimit(t *testing.T) {
+	fake, _ := setup()
+
+	for i := 0; i < lib.IPLimit.Max; i++ {
+		phone := fmt.Sprintf("41555501%02d", i)
+		if res := generate(t, phone, "10.0.0.1"); res.StatusCode != 201 {
+			t.Fatalf("expected send %d, got %d", i+1, res.StatusCode)
+		}
+	}
+
+	if res := generate(t, "4155550199", "10.0.0.1"); res.StatusCode != 429 {
+		t.Errorf("expected the ip limit, got %d", res.StatusCode)
+	}
+	if res := generate(t, "4155550199", "10.0.0.2"); res.StatusCode != 201 {
+		t.Errorf("expected other ips to be counted apart, got %d", res.StatusCode)
+	}
+	if len(fake.Sent) != lib.IPLimit.Max+1 {
+		t.Errorf("expected %d messages, got %d", lib.IPLimit.Max+1, len(fake.Sent))
+	}
+}
+
+// failingSender is a provider that is down
+type failingSender struct{}
+
+func (failingSender) SendSMS(to, body string) error {
+	return fmt.Errorf("provider unavailable")
+}
+
+func TestGenerateReleasesFailedSends(t *testing.T) {
+	fake, now := setup()
+	sender = failingSender{}
+
+	for i := 0; i < lib.PhoneLimit.Max; i++ {
+		if res := generate(t, "4155550100", "10.0.0.1"); res.StatusCode != 502 {
+			t.Fatalf("expected the provider failure, got %d", res.StatusCode)
+		}
+		*now = now.Add(lib.PhoneLimit.Cooldown)
+	}
+
+	// none of the failed sends counted
+	sender = fake
+	if res := generate(t, "4155550100", "10.0.0.1"); res.StatusCode != 201 {
+		t.Errorf("expected the send once the provider is back, got %d", res.StatusCode)
+	}
+}
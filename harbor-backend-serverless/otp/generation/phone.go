@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ttacon/libphonenumber"
+)
+
+// numbers without a country code are read as US numbers
+const DEFAULT_REGION = "US"
+
+type phone struct {
+	// E164 is the number tokens are stored and sent under
+	E164   string
+	Region string
+	// Landline numbers can not receive SMS, (US) numbers which may be
+	// either are taken as mobile
+	Landline bool
+}
+
+func parsePhone(raw string) (*phone, error) {
+	number, err := libphonenumber.Parse(raw, DEFAULT_REGION)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse phone number(%s): %s", raw, err)
+	}
+	if !libphonenumber.IsValidNumber(number) {
+		return nil, fmt.Errorf("invalid phone number(%s)", raw)
+	}
+
+	switch libphonenumber.GetNumberType(number) {
+	case libphonenumber.PREMIUM_RATE, libphonenumber.SHARED_COST, libphonenumber.TOLL_FREE:
+		return nil, fmt.Errorf("unsupported phone number(%s)", raw)
+	}
+
+	return &phone{
+		E164:     libphonenumber.Format(number, libphonenumber.E164),
+		Region:   libphonenumber.GetRegionCodeForNumber(number),
+		Landline: libphonenumber.GetNumberType(number) == libphonenumber.FIXED_LINE,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	twilio "github.com/kevinburke/twilio-go"
+)
+
+// SMSSender delivers codes to E.164 numbers
+type SMSSender interface {
+	SendSMS(to, body string) error
+}
+
+// VoiceCaller is implemented by the senders which can read a code to
+// numbers that can not receive SMS
+type VoiceCaller interface {
+	Call(to, message, language string) error
+}
+
+// newSender returns the sender of the provider, sending from the
+// number when the provider needs one. SNS picks an origination number
+// of the account when there is none.
+func newSender(provider, from string, sess *session.Session) (SMSSender, error) {
+	switch provider {
+	case "", "twilio":
+		if from == "" {
+			return nil, fmt.Errorf("OTP_SENDER is required to send with twilio")
+		}
+		return &twilioSender{client: twilio.NewClient(twilioSID, twilioToken, nil), from: from}, nil
+	case "sns":
+		return &snsSender{client: sns.New(sess), from: from}, nil
+	case "fake":
+		return &fakeSender{}, nil
+	}
+	return nil, fmt.Errorf("unknown sms provider(%s)", provider)
+}
+
+type twilioSender struct {
+	client *twilio.Client
+	from   string
+}
+
+func (s *twilioSender) SendSMS(to, body string) error {
+	_, err := s.client.Messages.SendMessage(s.from, to, body, nil)
+	return err
+}
+
+func (s *twilioSender) Call(to, message, language string) error {
+	var say strings.Builder
+	xml.EscapeText(&say, []byte(message))
+	twiml := fmt.Sprintf(`<Response><Say language="%s">%s</Say></Response>`, language, say.String())
+
+	_, err := s.client.Calls.Create(context.Background(), url.Values{
+		"From":  {s.from},
+		"To":    {to},
+		"Twiml": {twiml},
+	})
+	return err
+}
+
+// snsSender can not place calls
+type snsSender struct {
+	client snsiface.SNSAPI
+	from   string
+}
+
+func (s *snsSender) SendSMS(to, body string) error {
+	attributes := map[string]*sns.MessageAttributeValue{
+		"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"), StringValue: aws.String("Transactional")},
+	}
+	if s.from != "" {
+		attributes["AWS.MM.SMS.OriginationNumber"] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(s.from),
+		}
+	}
+
+	_, err := s.client.Publish(&sns.PublishInput{
+		PhoneNumber:       aws.String(to),
+		Message:           aws.String(body),
+		MessageAttributes: attributes,
+	})
+	return err
+}
+
+type sentMessage struct {
+	To       string
+	Body     string
+	Voice    bool
+	Language string
+}
+
+// fakeSender prints and records the messages, for running locally and
+// for tests
+type fakeSender struct {
+	Sent []sentMessage
+}
+
+func (s *fakeSender) SendSMS(to, body string) error {
+	fmt.Printf("sms to %s: %s\n", to, body)
+	s.Sent = append(s.Sent, sentMessage{To: to, Body: body})
+	return nil
+}
+
+func (s *fakeSender) Call(to, message, language string) error {
+	fmt.Printf("call to %s (%s): %s\n", to, language, message)
+	s.Sent = append(s.Sent, sentMessage{To: to, Body: message, Voice: true, Language: language})
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const DEFAULT_LOCALE = "en"
+
+// Templates of a locale are rendered with .Code, and .SpokenCode which
+// separates the digits so they are read one by one
+type Templates struct {
+	SMS   string `json:"sms"`
+	Voice string `json:"voice"`
+	// VoiceLanguage is the BCP 47 language the voice message is read in
+	VoiceLanguage string `json:"voice_language"`
+}
+
+var defaultTemplates = map[string]Templates{
+	"en": {
+		SMS:           "Your harbor code is {{.Code}}",
+		Voice:         "Your harbor code is {{.SpokenCode}}. Again, your code is {{.SpokenCode}}.",
+		VoiceLanguage: "en-US",
+	},
+	"es": {
+		SMS:           "Tu código de harbor es {{.Code}}",
+		Voice:         "Tu código de harbor es {{.SpokenCode}}. Otra vez, tu código es {{.SpokenCode}}.",
+		VoiceLanguage: "es-US",
+	},
+}
+
+// loadTemplates overrides the default templates with the locales of
+// the raw json object, e.g. {"fr": {"sms": "...", "voice": "...",
+// "voice_language": "fr-FR"}}. Overrides are merged field by field
+// into the defaults of the locale, a locale missing any of the fields
+// afterwards is refused rather than sending empty texts or calls.
+func loadTemplates(raw string) (map[string]Templates, error) {
+	templates := map[string]Templates{}
+	for locale, t := range defaultTemplates {
+		templates[locale] = t
+	}
+	if raw == "" {
+		return templates, nil
+	}
+
+	var overrides map[string]Templates
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid templates: %s", err)
+	}
+	for locale, override := range overrides {
+		locale = strings.ToLower(locale)
+		t := templates[locale]
+		if override.SMS != "" {
+			t.SMS = override.SMS
+		}
+		if override.Voice != "" {
+			t.Voice = override.Voice
+		}
+		if override.VoiceLanguage != "" {
+			t.VoiceLanguage = override.VoiceLanguage
+		}
+
+		if t.SMS == "" || t.Voice == "" || t.VoiceLanguage == "" {
+			return nil, fmt.Errorf("invalid templates(%s): sms, voice and voice_language are required", locale)
+		}
+		for _, text := range []string{t.SMS, t.Voice} {
+			if _, err := template.New(locale).Parse(text); err != nil {
+				return nil, fmt.Errorf("invalid templates(%s): %s", locale, err)
+			}
+		}
+		templates[locale] = t
+	}
+	return templates, nil
+}
+
+// templatesFor falls back from a regional locale (es-MX) to its
+// language (es), then to DEFAULT_LOCALE
+func templatesFor(templates map[string]Templates, locale string) Templates {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if t, ok := templates[locale]; ok {
+		return t
+	}
+	if i := strings.Index(locale, "-"); i > 0 {
+		if t, ok := templates[locale[:i]]; ok {
+			return t
+		}
+	}
+	return templates[DEFAULT_LOCALE]
+}
+
+func render(text, otp string) (string, error) {
+	t, err := template.New("otp").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	err = t.Execute(&b, struct{ Code, SpokenCode string }{
+		Code:       otp,
+		SpokenCode: strings.Join(strings.Split(otp, ""), ", "),
+	})
+	return b.String(), err
+}
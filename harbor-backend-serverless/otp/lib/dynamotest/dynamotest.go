@@ -0,0 +1,436 @@
+// Package dynamotest is an in-memory stand-in for the dynamodb tables
+// of the otp functions. It implements GetItem, PutItem, UpdateItem and
+// DeleteItem for tables keyed by tokenName, with the condition and
+// update expressions the functions use.
+package dynamotest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const KEY = "tokenName"
+
+type item = map[string]*dynamodb.AttributeValue
+
+// DB panics on the operations it does not implement
+type DB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	tables map[string]map[string]item
+}
+
+func New() *DB {
+	return &DB{tables: map[string]map[string]item{}}
+}
+
+// Item returns a copy of the stored item, nil when missing
+func (db *DB) Item(table, name string) map[string]*dynamodb.AttributeValue {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return copyItem(db.tables[table][name])
+}
+
+func (db *DB) table(name *string) map[string]item {
+	t, ok := db.tables[aws.StringValue(name)]
+	if !ok {
+		t = map[string]item{}
+		db.tables[aws.StringValue(name)] = t
+	}
+	return t
+}
+
+func keyOf(key item) (string, error) {
+	v, ok := key[KEY]
+	if !ok || v.S == nil {
+		return "", awserr.New("ValidationException", "missing key "+KEY, nil)
+	}
+	return *v.S, nil
+}
+
+func copyItem(i item) item {
+	if i == nil {
+		return nil
+	}
+	c := item{}
+	for k, v := range i {
+		c[k] = v
+	}
+	return c
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func (db *DB) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key, err := keyOf(in.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.GetItemOutput{Item: copyItem(db.table(in.TableName)[key])}, nil
+}
+
+func (db *DB) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key, err := keyOf(in.Item)
+	if err != nil {
+		return nil, err
+	}
+	t := db.table(in.TableName)
+	e := &env{names: in.ExpressionAttributeNames, values: in.ExpressionAttributeValues, item: t[key]}
+	if ok, err := e.check(in.ConditionExpression); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, conditionFailed()
+	}
+
+	t[key] = copyItem(in.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (db *DB) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key, err := keyOf(in.Key)
+	if err != nil {
+		return nil, err
+	}
+	t := db.table(in.TableName)
+	e := &env{names: in.ExpressionAttributeNames, values: in.ExpressionAttributeValues, item: t[key]}
+	if ok, err := e.check(in.ConditionExpression); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, conditionFailed()
+	}
+
+	updated := copyItem(t[key])
+	if updated == nil {
+		updated = copyItem(in.Key)
+	}
+	if err := e.update(aws.StringValue(in.UpdateExpression), updated); err != nil {
+		return nil, err
+	}
+	t[key] = updated
+
+	out := &dynamodb.UpdateItemOutput{}
+	if aws.StringValue(in.ReturnValues) == dynamodb.ReturnValueAllNew {
+		out.Attributes = copyItem(updated)
+	}
+	return out, nil
+}
+
+func (db *DB) DeleteItem(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key, err := keyOf(in.Key)
+	if err != nil {
+		return nil, err
+	}
+	t := db.table(in.TableName)
+	e := &env{names: in.ExpressionAttributeNames, values: in.ExpressionAttributeValues, item: t[key]}
+	if ok, err := e.check(in.ConditionExpression); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, conditionFailed()
+	}
+
+	delete(t, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// env evaluates the expressions of a request against the stored item
+type env struct {
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+	item   item
+}
+
+func (e *env) check(expr *string) (bool, error) {
+	if expr == nil {
+		return true, nil
+	}
+	p := &parser{env: e, tokens: tokenize(*expr)}
+	ok, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return false, awserr.New("ValidationException", fmt.Sprintf("invalid condition %q: %s", *expr, err), nil)
+	}
+	return ok, nil
+}
+
+func (e *env) name(token string) string {
+	if strings.HasPrefix(token, "#") {
+		return aws.StringValue(e.names[token])
+	}
+	return token
+}
+
+// operand resolves a :value or an attribute, nil when missing
+func (e *env) operand(token string) *dynamodb.AttributeValue {
+	if strings.HasPrefix(token, ":") {
+		return e.values[token]
+	}
+	if e.item == nil {
+		return nil
+	}
+	return e.item[e.name(token)]
+}
+
+// update applies `SET a = x [+|- y], ...` and `ADD a :n` clauses
+func (e *env) update(expr string, updated item) error {
+	tokens := tokenize(expr)
+	invalid := func() error {
+		return awserr.New("ValidationException", fmt.Sprintf("invalid update %q", expr), nil)
+	}
+
+	clause := ""
+	for i := 0; i < len(tokens); {
+		switch strings.ToUpper(tokens[i]) {
+		case "SET", "ADD":
+			clause = strings.ToUpper(tokens[i])
+			i++
+			continue
+		case ",":
+			i++
+			continue
+		}
+
+		e.item = updated
+		switch clause {
+		case "SET":
+			if i+2 >= len(tokens) || tokens[i+1] != "=" {
+				return invalid()
+			}
+			attr := e.name(tokens[i])
+			v := e.operand(tokens[i+2])
+			i += 3
+			if i+1 < len(tokens) && (tokens[i] == "+" || tokens[i] == "-") {
+				sum, err := add(v, e.operand(tokens[i+1]), tokens[i] == "-")
+				if err != nil {
+					return invalid()
+				}
+				v = sum
+				i += 2
+			}
+			if v == nil {
+				return invalid()
+			}
+			updated[attr] = v
+		case "ADD":
+			if i+1 >= len(tokens) {
+				return invalid()
+			}
+			attr := e.name(tokens[i])
+			current := updated[attr]
+			if current == nil {
+				current = &dynamodb.AttributeValue{N: aws.String("0")}
+			}
+			sum, err := add(current, e.operand(tokens[i+1]), false)
+			if err != nil {
+				return invalid()
+			}
+			updated[attr] = sum
+			i += 2
+		default:
+			return invalid()
+		}
+	}
+	return nil
+}
+
+func add(a, b *dynamodb.AttributeValue, subtract bool) (*dynamodb.AttributeValue, error) {
+	if a == nil || b == nil || a.N == nil || b.N == nil {
+		return nil, fmt.Errorf("not numbers")
+	}
+	x, err := strconv.ParseFloat(*a.N, 64)
+	if err != nil {
+		return nil, err
+	}
+	y, err := strconv.ParseFloat(*b.N, 64)
+	if err != nil {
+		return nil, err
+	}
+	if subtract {
+		y = -y
+	}
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(x+y, 'f', -1, 64))}, nil
+}
+
+func tokenize(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("(),+-=", c):
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("<>", c):
+			if i+1 < len(expr) && (expr[i+1] == '=' || (c == '<' && expr[i+1] == '>')) {
+				tokens = append(tokens, expr[i:i+2])
+				i += 2
+			} else {
+				tokens = append(tokens, string(c))
+				i++
+			}
+		default:
+			j := i
+			for j < len(expr) && !unicode.IsSpace(rune(expr[j])) && !strings.ContainsRune("(),+-=<>", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// parser is a recursive descent over
+//
+//	or         = and { OR and }
+//	and        = not { AND not }
+//	not        = [ NOT ] primary
+//	primary    = ( or ) | function ( operand ) | operand comparator operand
+type parser struct {
+	env    *env
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("expected %q, got %q", t, got)
+	}
+	return nil
+}
+
+func (p *parser) or() (bool, error) {
+	ok, err := p.and()
+	for err == nil && strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		var right bool
+		right, err = p.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (p *parser) and() (bool, error) {
+	ok, err := p.not()
+	for err == nil && strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		var right bool
+		right, err = p.not()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (p *parser) not() (bool, error) {
+	if strings.EqualFold(p.peek(), "NOT") {
+		p.next()
+		ok, err := p.primary()
+		return !ok, err
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (bool, error) {
+	t := p.next()
+	switch t {
+	case "(":
+		ok, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		return ok, p.expect(")")
+	case "attribute_exists", "attribute_not_exists":
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		v := p.env.operand(p.next())
+		if err := p.expect(")"); err != nil {
+			return false, err
+		}
+		return (v != nil) == (t == "attribute_exists"), nil
+	case "":
+		return false, fmt.Errorf("unexpected end")
+	}
+
+	left := p.env.operand(t)
+	op := p.next()
+	right := p.env.operand(p.next())
+	return compare(left, op, right)
+}
+
+// compare is false when either side is missing, as in dynamodb
+func compare(a *dynamodb.AttributeValue, op string, b *dynamodb.AttributeValue) (bool, error) {
+	var c int
+	switch {
+	case a == nil || b == nil:
+		return false, nil
+	case a.N != nil && b.N != nil:
+		x, _ := strconv.ParseFloat(*a.N, 64)
+		y, _ := strconv.ParseFloat(*b.N, 64)
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	case a.S != nil && b.S != nil:
+		c = strings.Compare(*a.S, *b.S)
+	default:
+		return false, nil
+	}
+
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparator %q", op)
+}
@@ -0,0 +1,5 @@
+module github.com/helloharbor/harbor-backend-serverless/otp/lib
+
+go 1.15
+
+require github.com/aws/aws-sdk-go v1.36.24
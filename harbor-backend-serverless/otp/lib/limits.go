@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// SendLimit allows Max sends per Window for a key, at least Cooldown
+// apart
+type SendLimit struct {
+	Prefix   string
+	Max      int
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+var (
+	PhoneLimit = SendLimit{Prefix: "otp-sends:phone:", Max: 5, Window: time.Hour, Cooldown: 30 * time.Second}
+	IPLimit    = SendLimit{Prefix: "otp-sends:ip:", Max: 20, Window: time.Hour}
+)
+
+type sendCounter struct {
+	Sends       int   `json:"sends"`
+	WindowStart int64 `json:"windowStart"`
+	LastSent    int64 `json:"lastSent"`
+}
+
+// AllowSend counts a send for key, returning how long to wait when
+// the limit is reached and nothing is counted
+func (t *Tokens) AllowSend(limit SendLimit, key string) (time.Duration, error) {
+	now := t.now()
+	name := limit.Prefix + key
+	ttlNames := map[string]*string{"#ttl": aws.String("ttl")}
+
+	// a new window
+	_, err := t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                      nameKey(name),
+		TableName:                aws.String(TOKENS_TABLE),
+		UpdateExpression:         aws.String("SET sends = :one, windowStart = :now, lastSent = :now, #ttl = :ttl"),
+		ConditionExpression:      aws.String("attribute_not_exists(tokenName) OR windowStart <= :windowFloor"),
+		ExpressionAttributeNames: ttlNames,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":         num(1),
+			":now":         num(now.Unix()),
+			":ttl":         num(now.Add(limit.Window).Unix()),
+			":windowFloor": num(now.Add(-limit.Window).Unix()),
+		},
+	})
+	if err == nil {
+		return 0, nil
+	} else if !isConditionFailed(err) {
+		return 0, fmt.Errorf("could not count send(%s): %s", name, err)
+	}
+
+	// or the current one
+	_, err = t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 nameKey(name),
+		TableName:           aws.String(TOKENS_TABLE),
+		UpdateExpression:    aws.String("SET sends = sends + :one, lastSent = :now"),
+		ConditionExpression: aws.String("sends < :max AND lastSent <= :cooldownFloor"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":           num(1),
+			":now":           num(now.Unix()),
+			":max":           num(int64(limit.Max)),
+			":cooldownFloor": num(now.Add(-limit.Cooldown).Unix()),
+		},
+	})
+	if err == nil {
+		return 0, nil
+	} else if !isConditionFailed(err) {
+		return 0, fmt.Errorf("could not count send(%s): %s", name, err)
+	}
+
+	return t.retryAfter(limit, name, now)
+}
+
+// ReleaseSend gives back a send counted by AllowSend that was never
+// delivered. The cooldown still applies, a failing provider is not
+// retried in a loop.
+func (t *Tokens) ReleaseSend(limit SendLimit, key string) error {
+	name := limit.Prefix + key
+	_, err := t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 nameKey(name),
+		TableName:           aws.String(TOKENS_TABLE),
+		UpdateExpression:    aws.String("SET sends = sends - :one"),
+		ConditionExpression: aws.String("sends > :zero"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":  num(1),
+			":zero": num(0),
+		},
+	})
+	if err != nil && !isConditionFailed(err) {
+		return fmt.Errorf("could not release send(%s): %s", name, err)
+	}
+	return nil
+}
+
+func (t *Tokens) retryAfter(limit SendLimit, name string, now time.Time) (time.Duration, error) {
+	result, err := t.DB.GetItem(&dynamodb.GetItemInput{
+		Key:            nameKey(name),
+		TableName:      aws.String(TOKENS_TABLE),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error getting send counter(%s): %s", name, err)
+	} else if result.Item == nil {
+		return limit.Cooldown, nil
+	}
+
+	var c sendCounter
+	if err = dynamodbattribute.UnmarshalMap(result.Item, &c); err != nil {
+		return 0, fmt.Errorf("error parsing send counter(%s): %s", name, err)
+	}
+
+	until := time.Unix(c.LastSent, 0).Add(limit.Cooldown)
+	if c.Sends >= limit.Max {
+		until = time.Unix(c.WindowStart, 0).Add(limit.Window)
+	}
+
+	// a send may have been counted in between, retry shortly
+	wait := until.Sub(now)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait, nil
+}
@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const TOKENS_TABLE = "tokens"
+
+// the tokens table holds, all expiring through the ttl attribute:
+//
+//	{nonce}        the phone number the code was sent to
+//	{phone}        the last code sent to the phone number, and the
+//	               verification attempts made against it with any
+//	               nonce
+//	otp-sends:...  the send counters of SendLimit
+type Token struct {
+	Name     string `json:"tokenName"`
+	Value    string `json:"tokenValue,omitempty"`
+	TTL      int64  `json:"ttl,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+type Tokens struct {
+	DB dynamodbiface.DynamoDBAPI
+
+	// overridden by tests
+	Now func() time.Time
+}
+
+func (t *Tokens) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func nameKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"tokenName": {S: aws.String(name)}}
+}
+
+func num(n int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+}
+
+// Get returns the token, nil when missing or expired. Expired items
+// linger until dynamodb deletes them.
+func (t *Tokens) Get(name string) (*Token, error) {
+	result, err := t.DB.GetItem(&dynamodb.GetItemInput{
+		Key:            nameKey(name),
+		TableName:      aws.String(TOKENS_TABLE),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting token(%s): %s", name, err)
+	} else if result.Item == nil {
+		return nil, nil
+	}
+
+	var token Token
+	if err = dynamodbattribute.UnmarshalMap(result.Item, &token); err != nil {
+		return nil, fmt.Errorf("error parsing token(%s): %s", name, err)
+	}
+	if len(token.Value) == 0 || !t.now().Before(time.Unix(token.TTL, 0)) {
+		return nil, nil
+	}
+
+	return &token, nil
+}
+
+// Put stores the token for ttl, replacing any previous one
+func (t *Tokens) Put(name, value string, ttl time.Duration) error {
+	i, _ := dynamodbattribute.MarshalMap(Token{
+		Name:  name,
+		Value: value,
+		TTL:   t.now().Add(ttl).Unix(),
+	})
+	_, err := t.DB.PutItem(&dynamodb.PutItemInput{
+		Item:      i,
+		TableName: aws.String(TOKENS_TABLE),
+	})
+	if err != nil {
+		return fmt.Errorf("could not store token(%s): %s", name, err)
+	}
+	return nil
+}
+
+// Attempt counts a verification attempt against the code of the
+// phone and returns the code it was counted against, nil once max
+// attempts were made and the code is locked until it expires or a new
+// one is sent
+func (t *Tokens) Attempt(phone string, max int) (*Token, error) {
+	result, err := t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 nameKey(phone),
+		TableName:           aws.String(TOKENS_TABLE),
+		UpdateExpression:    aws.String("ADD attempts :one"),
+		ConditionExpression: aws.String("attribute_exists(tokenValue) AND (attribute_not_exists(attempts) OR attempts < :max)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": num(1),
+			":max": num(int64(max)),
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionFailed(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not count attempt of token(%s): %s", phone, err)
+	}
+
+	var token Token
+	if err = dynamodbattribute.UnmarshalMap(result.Attributes, &token); err != nil {
+		return nil, fmt.Errorf("error parsing token(%s): %s", phone, err)
+	}
+	return &token, nil
+}
+
+// Consume deletes the nonce and the code of the phone, false when a
+// concurrent verification consumed them first. A newer code sent to
+// the phone is kept.
+func (t *Tokens) Consume(nonce, phone, otp string) (bool, error) {
+	_, err := t.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:                 nameKey(nonce),
+		TableName:           aws.String(TOKENS_TABLE),
+		ConditionExpression: aws.String("attribute_exists(tokenName)"),
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not delete token(%s): %s", nonce, err)
+	}
+
+	_, err = t.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:                 nameKey(phone),
+		TableName:           aws.String(TOKENS_TABLE),
+		ConditionExpression: aws.String("tokenValue = :otp"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":otp": {S: aws.String(otp)},
+		},
+	})
+	if err != nil && !isConditionFailed(err) {
+		return false, fmt.Errorf("could not delete token(%s): %s", phone, err)
+	}
+
+	return true, nil
+}
+
+// Matches compares codes in constant time
+func Matches(expected, provided string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(provided)) == 1
+}
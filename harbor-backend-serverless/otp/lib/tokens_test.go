@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib/dynamotest"
+)
+
+func setup() (*Tokens, *dynamotest.DB, *time.Time) {
+	now := time.Date(2021, 12, 1, 12, 0, 0, 0, time.UTC)
+	db := dynamotest.New()
+	return &Tokens{DB: db, Now: func() time.Time { return now }}, db, &now
+}
+
+func TestGetExpired(t *testing.T) {
+	tokens, _, now := setup()
+	if err := tokens.Put("+15555550100", "123456", 5*time.Minute); err != nil {
+		t.Fatalf("failed storing: %s", err)
+	}
+
+	if token, err := tokens.Get("+15555550100"); err != nil || token == nil || token.Value != "123456" {
+		t.Fatalf("expected the token, got %+v: %v", token, err)
+	}
+
+	*now = now.Add(5 * time.Minute)
+	if token, err := tokens.Get("+15555550100"); err != nil || token != nil {
+		t.Errorf("expected the expired token to be missing, got %+v: %v", token, err)
+	}
+}
+
+func TestAttemptLocks(t *testing.T) {
+	tokens, _, _ := setup()
+	if token, err := tokens.Attempt("+15555550100", 3); err != nil || token != nil {
+		t.Fatalf("expected a missing code to be refused, got %+v: %v", token, err)
+	}
+
+	tokens.Put("+15555550100", "123456", 5*time.Minute)
+	for i := 0; i < 3; i++ {
+		if token, err := tokens.Attempt("+15555550100", 3); err != nil || token == nil || token.Value != "123456" {
+			t.Fatalf("expected attempt %d to be allowed, got %+v: %v", i+1, token, err)
+		}
+	}
+	if token, err := tokens.Attempt("+15555550100", 3); err != nil || token != nil {
+		t.Errorf("expected the code to be locked, got %+v: %v", token, err)
+	}
+	if token, _ := tokens.Get("+15555550100"); token == nil || token.Attempts != 3 {
+		t.Errorf("expected 3 counted attempts, got %+v", token)
+	}
+
+	// a new code starts over
+	tokens.Put("+15555550100", "654321", 5*time.Minute)
+	if token, err := tokens.Attempt("+15555550100", 3); err != nil || token == nil || token.Value != "654321" {
+		t.Errorf("expected the new code to be allowed, got %+v: %v", token, err)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	tokens, db, _ := setup()
+	tokens.Put("nonce", "+15555550100", 5*time.Minute)
+	tokens.Put("+15555550100", "123456", 5*time.Minute)
+
+	if ok, err := tokens.Consume("nonce", "+15555550100", "123456"); err != nil || !ok {
+		t.Fatalf("expected the code to be consumed, got %v: %v", ok, err)
+	}
+	if db.Item(TOKENS_TABLE, "nonce") != nil || db.Item(TOKENS_TABLE, "+15555550100") != nil {
+		t.Errorf("expected both tokens to be deleted")
+	}
+	if ok, err := tokens.Consume("nonce", "+15555550100", "123456"); err != nil || ok {
+		t.Errorf("expected the code to be consumed once, got %v: %v", ok, err)
+	}
+
+	// a code sent since is kept
+	tokens.Put("nonce", "+15555550100", 5*time.Minute)
+	tokens.Put("+15555550100", "654321", 5*time.Minute)
+	if ok, _ := tokens.Consume("nonce", "+15555550100", "123456"); !ok {
+		t.Fatalf("expected the nonce to be consumed")
+	}
+	if db.Item(TOKENS_TABLE, "+15555550100") == nil {
+		t.Errorf("expected the newer code to be kept")
+	}
+}
+
+func TestAllowSend(t *testing.T) {
+	tokens, _, now := setup()
+	limit := SendLimit{Prefix: "test:", Max: 3, Window: time.Hour, Cooldown: 30 * time.Second}
+
+	if wait, err := tokens.AllowSend(limit, "key"); err != nil || wait != 0 {
+		t.Fatalf("expected the first send, got %s: %v", wait, err)
+	}
+	if wait, _ := tokens.AllowSend(limit, "key"); wait != 30*time.Second {
+		t.Errorf("expected the cooldown, got %s", wait)
+	}
+
+	for i := 0; i < 2; i++ {
+		*now = now.Add(30 * time.Second)
+		if wait, err := tokens.AllowSend(limit, "key"); err != nil || wait != 0 {
+			t.Fatalf("expected send %d, got %s: %v", i+2, wait, err)
+		}
+	}
+
+	*now = now.Add(30 * time.Second)
+	if wait, _ := tokens.AllowSend(limit, "key"); wait != time.Hour-90*time.Second {
+		t.Errorf("expected to wait for the window, got %s", wait)
+	}
+	if wait, _ := tokens.AllowSend(limit, "other"); wait != 0 {
+		t.Errorf("expected other keys to be counted apart, got %s", wait)
+	}
+
+	*now = now.Add(time.Hour)
+	if wait, _ := tokens.AllowSend(limit, "key"); wait != 0 {
+		t.Errorf("expected a new window, got %s", wait)
+	}
+}
+
+func TestReleaseSend(t *testing.T) {
+	tokens, _, now := setup()
+	limit := SendLimit{Prefix: "test:", Max: 2, Window: time.Hour, Cooldown: 30 * time.Second}
+
+	tokens.AllowSend(limit, "key")
+	if err := tokens.ReleaseSend(limit, "key"); err != nil {
+		t.Fatalf("failed releasing: %s", err)
+	}
+	if wait, _ := tokens.AllowSend(limit, "key"); wait != 30*time.Second {
+		t.Errorf("expected the cooldown to still apply, got %s", wait)
+	}
+
+	// the released send is not counted
+	for i := 0; i < 2; i++ {
+		*now = now.Add(30 * time.Second)
+		if wait, err := tokens.AllowSend(limit, "key"); err != nil || wait != 0 {
+			t.Fatalf("expected send %d, got %s: %v", i+1, wait, err)
+		}
+	}
+
+	// nothing to give back
+	if err := tokens.ReleaseSend(limit, "other"); err != nil {
+		t.Errorf("expected releasing an unknown key to be a no-op, got %s", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	if !Matches("123456", "123456") || Matches("123456", "123457") || Matches("123456", "12345") {
+		t.Errorf("unexpected comparison")
+	}
+}
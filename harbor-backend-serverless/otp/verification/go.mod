@@ -1,8 +1,11 @@
 require (
 	github.com/aws/aws-lambda-go v1.13.3
 	github.com/aws/aws-sdk-go v1.36.24
+	github.com/helloharbor/harbor-backend-serverless/otp/lib v0.0.0
 )
 
+replace github.com/helloharbor/harbor-backend-serverless/otp/lib => ../lib
+
 module otp-verification
 
 go 1.15
@@ -5,19 +5,20 @@ import (
 	"fmt"
 	"os"
 	"strconv"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib"
 )
 
-const TOKENS_TABLE = "tokens"
+// a code is locked once it was guessed this many times, with any of
+// the nonces sent to the phone
+const MAX_ATTEMPTS = 5
 
-var db *dynamodb.DynamoDB
+var tokens *lib.Tokens
 
 type ErrorBody struct {
 	ErrorMsg string `json:"error"`
@@ -28,20 +29,6 @@ type OtpVerificationRequest struct {
 	OTP   string `json:"otp"`
 }
 
-type Token struct {
-	Name  string `json:"tokenName"`
-	Value string `json:"tokenValue,omitempty"`
-	TTL   int64  `json:"ttl,omitempty"`
-}
-
-func (k *Token) valid() bool {
-	if len(k.Value) == 0 {
-		return false
-	}
-	ttl := time.Unix(k.TTL, 0)
-	return time.Now().UTC().Before(ttl)
-}
-
 func makeResponse(status int, v bool) (*events.APIGatewayProxyResponse, error) {
 	return &events.APIGatewayProxyResponse{
 		StatusCode: status,
@@ -62,52 +49,45 @@ func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse
 		return makeResponse(400, false)
 	}
 
-	key, _ := dynamodbattribute.MarshalMap(Token{Name: o.Nonce})
-	result, err := db.GetItem(&dynamodb.GetItemInput{
-		Key:       key,
-		TableName: aws.String(TOKENS_TABLE),
-	})
+	nonce, err := tokens.Get(o.Nonce)
 	if err != nil {
-		fmt.Printf("error getting token(%s): %s\n", o.Nonce, err)
+		fmt.Printf("%s\n", err)
 		return makeResponse(500, false)
-	} else if result.Item == nil {
+	} else if nonce == nil {
 		fmt.Printf("token(%s) not found\n", o.Nonce)
 		return makeResponse(404, false)
 	}
 
-	var t1 Token
-	if err = dynamodbattribute.UnmarshalMap(result.Item, &t1); err != nil {
-		fmt.Printf("error parsing token(%s): %s\n", o.Nonce, err)
+	if otp, err := tokens.Get(nonce.Value); err != nil {
+		fmt.Printf("%s\n", err)
 		return makeResponse(500, false)
-	} else if !t1.valid() {
-		fmt.Printf("token(%+v) is invalid\n", t1)
+	} else if otp == nil {
+		fmt.Printf("otp of token(%s) not found\n", o.Nonce)
 		return makeResponse(404, false)
 	}
 
-	key, _ = dynamodbattribute.MarshalMap(Token{Name: t1.Value})
-	result, err = db.GetItem(&dynamodb.GetItemInput{
-		Key:       key,
-		TableName: aws.String(TOKENS_TABLE),
-	})
+	// counted on the code before comparing, so concurrent guesses and
+	// guesses spread over several nonces are bounded too
+	otp, err := tokens.Attempt(nonce.Value, MAX_ATTEMPTS)
 	if err != nil {
-		fmt.Printf("error getting token(%s): %s\n", t1.Value, err)
+		fmt.Printf("%s\n", err)
 		return makeResponse(500, false)
-	} else if result.Item == nil {
-		fmt.Printf("token(%s) not found\n", t1.Value)
-		return makeResponse(404, false)
+	} else if otp == nil {
+		fmt.Printf("otp of token(%s) is locked after %d attempts\n", o.Nonce, MAX_ATTEMPTS)
+		return makeResponse(429, false)
 	}
 
-	var t2 Token
-	if err = dynamodbattribute.UnmarshalMap(result.Item, &t2); err != nil {
-		fmt.Printf("error parsing token(%s): %s\n", t1.Value, err)
-		return makeResponse(500, false)
-	} else if !t2.valid() {
-		fmt.Printf("token(%+v) is invalid\n", t2)
+	if !lib.Matches(otp.Value, o.OTP) {
+		fmt.Printf("provided otp for token(%s) did not match\n", o.Nonce)
 		return makeResponse(404, false)
 	}
 
-	if t2.Value != o.OTP {
-		fmt.Printf("provided otp: %s did not match: %s\n", t2.Value, o.OTP)
+	consumed, err := tokens.Consume(o.Nonce, nonce.Value, otp.Value)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return makeResponse(500, false)
+	} else if !consumed {
+		fmt.Printf("token(%s) was already used\n", o.Nonce)
 		return makeResponse(404, false)
 	}
 
@@ -115,9 +95,13 @@ func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse
 }
 
 func init() {
-	db = dynamodb.New(session.Must(session.NewSession(&aws.Config{
+	if os.Getenv("TESTING") == "1" {
+		return
+	}
+
+	tokens = &lib.Tokens{DB: dynamodb.New(session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(os.Getenv("AWS_REGION")),
-	})))
+	})))}
 }
 
 func main() {
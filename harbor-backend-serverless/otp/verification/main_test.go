@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib"
+	"github.com/helloharbor/harbor-backend-serverless/otp/lib/dynamotest"
+)
+
+const phone = "5555550100"
+
+func setup(t *testing.T) *dynamotest.DB {
+	db := dynamotest.New()
+	tokens = &lib.Tokens{DB: db}
+	if err := tokens.Put("nonce", phone, 5*time.Minute); err != nil {
+		t.Fatalf("failed storing nonce: %s", err)
+	}
+	if err := tokens.Put(phone, "123456", 5*time.Minute); err != nil {
+		t.Fatalf("failed storing otp: %s", err)
+	}
+	return db
+}
+
+func verify(t *testing.T, nonce, otp string) int {
+	res, err := handler(events.APIGatewayProxyRequest{
+		Body: fmt.Sprintf(`{"nonce": "%s", "otp": "%s"}`, nonce, otp),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return res.StatusCode
+}
+
+func TestVerifyOnce(t *testing.T) {
+	db := setup(t)
+
+	if status := verify(t, "nonce", "123456"); status != 200 {
+		t.Fatalf("expected the otp to verify, got %d", status)
+	}
+	if db.Item(lib.TOKENS_TABLE, "nonce") != nil || db.Item(lib.TOKENS_TABLE, phone) != nil {
+		t.Errorf("expected the tokens to be consumed")
+	}
+	if status := verify(t, "nonce", "123456"); status != 404 {
+		t.Errorf("expected a replay to be refused, got %d", status)
+	}
+}
+
+func TestVerifyLockout(t *testing.T) {
+	setup(t)
+
+	for i := 0; i < MAX_ATTEMPTS; i++ {
+		if status := verify(t, "nonce", "000000"); status != 404 {
+			t.Fatalf("expected attempt %d to mismatch, got %d", i+1, status)
+		}
+	}
+	if status := verify(t, "nonce", "123456"); status != 429 {
+		t.Errorf("expected the code to be locked, got %d", status)
+	}
+}
+
+func TestVerifyLockoutAcrossNonces(t *testing.T) {
+	setup(t)
+	// every send hands out another nonce for the phone
+	for _, nonce := range []string{"second", "third"} {
+		if err := tokens.Put(nonce, phone, 5*time.Minute); err != nil {
+			t.Fatalf("failed storing nonce: %s", err)
+		}
+	}
+
+	nonces := []string{"nonce", "second", "third"}
+	for i := 0; i < MAX_ATTEMPTS; i++ {
+		if status := verify(t, nonces[i%len(nonces)], "000000"); status != 404 {
+			t.Fatalf("expected attempt %d to mismatch, got %d", i+1, status)
+		}
+	}
+	for _, nonce := range nonces {
+		if status := verify(t, nonce, "123456"); status != 429 {
+			t.Errorf("expected the code to be locked for %s, got %d", nonce, status)
+		}
+	}
+
+	// a new code can be verified again
+	if err := tokens.Put(phone, "654321", 5*time.Minute); err != nil {
+		t.Fatalf("failed storing otp: %s", err)
+	}
+	if status := verify(t, "third", "654321"); status != 200 {
+		t.Errorf("expected the new code to verify, got %d", status)
+	}
+}
+
+func TestVerifyUnknownNonce(t *testing.T) {
+	setup(t)
+
+	if status := verify(t, "other", "123456"); status != 404 {
+		t.Errorf("expected an unknown nonce to be refused, got %d", status)
+	}
+	if status := verify(t, "", "123456"); status != 400 {
+		t.Errorf("expected a missing nonce to be refused, got %d", status)
+	}
+}
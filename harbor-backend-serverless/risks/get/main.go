@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-redis/redis/v8"
 	"github.com/helloharbor/golang-lib/form-inputs/meta"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -24,9 +25,12 @@ func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
 	riskID := req.PathParameters["id"]
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, onBehalfOf := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
+	if onBehalfOf {
+		oStr = getOwnershipsStr(userID)
+	}
 	hhID := hhLib.GetCurrentHouseholdID(userID, rDB, pgDB)
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
 
 	maxVersion := "1"
 	v, ok := req.QueryStringParameters["maxPlanBuilderVersion"]
@@ -0,0 +1,13 @@
+package main
+
+import (
+	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
+)
+
+func getOwnershipsStr(userID string) string {
+	idsJSON, err := hhLib.GetOwnershipsJSON(userID, rDB, pgDB)
+	if err != nil {
+		panic(err)
+	}
+	return idsJSON
+}
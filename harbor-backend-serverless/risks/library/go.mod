@@ -1,10 +1,17 @@
 require (
-	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-lambda-go v1.24.0
 	github.com/aws/aws-sdk-go v1.37.11 // indirect
-	github.com/jmoiron/sqlx v1.2.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
+	github.com/helloharbor/harbor-backend-serverless/households/lib v0.0.0
+	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.8.0
 )
 
 module library-risks
 
 go 1.15
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
+	github.com/helloharbor/harbor-backend-serverless/households/lib => ../../households/lib
+)
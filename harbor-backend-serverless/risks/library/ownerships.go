@@ -0,0 +1,15 @@
+package main
+
+import (
+	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
+)
+
+// only admins acting on behalf of a user look their ownerships up,
+// rarely enough to skip the redis cache
+func getOwnershipsStr(userID string) string {
+	idsJSON, err := hhLib.GetOwnershipsJSON(userID, nil, pgDB)
+	if err != nil {
+		panic(err)
+	}
+	return idsJSON
+}
@@ -1,5 +1,6 @@
 require (
-	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-lambda-go v1.24.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/lib/pq v1.8.0
 )
@@ -7,3 +8,5 @@ require (
 module onboarding-risks
 
 go 1.15
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
@@ -9,6 +9,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -111,13 +112,13 @@ order by
     rp.risk_level desc,
     name;
 `
-	uID, ok := gRR.RequestContext.Authorizer["userID"]
-	if !ok {
+	if _, ok := gRR.RequestContext.Authorizer[authLib.ContextUserID]; !ok {
 		return &events.APIGatewayProxyResponse{
 			StatusCode: 400,
 			Body:       "missing UserId from request context",
 		}, errors.New("missing UserId from request context")
 	}
+	uID, _ := authLib.ActingUserID(gRR)
 
 	results := []*RiskRow{}
 	err = db.SelectContext(ctx, &results, query, uID)
@@ -1,9 +1,16 @@
 require (
-	github.com/aws/aws-lambda-go v1.22.0
-	github.com/jmoiron/sqlx v1.3.1
+	github.com/aws/aws-lambda-go v1.24.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
+	github.com/helloharbor/harbor-backend-serverless/households/lib v0.0.0
+	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.2.0
 )
 
 module risks-readiness
 
 go 1.15
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
+	github.com/helloharbor/harbor-backend-serverless/households/lib => ../../households/lib
+)
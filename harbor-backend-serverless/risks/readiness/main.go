@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -27,10 +28,13 @@ type RespBody struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, onBehalfOf := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
+	if onBehalfOf {
+		oStr = getOwnershipsStr(userID)
+	}
 
 	var ownerships []int64
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
 	json.Unmarshal([]byte(oStr), &ownerships)
 
 	query, args, _ := sqlx.In(query, ownerships)
@@ -6,7 +6,13 @@ require (
 	github.com/aws/aws-lambda-go v1.26.0
 	github.com/go-redis/redis/v8 v8.11.2
 	github.com/helloharbor/golang-lib/form-inputs/meta v0.0.0-20211216211413-8929e00670f6
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/helloharbor/harbor-backend-serverless/households/lib v0.0.0-20210902031241-cc2fefd59c6b
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 )
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../auth/lib
+	github.com/helloharbor/harbor-backend-serverless/households/lib => ../households/lib
+)
@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-redis/redis/v8"
 	"github.com/helloharbor/golang-lib/form-inputs/meta"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -30,10 +31,13 @@ type Snack struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
+	userID, onBehalfOf := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
+	if onBehalfOf {
+		oStr = getOwnershipsStr(userID)
+	}
 	hhID := hhLib.GetCurrentHouseholdID(userID, rDB, pgDB)
 	themeID := req.PathParameters["id"]
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
 
 	maxVersion := "1"
 	v, ok := req.QueryStringParameters["maxPlanBuilderVersion"]
@@ -7,7 +7,13 @@ require (
 	github.com/aws/aws-sdk-go v1.40.40
 	github.com/go-redis/redis/v8 v8.11.3
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/helloharbor/harbor-backend-serverless/households/lib v0.0.0-20210826183052-3ad535ec0f2d
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 )
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../auth/lib
+	github.com/helloharbor/harbor-backend-serverless/households/lib => ../households/lib
+)
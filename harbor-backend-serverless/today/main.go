@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	lambdaSVC "github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/go-redis/redis/v8"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	hhLib "github.com/helloharbor/harbor-backend-serverless/households/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -71,13 +72,10 @@ type Week struct {
 func handler(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse, error,
 ) {
-	userID := req.RequestContext.Authorizer["userID"].(string)
-	oStr := req.RequestContext.Authorizer["allUserOwnershipsJSON"].(string)
-
-	// harbor admin can request info about a user
-	onBehalfOf := req.QueryStringParameters["userID"]
-	if onBehalfOf != "" && userID == "1" {
-		userID = onBehalfOf
+	// admins can request info about a user
+	userID, onBehalfOf := authLib.ActingUserID(req)
+	oStr := req.RequestContext.Authorizer[authLib.ContextOwnerships].(string)
+	if onBehalfOf {
 		oStr = getOwnershipsStr(userID)
 	}
 
@@ -3,8 +3,13 @@ module weather-events-get-all
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/aws/aws-lambda-go v1.25.0
 	github.com/go-redis/redis/v8 v8.11.0
-	github.com/golang/geo v0.0.0-20210211234256-740aa86cb551
+	github.com/helloharbor/harbor-workers/alertmodel v1.2.0
 	github.com/sirupsen/logrus v1.8.1
 )
+
+// the alert model is shared with the ipaws workers, built from this
+// checkout rather than the tagged version
+replace github.com/helloharbor/harbor-workers/alertmodel => ../../../harbor-workers/alertmodel
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -15,6 +16,9 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+
 	"weather-events-get-all/models"
 )
 
@@ -22,6 +26,7 @@ var (
 	redisConn *redis.Client
 	stdFields map[string]interface{}
 
+	alertBufferKm  float64
 	ctx            = context.Background()
 	allEventsQName = os.Getenv("REDIS_ALL_EVENTS_KEY")
 )
@@ -38,7 +43,7 @@ func handler(awsCtx context.Context, req events.APIGatewayProxyRequest) (*events
 		}, nil
 	}
 
-	cachedAlerts, err := getCachedAlerts()
+	cachedAlerts, err := getCachedAlerts(*lat, *long)
 	if err != nil {
 		log.WithFields(stdFields).WithFields(log.Fields{"err": err}).Fatal("getCachedAlerts failed")
 	}
@@ -60,32 +65,90 @@ func handler(awsCtx context.Context, req events.APIGatewayProxyRequest) (*events
 	}, nil
 }
 
-func getCachedAlerts() (*[]models.WeatherAlert, error) {
-	strArray, err := redisConn.LRange(ctx, allEventsQName, 0, -1).Result()
+// alerts are indexed by the s2 cells covering them, only the alerts
+// sharing a cell with the caller are fetched. expired alerts are
+// dropped here since ingest only evicts once per run.
+func getCachedAlerts(lat float64, long float64) (*[]alertmodel.ShortAlertMsg, error) {
+	var cellKeys []string
+	for _, token := range geo.PointCellTokens(lat, long, alertBufferKm) {
+		cellKeys = append(cellKeys, allEventsQName+":cell:"+token)
+	}
+	candidates, err := redisConn.SUnion(ctx, cellKeys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("sunion failed: %s", err)
+	}
+	if len(candidates) == 0 {
+		return &[]alertmodel.ShortAlertMsg{}, nil
+	}
+
+	pipe := redisConn.Pipeline()
+	scores := make([]*redis.FloatCmd, len(candidates))
+	for i, id := range candidates {
+		scores[i] = pipe.ZScore(ctx, allEventsQName+":active", id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("zscore failed: %s", err)
+	}
+
+	now := float64(time.Now().Unix())
+	var ids []string
+	for i, id := range candidates {
+		if expires, err := scores[i].Result(); err == nil && expires > now {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return &[]alertmodel.ShortAlertMsg{}, nil
+	}
+
+	vals, err := redisConn.HMGet(ctx, allEventsQName+":alerts", ids...).Result()
 	if err != nil {
-		return nil, fmt.Errorf("lrange failed: %s", err)
+		return nil, fmt.Errorf("hmget failed: %s", err)
 	}
 
-	var alertMsgArr []models.WeatherAlert
-	alertMsg := models.WeatherAlert{}
-	for _, str := range strArray {
-		err := json.Unmarshal([]byte(str), &alertMsg)
-		if err != nil {
+	var alertMsgArr []alertmodel.ShortAlertMsg
+	for _, val := range vals {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+		var alertMsgs []alertmodel.ShortAlertMsg
+		if err := json.Unmarshal([]byte(str), &alertMsgs); err != nil {
 			return nil, err
 		}
-		alertMsgArr = append(alertMsgArr, alertMsg)
+		alertMsgArr = append(alertMsgArr, alertMsgs...)
 	}
 
 	return &alertMsgArr, nil
 }
 
-func findEvents(lat float64, long float64, alerts *[]models.WeatherAlert) (*[]models.WeatherEventsResponse, error) {
+func findEvents(lat float64, long float64, alerts *[]alertmodel.ShortAlertMsg) (*[]models.WeatherEventsResponse, error) {
 	resp := []models.WeatherEventsResponse{}
 
 	for _, alert := range *alerts {
+		// ingest categorizes with the database rules, codes without
+		// a level are looked up in the table this version knows
+		cat := alert.Categorization
+		if cat.Level == "" {
+			var ok bool
+			if cat, ok = alertmodel.Categorize(cat.Code); !ok {
+				log.WithFields(stdFields).WithFields(log.Fields{"id": alert.Identifier, "code": cat.Code}).Warn("uncategorized alert")
+				continue
+			}
+		}
+
+		if alert.Geometry != nil && len(alert.Geometry.Vertices) >= 3 {
+			if alert.Geometry.ContainsPoint(lat, long, alertBufferKm) {
+				resp = append(resp, getEventResponse(alert, cat))
+			}
+			continue
+		}
+
+		// alerts queued before geometries were added only
+		// carry the inflated bounding box
 		pStr := alert.BoundingBox
 		if pStr != "" {
-			bbr, err := GetBoundingBoxFromString(alert.BoundingBox)
+			bbr, err := geo.GetBoundingBoxFromString(alert.BoundingBox)
 			if err != nil {
 				return nil, err
 			}
@@ -94,20 +157,7 @@ func findEvents(lat float64, long float64, alerts *[]models.WeatherAlert) (*[]mo
 				return &resp, nil
 			}
 			if bbr.ContainsPoint(lat, long) {
-				wer := models.WeatherEventsResponse{
-					ID:     alert.Identifier,
-					RefIds: alert.RefIds,
-					Categorization: models.WeatherEventCategorization{
-						Text:     alert.Categorization.Text,
-						Category: alert.Categorization.Category,
-						Code:     alert.Categorization.Code,
-						Level:    alert.Categorization.Level,
-					},
-					OnsetTime:      alert.OnsetTime,
-					ExpirationTime: alert.ExpirationTime,
-				}
-
-				resp = append(resp, wer)
+				resp = append(resp, getEventResponse(alert, cat))
 			}
 		}
 	}
@@ -115,6 +165,21 @@ func findEvents(lat float64, long float64, alerts *[]models.WeatherAlert) (*[]mo
 	return &resp, nil
 }
 
+func getEventResponse(alert alertmodel.ShortAlertMsg, cat alertmodel.AlertCategorization) models.WeatherEventsResponse {
+	return models.WeatherEventsResponse{
+		ID:     alert.Identifier,
+		RefIds: alert.RefIds,
+		Categorization: models.WeatherEventCategorization{
+			Text:     cat.Text,
+			Category: cat.Category,
+			Code:     cat.Code,
+			Level:    cat.Level,
+		},
+		OnsetTime:      alert.OnsetTime,
+		ExpirationTime: alert.ExpirationTime,
+	}
+}
+
 func getCoordinates(qParam string) (*float64, *float64, error) {
 	if qParam == "" {
 		return nil, nil, fmt.Errorf("query param latlong missing")
@@ -143,8 +208,28 @@ func setCtxFields(awsCtx context.Context) {
 }
 
 func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
 	opt, _ := redis.ParseURL(os.Getenv("REDIS_URL"))
 	redisConn = redis.NewClient(opt)
+
+	alertBufferKm = envFloat("ALERT_BUFFER_KM", 0)
+}
+
+// envFloat reads a float like env.Float of the ipaws workers, the
+// buffer has to match the one of the alert notifier
+func envFloat(key string, def float64) float64 {
+	str := os.Getenv(key)
+	if str == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		panic(fmt.Errorf("%s malformed: %s", key, err))
+	}
+	return v
 }
 
 func main() {
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+)
+
+// a tornado warning over Moore, OK
+const moore = "35.17,-97.53 35.24,-97.42 35.30,-97.47 35.17,-97.53"
+
+// inside the polygon, and outside of it about 6km south of its
+// eastern edge, in the same index cells
+var (
+	inside  = [2]float64{35.24, -97.47}
+	outside = [2]float64{35.17, -97.42}
+)
+
+func setup(t *testing.T) *miniredis.Miniredis {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+
+	redisConn = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	allEventsQName = "alerts"
+	alertBufferKm = 0
+	t.Cleanup(func() { alertBufferKm = 0 })
+
+	return mr
+}
+
+// cacheAlert indexes the alert the way ingest's alert state does
+func cacheAlert(t *testing.T, mr *miniredis.Miniredis, id string, polygon string, expires time.Time) {
+	geom, err := geo.GetPolygonFromString(polygon)
+	if err != nil {
+		t.Fatalf("failed parsing polygon: %s", err)
+	}
+	b, _ := json.Marshal([]alertmodel.ShortAlertMsg{{
+		Identifier:     id,
+		Categorization: alertmodel.AlertCategorization{Code: "TOR"},
+		Polygon:        polygon,
+		Geometry:       geom,
+	}})
+
+	mr.HSet(allEventsQName+":alerts", id, string(b))
+	mr.ZAdd(allEventsQName+":active", float64(expires.Unix()), id)
+	for _, token := range geom.CellTokens() {
+		mr.SAdd(allEventsQName+":cell:"+token, id)
+	}
+}
+
+func lookup(t *testing.T, point [2]float64) (candidates []string, found []string) {
+	alerts, err := getCachedAlerts(point[0], point[1])
+	if err != nil {
+		t.Fatalf("getCachedAlerts failed: %s", err)
+	}
+	for _, alert := range *alerts {
+		candidates = append(candidates, alert.Identifier)
+	}
+
+	resp, err := findEvents(point[0], point[1], alerts)
+	if err != nil {
+		t.Fatalf("findEvents failed: %s", err)
+	}
+	for _, event := range *resp {
+		found = append(found, event.ID)
+	}
+	return candidates, found
+}
+
+func TestGetCachedAlertsDropsExpiredAlerts(t *testing.T) {
+	mr := setup(t)
+	cacheAlert(t, mr, "active", moore, time.Now().Add(time.Hour))
+	cacheAlert(t, mr, "expired", moore, time.Now().Add(-time.Minute))
+
+	candidates, found := lookup(t, inside)
+	if len(candidates) != 1 || candidates[0] != "active" {
+		t.Errorf("expected only the active alert, got %v", candidates)
+	}
+	if len(found) != 1 || found[0] != "active" {
+		t.Errorf("expected the active alert found, got %v", found)
+	}
+}
+
+func TestFindEventsMatchesThePolygonNotTheCell(t *testing.T) {
+	mr := setup(t)
+	cacheAlert(t, mr, "a", moore, time.Now().Add(time.Hour))
+
+	candidates, found := lookup(t, outside)
+	if len(candidates) != 1 {
+		t.Fatalf("expected the alert sharing the point's cell, got %v", candidates)
+	}
+	if len(found) != 0 {
+		t.Errorf("expected a point outside the polygon not to match, got %v", found)
+	}
+
+	if _, found = lookup(t, inside); len(found) != 1 {
+		t.Errorf("expected a point inside the polygon to match, got %v", found)
+	}
+}
+
+func TestFindEventsBufferEdge(t *testing.T) {
+	mr := setup(t)
+	cacheAlert(t, mr, "a", moore, time.Now().Add(time.Hour))
+
+	for _, tc := range []struct {
+		bufferKm float64
+		match    bool
+	}{
+		{0, false},
+		{5, false},
+		{7, true},
+		{50, true},
+	} {
+		alertBufferKm = tc.bufferKm
+		if _, found := lookup(t, outside); (len(found) == 1) != tc.match {
+			t.Errorf("buffer %vkm: expected match %t, got %v", tc.bufferKm, tc.match, found)
+		}
+	}
+}
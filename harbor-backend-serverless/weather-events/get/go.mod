@@ -5,6 +5,13 @@ go 1.15
 require (
 	github.com/aws/aws-lambda-go v1.24.0
 	github.com/go-redis/redis/v8 v8.11.0
+	github.com/helloharbor/harbor-workers/alertmodel v1.2.0
+	github.com/jmoiron/sqlx v1.3.4
+	github.com/lib/pq v1.10.3
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect
 )
+
+// the alert model is shared with the ipaws workers, built from this
+// checkout rather than the tagged version
+replace github.com/helloharbor/harbor-workers/alertmodel => ../../../harbor-workers/alertmodel
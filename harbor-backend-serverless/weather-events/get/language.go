@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+
+	"weather-events-get/models"
+)
+
+// parseAcceptLanguage returns the accepted language tags lowercased,
+// most preferred first. Tags with q=0 and the wildcard are dropped.
+func parseAcceptLanguage(header string) []string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err == nil {
+				q = v
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		langs = append(langs, accepted{tag: tag, q: q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	var tags []string
+	for _, l := range langs {
+		tags = append(tags, l.tag)
+	}
+
+	return tags
+}
+
+func primarySubtag(tag string) string {
+	return strings.SplitN(strings.ToLower(tag), "-", 2)[0]
+}
+
+// selectInfo returns the cap info block that best matches the accepted
+// languages, an exact tag beats a primary subtag match. Without a
+// match the primary info block is kept.
+func selectInfo(msg alertmodel.AlertMsg, accepted []string) alertmodel.InfoMsg {
+	for _, tag := range accepted {
+		for _, info := range msg.Infos {
+			if strings.ToLower(info.Language) == tag {
+				return info
+			}
+		}
+		for _, info := range msg.Infos {
+			if primarySubtag(info.Language) == primarySubtag(tag) {
+				return info
+			}
+		}
+	}
+
+	return msg.Info
+}
+
+// translationFor returns the translation table for the language of
+// the selected info block, the categorization texts must not be in a
+// different language than the headline and description
+func translationFor(lang string) string {
+	if _, ok := models.AlertTranslations[primarySubtag(lang)]; ok {
+		return primarySubtag(lang)
+	}
+
+	return models.DefaultLanguage
+}
+
+// localizeCategorization translates the display texts, category and
+// level stay the english identifiers clients key off
+func localizeCategorization(code string, mapping alertmodel.AlertData, lang string) models.WeatherEventCategorization {
+	cat := models.WeatherEventCategorization{
+		Text:         mapping.Text,
+		Category:     string(mapping.Category),
+		CategoryText: string(mapping.Category),
+		Code:         code,
+		Level:        string(mapping.Level),
+		LevelText:    string(mapping.Level),
+	}
+
+	for _, l := range []string{models.DefaultLanguage, lang} {
+		tr, ok := models.AlertTranslations[l]
+		if !ok {
+			continue
+		}
+		if text, ok := tr.Texts[code]; ok {
+			cat.Text = text
+		}
+		if text, ok := tr.Categories[mapping.Category]; ok {
+			cat.CategoryText = text
+		}
+		if text, ok := tr.Levels[mapping.Level]; ok {
+			cat.LevelText = text
+		}
+	}
+
+	return cat
+}
+
+// getHeader reads a header case insensitively, api gateway passes
+// them through as the client sent them
+func getHeader(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+
+	return ""
+}
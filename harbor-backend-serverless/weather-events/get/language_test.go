@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+
+	"weather-events-get/models"
+)
+
+const bilingualAlert = `{
+	"identifier": "tor.1",
+	"status": "Actual",
+	"msgType": "Alert",
+	"info": {
+		"language": "en-US",
+		"eventCode": {"value": "TOR"},
+		"onset": "2021-05-03T18:42:00-05:00",
+		"expires": "2021-05-03T19:15:00-05:00",
+		"headline": "Tornado Warning issued May 3",
+		"description": "At 642 PM CDT, a severe thunderstorm capable of producing a tornado was located near Norman.",
+		"instruction": "TAKE COVER NOW!",
+		"areas": [{"polygons": ["35.17,-97.53 35.24,-97.42 35.3,-97.47 35.17,-97.53"]}]
+	},
+	"infos": [
+		{"language": "en-US", "headline": "Tornado Warning issued May 3", "instruction": "TAKE COVER NOW!"},
+		{"language": "es-US", "headline": "Aviso de tornado emitido el 3 de mayo", "instruction": "¡RESGUÁRDESE AHORA!"}
+	]
+}`
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := strings.Join(parseAcceptLanguage("en;q=0.5, es-MX, fr;q=0, *;q=0.1, de;q=0.8"), ",")
+	if got != "es-mx,de,en" {
+		t.Fatalf("unexpected languages %s", got)
+	}
+	if langs := parseAcceptLanguage(""); len(langs) != 0 {
+		t.Fatalf("expected no languages, got %v", langs)
+	}
+}
+
+func TestHydrateAlertResponseLanguage(t *testing.T) {
+	t.Run("spanish info block and categorization", func(t *testing.T) {
+		resp, err := hydrateAlertResponse(bilingualAlert, parseAcceptLanguage("es-MX,es;q=0.9,en;q=0.5"))
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if resp.Language != "es-US" || resp.Instructions != "¡RESGUÁRDESE AHORA!" {
+			t.Errorf("expected the spanish block, got %s %s", resp.Language, resp.Instructions)
+		}
+		cat := resp.Categorization
+		if cat.Text != "Aviso de tornado" || cat.CategoryText != "Tornados" || cat.LevelText != "Aviso" {
+			t.Errorf("expected spanish texts, got %+v", cat)
+		}
+		if cat.Category != string(alertmodel.TORNADOES) || cat.Level != string(alertmodel.WARNING) {
+			t.Errorf("expected category and level to stay identifiers, got %+v", cat)
+		}
+		// shared with the primary block
+		if len(resp.Polygon) != 4 || resp.OnsetTime == "" {
+			t.Errorf("expected the primary area and times, got %+v", resp)
+		}
+	})
+
+	t.Run("unsupported languages fall back to english", func(t *testing.T) {
+		resp, err := hydrateAlertResponse(bilingualAlert, parseAcceptLanguage("fr-FR"))
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if resp.Language != "en-US" || resp.Categorization.Text != "Tornado Warning" ||
+			resp.Categorization.LevelText != "Warning" {
+			t.Errorf("expected english, got %+v", resp)
+		}
+	})
+
+	t.Run("categorization follows the info block", func(t *testing.T) {
+		noSpanish := strings.Replace(bilingualAlert, `"language": "es-US"`, `"language": "de-DE"`, 1)
+		resp, err := hydrateAlertResponse(noSpanish, parseAcceptLanguage("es,en;q=0.5"))
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if resp.Language != "en-US" || resp.Categorization.Text != "Tornado Warning" ||
+			resp.Categorization.CategoryText != "Tornadoes" {
+			t.Errorf("expected english texts with the english block, got %+v", resp)
+		}
+	})
+}
+
+func TestSpanishCoversEveryCode(t *testing.T) {
+	for code := range alertmodel.AlertCodeToCategorization {
+		if _, ok := models.AlertTranslations["es"].Texts[code]; !ok {
+			t.Errorf("missing spanish text for %s", code)
+		}
+	}
+}
+
+func TestHydrateAlertResponseCategorization(t *testing.T) {
+	alert := strings.Replace(bilingualAlert, `"TOR"`, `"AQA"`, 1)
+	if _, err := hydrateAlertResponse(alert, nil); err == nil {
+		t.Errorf("expected an uncategorized code to fail")
+	}
+
+	// ingest sets the categorization from the rules
+	alert = strings.Replace(alert, `"msgType": "Alert",`,
+		`"msgType": "Alert", "categorization": {"text": "Air Quality Alert", "category": "Heatwaves", "code": "AQA", "level": "WARNING"},`, 1)
+	resp, err := hydrateAlertResponse(alert, parseAcceptLanguage("es"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	cat := resp.Categorization
+	if cat.Text != "Air Quality Alert" || cat.Level != string(alertmodel.WARNING) || cat.CategoryText != "Olas de calor" {
+		t.Errorf("expected the cached categorization, got %+v", cat)
+	}
+}
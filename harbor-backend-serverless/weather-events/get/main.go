@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -12,37 +13,71 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+
 	"weather-events-get/models"
 )
 
 var (
+	pgDB      *sqlx.DB
 	redisConn *redis.Client
 	stdFields map[string]interface{}
 
-	ctx       = context.Background()
-	traceID = ""
+	ctx            = context.Background()
+	traceID        = ""
+	allEventsQName = os.Getenv("REDIS_ALL_EVENTS_KEY")
 )
 
+// guards against a reference loop in the update chain
+const maxChainDepth = 20
+
 func handler(awsCtx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 	id := req.PathParameters["id"]
 	setCtxFields(awsCtx)
 
-	cachedVal, err := getCachedEvent(id)
+	currentID, active, err := getActiveEventID(id)
 	if err != nil {
 		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
-			Fatal("failed getting cached event")
-	} else if cachedVal == nil {
-		return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+			Fatal("failed resolving current event")
+	}
+
+	var cachedVal *string
+	if active {
+		cachedVal, err = getCachedEvent(currentID)
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
+				Fatal("failed getting cached event")
+		}
+	} else {
+		log.WithFields(stdFields).WithFields(log.Fields{"alertID": id, "currentID": currentID}).
+			Info("event no longer active")
 	}
 
-	resp, err := hydrateAlertResponse(*cachedVal)
+	// alerts are only cached for a day, older and inactive events
+	// are served from the archive
+	if cachedVal == nil {
+		cachedVal, err = getArchivedEvent(currentID)
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
+				Fatal("failed getting archived event")
+		} else if cachedVal == nil {
+			return &events.APIGatewayProxyResponse{StatusCode: 404}, nil
+		}
+	}
+
+	accepted := parseAcceptLanguage(getHeader(req.Headers, "Accept-Language"))
+	resp, err := hydrateAlertResponse(*cachedVal, accepted)
 	if err != nil {
 		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
 			Fatal("failed to hydrate alert response")
 	}
+	resp.Expired = !active
 	byteResp, err := json.Marshal(resp)
 
 	log.WithFields(stdFields).WithFields(log.Fields{"resp": resp}).Info()
@@ -50,10 +85,38 @@ func handler(awsCtx context.Context, req events.APIGatewayProxyRequest) (*events
 	return &events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Body:       string(byteResp),
-		Headers:    map[string]string{"Content-Type": "application/json"},
+		Headers: map[string]string{
+			"Content-Type":     "application/json",
+			"Content-Language": resp.Language,
+			"Vary":             "Accept-Language",
+		},
 	}, nil
 }
 
+// follows updates to the latest version of an event, which is only
+// active until it expires or is cancelled
+func getActiveEventID(alertID string) (string, bool, error) {
+	currentID := alertID
+	for i := 0; i < maxChainDepth; i++ {
+		nextID, err := redisConn.Get(ctx, allEventsQName+":superseded:"+currentID).Result()
+		if err == redis.Nil {
+			break
+		} else if err != nil {
+			return "", false, fmt.Errorf("unexpected error during redis fetch(%s), %s", currentID, err)
+		}
+		currentID = nextID
+	}
+
+	expires, err := redisConn.ZScore(ctx, allEventsQName+":active", currentID).Result()
+	if err == redis.Nil {
+		return currentID, false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("unexpected error during redis zscore(%s), %s", currentID, err)
+	}
+
+	return currentID, int64(expires) > time.Now().Unix(), nil
+}
+
 func getCachedEvent(alertID string) (*string, error) {
 	res, err := redisConn.Get(ctx, alertID).Result()
 	if err == redis.Nil {
@@ -67,8 +130,43 @@ func getCachedEvent(alertID string) (*string, error) {
 	return &res, nil
 }
 
-func hydrateAlertResponse(cachedVal string) (*models.WeatherEventResponse, error) {
-	incomingMsg := models.RedisAlertMsg{}
+// follows updates through the archive to the latest version that is
+// not a cancel, a cancel carries no details of its own
+const archivedEventQuery = `
+with recursive chain as (
+	select id, superseded_by, msg_type, alert, 0 as depth
+	from weather_alerts
+	where id = $1
+	union all
+	select w.id, w.superseded_by, w.msg_type, w.alert, c.depth + 1
+	from weather_alerts w
+	join chain c on w.id = c.superseded_by
+	where c.depth < $2
+)
+select alert
+from chain
+where lower(msg_type) != 'cancel'
+order by depth desc
+limit 1`
+
+func getArchivedEvent(alertID string) (*string, error) {
+	var res string
+	err := pgDB.Get(&res, archivedEventQuery, alertID, maxChainDepth)
+	if err == sql.ErrNoRows {
+		log.WithFields(stdFields).WithFields(log.Fields{"alertID": alertID}).
+			Warn("archive miss")
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unexpected error during archive fetch(%s), %s", alertID, err)
+	}
+
+	return &res, nil
+}
+
+// hydrateAlertResponse serves the cap info block and categorization
+// texts in the most preferred of the accepted languages available
+func hydrateAlertResponse(cachedVal string, accepted []string) (*models.WeatherEventResponse, error) {
+	incomingMsg := alertmodel.AlertMsg{}
 	err := json.Unmarshal([]byte(cachedVal), &incomingMsg)
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshall incoming alert\n %s", cachedVal)
@@ -77,8 +175,16 @@ func hydrateAlertResponse(cachedVal string) (*models.WeatherEventResponse, error
 	// check to see if event code refers to the national weather service
 	// if yes, get the code from the NWS event code value
 	eventCode := incomingMsg.Info.EventCode.Value
-	codeMapping, ok := models.AlertCodeToCategorization[eventCode]
-	if !ok {
+	codeMapping, ok := alertmodel.AlertCodeToCategorization[eventCode]
+	// ingest categorizes with the database rules, alerts cached before
+	// that only have the table
+	if cat := incomingMsg.Categorization; cat != nil {
+		codeMapping = alertmodel.AlertData{
+			Text:     cat.Text,
+			Level:    alertmodel.AlertLevel(cat.Level),
+			Category: alertmodel.AlertCategory(cat.Category),
+		}
+	} else if !ok {
 		return nil, fmt.Errorf("code %s does not exist in cateogry mapping", eventCode)
 	}
 
@@ -86,24 +192,23 @@ func hydrateAlertResponse(cachedVal string) (*models.WeatherEventResponse, error
 	alertResp.ID = incomingMsg.Identifier
 	alertResp.RefIds = incomingMsg.References
 	alertResp.Status = incomingMsg.Status
-	alertResp.Categorization = models.WeatherEventCategorization{
-		Text: codeMapping.Text,
-		Category: string(codeMapping.Category),
-		Code: eventCode,
-		Level: string(codeMapping.Level),
-	}
-	alertResp.Headline = incomingMsg.Info.Headline
-	alertResp.Description = incomingMsg.Info.Description
-	alertResp.Instructions = incomingMsg.Info.Instruction
-	alertResp.Polygon, err = extractCoordsFromPolygon(incomingMsg.Info.Area.Polygon)
+	// translated blocks repeat the codes, times and areas of the
+	// primary block, only the texts differ
+	info := selectInfo(incomingMsg, accepted)
+	alertResp.Categorization = localizeCategorization(eventCode, codeMapping, translationFor(info.Language))
+	alertResp.Language = info.Language
+	alertResp.Headline = info.Headline
+	alertResp.Description = info.Description
+	alertResp.Instructions = info.Instruction
+	alertResp.Polygon, err = extractCoordsFromPolygon(getFirstPolygon(incomingMsg.Info.Areas))
 	if err != nil {
 		return nil, err
 	}
-	alertResp.OnsetTime, err = getUTC3339(incomingMsg.Info.Onset)
+	alertResp.OnsetTime, err = parse.UTC3339(incomingMsg.Info.Onset)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse onset time(%s), %s", incomingMsg.Info.Onset, err)
 	}
-	alertResp.ExpirationTime, err = getUTC3339(incomingMsg.Info.Expires)
+	alertResp.ExpirationTime, err = parse.UTC3339(incomingMsg.Info.Expires)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse expiration time(%s), %s", incomingMsg.Info.Onset, err)
 	}
@@ -111,14 +216,15 @@ func hydrateAlertResponse(cachedVal string) (*models.WeatherEventResponse, error
 	return &alertResp, nil
 }
 
-func getUTC3339(alertTimeMsg string) (string, error){
-	isoTime, err := time.Parse(time.RFC3339, alertTimeMsg)
-	if err != nil {
-		return "", err
+
+func getFirstPolygon(areas []alertmodel.AreaMsg) string {
+	for _, area := range areas {
+		if len(area.Polygons) != 0 {
+			return area.Polygons[0]
+		}
 	}
-	formatted := isoTime.UTC().Format("2006-01-02T15:04:05.000Z0700")
 
-	return formatted, nil
+	return ""
 }
 
 func extractCoordsFromPolygon(polygonStr string) ([]models.WeatherEventCoords, error) {
@@ -153,6 +259,16 @@ func setCtxFields(awsCtx context.Context) {
 }
 
 func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
+	d, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		panic(err)
+	}
+	pgDB = d
+
 	opt, err := redis.ParseURL(os.Getenv("REDIS_URL"))
 	if err != nil {
 		panic(fmt.Errorf("unable to connect to redis: %s", err))
@@ -0,0 +1,141 @@
+package models
+
+import "github.com/helloharbor/harbor-workers/alertmodel"
+
+// AlertTranslation localizes the categorization of alerts. Texts is
+// keyed by event code like alertmodel.AlertCodeToCategorization, a missing entry
+// falls back to the english text.
+type AlertTranslation struct {
+	Texts      map[string]string
+	Levels     map[alertmodel.AlertLevel]string
+	Categories map[alertmodel.AlertCategory]string
+}
+
+// DefaultLanguage is served when none of the accepted languages are
+// supported
+const DefaultLanguage = "en"
+
+// AlertTranslations is keyed by primary language subtag, english is
+// the categorization table itself
+var AlertTranslations = map[string]AlertTranslation{
+	DefaultLanguage: {
+		Levels: map[alertmodel.AlertLevel]string{
+			alertmodel.CLEAR:     "Clear",
+			alertmodel.AWARE:     "Aware",
+			alertmodel.WATCH:     "On Watch",
+			alertmodel.WARNING:   "Warning",
+			alertmodel.DANGEROUS: "Dangerous",
+		},
+		Categories: map[alertmodel.AlertCategory]string{
+			alertmodel.EARTHQUAKE:   "Earthquake",
+			alertmodel.FLOODS:       "Floods",
+			alertmodel.HEATWAVES:    "Heatwaves",
+			alertmodel.HURRICANES:   "Hurricanes",
+			alertmodel.NONE:         "None",
+			alertmodel.TORNADOES:    "Tornadoes",
+			alertmodel.TSUNAMIS:     "Tsunamis",
+			alertmodel.VOLCANO:      "Volcano",
+			alertmodel.WILDFIRE:     "Wildfire",
+			alertmodel.WINTERSTORMS: "Winter Storms",
+		},
+	},
+	"es": {
+		Levels: map[alertmodel.AlertLevel]string{
+			alertmodel.CLEAR:     "Sin peligro",
+			alertmodel.AWARE:     "Atención",
+			alertmodel.WATCH:     "En vigilancia",
+			alertmodel.WARNING:   "Aviso",
+			alertmodel.DANGEROUS: "Peligroso",
+		},
+		Categories: map[alertmodel.AlertCategory]string{
+			alertmodel.EARTHQUAKE:   "Terremoto",
+			alertmodel.FLOODS:       "Inundaciones",
+			alertmodel.HEATWAVES:    "Olas de calor",
+			alertmodel.HURRICANES:   "Huracanes",
+			alertmodel.NONE:         "Ninguna",
+			alertmodel.TORNADOES:    "Tornados",
+			alertmodel.TSUNAMIS:     "Tsunamis",
+			alertmodel.VOLCANO:      "Volcán",
+			alertmodel.WILDFIRE:     "Incendios forestales",
+			alertmodel.WINTERSTORMS: "Tormentas invernales",
+		},
+		Texts: map[string]string{
+			// WEATHER-RELATED EVENTS
+			"BZW":  "Aviso de ventisca",
+			"CFA":  "Vigilancia de inundación costera",
+			"CFW":  "Aviso de inundación costera",
+			"DSW":  "Aviso de tormenta de polvo",
+			"EHA":  "Vigilancia de calor extremo",
+			"EHW":  "Aviso de calor extremo",
+			"EWW":  "Aviso de viento extremo",
+			"FAY":  "Advertencia de inundación repentina",
+			"FFA":  "Vigilancia de inundación repentina",
+			"FFW":  "Aviso de inundación repentina",
+			"FFS":  "Declaración de inundación repentina",
+			"FLA":  "Vigilancia de inundación",
+			"FLW":  "Aviso de inundación",
+			"FLS":  "Declaración de inundación",
+			"HWA":  "Vigilancia de vientos fuertes",
+			"HWW":  "Aviso de vientos fuertes",
+			"HUA":  "Vigilancia de huracán",
+			"HUW":  "Aviso de huracán",
+			"HLS":  "Declaración de huracán",
+			"HTY":  "Advertencia de calor",
+			"MWS":  "Declaración meteorológica marina",
+			"SVA":  "Vigilancia de tormenta eléctrica severa",
+			"SVR":  "Aviso de tormenta eléctrica severa",
+			"SVS":  "Declaración de tiempo severo",
+			"SQW2": "Aviso de ráfagas de nieve",
+			"SMW":  "Aviso marino especial",
+			"SPS":  "Declaración meteorológica especial",
+			"SSA":  "Vigilancia de marejada ciclónica",
+			"SSW":  "Aviso de marejada ciclónica",
+			"TOA":  "Vigilancia de tornado",
+			"TOR":  "Aviso de tornado",
+			"TRA":  "Vigilancia de tormenta tropical",
+			"TRW":  "Aviso de tormenta tropical",
+			"TSA":  "Vigilancia de tsunami",
+			"TSW":  "Aviso de tsunami",
+			"WSA":  "Vigilancia de tormenta invernal",
+			"WSW":  "Aviso de tormenta invernal",
+			"WIY":  "Advertencia de viento",
+			// NON-WEATHER RELATED EVENTS
+			"AVA":  "Vigilancia de avalancha",
+			"AVW":  "Aviso de avalancha",
+			"BHS":  "Declaración de peligros en la playa",
+			"BLU":  "Alerta azul",
+			"BWY":  "Advertencia de vientos bruscos",
+			"CAE":  "Emergencia por secuestro de menor",
+			"CDW":  "Aviso de peligro civil",
+			"CEM":  "Mensaje de emergencia civil",
+			"CFS":  "Declaración de inundación costera",
+			"CFY":  "Advertencia de inundación costera",
+			"DSY":  "Advertencia de polvo",
+			"EQW":  "Aviso de terremoto",
+			"ESF":  "Perspectiva hidrológica",
+			"EVI":  "Evacuación inmediata",
+			"FGY":  "Advertencia de niebla densa",
+			"FLY":  "Advertencia de inundación",
+			"FRW":  "Aviso de incendio",
+			"FWA":  "Vigilancia de tiempo propicio para incendios",
+			"FWW":  "Aviso de bandera roja (incendio)",
+			"GLA":  "Vigilancia de vendaval",
+			"GLW":  "Aviso de vendaval",
+			"HMW":  "Aviso de materiales peligrosos",
+			"LEW":  "Aviso de las fuerzas del orden",
+			"LAE":  "Emergencia en el área local",
+			"MAW":  "Aviso marino especial",
+			"MFY":  "Advertencia de niebla densa",
+			"TOE":  "Emergencia por interrupción del 911",
+			"NUW":  "Aviso de planta de energía nuclear",
+			"RPS":  "Declaración de corrientes de resaca",
+			"RHW":  "Aviso de peligro radiológico",
+			"SCY":  "Advertencia para embarcaciones pequeñas",
+			"SEW":  "Aviso de mar peligroso",
+			"SPW":  "Aviso de refugio en el lugar",
+			"SUY":  "Advertencia de oleaje alto",
+			"VOW":  "Aviso de volcán",
+			"NULL": "Marcador de posición",
+		},
+	},
+}
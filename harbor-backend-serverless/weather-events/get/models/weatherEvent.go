@@ -1,9 +1,11 @@
 package models
 
 type WeatherEventResponse struct {
-	ID             string                     `json:"id"`
-	RefIds         []string                   `json:"referenceIDs"`
-	Status         string                     `json:"status"`
+	ID     string   `json:"id"`
+	RefIds []string `json:"referenceIDs"`
+	Status string   `json:"status"`
+	// the language of the headline, description and instructions
+	Language       string                     `json:"language"`
 	Categorization WeatherEventCategorization `json:"categorization"`
 	Headline       string                     `json:"headline"`
 	Description    string                     `json:"description"`
@@ -11,16 +13,20 @@ type WeatherEventResponse struct {
 	Polygon        []WeatherEventCoords       `json:"polygon"`
 	OnsetTime      string                     `json:"onsetTime"`
 	ExpirationTime string                     `json:"expirationTime"`
+	// served from the archive, no longer active
+	Expired bool `json:"expired"`
 }
 
 type WeatherEventCategorization struct {
-	Text     string `json:"text"`
-	Category string `json:"category"`
-	Code     string `json:"code"`
-	Level    string `json:"level"`
+	Text         string `json:"text"`
+	Category     string `json:"category"`
+	CategoryText string `json:"categoryText"`
+	Code         string `json:"code"`
+	Level        string `json:"level"`
+	LevelText    string `json:"levelText"`
 }
 
 type WeatherEventCoords struct {
 	Lat  string `json:"lat"`
 	Long string `json:"long"`
-}
\ No newline at end of file
+}
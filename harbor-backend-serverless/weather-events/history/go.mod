@@ -0,0 +1,18 @@
+module weather-events-history
+
+go 1.15
+
+require (
+	github.com/aws/aws-lambda-go v1.24.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
+	github.com/helloharbor/harbor-workers/alertmodel v1.2.0
+	github.com/jmoiron/sqlx v1.3.4
+	github.com/lib/pq v1.10.3
+)
+
+replace (
+	github.com/helloharbor/harbor-backend-serverless/auth/lib => ../../auth/lib
+	// the alert model is shared with the ipaws workers, built from this
+	// checkout rather than the tagged version
+	github.com/helloharbor/harbor-workers/alertmodel => ../../../harbor-workers/alertmodel
+)
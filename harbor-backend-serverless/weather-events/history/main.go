@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"weather-events-history/models"
+)
+
+var pgDB *sqlx.DB
+
+const (
+	defaultRange = time.Hour * 24 * 90
+	defaultLimit = 50
+	maxLimit     = 200
+	// candidates fetched before the exact polygon test, bounds are
+	// loose so this sits well above the page size
+	maxCandidates = 1000
+)
+
+type historyRow struct {
+	ID           string         `db:"id"`
+	ChainID      string         `db:"chain_id"`
+	Refs         pq.StringArray `db:"refs"`
+	SupersededBy *string        `db:"superseded_by"`
+	Sent         *time.Time     `db:"sent"`
+	EventCode    *string        `db:"event_code"`
+	Category     *string        `db:"category"`
+	Level        *string        `db:"level"`
+	Text         *string        `db:"text"`
+	Headline     *string        `db:"headline"`
+	AreaDesc     *string        `db:"area_desc"`
+	Onset        *time.Time     `db:"onset"`
+	Expires      *time.Time     `db:"expires"`
+	Polygons     pq.StringArray `db:"polygons"`
+	Lat          float64        `db:"latitude"`
+	Long         float64        `db:"longitude"`
+}
+
+type historyParams struct {
+	From       time.Time
+	To         time.Time
+	Categories []string
+	Limit      int
+}
+
+func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	userID, _ := authLib.ActingUserID(req)
+
+	params, err := parseParams(req.QueryStringParameters, time.Now())
+	if err != nil {
+		return badRequest(err), nil
+	}
+
+	var categories interface{}
+	if len(params.Categories) > 0 {
+		categories = pq.Array(params.Categories)
+	}
+
+	var rows []historyRow
+	err = pgDB.Select(&rows, query, userID, params.From, params.To, categories, maxCandidates)
+	if err != nil {
+		panic(fmt.Errorf("error getting weather event history for user(%s): %s", userID, err))
+	}
+
+	resp := models.WeatherEventHistoryResponse{
+		Events: buildHistory(rows, params.Limit, time.Now()),
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		panic(fmt.Errorf("error marshalling weather event history for user(%s): %s", userID, err))
+	}
+
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(b),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// parseParams reads from, to (dates or rfc3339 times), category (a
+// comma separated list) and limit. the range defaults to the last 90
+// days.
+func parseParams(qs map[string]string, now time.Time) (*historyParams, error) {
+	params := historyParams{
+		From:  now.Add(-defaultRange),
+		To:    now,
+		Limit: defaultLimit,
+	}
+
+	if v, ok := qs["from"]; ok && v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed from %s", v)
+		}
+		params.From = t
+	}
+	if v, ok := qs["to"]; ok && v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed to %s", v)
+		}
+		// a date covers the whole day
+		if len(v) == len("2006-01-02") {
+			t = t.Add(time.Hour*24 - time.Nanosecond)
+		}
+		params.To = t
+	}
+	if params.From.After(params.To) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	if v, ok := qs["category"]; ok {
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				params.Categories = append(params.Categories, c)
+			}
+		}
+	}
+
+	if v, ok := qs["limit"]; ok && v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("malformed limit %s", v)
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+		params.Limit = limit
+	}
+
+	return &params, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// buildHistory keeps the alerts whose polygons cover the user's home,
+// then the latest version of each update chain, newest onset first
+func buildHistory(rows []historyRow, limit int, now time.Time) []models.WeatherEventHistoryItem {
+	latest := map[string]historyRow{}
+	for _, row := range rows {
+		if !rowContainsHome(row) {
+			continue
+		}
+		cur, ok := latest[row.ChainID]
+		if ok && !after(row.Sent, cur.Sent) {
+			continue
+		}
+		latest[row.ChainID] = row
+	}
+
+	var chains []historyRow
+	for _, row := range latest {
+		chains = append(chains, row)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		oi, oj := onsetOrSent(chains[i]), onsetOrSent(chains[j])
+		if oi.Equal(oj) {
+			return chains[i].ID < chains[j].ID
+		}
+		return oi.After(oj)
+	})
+	if len(chains) > limit {
+		chains = chains[:limit]
+	}
+
+	items := []models.WeatherEventHistoryItem{}
+	for _, row := range chains {
+		refs := []string(row.Refs)
+		if refs == nil {
+			refs = []string{}
+		}
+		items = append(items, models.WeatherEventHistoryItem{
+			ID:           row.ID,
+			ChainID:      row.ChainID,
+			RefIds:       refs,
+			SupersededBy: row.SupersededBy,
+			Categorization: models.WeatherEventCategorization{
+				Text:     str(row.Text),
+				Category: str(row.Category),
+				Code:     str(row.EventCode),
+				Level:    str(row.Level),
+			},
+			Headline:       str(row.Headline),
+			AreaDesc:       str(row.AreaDesc),
+			OnsetTime:      formatTime(row.Onset),
+			ExpirationTime: formatTime(row.Expires),
+			Expired:        row.Expires == nil || !row.Expires.After(now),
+		})
+	}
+
+	return items
+}
+
+// the query only matched the bounding box, alerts without a usable
+// polygon are kept on that alone
+func rowContainsHome(row historyRow) bool {
+	tested := false
+	for _, polygon := range row.Polygons {
+		if polygon == "" {
+			continue
+		}
+		p, err := geo.GetPolygonFromString(polygon)
+		if err != nil {
+			fmt.Printf("skipping polygon of alert(%s): %s\n", row.ID, err)
+			continue
+		}
+		if p.ContainsPoint(row.Lat, row.Long, 0) {
+			return true
+		}
+		tested = true
+	}
+
+	return !tested
+}
+
+func after(a *time.Time, b *time.Time) bool {
+	if a == nil {
+		return false
+	}
+	return b == nil || a.After(*b)
+}
+
+func onsetOrSent(row historyRow) time.Time {
+	if row.Onset != nil {
+		return *row.Onset
+	}
+	if row.Sent != nil {
+		return *row.Sent
+	}
+	return time.Time{}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000Z0700")
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func badRequest(err error) *events.APIGatewayProxyResponse {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return &events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Body:       string(b),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
+	d, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		panic(err)
+	}
+	pgDB = d
+}
+
+func main() {
+	lambda.Start(handler)
+}
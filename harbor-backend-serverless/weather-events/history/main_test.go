@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// norman, ok
+const homeLat, homeLong = 35.22, -97.50
+
+const covering = "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.23,-97.59 35.17,-97.53"
+const missing = "35.5,-97.2 35.6,-97.1 35.7,-97.2 35.5,-97.2"
+
+func ts(s string) *time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return &t
+}
+
+func row(id string, chainID string, sent string, polygons ...string) historyRow {
+	return historyRow{
+		ID:       id,
+		ChainID:  chainID,
+		Sent:     ts(sent),
+		Onset:    ts(sent),
+		Expires:  ts("2021-05-04T00:15:00Z"),
+		Polygons: polygons,
+		Lat:      homeLat,
+		Long:     homeLong,
+	}
+}
+
+func TestBuildHistory(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("keeps the latest covering version of a chain", func(t *testing.T) {
+		rows := []historyRow{
+			row("tor.1", "tor.1", "2021-05-03T23:42:00Z", covering),
+			row("tor.2", "tor.1", "2021-05-03T23:50:00Z", covering),
+			// the last update moved away from the home
+			row("tor.3", "tor.1", "2021-05-03T23:58:00Z", missing),
+		}
+		items := buildHistory(rows, 10, now)
+		if len(items) != 1 || items[0].ID != "tor.2" {
+			t.Fatalf("expected tor.2, got %+v", items)
+		}
+		if !items[0].Expired {
+			t.Fatalf("expected the event to have expired")
+		}
+	})
+
+	t.Run("alerts without a polygon are kept on bounds", func(t *testing.T) {
+		items := buildHistory([]historyRow{row("heat.1", "heat.1", "2021-05-02T12:00:00Z")}, 10, now)
+		if len(items) != 1 {
+			t.Fatalf("expected the alert to be kept, got %d", len(items))
+		}
+	})
+
+	t.Run("newest onset first and limited", func(t *testing.T) {
+		rows := []historyRow{
+			row("a", "a", "2021-04-01T00:00:00Z", covering),
+			row("b", "b", "2021-05-01T00:00:00Z", covering),
+			row("c", "c", "2021-03-01T00:00:00Z", covering),
+		}
+		items := buildHistory(rows, 2, now)
+		if len(items) != 2 || items[0].ID != "b" || items[1].ID != "a" {
+			t.Fatalf("unexpected history %+v", items)
+		}
+	})
+}
+
+func TestParseParams(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	params, err := parseParams(map[string]string{}, now)
+	if err != nil || !params.From.Equal(now.Add(-defaultRange)) || params.Limit != defaultLimit {
+		t.Fatalf("unexpected defaults %+v %v", params, err)
+	}
+
+	params, err = parseParams(map[string]string{
+		"from":     "2021-03-01",
+		"to":       "2021-05-31",
+		"category": "Tornadoes, Floods",
+		"limit":    "500",
+	}, now)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !params.To.After(time.Date(2021, 5, 31, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected to to cover the whole day, got %s", params.To)
+	}
+	if len(params.Categories) != 2 || params.Categories[1] != "Floods" {
+		t.Errorf("unexpected categories %v", params.Categories)
+	}
+	if params.Limit != maxLimit {
+		t.Errorf("expected the limit to be capped, got %d", params.Limit)
+	}
+
+	for _, qs := range []map[string]string{
+		{"from": "yesterday"},
+		{"from": "2021-05-01", "to": "2021-04-01"},
+		{"limit": "-1"},
+	} {
+		if _, err := parseParams(qs, now); err == nil {
+			t.Errorf("expected %v to be rejected", qs)
+		}
+	}
+}
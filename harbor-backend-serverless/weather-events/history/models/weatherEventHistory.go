@@ -0,0 +1,25 @@
+package models
+
+type WeatherEventHistoryResponse struct {
+	Events []WeatherEventHistoryItem `json:"events"`
+}
+
+type WeatherEventHistoryItem struct {
+	ID             string                     `json:"id"`
+	ChainID        string                     `json:"chainID"`
+	RefIds         []string                   `json:"referenceIDs"`
+	SupersededBy   *string                    `json:"supersededBy"`
+	Categorization WeatherEventCategorization `json:"categorization"`
+	Headline       string                     `json:"headline"`
+	AreaDesc       string                     `json:"areaDesc"`
+	OnsetTime      string                     `json:"onsetTime"`
+	ExpirationTime string                     `json:"expirationTime"`
+	Expired        bool                       `json:"expired"`
+}
+
+type WeatherEventCategorization struct {
+	Text     string `json:"text"`
+	Category string `json:"category"`
+	Code     string `json:"code"`
+	Level    string `json:"level"`
+}
@@ -0,0 +1,38 @@
+package main
+
+// alerts whose bounds cover the user's home and overlap the range,
+// the exact polygon test and chain dedupe happen after. the bounds
+// prefilter is loose so the row cap sits well above the page size.
+const query = `
+with home as (
+	select a.latitude, a.longitude
+	from users u
+	join addresses a on a.id = u.address_id
+	where u.id = $1
+)
+select
+	w.id,
+	w.chain_id,
+	w.refs,
+	w.superseded_by,
+	w.sent,
+	w.event_code,
+	w.category,
+	w.level,
+	w.text,
+	w.headline,
+	w.area_desc,
+	w.onset,
+	w.expires,
+	w.polygons,
+	h.latitude,
+	h.longitude
+from weather_alerts w, home h
+where w.bb_lat_lo <= h.latitude and w.bb_lat_hi >= h.latitude
+	and w.bb_lng_lo <= h.longitude and w.bb_lng_hi >= h.longitude
+	and coalesce(w.onset, w.sent) <= $3
+	and coalesce(w.expires, w.onset, w.sent) >= $2
+	and lower(w.msg_type) != 'cancel'
+	and ($4::text[] is null or w.category = any($4))
+order by w.sent desc nulls last
+limit $5`
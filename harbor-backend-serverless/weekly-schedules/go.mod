@@ -4,6 +4,9 @@ go 1.15
 
 require (
 	github.com/aws/aws-lambda-go v1.26.0
+	github.com/helloharbor/harbor-backend-serverless/auth/lib v0.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.3
 )
+
+replace github.com/helloharbor/harbor-backend-serverless/auth/lib => ../auth/lib
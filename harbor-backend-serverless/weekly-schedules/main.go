@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	authLib "github.com/helloharbor/harbor-backend-serverless/auth/lib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
@@ -20,9 +21,9 @@ func handler(req events.APIGatewayProxyRequest) (
 ) {
 	var userID string
 
-	uID, ok := req.RequestContext.Authorizer["userID"]
-	if ok {
-		userID = uID.(string)
+	// other lambdas invoke it directly with the user in the body
+	if _, ok := req.RequestContext.Authorizer[authLib.ContextUserID]; ok {
+		userID, _ = authLib.ActingUserID(req)
 	} else {
 		var reqBody struct {
 			UserID int64 `json:"userID"`
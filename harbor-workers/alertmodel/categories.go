@@ -1,4 +1,4 @@
-package models
+package alertmodel
 
 type AlertData struct {
 	Text     string
@@ -109,3 +109,19 @@ var AlertCodeToCategorization = map[string]AlertData{
 	"VOW":  {Text: "Volcano Warning", Level: WARNING, Category: VOLCANO},
 	"NULL": {Text: "Placeholder", Level: CLEAR, Category: NONE},
 }
+
+// Categorize looks up the categorization of a cap event code, ok is
+// false for codes outside the table
+func Categorize(code string) (cat AlertCategorization, ok bool) {
+	mapping, ok := AlertCodeToCategorization[code]
+	if !ok {
+		return AlertCategorization{Code: code}, false
+	}
+
+	return AlertCategorization{
+		Text:     mapping.Text,
+		Category: string(mapping.Category),
+		Code:     code,
+		Level:    string(mapping.Level),
+	}, true
+}
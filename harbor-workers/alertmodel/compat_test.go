@@ -0,0 +1,160 @@
+package alertmodel_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+var levels = map[alertmodel.AlertLevel]bool{
+	alertmodel.CLEAR:     true,
+	alertmodel.AWARE:     true,
+	alertmodel.WATCH:     true,
+	alertmodel.WARNING:   true,
+	alertmodel.DANGEROUS: true,
+}
+
+var categories = map[alertmodel.AlertCategory]bool{
+	alertmodel.EARTHQUAKE:   true,
+	alertmodel.FLOODS:       true,
+	alertmodel.HEATWAVES:    true,
+	alertmodel.HURRICANES:   true,
+	alertmodel.NONE:         true,
+	alertmodel.TORNADOES:    true,
+	alertmodel.TSUNAMIS:     true,
+	alertmodel.VOLCANO:      true,
+	alertmodel.WILDFIRE:     true,
+	alertmodel.WINTERSTORMS: true,
+}
+
+// clients key off codes, levels and categories, the table only
+// changes with a new version
+func TestCategoriesCompat(t *testing.T) {
+	got, err := json.MarshalIndent(alertmodel.AlertCodeToCategorization, "", "  ")
+	if err != nil {
+		t.Fatalf("failed marshalling the table: %s", err)
+	}
+
+	golden := "testdata/categories.golden.json"
+	if *update {
+		if err := ioutil.WriteFile(golden, append(got, '\n'), 0644); err != nil {
+			t.Fatalf("failed writing %s: %s", golden, err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed reading golden file: %s", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(want), got) {
+		t.Errorf("the categorization table changed, bump alertmodel.Version and run with -update")
+	}
+}
+
+func TestCategoriesValid(t *testing.T) {
+	for code, data := range alertmodel.AlertCodeToCategorization {
+		if data.Text == "" {
+			t.Errorf("%s has no text", code)
+		}
+		if !levels[data.Level] {
+			t.Errorf("%s has unknown level %s", code, data.Level)
+		}
+		if !categories[data.Category] {
+			t.Errorf("%s has unknown category %s", code, data.Category)
+		}
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	cat, ok := alertmodel.Categorize("TOR")
+	want := alertmodel.AlertCategorization{
+		Text:     "Tornado Warning",
+		Category: string(alertmodel.TORNADOES),
+		Code:     "TOR",
+		Level:    string(alertmodel.WARNING),
+	}
+	if !ok || cat != want {
+		t.Errorf("expected %+v, got %+v", want, cat)
+	}
+
+	if cat, ok = alertmodel.Categorize("XYZ"); ok || cat.Code != "XYZ" || cat.Category != "" {
+		t.Errorf("expected an unknown code to stay uncategorized, got %+v", cat)
+	}
+}
+
+// the short form is published by ingest and cached for get-all, it
+// must decode and encode without loss
+func TestShortAlertCompat(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/short_alert.json")
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+
+	sfa := alertmodel.ShortAlertMsg{}
+	if err := json.Unmarshal(body, &sfa); err != nil {
+		t.Fatalf("failed decoding the short form: %s", err)
+	}
+	if sfa.Geometry == nil || !sfa.Geometry.ContainsPoint(35.22, -97.50, 0) {
+		t.Errorf("expected the decoded geometry to contain norman")
+	}
+
+	encoded, err := json.Marshal(sfa)
+	if err != nil {
+		t.Fatalf("failed encoding the short form: %s", err)
+	}
+	var want, got map[string]interface{}
+	json.Unmarshal(body, &want)
+	json.Unmarshal(encoded, &got)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("the short form changed on a round trip\nwant %v\ngot  %v", want, got)
+	}
+}
+
+// raw messages are kept for the dead letter queue, they must not
+// leak into the cache
+func TestAlertMsgOmitsRaw(t *testing.T) {
+	b, err := json.Marshal(alertmodel.AlertMsg{Identifier: "a", Raw: "<alert/>", RawFormat: "cap"})
+	if err != nil {
+		t.Fatalf("failed encoding: %s", err)
+	}
+	if bytes.Contains(b, []byte("<alert/>")) || bytes.Contains(b, []byte(`"cap"`)) {
+		t.Errorf("expected the raw message to be left out, got %s", b)
+	}
+}
+
+func TestShortAlertForLanguage(t *testing.T) {
+	en := alertmodel.ShortAlertMsg{Language: "en-US", Languages: []string{"en-US", "es-US"}}
+	es := alertmodel.ShortAlertMsg{Language: "es-US", Languages: []string{"en-US", "es-US"}}
+
+	tests := []struct {
+		lang   string
+		en, es bool
+	}{
+		{"", true, false},
+		{"en", true, false},
+		{"es", false, true},
+		{"ES-mx", false, true},
+		// no variant in french, the primary one is sent
+		{"fr", true, false},
+	}
+	for _, tt := range tests {
+		if got := en.ForLanguage(tt.lang); got != tt.en {
+			t.Errorf("%q: expected the english variant %v, got %v", tt.lang, tt.en, got)
+		}
+		if got := es.ForLanguage(tt.lang); got != tt.es {
+			t.Errorf("%q: expected the spanish variant %v, got %v", tt.lang, tt.es, got)
+		}
+	}
+
+	// short forms published before Languages was added
+	if old := (alertmodel.ShortAlertMsg{Language: "en-US"}); !old.Primary() || !old.ForLanguage("es") {
+		t.Errorf("expected a single variant to be sent to everyone")
+	}
+}
@@ -0,0 +1,16 @@
+// Package alertmodel is the alert model shared by the ipaws workers
+// and the weather-events api: the cached alert formats, the event code
+// categorization table, cap and nws parsing (alertmodel/parse) and the
+// geometry helpers (alertmodel/geometries).
+//
+// The workers write and the api reads the same json, so any change to
+// the cached formats or the table is a new version. The compatibility
+// tests pin both against golden files in testdata, update them with
+//
+//	go test ./... -update
+//
+// and bump Version alongside.
+package alertmodel
+
+// Version is tagged as alertmodel/vX.Y.Z
+const Version = "1.2.0"
@@ -0,0 +1,60 @@
+package geometries
+
+import (
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// alerts are indexed by an s2 covering between these levels, roughly
+// 600km cells for hurricane sized areas down to 40km for storm cells.
+// backend lookups must use the same levels.
+const (
+	IndexMinLevel = 4
+	IndexMaxLevel = 8
+	IndexMaxCells = 16
+)
+
+// CellTokens returns the s2 cell tokens covering the polygon.
+func (p *Polygon) CellTokens() []string {
+	if len(p.Vertices) < 3 {
+		return nil
+	}
+	p.init()
+
+	rc := &s2.RegionCoverer{MinLevel: IndexMinLevel, MaxLevel: IndexMaxLevel, MaxCells: IndexMaxCells}
+	var tokens []string
+	for _, cID := range rc.Covering(p.loop) {
+		tokens = append(tokens, cID.ToToken())
+	}
+
+	return tokens
+}
+
+// PointCellTokens returns every index cell that could hold an alert
+// containing the point, or within bufferKm of it.
+func PointCellTokens(lat float64, long float64, bufferKm float64) []string {
+	ll := s2.LatLngFromDegrees(lat, long)
+
+	var leaves []s2.CellID
+	if bufferKm > 0 {
+		c := s2.CapFromCenterAngle(s2.PointFromLatLng(ll), s1.Angle(bufferKm/earthRadiusKm))
+		rc := &s2.RegionCoverer{MinLevel: IndexMaxLevel, MaxLevel: IndexMaxLevel, MaxCells: 8}
+		leaves = rc.Covering(c)
+	} else {
+		leaves = []s2.CellID{s2.CellIDFromLatLng(ll).Parent(IndexMaxLevel)}
+	}
+
+	seen := map[string]bool{}
+	var tokens []string
+	for _, leaf := range leaves {
+		for lvl := IndexMinLevel; lvl <= IndexMaxLevel; lvl++ {
+			token := leaf.Parent(lvl).ToToken()
+			if !seen[token] {
+				seen[token] = true
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
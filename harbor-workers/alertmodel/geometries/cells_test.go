@@ -0,0 +1,110 @@
+package geometries_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+)
+
+// random storm sized quads scattered over the continental us
+func randomAlertPolygons(r *rand.Rand, n int) []*geo.Polygon {
+	var polygons []*geo.Polygon
+	for i := 0; i < n; i++ {
+		lat := 25 + r.Float64()*24
+		long := -124 + r.Float64()*57
+		dLat := 0.05 + r.Float64()*0.5
+		dLong := 0.05 + r.Float64()*0.5
+		p, err := geo.GetPolygonFromString(fmt.Sprintf("%f,%f %f,%f %f,%f %f,%f",
+			lat, long, lat+dLat, long+dLong*0.2, lat+dLat*0.8, long+dLong, lat-dLat*0.1, long+dLong*0.7))
+		if err != nil {
+			panic(err)
+		}
+		polygons = append(polygons, p)
+	}
+	return polygons
+}
+
+func buildCellIndex(polygons []*geo.Polygon) map[string][]int {
+	index := map[string][]int{}
+	for i, p := range polygons {
+		for _, token := range p.CellTokens() {
+			index[token] = append(index[token], i)
+		}
+	}
+	return index
+}
+
+func lookupCellIndex(index map[string][]int, polygons []*geo.Polygon, lat float64, long float64, bufferKm float64) map[int]bool {
+	found := map[int]bool{}
+	for _, token := range geo.PointCellTokens(lat, long, bufferKm) {
+		for _, i := range index[token] {
+			if !found[i] && polygons[i].ContainsPoint(lat, long, bufferKm) {
+				found[i] = true
+			}
+		}
+	}
+	return found
+}
+
+func lookupLinear(polygons []*geo.Polygon, lat float64, long float64, bufferKm float64) map[int]bool {
+	found := map[int]bool{}
+	for i, p := range polygons {
+		if p.ContainsPoint(lat, long, bufferKm) {
+			found[i] = true
+		}
+	}
+	return found
+}
+
+func TestCellIndexMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	polygons := randomAlertPolygons(r, 2000)
+	index := buildCellIndex(polygons)
+
+	for _, bufferKm := range []float64{0, 5, 25} {
+		matched := 0
+		for i := 0; i < 2000; i++ {
+			lat := 25 + r.Float64()*24
+			long := -124 + r.Float64()*57
+			want := lookupLinear(polygons, lat, long, bufferKm)
+			got := lookupCellIndex(index, polygons, lat, long, bufferKm)
+			if len(got) != len(want) {
+				t.Fatalf("buffer %v at %f,%f: expected %d alerts, got %d", bufferKm, lat, long, len(want), len(got))
+			}
+			for idx := range want {
+				if !got[idx] {
+					t.Fatalf("buffer %v at %f,%f: index missed alert %d", bufferKm, lat, long, idx)
+				}
+			}
+			matched += len(want)
+		}
+		if matched == 0 {
+			t.Fatalf("buffer %v: no points matched, fixture is not exercising the index", bufferKm)
+		}
+	}
+}
+
+func benchmarkLookup(b *testing.B, numAlerts int, indexed bool) {
+	r := rand.New(rand.NewSource(1))
+	polygons := randomAlertPolygons(r, numAlerts)
+	index := buildCellIndex(polygons)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lat := 25 + r.Float64()*24
+		long := -124 + r.Float64()*57
+		if indexed {
+			lookupCellIndex(index, polygons, lat, long, 0)
+		} else {
+			lookupLinear(polygons, lat, long, 0)
+		}
+	}
+}
+
+func BenchmarkLinearScan1000(b *testing.B) { benchmarkLookup(b, 1000, false) }
+func BenchmarkLinearScan5000(b *testing.B) { benchmarkLookup(b, 5000, false) }
+func BenchmarkCellIndex1000(b *testing.B)  { benchmarkLookup(b, 1000, true) }
+func BenchmarkCellIndex5000(b *testing.B)  { benchmarkLookup(b, 5000, true) }
+func BenchmarkCellIndex20000(b *testing.B) { benchmarkLookup(b, 20000, true) }
@@ -32,7 +32,31 @@ func (b *BBRect) ScaleBoundingBox(scaleFactorKm float64) {
 	b.LngHi = b.LngHi + scaleLongKmToDegrees
 }
 
-func (b *BBRect) containsPoint(lat float64, long float64) bool {
+// ContainsLatLng checks the point against the bounds alone. Boxes
+// crossing the antimeridian have LngLo > LngHi.
+func (b *BBRect) ContainsLatLng(lat float64, long float64) bool {
+	if lat < b.LatLo || lat > b.LatHi {
+		return false
+	}
+
+	lngLo, lngHi := b.LngLo, b.LngHi
+	if lngLo > lngHi {
+		lngHi += 360
+	}
+	if lngHi-lngLo >= 360 {
+		return true
+	}
+	// a scaled box may also reach past +-180
+	for _, l := range []float64{long - 360, long, long + 360} {
+		if l >= lngLo && l <= lngHi {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *BBRect) ContainsPoint(lat float64, long float64) bool {
 	tll := s2.LatLngFromDegrees(lat, long)
 	tpt := s2.PointFromLatLng(tll)
 
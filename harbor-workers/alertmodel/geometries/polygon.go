@@ -0,0 +1,184 @@
+package geometries
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+const earthRadiusKm = float64(6371.0088)
+
+// number of vertices used to approximate cap circles
+const circleVertices = 32
+
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Polygon is the exact area an alert applies to, either the CAP
+// polygon itself or the convex hull of a UGC geocode.
+type Polygon struct {
+	Vertices []LatLng `json:"vertices"`
+
+	loop *s2.Loop
+	rect *BBRect
+}
+
+func GetPolygonFromString(vertices string) (*Polygon, error) {
+	points, err := getVerticesFromPolygonString(vertices)
+	if err != nil {
+		return nil, fmt.Errorf("error getting vertices from string: %s", err)
+	}
+
+	// cap polygons repeat the first vertex to close the ring,
+	// s2 loops are implicitly closed so drop the duplicate
+	pts := *points
+	if len(pts) > 1 && pts[0] == pts[len(pts)-1] {
+		pts = pts[:len(pts)-1]
+	}
+	if len(pts) < 3 {
+		return nil, fmt.Errorf("polygon requires at least 3 distinct vertices, found %d", len(pts))
+	}
+
+	p := Polygon{}
+	for _, pt := range pts {
+		ll := s2.LatLngFromPoint(pt)
+		p.Vertices = append(p.Vertices, LatLng{Lat: ll.Lat.Degrees(), Lng: ll.Lng.Degrees()})
+	}
+
+	return &p, nil
+}
+
+// GetPolygonFromCircleString approximates a cap circle, given as
+// "lat,long radius" with the radius in km, with a regular polygon.
+func GetPolygonFromCircleString(circle string) (*Polygon, error) {
+	parts := strings.Fields(circle)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("circle poorly formed: %s", circle)
+	}
+	coords := strings.Split(parts[0], ",")
+	if len(coords) != 2 {
+		return nil, fmt.Errorf("circle poorly formed: %s", circle)
+	}
+	latD, errLat := strconv.ParseFloat(coords[0], 64)
+	lonD, errLng := strconv.ParseFloat(coords[1], 64)
+	radiusKm, errRad := strconv.ParseFloat(parts[1], 64)
+	if errLat != nil || errLng != nil || errRad != nil {
+		return nil, fmt.Errorf("circle poorly formed: %s", circle)
+	}
+	if radiusKm <= 0 {
+		return nil, fmt.Errorf("circle radius must be positive: %s", circle)
+	}
+
+	center := s2.PointFromLatLng(s2.LatLngFromDegrees(latD, lonD))
+	loop := s2.RegularLoop(center, s1.Angle(radiusKm/earthRadiusKm), circleVertices)
+
+	p := Polygon{}
+	for _, pt := range loop.Vertices() {
+		ll := s2.LatLngFromPoint(pt)
+		p.Vertices = append(p.Vertices, LatLng{Lat: ll.Lat.Degrees(), Lng: ll.Lng.Degrees()})
+	}
+
+	return &p, nil
+}
+
+// String formats the polygon the way cap does, as a closed
+// ring of space separated "lat,long" pairs.
+func (p *Polygon) String() string {
+	if len(p.Vertices) == 0 {
+		return ""
+	}
+
+	var strVertexArr []string
+	for i := 0; i <= len(p.Vertices); i++ {
+		v := p.Vertices[i%len(p.Vertices)]
+		strVertexArr = append(strVertexArr,
+			strconv.FormatFloat(v.Lat, 'f', 4, 64)+","+strconv.FormatFloat(v.Lng, 'f', 4, 64))
+	}
+
+	return strings.Join(strVertexArr, " ")
+}
+
+// ContainsPoint does a bounding box prefilter followed by an exact
+// point in polygon test. Points outside the polygon but within
+// bufferKm of its boundary are also considered contained.
+func (p *Polygon) ContainsPoint(lat float64, long float64, bufferKm float64) bool {
+	if len(p.Vertices) < 3 {
+		return false
+	}
+	p.init()
+
+	bb := *p.rect
+	if bufferKm > 0 {
+		bb.ScaleBoundingBox(bufferKm)
+	}
+	if !bb.ContainsLatLng(lat, long) {
+		return false
+	}
+
+	pt := s2.PointFromLatLng(s2.LatLngFromDegrees(lat, long))
+	if p.loop.ContainsPoint(pt) {
+		return true
+	}
+	if bufferKm <= 0 {
+		return false
+	}
+
+	return p.distanceToBoundary(pt) <= s1.Angle(bufferKm/earthRadiusKm)
+}
+
+// BoundingBox returns the polygon bounds, grown by scaleFactorKm.
+func (p *Polygon) BoundingBox(scaleFactorKm float64) *BBRect {
+	p.init()
+
+	bb := *p.rect
+	if scaleFactorKm > 0 {
+		bb.ScaleBoundingBox(scaleFactorKm)
+	}
+	bb.Polygon = getBoundingBoxPolygonLoop(bb.LatLo, bb.LatHi, bb.LngLo, bb.LngHi)
+
+	return &bb
+}
+
+func (p *Polygon) distanceToBoundary(pt s2.Point) s1.Angle {
+	minDist := s1.InfAngle()
+	for i := 0; i < p.loop.NumEdges(); i++ {
+		e := p.loop.Edge(i)
+		if d := s2.DistanceFromSegment(pt, e.V0, e.V1); d < minDist {
+			minDist = d
+		}
+	}
+
+	return minDist
+}
+
+func (p *Polygon) init() {
+	if p.loop != nil {
+		return
+	}
+
+	var points []s2.Point
+	for _, v := range p.Vertices {
+		points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(v.Lat, v.Lng)))
+	}
+
+	// see GetBoundingBoxFromPolygonString, ipaws loops are not
+	// consistently wound so invert anything larger than the US
+	loop := s2.LoopFromPoints(points)
+	if loop.Area() > 0.1 {
+		loop.Invert()
+	}
+	rect := loop.RectBound()
+
+	p.loop = loop
+	p.rect = &BBRect{
+		LatLo: rect.Lat.Lo * degConv,
+		LatHi: rect.Lat.Hi * degConv,
+		LngLo: rect.Lng.Lo * degConv,
+		LngHi: rect.Lng.Hi * degConv,
+	}
+}
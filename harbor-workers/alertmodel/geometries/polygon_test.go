@@ -0,0 +1,184 @@
+package geometries_test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+)
+
+func loadFixturePolygons(t *testing.T) map[string]string {
+	b, err := ioutil.ReadFile("testdata/alerts.xml")
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+	alerts := parse.AlertsXML{}
+	if err := xml.Unmarshal(b, &alerts); err != nil {
+		t.Fatalf("failed unmarshalling fixture: %s", err)
+	}
+
+	polygons := map[string]string{}
+	for _, alert := range alerts.Alert {
+		polygons[alert.Info[0].Event] = alert.Info[0].Area[0].Polygon[0]
+	}
+	return polygons
+}
+
+func TestPolygonContainsPoint(t *testing.T) {
+	polygons := loadFixturePolygons(t)
+
+	tests := []struct {
+		name     string
+		event    string
+		lat      float64
+		long     float64
+		bufferKm float64
+		inBBox   bool
+		want     bool
+	}{
+		{"tornado warning center", "Tornado Warning", 35.235, -97.50, 0, true, true},
+		{"tornado warning outside polygon inside bbox", "Tornado Warning", 35.18, -97.44, 0, true, false},
+		{"tornado warning outside polygon within buffer", "Tornado Warning", 35.18, -97.44, 5, true, true},
+		{"tornado warning outside polygon beyond buffer", "Tornado Warning", 35.18, -97.44, 2, true, false},
+		{"tornado warning far away", "Tornado Warning", 36.00, -97.50, 5, false, false},
+		{"clockwise flash flood warning center", "Flash Flood Warning", 35.22, -111.58, 0, true, true},
+		{"clockwise flash flood warning north of polygon", "Flash Flood Warning", 35.30, -111.58, 0, true, false},
+		{"clockwise flash flood warning north within buffer", "Flash Flood Warning", 35.30, -111.58, 5, true, true},
+		{"thunderstorm warning center", "Severe Thunderstorm Warning", 38.94, -77.30, 0, true, true},
+		{"thunderstorm warning outside polygon inside bbox", "Severe Thunderstorm Warning", 38.87, -77.20, 0, true, false},
+		{"thunderstorm warning outside polygon within buffer", "Severe Thunderstorm Warning", 38.87, -77.20, 8, true, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			polygonStr, ok := polygons[tc.event]
+			if !ok {
+				t.Fatalf("fixture missing %s", tc.event)
+			}
+
+			// the legacy matching used the 16km inflated box
+			bbr, err := geo.GetBoundingBoxFromPolygonString(polygonStr)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			bbr.ScaleBoundingBox(16.0)
+			inBBox := tc.lat >= bbr.LatLo && tc.lat <= bbr.LatHi && tc.long >= bbr.LngLo && tc.long <= bbr.LngHi
+			if inBBox != tc.inBBox {
+				t.Fatalf("expected bbox match %t, got %t", tc.inBBox, inBBox)
+			}
+
+			p, err := geo.GetPolygonFromString(polygonStr)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := p.ContainsPoint(tc.lat, tc.long, tc.bufferKm); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPolygonContainsPointAntimeridian(t *testing.T) {
+	// a warning over the western aleutians, from Attu across 180 to Adak
+	p, err := geo.GetPolygonFromString("51.5,172.5 53.5,172.5 53.5,-176.0 51.5,-176.0 51.5,172.5")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		lat      float64
+		long     float64
+		bufferKm float64
+		want     bool
+	}{
+		{"west of the antimeridian", 52.9, 173.2, 0, true},
+		{"east of the antimeridian", 51.9, -176.6, 0, true},
+		{"on the antimeridian", 52.5, 180, 0, true},
+		{"east of the polygon", 52.5, -175.0, 0, false},
+		{"east of the polygon within buffer", 52.5, -175.9, 10, true},
+		{"west of the polygon", 52.5, 171.0, 0, false},
+		{"across the globe", 52.5, 0, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.ContainsPoint(tc.lat, tc.long, tc.bufferKm); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+
+	bb := p.BoundingBox(0)
+	if bb.LngLo < bb.LngHi {
+		t.Errorf("expected the box to cross the antimeridian, got %f to %f", bb.LngLo, bb.LngHi)
+	}
+}
+
+func TestGetPolygonFromString(t *testing.T) {
+	tests := []struct {
+		name     string
+		polygon  string
+		vertices int
+		wantErr  bool
+	}{
+		{"closed ring drops duplicate vertex", "35.17,-97.53 35.24,-97.42 35.30,-97.47 35.17,-97.53", 3, false},
+		{"open ring is accepted", "35.17,-97.53 35.24,-97.42 35.30,-97.47", 3, false},
+		{"degenerate ring", "35.17,-97.53 35.24,-97.42 35.17,-97.53", 0, true},
+		{"malformed vertex", "35.17,-97.53 35.24 35.30,-97.47", 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := geo.GetPolygonFromString(tc.polygon)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(p.Vertices) != tc.vertices {
+				t.Fatalf("expected %d vertices, got %d", tc.vertices, len(p.Vertices))
+			}
+		})
+	}
+}
+
+func TestGetPolygonFromCircleString(t *testing.T) {
+	// 10km circle around downtown Anchorage
+	p, err := geo.GetPolygonFromCircleString("61.2181,-149.9003 10")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		lat  float64
+		long float64
+		want bool
+	}{
+		{"center", 61.2181, -149.9003, true},
+		{"9km north", 61.2181 + 9/110.574, -149.9003, true},
+		{"11km north", 61.2181 + 11/110.574, -149.9003, false},
+		{"9km east", 61.2181, -149.9003 + 9/53.5, true},
+		{"11km east", 61.2181, -149.9003 + 11/53.5, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.ContainsPoint(tc.lat, tc.long, 0); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+
+	for _, circle := range []string{"61.2181,-149.9003", "61.2181 10", "61.2181,-149.9003 0"} {
+		if _, err := geo.GetPolygonFromCircleString(circle); err == nil {
+			t.Fatalf("expected error for %q", circle)
+		}
+	}
+}
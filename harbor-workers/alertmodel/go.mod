@@ -0,0 +1,8 @@
+module github.com/helloharbor/harbor-workers/alertmodel
+
+go 1.15
+
+require (
+	github.com/golang/geo v0.0.0-20210211234256-740aa86cb551
+	github.com/sirupsen/logrus v1.8.1
+)
@@ -1,4 +1,4 @@
-package models
+package alertmodel
 
 type AlertsMsg struct {
 	Alert []AlertMsg `json:"alert"`
@@ -6,28 +6,39 @@ type AlertsMsg struct {
 
 type AlertMsg struct {
 	Identifier string   `json:"identifier"`
+	Sent       string   `json:"sent"`
 	Status     string   `json:"status"`
 	MsgType    string   `json:"msgType"`
 	Scope      string   `json:"scope"`
 	References []string `json:"references"`
-	Info       InfoMsg  `json:"info"`
+	// Info is the primary language block, Infos holds every
+	// block in the message including translations of Info
+	Info  InfoMsg   `json:"info"`
+	Infos []InfoMsg `json:"infos"`
+	// set by ingest from the categorization rules, cancels have none
+	Categorization *AlertCategorization `json:"categorization,omitempty"`
+	// the message as received, the cap xml for ipaws and the
+	// geojson feature for the nws, kept to dead letter and replay
+	Raw       string `json:"-"`
+	RawFormat string `json:"-"`
 }
 
 type InfoMsg struct {
+	Language     string       `json:"language"`
 	Category     string       `json:"category"`
 	Event        string       `json:"event"`
 	ResponseType string       `json:"responseType"`
 	Urgency      string       `json:"urgency"`
 	Severity     string       `json:"severity"`
-	Certainty   string       `json:"certainty"`
-	EventCode   EventCodeMsg `json:"eventCode"`
-	Effective   string       `json:"effective"`
+	Certainty    string       `json:"certainty"`
+	EventCode    EventCodeMsg `json:"eventCode"`
+	Effective    string       `json:"effective"`
 	Onset        string       `json:"onset"`
 	Expires      string       `json:"expires"`
 	Headline     string       `json:"headline"`
 	Description  string       `json:"description"`
-	Instruction string       `json:"instruction"`
-	Area        AreaMsg      `json:"area"`
+	Instruction  string       `json:"instruction"`
+	Areas        []AreaMsg    `json:"areas"`
 }
 
 type EventCodeMsg struct {
@@ -37,7 +48,7 @@ type EventCodeMsg struct {
 
 type AreaMsg struct {
 	AreaDesc string   `json:"areaDesc"`
-	Polygon  string   `json:"polygon"`
-	Circle   string   `json:"circle"`
+	Polygons []string `json:"polygons"`
+	Circles  []string `json:"circles"`
 	Geocodes []string `json:"geocodes"`
 }
@@ -0,0 +1,189 @@
+package parse
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const capNamespace = "urn:oasis:names:tc:emergency:cap:1.2"
+
+// CAP parses a feed of CAP 1.2 alerts, as served by IPAWS-OPEN
+func CAP(body []byte) (*alertmodel.AlertsMsg, error) {
+	alerts := AlertsXML{}
+	err := xml.Unmarshal(body, &alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAlerts(alerts)
+}
+
+func parseAlerts(alerts AlertsXML) (*alertmodel.AlertsMsg, error) {
+	parsedAlerts := alertmodel.AlertsMsg{}
+	for _, alert := range alerts.Alert {
+		parsedAlert := alertmodel.AlertMsg{}
+		// check alert type and remove urn
+		strippedId := strings.Split(alert.Identifier, "urn:oid:")
+		if len(strippedId) != 2 {
+			continue
+		}
+
+		parsedAlert.Identifier = strippedId[1]
+		// only used to advance the watermark, a malformed time is
+		// left empty rather than dropping the alert
+		parsedAlert.Sent, _ = UTC3339(alert.Sent)
+		parsedAlert.Status = alert.Status
+		parsedAlert.MsgType = alert.MsgType
+		parsedAlert.Scope = alert.Scope
+		parsedAlert.References = parseReferences(alert.References)
+		parsedAlert.Raw = fmt.Sprintf(`<alert xmlns="%s">%s</alert>`, capNamespace, alert.Inner)
+		parsedAlert.RawFormat = FormatCAP
+
+		// a malformed alert is dropped on its own, failing the feed
+		// would hold back every other alert in it
+		var err error
+		for _, info := range alert.Info {
+			var parsedInfo *alertmodel.InfoMsg
+			parsedInfo, err = parseInfo(info)
+			if err != nil {
+				break
+			}
+			parsedAlert.Infos = append(parsedAlert.Infos, *parsedInfo)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"alertId": parsedAlert.Identifier, "error": err}).
+				Warn("skipping malformed alert")
+			continue
+		}
+		if len(parsedAlert.Infos) == 0 {
+			log.WithFields(log.Fields{"alertId": parsedAlert.Identifier}).
+				Warn("alert has no info blocks")
+			continue
+		}
+		parsedAlert.Info = getPrimaryInfo(parsedAlert.Infos)
+
+		parsedAlerts.Alert = append(parsedAlerts.Alert, parsedAlert)
+	}
+
+	return &parsedAlerts, nil
+}
+
+func parseInfo(info InfoXML) (*alertmodel.InfoMsg, error) {
+	parsedInfo := alertmodel.InfoMsg{}
+
+	// cap defaults to en-US when no language is given
+	parsedInfo.Language = info.Language
+	if parsedInfo.Language == "" {
+		parsedInfo.Language = "en-US"
+	}
+	parsedInfo.Category = info.Category
+	parsedInfo.Event = info.Event
+	parsedInfo.ResponseType = info.ResponseType
+	parsedInfo.Urgency = info.Urgency
+	parsedInfo.Severity = info.Severity
+	parsedInfo.Certainty = info.Certainty
+	parsedInfo.EventCode = getEventCode(info.EventCode)
+
+	var tErr error
+	parsedInfo.Effective, tErr = UTC3339(info.Effective)
+	if tErr != nil {
+		return nil, fmt.Errorf("failed to convert effective event time (%s)", info.Effective)
+	}
+	parsedInfo.Onset, tErr = UTC3339(info.Onset)
+	if tErr != nil {
+		return nil, fmt.Errorf("failed to convert onset event time (%s)", info.Onset)
+	}
+	parsedInfo.Expires, tErr = UTC3339(info.Expires)
+	if tErr != nil {
+		return nil, fmt.Errorf("failed to convert expires event time (%s)", info.Expires)
+	}
+	parsedInfo.Headline = info.Headline
+	parsedInfo.Description = info.Description
+	parsedInfo.Instruction = info.Instruction
+
+	for _, area := range info.Area {
+		parsedArea := alertmodel.AreaMsg{
+			AreaDesc: area.AreaDesc,
+			Polygons: []string{},
+			Circles:  []string{},
+			Geocodes: []string{},
+		}
+		for _, polygon := range area.Polygon {
+			if p := strings.TrimSpace(polygon); p != "" {
+				parsedArea.Polygons = append(parsedArea.Polygons, p)
+			}
+		}
+		for _, circle := range area.Circle {
+			if c := strings.TrimSpace(circle); c != "" {
+				parsedArea.Circles = append(parsedArea.Circles, c)
+			}
+		}
+		for _, gc := range area.Geocode {
+			if gc.ValueName == "UGC" {
+				parsedArea.Geocodes = append(parsedArea.Geocodes, gc.Value)
+			}
+		}
+		parsedInfo.Areas = append(parsedInfo.Areas, parsedArea)
+	}
+
+	return &parsedInfo, nil
+}
+
+// the first english block is primary, otherwise whichever came first
+func getPrimaryInfo(infos []alertmodel.InfoMsg) alertmodel.InfoMsg {
+	for _, info := range infos {
+		if strings.HasPrefix(strings.ToLower(info.Language), "en") {
+			return info
+		}
+	}
+
+	return infos[0]
+}
+
+// prefer a code we know how to categorize. the nws sends its own
+// "NWS" placeholder code ahead of the actual event code.
+func getEventCode(codes []ValuePairXML) alertmodel.EventCodeMsg {
+	for _, code := range codes {
+		if _, ok := alertmodel.AlertCodeToCategorization[code.Value]; ok {
+			return alertmodel.EventCodeMsg{ValueName: code.ValueName, Value: code.Value}
+		}
+	}
+	for _, code := range codes {
+		if code.Value != "" && code.Value != "NWS" {
+			return alertmodel.EventCodeMsg{ValueName: code.ValueName, Value: code.Value}
+		}
+	}
+
+	return alertmodel.EventCodeMsg{}
+}
+
+func parseReferences(refs string) []string {
+	var newRefsArray []string
+	refsArray := strings.Split(refs, ",")
+	for _, ref := range refsArray {
+		if strings.Contains(ref, "urn:oid:") {
+			strippedRef := strings.Split(ref, "urn:oid:")
+			newRefsArray = append(newRefsArray, strippedRef[1])
+		}
+	}
+
+	return newRefsArray
+}
+
+// UTC3339 normalizes a cap time to utc with milliseconds, the format
+// every alert time is cached in
+func UTC3339(alertTimeMsg string) (string, error) {
+	isoTime, err := time.Parse(time.RFC3339, alertTimeMsg)
+	if err != nil {
+		return "", err
+	}
+	formatted := isoTime.UTC().Format("2006-01-02T15:04:05.000Z0700")
+
+	return formatted, nil
+}
@@ -0,0 +1,219 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type nwsFeed struct {
+	Features []json.RawMessage `json:"features"`
+}
+
+type nwsFeature struct {
+	Geometry   *nwsGeometry  `json:"geometry"`
+	Properties nwsProperties `json:"properties"`
+}
+
+type nwsGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type nwsProperties struct {
+	ID          string              `json:"id"`
+	AreaDesc    string              `json:"areaDesc"`
+	Geocode     map[string][]string `json:"geocode"`
+	References  []nwsReference      `json:"references"`
+	Sent        string              `json:"sent"`
+	Effective   string              `json:"effective"`
+	Onset       *string             `json:"onset"`
+	Expires     string              `json:"expires"`
+	Status      string              `json:"status"`
+	MessageType string              `json:"messageType"`
+	Category    string              `json:"category"`
+	Severity    string              `json:"severity"`
+	Certainty   string              `json:"certainty"`
+	Urgency     string              `json:"urgency"`
+	Event       string              `json:"event"`
+	Headline    *string             `json:"headline"`
+	Description string              `json:"description"`
+	Instruction *string             `json:"instruction"`
+	Response    string              `json:"response"`
+	EventCode   map[string][]string `json:"eventCode"`
+}
+
+type nwsReference struct {
+	Identifier string `json:"identifier"`
+}
+
+// NWS parses the api.weather.gov alerts geojson, the feed carries
+// the same cap identifiers the nws sends to ipaws
+func NWS(body []byte) (*alertmodel.AlertsMsg, error) {
+	feed := nwsFeed{}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, err
+	}
+
+	parsedAlerts := alertmodel.AlertsMsg{}
+	for _, raw := range feed.Features {
+		// a malformed alert is dropped on its own, failing the feed
+		// would hold back every other alert in it
+		feature := nwsFeature{}
+		if err := json.Unmarshal(raw, &feature); err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("skipping malformed alert")
+			continue
+		}
+		props := feature.Properties
+		strippedId := strings.Split(props.ID, "urn:oid:")
+		if len(strippedId) != 2 {
+			continue
+		}
+
+		parsedAlert := alertmodel.AlertMsg{
+			Identifier: strippedId[1],
+			Status:     props.Status,
+			MsgType:    props.MessageType,
+			Scope:      "Public",
+			Raw:        string(raw),
+			RawFormat:  FormatNWS,
+		}
+		parsedAlert.Sent, _ = UTC3339(props.Sent)
+		for _, ref := range props.References {
+			if strippedRef := strings.Split(ref.Identifier, "urn:oid:"); len(strippedRef) == 2 {
+				parsedAlert.References = append(parsedAlert.References, strippedRef[1])
+			}
+		}
+
+		info, err := parseNWSInfo(feature)
+		if err != nil {
+			log.WithFields(log.Fields{"alertId": parsedAlert.Identifier, "error": err}).
+				Warn("skipping malformed alert")
+			continue
+		}
+		parsedAlert.Info = *info
+		parsedAlert.Infos = []alertmodel.InfoMsg{*info}
+
+		parsedAlerts.Alert = append(parsedAlerts.Alert, parsedAlert)
+	}
+
+	return &parsedAlerts, nil
+}
+
+func parseNWSInfo(feature nwsFeature) (*alertmodel.InfoMsg, error) {
+	props := feature.Properties
+
+	// the feed keeps cap value pairs as a map of valueName to values
+	var codes []ValuePairXML
+	for _, name := range []string{"SAME", "NationalWeatherService"} {
+		for _, val := range props.EventCode[name] {
+			codes = append(codes, ValuePairXML{ValueName: name, Value: val})
+		}
+	}
+
+	info := alertmodel.InfoMsg{
+		Language:     "en-US",
+		Category:     props.Category,
+		Event:        props.Event,
+		ResponseType: props.Response,
+		Urgency:      props.Urgency,
+		Severity:     props.Severity,
+		Certainty:    props.Certainty,
+		EventCode:    getEventCode(codes),
+		Description:  props.Description,
+	}
+	if props.Headline != nil {
+		info.Headline = *props.Headline
+	}
+	if props.Instruction != nil {
+		info.Instruction = *props.Instruction
+	}
+
+	var tErr error
+	info.Effective, tErr = UTC3339(props.Effective)
+	if tErr != nil {
+		return nil, fmt.Errorf("failed to convert effective event time (%s)", props.Effective)
+	}
+	// onset is optional in the feed, cap falls back to effective
+	onset := props.Effective
+	if props.Onset != nil {
+		onset = *props.Onset
+	}
+	info.Onset, tErr = UTC3339(onset)
+	if tErr != nil {
+		return nil, fmt.Errorf("failed to convert onset event time (%s)", onset)
+	}
+	info.Expires, tErr = UTC3339(props.Expires)
+	if tErr != nil {
+		return nil, fmt.Errorf("failed to convert expires event time (%s)", props.Expires)
+	}
+
+	area := alertmodel.AreaMsg{
+		AreaDesc: props.AreaDesc,
+		Polygons: []string{},
+		Circles:  []string{},
+		Geocodes: []string{},
+	}
+	area.Geocodes = append(area.Geocodes, props.Geocode["UGC"]...)
+
+	polygons, err := getNWSPolygons(feature.Geometry)
+	if err != nil {
+		return nil, err
+	}
+	area.Polygons = append(area.Polygons, polygons...)
+	info.Areas = []alertmodel.AreaMsg{area}
+
+	return &info, nil
+}
+
+// geojson positions are long,lat, cap polygons are lat,long. only
+// the outer ring of each polygon is kept.
+func getNWSPolygons(geometry *nwsGeometry) ([]string, error) {
+	if geometry == nil {
+		return nil, nil
+	}
+
+	var rings [][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("malformed polygon: %s", err)
+		}
+		if len(coords) > 0 {
+			rings = append(rings, coords[0])
+		}
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("malformed multipolygon: %s", err)
+		}
+		for _, polygon := range coords {
+			if len(polygon) > 0 {
+				rings = append(rings, polygon[0])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %s", geometry.Type)
+	}
+
+	var polygons []string
+	for _, ring := range rings {
+		var strVertexArr []string
+		for _, pos := range ring {
+			if len(pos) < 2 {
+				return nil, fmt.Errorf("malformed position %v", pos)
+			}
+			strVertexArr = append(strVertexArr,
+				strconv.FormatFloat(pos[1], 'f', -1, 64)+","+strconv.FormatFloat(pos[0], 'f', -1, 64))
+		}
+		polygons = append(polygons, strings.Join(strVertexArr, " "))
+	}
+
+	return polygons, nil
+}
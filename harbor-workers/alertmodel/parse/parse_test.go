@@ -0,0 +1,153 @@
+package parse_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// the parsed feeds are what ingest caches and the api reads back,
+// a diff here is a change to the cached format
+func TestParseCompat(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		golden  string
+		parse   func([]byte) (*alertmodel.AlertsMsg, error)
+	}{
+		{"testdata/ipaws.xml", "testdata/ipaws.golden.json", parse.CAP},
+		{"testdata/nws.json", "testdata/nws.golden.json", parse.NWS},
+	} {
+		body, err := ioutil.ReadFile(tc.fixture)
+		if err != nil {
+			t.Fatalf("failed reading fixture: %s", err)
+		}
+		alerts, err := tc.parse(body)
+		if err != nil {
+			t.Fatalf("failed parsing %s: %s", tc.fixture, err)
+		}
+		got, err := json.MarshalIndent(alerts, "", "  ")
+		if err != nil {
+			t.Fatalf("failed marshalling %s: %s", tc.fixture, err)
+		}
+
+		if *update {
+			if err := ioutil.WriteFile(tc.golden, append(got, '\n'), 0644); err != nil {
+				t.Fatalf("failed writing %s: %s", tc.golden, err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(tc.golden)
+		if err != nil {
+			t.Fatalf("failed reading golden file: %s", err)
+		}
+		if !bytes.Equal(bytes.TrimSpace(want), got) {
+			t.Errorf("%s no longer parses to %s, bump alertmodel.Version and run with -update\n%s",
+				tc.fixture, tc.golden, got)
+		}
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		parse   func([]byte) (*alertmodel.AlertsMsg, error)
+	}{
+		{"testdata/ipaws.xml", parse.CAP},
+		{"testdata/nws.json", parse.NWS},
+	} {
+		body, err := ioutil.ReadFile(tc.fixture)
+		if err != nil {
+			t.Fatalf("failed reading fixture: %s", err)
+		}
+		alerts, err := tc.parse(body)
+		if err != nil {
+			t.Fatalf("failed parsing %s: %s", tc.fixture, err)
+		}
+		for _, alert := range alerts.Alert {
+			parsed, err := parse.Raw(alert.RawFormat, alert.Raw)
+			if err != nil {
+				t.Fatalf("failed reparsing %s: %s", alert.Identifier, err)
+			}
+			if !reflect.DeepEqual(*parsed, alert) {
+				t.Errorf("alert %s changed on reparse", alert.Identifier)
+			}
+		}
+	}
+
+	if _, err := parse.Raw("csv", "a,b"); err == nil {
+		t.Errorf("expected unknown formats to fail")
+	}
+}
+
+func TestUTC3339(t *testing.T) {
+	got, err := parse.UTC3339("2021-05-03T18:42:00-05:00")
+	if err != nil || got != "2021-05-03T23:42:00.000Z" {
+		t.Errorf("unexpected normalized time %s %v", got, err)
+	}
+	if _, err := parse.UTC3339("May 3"); err == nil {
+		t.Errorf("expected a malformed time to fail")
+	}
+}
+
+func TestCAPSkipsMalformedAlerts(t *testing.T) {
+	alert := func(id, expires string) string {
+		return `<alert xmlns="urn:oasis:names:tc:emergency:cap:1.2">
+    <identifier>urn:oid:` + id + `</identifier>
+    <sent>2021-05-03T18:42:00-05:00</sent>
+    <status>Actual</status>
+    <msgType>Alert</msgType>
+    <scope>Public</scope>
+    <info>
+      <event>Flood Warning</event>
+      <effective>2021-05-03T18:42:00-05:00</effective>
+      <onset>2021-05-03T18:42:00-05:00</onset>
+      <expires>` + expires + `</expires>
+    </info>
+  </alert>`
+	}
+	body := `<alerts xmlns="http://gov.fema.ipaws.services/IPAWSOPEN_EAS_SERVICE/">` +
+		alert("2.49.0.1.840.0.bad", "May 3 at 7:15PM") +
+		alert("2.49.0.1.840.0.good", "2021-05-03T19:15:00-05:00") +
+		`</alerts>`
+
+	alerts, err := parse.CAP([]byte(body))
+	if err != nil {
+		t.Fatalf("expected the feed to parse around the malformed alert: %s", err)
+	}
+	if len(alerts.Alert) != 1 || alerts.Alert[0].Identifier != "2.49.0.1.840.0.good" {
+		t.Errorf("expected only the well formed alert, got %+v", alerts.Alert)
+	}
+}
+
+func TestNWSSkipsMalformedAlerts(t *testing.T) {
+	feature := func(id, expires string) string {
+		return `{"properties": {
+			"id": "urn:oid:` + id + `",
+			"sent": "2021-05-03T18:42:00-05:00",
+			"effective": "2021-05-03T18:42:00-05:00",
+			"expires": "` + expires + `",
+			"status": "Actual",
+			"messageType": "Alert",
+			"event": "Flood Warning"
+		}}`
+	}
+	body := `{"features": [` +
+		feature("2.49.0.1.840.0.bad", "May 3 at 7:15PM") + `,` +
+		feature("2.49.0.1.840.0.good", "2021-05-03T19:15:00-05:00") + `]}`
+
+	alerts, err := parse.NWS([]byte(body))
+	if err != nil {
+		t.Fatalf("expected the feed to parse around the malformed alert: %s", err)
+	}
+	if len(alerts.Alert) != 1 || alerts.Alert[0].Identifier != "2.49.0.1.840.0.good" {
+		t.Errorf("expected only the well formed alert, got %+v", alerts.Alert)
+	}
+}
@@ -0,0 +1,35 @@
+package parse
+
+import (
+	"fmt"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+// formats of alertmodel.AlertMsg.Raw
+const (
+	FormatCAP = "cap"
+	FormatNWS = "nws-geojson"
+)
+
+// Raw parses a single alert kept in alertmodel.AlertMsg.Raw
+func Raw(format string, raw string) (*alertmodel.AlertMsg, error) {
+	var alerts *alertmodel.AlertsMsg
+	var err error
+	switch format {
+	case FormatCAP:
+		alerts, err = CAP([]byte("<alerts>" + raw + "</alerts>"))
+	case FormatNWS:
+		alerts, err = NWS([]byte(`{"features": [` + raw + `]}`))
+	default:
+		return nil, fmt.Errorf("unknown raw alert format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(alerts.Alert) != 1 {
+		return nil, fmt.Errorf("expected a single alert, parsed %d", len(alerts.Alert))
+	}
+
+	return &alerts.Alert[0], nil
+}
@@ -0,0 +1,46 @@
+package parse
+
+type AlertsXML struct {
+	Alert []AlertXML `xml:"alert"`
+}
+
+type AlertXML struct {
+	Identifier string    `xml:"identifier"`
+	Sent       string    `xml:"sent"`
+	Status     string    `xml:"status"`
+	MsgType    string    `xml:"msgType"`
+	Scope      string    `xml:"scope"`
+	References string    `xml:"references"`
+	Info       []InfoXML `xml:"info"`
+	Inner      string    `xml:",innerxml"`
+}
+
+type InfoXML struct {
+	Language     string         `xml:"language"`
+	Category     string         `xml:"category"`
+	Event        string         `xml:"event"`
+	ResponseType string         `xml:"responseType"`
+	Urgency      string         `xml:"urgency"`
+	Severity     string         `xml:"severity"`
+	Certainty    string         `xml:"certainty"`
+	EventCode    []ValuePairXML `xml:"eventCode"`
+	Effective    string         `xml:"effective"`
+	Onset        string         `xml:"onset"`
+	Expires      string         `xml:"expires"`
+	Headline     string         `xml:"headline"`
+	Description  string         `xml:"description"`
+	Instruction  string         `xml:"instruction"`
+	Area         []AreaXML      `xml:"area"`
+}
+
+type AreaXML struct {
+	AreaDesc string         `xml:"areaDesc"`
+	Polygon  []string       `xml:"polygon"`
+	Circle   []string       `xml:"circle"`
+	Geocode  []ValuePairXML `xml:"geocode"`
+}
+
+type ValuePairXML struct {
+	ValueName string `xml:"valueName"`
+	Value     string `xml:"value"`
+}
@@ -0,0 +1,166 @@
+package alertmodel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SeverityLevels is the level of alerts no rule or event code covers,
+// keyed by cap severity
+var SeverityLevels = map[string]AlertLevel{
+	"extreme":  DANGEROUS,
+	"severe":   WARNING,
+	"moderate": WATCH,
+	"minor":    AWARE,
+	"unknown":  AWARE,
+}
+
+// SeverityLevel returns the default level of a cap severity
+func SeverityLevel(severity string) AlertLevel {
+	if level, ok := SeverityLevels[strings.ToLower(severity)]; ok {
+		return level
+	}
+
+	return AWARE
+}
+
+// Rule overrides the categorization of the alerts it matches. Empty
+// match fields match anything, cap values are compared case
+// insensitively. An empty Level, Category or Text keeps the one of the
+// event code.
+type Rule struct {
+	EventCode    string
+	Urgency      string
+	Severity     string
+	Certainty    string
+	ResponseType string
+
+	Level    AlertLevel
+	Category AlertCategory
+	Text     string
+}
+
+func (r Rule) fields() []string {
+	return []string{r.EventCode, r.Urgency, r.Severity, r.Certainty, r.ResponseType}
+}
+
+func (r Rule) matches(info InfoMsg) bool {
+	values := []string{info.EventCode.Value, info.Urgency, info.Severity, info.Certainty, info.ResponseType}
+	for i, field := range r.fields() {
+		if field != "" && !strings.EqualFold(field, values[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// specificity is the number of fields the rule matches on
+func (r Rule) specificity() int {
+	n := 0
+	for _, field := range r.fields() {
+		if field != "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+// RuleSet is an ordered set of rules, the most specific matching rule
+// wins and an event code breaks ties. A nil RuleSet categorizes from
+// the event code table alone.
+type RuleSet struct {
+	rules []Rule
+}
+
+// NewRuleSet validates and orders the rules
+func NewRuleSet(rules []Rule) (*RuleSet, error) {
+	for i, r := range rules {
+		if r.Level != "" && !validLevel(r.Level) {
+			return nil, fmt.Errorf("rule %d has unknown level %s", i, r.Level)
+		}
+		if r.Category != "" && !validCategory(r.Category) {
+			return nil, fmt.Errorf("rule %d has unknown category %s", i, r.Category)
+		}
+		if r.Level == "" && r.Category == "" && r.Text == "" {
+			return nil, fmt.Errorf("rule %d changes nothing", i)
+		}
+	}
+
+	ordered := make([]Rule, len(rules))
+	copy(ordered, rules)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		si, sj := ordered[i].specificity(), ordered[j].specificity()
+		if si != sj {
+			return si > sj
+		}
+		return ordered[i].EventCode != "" && ordered[j].EventCode == ""
+	})
+
+	return &RuleSet{rules: ordered}, nil
+}
+
+// Len is the number of rules in the set
+func (rs *RuleSet) Len() int {
+	if rs == nil {
+		return 0
+	}
+
+	return len(rs.rules)
+}
+
+// Categorize starts from the event code table, codes outside it get
+// the level of their cap severity, and applies the first matching rule
+func (rs *RuleSet) Categorize(info InfoMsg) AlertCategorization {
+	code := info.EventCode.Value
+	data, ok := AlertCodeToCategorization[code]
+	if !ok {
+		data = AlertData{Text: info.Event, Level: SeverityLevel(info.Severity), Category: NONE}
+		if data.Text == "" {
+			data.Text = code
+		}
+	}
+
+	if rs != nil {
+		for _, r := range rs.rules {
+			if !r.matches(info) {
+				continue
+			}
+			if r.Level != "" {
+				data.Level = r.Level
+			}
+			if r.Category != "" {
+				data.Category = r.Category
+			}
+			if r.Text != "" {
+				data.Text = r.Text
+			}
+			break
+		}
+	}
+
+	return AlertCategorization{
+		Text:     data.Text,
+		Category: string(data.Category),
+		Code:     code,
+		Level:    string(data.Level),
+	}
+}
+
+func validLevel(level AlertLevel) bool {
+	switch level {
+	case CLEAR, AWARE, WATCH, WARNING, DANGEROUS:
+		return true
+	}
+	return false
+}
+
+func validCategory(category AlertCategory) bool {
+	switch category {
+	case EARTHQUAKE, FLOODS, HEATWAVES, HURRICANES, NONE, TORNADOES, TSUNAMIS, VOLCANO, WILDFIRE, WINTERSTORMS:
+		return true
+	}
+	return false
+}
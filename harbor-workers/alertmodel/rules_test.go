@@ -0,0 +1,83 @@
+package alertmodel_test
+
+import (
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+func info(code string, urgency string, severity string, certainty string) alertmodel.InfoMsg {
+	return alertmodel.InfoMsg{
+		Event:     "Test Event",
+		EventCode: alertmodel.EventCodeMsg{Value: code},
+		Urgency:   urgency,
+		Severity:  severity,
+		Certainty: certainty,
+	}
+}
+
+func TestRuleSetCategorize(t *testing.T) {
+	rs, err := alertmodel.NewRuleSet([]alertmodel.Rule{
+		{EventCode: "SVR", Level: alertmodel.WATCH},
+		{Urgency: "Immediate", Severity: "Extreme", Level: alertmodel.DANGEROUS},
+		{EventCode: "SVR", Severity: "extreme", Level: alertmodel.DANGEROUS, Text: "Destructive Thunderstorm Warning"},
+		{EventCode: "AQA", Category: alertmodel.HEATWAVES},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		info  alertmodel.InfoMsg
+		level alertmodel.AlertLevel
+		text  string
+		cat   alertmodel.AlertCategory
+	}{
+		{"code rule", info("SVR", "Immediate", "Severe", "Observed"), alertmodel.WATCH, "Severe Thunderstorm Warning", alertmodel.NONE},
+		{"ties break on the code", info("SVR", "Immediate", "Extreme", "Observed"), alertmodel.DANGEROUS, "Destructive Thunderstorm Warning", alertmodel.NONE},
+		{"most specific rule wins", info("SVR", "Expected", "Extreme", "Likely"), alertmodel.DANGEROUS, "Destructive Thunderstorm Warning", alertmodel.NONE},
+		{"rule without a code", info("TOR", "Immediate", "Extreme", "Observed"), alertmodel.DANGEROUS, "Tornado Warning", alertmodel.TORNADOES},
+		{"table without a match", info("TOR", "Immediate", "Severe", "Observed"), alertmodel.WARNING, "Tornado Warning", alertmodel.TORNADOES},
+		{"unknown code on severity", info("XYZ", "Future", "Moderate", "Possible"), alertmodel.WATCH, "Test Event", alertmodel.NONE},
+		{"unknown code with a rule", info("AQA", "Expected", "Minor", "Likely"), alertmodel.AWARE, "Test Event", alertmodel.HEATWAVES},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := rs.Categorize(tc.info)
+			want := alertmodel.AlertCategorization{
+				Text:     tc.text,
+				Category: string(tc.cat),
+				Code:     tc.info.EventCode.Value,
+				Level:    string(tc.level),
+			}
+			if got != want {
+				t.Errorf("expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestNilRuleSetCategorize(t *testing.T) {
+	var rs *alertmodel.RuleSet
+	if got := rs.Categorize(info("TOR", "", "", "")); got.Level != string(alertmodel.WARNING) {
+		t.Errorf("expected the table level, got %+v", got)
+	}
+	if got := rs.Categorize(info("XYZ", "", "Extreme", "")); got.Level != string(alertmodel.DANGEROUS) {
+		t.Errorf("expected the severity level, got %+v", got)
+	}
+	if got := rs.Categorize(alertmodel.InfoMsg{EventCode: alertmodel.EventCodeMsg{Value: "XYZ"}}); got.Text != "XYZ" || got.Level != string(alertmodel.AWARE) {
+		t.Errorf("expected the code and the lowest level, got %+v", got)
+	}
+}
+
+func TestNewRuleSetValidates(t *testing.T) {
+	for _, r := range []alertmodel.Rule{
+		{EventCode: "TOR", Level: "SEVERE"},
+		{EventCode: "TOR", Category: "Hail"},
+		{EventCode: "TOR"},
+	} {
+		if _, err := alertmodel.NewRuleSet([]alertmodel.Rule{r}); err == nil {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+}
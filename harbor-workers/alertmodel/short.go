@@ -0,0 +1,66 @@
+package alertmodel
+
+import (
+	"strings"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+)
+
+type ShortAlertMsg struct {
+	Identifier string   `json:"identifier"`
+	IsUpdate   bool     `json:"isUpdate"`
+	RefIds     []string `json:"referenceIDs"`
+	Language   string   `json:"language"`
+	// every language the alert was sent in, primary first. the
+	// short form is published once per language.
+	Languages      []string            `json:"languages,omitempty"`
+	AreaDesc       string              `json:"areaDesc"`
+	Categorization AlertCategorization `json:"categorization"`
+	BoundingBox    string              `json:"boundingBox"`
+	Polygon        string              `json:"polygon"`
+	Geometry       *geo.Polygon        `json:"geometry"`
+	OnsetTime      string              `json:"onsetTime"`
+	ExpirationTime string              `json:"expirationTime"`
+}
+
+// Primary reports whether this is the variant in the primary
+// language, short forms without Languages have a single variant
+func (s ShortAlertMsg) Primary() bool {
+	return len(s.Languages) == 0 || s.Language == s.Languages[0]
+}
+
+// ForLanguage reports whether this is the variant to send a user
+// reading lang, the primary variant when the alert was not sent in
+// lang. "es-US" and "es" are the same language.
+func (s ShortAlertMsg) ForLanguage(lang string) bool {
+	if lang == "" {
+		return s.Primary()
+	}
+	if sameLanguage(s.Language, lang) {
+		return true
+	}
+	for _, l := range s.Languages {
+		if sameLanguage(l, lang) {
+			return false
+		}
+	}
+	return s.Primary()
+}
+
+func sameLanguage(a, b string) bool {
+	return strings.EqualFold(baseLanguage(a), baseLanguage(b))
+}
+
+func baseLanguage(tag string) string {
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+type AlertCategorization struct {
+	Text     string `json:"text"`
+	Category string `json:"category"`
+	Code     string `json:"code"`
+	Level    string `json:"level"`
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/go-redis/redis/v8"
-	geo "github.com/helloharbor/harbor-workers/ipaws/shared/geometries"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/env"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/iterable"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
 	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/notify"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/userlookup"
 	"github.com/jmoiron/sqlx"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"time"
@@ -20,18 +23,21 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	lambdaContext "github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/service/sns"
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	iterableTWURL string
-	pgDB          *sqlx.DB
-	redisConn     *redis.Client
-	retryClient   *http.Client
-	snsClient     *sns.SNS
-	stdFields     map[string]interface{}
+	iterableClient *iterable.Client
+	iterableDLQ    *iterable.DeadLetter
+	notifyLedger   *ledger.Ledger
+	pipeline       *notify.Pipeline
+	pgDB           *sqlx.DB
+	redisConn      *redis.Client
+	snsClient      *sns.SNS
+	stdFields      map[string]interface{}
+	userLookup     *userlookup.Lookup
 
 	ctx = context.Background()
 
@@ -41,124 +47,164 @@ var (
 	snsArn      = os.Getenv("NOTIFICATIONS_SNS_ARN")
 )
 
-type UserRow struct {
-	Email     *string `db:"email"`
-	Address   *string `db:"address"`
-	Latitude  *string `db:"latitude"`
-	Longitude *string `db:"longitude"`
-}
-
-type User struct {
-	Email     string
-	Address   string
-	Latitude  string
-	Longitude string
-}
-
 func handler(awsCtx context.Context, req events.SNSEvent) error {
 	setCtxFields(awsCtx)
+	now := time.Now()
 
 	snsMsgBody := req.Records[0].SNS.Message
-	var alertObj = models.ShortAlertMsg{}
+	var alertObj = alertmodel.ShortAlertMsg{}
 	err := json.Unmarshal([]byte(snsMsgBody), &alertObj)
 	if err != nil {
 		log.WithFields(stdFields).
 			WithFields(log.Fields{"alert-obj": alertObj, "err": err}).Fatal("failed to extract message from sns")
 	}
 
-	users, err := fetchUsersInRange(alertObj.BoundingBox)
+	users, err := fetchUsersInRange(alertObj)
 	if err != nil {
 		log.WithFields(stdFields).
 			WithFields(log.Fields{"boundingBox": alertObj.BoundingBox, "err": err}).Fatal("failed to fetch users in range")
 	}
+	if len(users) > 0 {
+		users = filterByPreferences(alertObj, users, now)
+	}
+	if len(users) == 0 {
+		return nil
+	}
 
-	if users != nil {
-		err = sendToNotificationSNS(alertObj, users)
-		if err != nil {
-			log.WithFields(stdFields).
-				WithFields(log.Fields{"err": err}).Warn("failed to place beacon users data to SNS topic")
-		}
+	res, err := filterByLedger(alertObj, users, now)
+	if err != nil {
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"alertId": alertObj.Identifier, "err": err}).Fatal("failed to check notification ledger")
+	}
+	users = res.Users
+	if len(users) == 0 {
+		return nil
+	}
 
-		triggerAlerts, err := createTriggerAlerts(alertObj, *users)
-		if err != nil {
-			log.WithFields(stdFields).
-				WithFields(log.Fields{"alert": alertObj, "err": err}).Fatal("failed to create triggers")
-		}
+	err = sendToNotificationSNS(alertObj, users)
+	if err != nil {
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"err": err}).Warn("failed to place beacon users data to SNS topic")
+	}
 
-		if triggerAlerts != nil && environment != "development" {
-			err = invokeIterableWorkflows(*triggerAlerts)
-			if err != nil {
-				log.WithFields(stdFields).
-					WithFields(log.Fields{"triggerAlerts": *triggerAlerts, "err": err}).
-					Fatal("failed to send iterable notifications")
-			}
-		}
+	// the sends are reserved in the ledger, they are only recorded
+	// once delivered and given back otherwise so the sns retry
+	// sends them again
+	triggerAlerts, err := pipeline.Triggers(ctx, alertObj, users)
+	if err != nil {
+		releaseSends(alertObj, res.Chain, users)
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"alertId": alertObj.Identifier, "num_users": len(users), "err": err}).Fatal("failed to create triggers")
+	}
+
+	var undelivered map[string]bool
+	if triggerAlerts != nil && environment != "development" {
+		undelivered, err = invokeIterableWorkflows(triggerAlerts)
+	}
+	delivered, failed := notify.Undelivered(users, undelivered)
+	if cErr := pipeline.Commit(ctx, alertObj, res.Chain, delivered, now); cErr != nil {
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"alertId": alertObj.Identifier, "err": cErr}).Error("failed to record notified users")
+	}
+	if err != nil {
+		releaseSends(alertObj, res.Chain, failed)
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"alertId": alertObj.Identifier, "num_triggers": len(triggerAlerts), "num_failed": len(failed), "err": err}).
+			Fatal("failed to send iterable notifications")
 	}
 
 	return nil
 }
 
-func invokeIterableWorkflows(triggers []models.WorkflowTriggerAlert) error {
-	for _, trigger := range triggers {
-
-		tBuff, err := json.Marshal(trigger)
-		if err != nil {
-			return fmt.Errorf("failed to marshal object %+v: %s", trigger, err)
-		}
+// invokeIterableWorkflows delivers the triggers in bulk, chunks that
+// can not be delivered are dead lettered for replay. Returns the
+// emails of the chunks that could not be dead lettered either.
+func invokeIterableWorkflows(triggers []models.WorkflowTriggerAlert) (map[string]bool, error) {
+	res := iterableClient.TrackBulk(ctx, triggers)
+	log.WithFields(stdFields).
+		WithFields(log.Fields{"sent": res.Sent, "rejected": res.Rejected, "failed_chunks": len(res.Failed)}).
+		Info("sent triggers to iterable")
+	if len(res.Failed) == 0 {
+		return nil, nil
+	}
 
-		log.WithFields(stdFields).WithFields(log.Fields{"url": iterableTWURL, "payload": bytes.NewBuffer(tBuff)}).
-			Info("sending payload to iterable")
+	for _, fc := range res.Failed {
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"num_events": len(fc.Chunk.Events), "err": fc.Err}).
+			Warn("dead lettering iterable chunk")
+	}
+	// dead lettered chunks are replayed, they count as delivered
+	if err := iterableDLQ.Send(ctx, res.Failed); err != nil {
+		return res.FailedEmails(), err
+	}
 
-		resp, err := retryClient.Post(iterableTWURL, "application/json", bytes.NewBuffer(tBuff))
-		if err != nil {
-			return fmt.Errorf("failed to send message %s: %s", string(tBuff), err)
-		}
+	return nil, nil
+}
 
-		if resp.StatusCode != 200 {
-			b, _ := ioutil.ReadAll(resp.Body)
-			return fmt.Errorf("failed to post workflow (%d: %s)", resp.StatusCode, string(b))
-		}
+// releaseSends gives back the ledger reservations of users that were
+// not delivered
+func releaseSends(alert alertmodel.ShortAlertMsg, chain string, users []notify.User) {
+	if err := pipeline.Release(ctx, alert, chain, users); err != nil {
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"alertId": alert.Identifier, "err": err}).Error("failed to release notification ledger")
 	}
-
-	return nil
 }
 
-func fetchUsersInRange(boundingBox string) (*[]User, error) {
-	bbr, err := geo.GetBoundingBoxFromString(boundingBox)
+func fetchUsersInRange(alert alertmodel.ShortAlertMsg) ([]notify.User, error) {
+	users, res, err := pipeline.UsersInRange(alert)
 	if err != nil {
-		return nil, fmt.Errorf("get bounding box failed: %s", err)
+		return nil, err
 	}
-	if bbr == nil {
+	if res == nil {
 		log.WithFields(stdFields).Info("No bounding box")
 		return nil, nil
 	}
+	log.WithFields(stdFields).
+		WithFields(log.Fields{"bounding box": fmt.Sprintf("%+v", *res.Bounds),
+			"alertId": alert.Identifier, "num_candidates": res.Candidates, "num_users": len(users)}).
+		Info("filtered users")
+
+	return users, nil
+}
 
-	var res []*UserRow
-	if err := pgDB.Select(&res, query, bbr.LatLo, bbr.LatHi, bbr.LngLo, bbr.LngHi); err != nil {
-		return nil, fmt.Errorf("fetchUsersInRange query failed: %s", err)
+// filterByPreferences drops users whose preferences withhold the alert
+func filterByPreferences(alert alertmodel.ShortAlertMsg, users []notify.User, now time.Time) []notify.User {
+	allowed, reasons := notify.FilterByPreferences(alert, users, now)
+	withheld := map[string]int{}
+	for _, reason := range reasons {
+		withheld[reason] = withheld[reason] + 1
 	}
+	log.WithFields(stdFields).
+		WithFields(log.Fields{"alertId": alert.Identifier, "num_users": len(allowed), "withheld": withheld}).
+		Info("applied notification preferences")
 
-	var uArr []User
-	for _, v := range res {
-		uArr = append(uArr,
-			User{
-				Email:     *v.Email,
-				Address:   *v.Address,
-				Latitude:  *v.Latitude,
-				Longitude: *v.Longitude})
+	return allowed
+}
+
+// filterByLedger drops users already notified of the alert chain at
+// the same or a higher level, or notified too often recently, and
+// reserves the sends of the others
+func filterByLedger(alert alertmodel.ShortAlertMsg, users []notify.User, now time.Time) (*notify.LedgerResult, error) {
+	res, err := pipeline.FilterByLedger(ctx, alert, users, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = notifyLedger.Audit(ctx, alert, res.Chain, res.Suppressed, now); err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "err": err}).
+			Warn("failed to audit suppressed notifications")
 	}
 	log.WithFields(stdFields).
-		WithFields(log.Fields{"bounding box": fmt.Sprintf("%+v", *bbr),
-			"num_users": len(res), "users": fmt.Sprintf("%+v", uArr)}).
-		Info("filtered users")
+		WithFields(log.Fields{"alertId": alert.Identifier, "chain": res.Chain, "num_users": len(res.Users), "num_suppressed": len(res.Suppressed)}).
+		Info("applied notification ledger")
 
-	return &uArr, nil
+	return res, nil
 }
 
-func sendToNotificationSNS(msg models.ShortAlertMsg, users *[]User) error {
+func sendToNotificationSNS(msg alertmodel.ShortAlertMsg, users []notify.User) error {
 	var userStrs []string
 
-	for _, user := range *users {
+	for _, user := range users {
 		userStrs = append(userStrs, user.Email)
 	}
 
@@ -176,91 +222,18 @@ func sendToNotificationSNS(msg models.ShortAlertMsg, users *[]User) error {
 	}
 	usersMsgStr := string(usersMsg)
 
-	fmt.Printf("usersMsgStr: %s\nsnsArn: %s\n", usersMsgStr, snsArn)
-
 	_, err = snsClient.Publish(&sns.PublishInput{
 		Message:  &usersMsgStr,
 		TopicArn: &snsArn,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed sending sns\nmessage: %s\nsnsArn: %s", usersMsgStr, snsArn)
+		return fmt.Errorf("failed sending sns for alert(%s) to %d users: %s", msg.Identifier, len(userStrs), err)
 	}
 
 	return nil
 }
 
-func createTriggerAlerts(msg models.ShortAlertMsg, users []User) (*[]models.WorkflowTriggerAlert, error) {
-	var triggerAlerts []models.WorkflowTriggerAlert
-	currOutLvl, found := models.OutlookLevelDict[msg.Categorization.Level]
-	if !found {
-		return nil, fmt.Errorf("failed to lookup outlook level: %s", msg.Categorization.Level)
-	}
-	var prevOutLvl = "0"
-	if msg.IsUpdate && msg.RefIds != nil && len(msg.RefIds) > 0 {
-		var pErr error
-		prevOutLvl, pErr = GetPreviousOutlookLevel(msg)
-		if pErr != nil {
-			return nil, pErr
-		}
-	}
-
-	for _, user := range users {
-		ta := models.WorkflowTriggerAlert{
-			Name:  "BEACON",
-			Email: user.Email,
-			DataFields: models.BeaconFields{
-				PushData: models.BeaconPush{
-					Update:               msg.IsUpdate,
-					AlertId:              msg.Identifier,
-					AlertType:            msg.Categorization.Text,
-					CurrentOutlookLevel:  currOutLvl,
-					PreviousOutlookLevel: prevOutLvl,
-					WeatherEvent:         msg.Categorization.Category,
-					Deeplink:             "emergency",
-				},
-			},
-		}
-
-		triggerAlerts = append(triggerAlerts, ta)
-	}
-
-	return &triggerAlerts, nil
-}
-
-func GetPreviousOutlookLevel(msg models.ShortAlertMsg) (string, error) {
-	var prevOutLvl = "0"
-	lastStamp := time.Date(1971, time.November, 1, 1, 1, 0, 0, time.UTC)
-	for _, refId := range msg.RefIds {
-		fullAlert, err := redisConn.Get(ctx, refId).Result()
-		if err == redis.Nil {
-			log.WithFields(stdFields).Warningf("key(%s) not found in cache", refId)
-			return "", nil
-		} else if err != nil {
-			return "", fmt.Errorf("unexpected error during redis fetch(%s), %s", refId, err)
-		}
-
-		fullObj := models.AlertMsg{}
-
-		err = json.Unmarshal([]byte(fullAlert), &fullObj)
-		if err == nil {
-			return "", nil
-		}
-
-		msgDate, err := time.Parse(time.RFC3339, msg.OnsetTime)
-		if err != nil {
-			return "", fmt.Errorf("failed parsing %s: %s", msg.OnsetTime, err)
-		}
-		if msgDate.After(lastStamp) {
-			lastStamp = msgDate
-			code := models.AlertCodeToCategorization[fullObj.Info.EventCode.Value]
-			prevOutLvl = models.OutlookLevelDict[string(code.Level)]
-		}
-	}
-
-	return prevOutLvl, nil
-}
-
 func setCtxFields(awsCtx context.Context) {
 	lambdaCtx, ok := lambdaContext.FromContext(awsCtx)
 	reqID := ""
@@ -272,6 +245,10 @@ func setCtxFields(awsCtx context.Context) {
 }
 
 func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
 	opt, _ := redis.ParseURL(redisUrl)
 	redisConn = redis.NewClient(opt)
 
@@ -297,17 +274,34 @@ func init() {
 	}))
 	snsClient = sns.New(sess)
 
-	apiKey := os.Getenv("ITERABLE_API_KEY")
-	iterableBaseAPIURL := "https://api.iterable.com/api"
-	tpl := iterableBaseAPIURL + "/events/track?api_key=%s"
-	iterableTWURL = fmt.Sprintf(tpl, apiKey)
-
-	rC := retryablehttp.NewClient()
-	rC.Logger = nil
-	rC.RetryMax = 3
-	retryClient = rC.StandardClient()
-	retryClient.Timeout = 5 * time.Second
-	retryClient.Timeout = 5 * time.Second
+	userLookup = &userlookup.Lookup{
+		DB:       pgDB,
+		BufferKm: env.Float("ALERT_BUFFER_KM", 0),
+		MaxCells: env.Int("USER_LOOKUP_MAX_CELLS", 32),
+	}
+
+	notifyLedger = &ledger.Ledger{
+		Conn:   redisConn,
+		Prefix: "notification-ledger",
+		Limit:  env.Int("NOTIFY_RATE_LIMIT", 3),
+		Window: time.Duration(env.Int("NOTIFY_RATE_WINDOW_MINUTES", 60)) * time.Minute,
+	}
+	pipeline = &notify.Pipeline{Lookup: userLookup, Ledger: notifyLedger, Cache: redisConn}
+
+	// the iterable client retries on its own, honoring 429s
+	iterableClient = &iterable.Client{
+		BaseURL:     iterable.DefaultBaseURL,
+		APIKey:      os.Getenv("ITERABLE_API_KEY"),
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+		ChunkSize:   env.Int("ITERABLE_CHUNK_SIZE", 1000),
+		Concurrency: env.Int("ITERABLE_CONCURRENCY", 4),
+		MaxRetries:  env.Int("ITERABLE_MAX_RETRIES", 4),
+		Backoff:     time.Second,
+	}
+	iterableDLQ = &iterable.DeadLetter{
+		SQS:      sqs.New(sess),
+		QueueURL: os.Getenv("ITERABLE_DLQ_URL"),
+	}
 }
 
 func main() {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	lambdaContext "github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/alertstate"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/env"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/iterable"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/userlookup"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	alertStore     *alertstate.Store
+	iterableClient *iterable.Client
+	iterableDLQ    *iterable.DeadLetter
+	notifyLedger   *ledger.Ledger
+	pgDB           *sqlx.DB
+	redisConn      *redis.Client
+	stdFields      map[string]interface{}
+	userLookup     *userlookup.Lookup
+
+	ctx = context.Background()
+
+	awsRegion   = os.Getenv("AWS_REGION")
+	redisUrl    = os.Getenv("REDIS_URL")
+	environment = os.Getenv("LAMBDA_ENV")
+)
+
+// handler runs on a schedule, every chain the ledger notified users of
+// is checked and once none of its alerts are active the users are sent
+// the all clear
+func handler(awsCtx context.Context) error {
+	setCtxFields(awsCtx)
+	now := time.Now()
+
+	chains, err := notifyLedger.OpenChains(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var triggers []models.WorkflowTriggerAlert
+	for _, chain := range chains {
+		chainTriggers, err := clearChain(chain, now)
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"chain": chain, "err": err}).
+				Error("failed to clear chain")
+			continue
+		}
+		triggers = append(triggers, chainTriggers...)
+	}
+	log.WithFields(stdFields).
+		WithFields(log.Fields{"num_chains": len(chains), "num_triggers": len(triggers)}).
+		Info("checked open chains")
+
+	if len(triggers) > 0 && environment != "development" {
+		if err = invokeIterableWorkflows(triggers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearChain returns the all clear triggers for the chain, none while
+// any of its alerts is still active
+func clearChain(chain string, now time.Time) ([]models.WorkflowTriggerAlert, error) {
+	n, err := notifyLedger.Notified(ctx, chain)
+	if err != nil {
+		return nil, err
+	}
+	active, err := alertStore.Active(ctx, n.Alerts, now)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, nil
+	}
+
+	var userIDs []int
+	for userID := range n.Levels {
+		userIDs = append(userIDs, userID)
+	}
+	rows, err := userLookup.UsersByID(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ready, deferred := pendingClear(n, rows, now)
+	cleared, err := notifyLedger.Clear(ctx, n, ready)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(stdFields).
+		WithFields(log.Fields{"chain": chain, "num_cleared": len(cleared), "num_deferred": deferred}).
+		Info("cleared chain")
+
+	return createClearTriggers(n, cleared, rows), nil
+}
+
+// pendingClear returns the users of the chain to clear now, users in
+// their quiet hours are deferred. Users without a row have no email
+// anymore, they are cleared without a notification.
+func pendingClear(n *ledger.Notified, rows []*userlookup.Row, now time.Time) ([]int, int) {
+	byID := map[int]*userlookup.Row{}
+	for _, row := range rows {
+		byID[row.ID] = row
+	}
+
+	var ready []int
+	deferred := 0
+	for userID := range n.Levels {
+		if row, ok := byID[userID]; ok && row.Preferences().InQuietHours(now) {
+			deferred++
+			continue
+		}
+		ready = append(ready, userID)
+	}
+
+	return ready, deferred
+}
+
+// createClearTriggers moves the cleared users from the level they were
+// last sent to CLEAR through the BEACON workflow
+func createClearTriggers(n *ledger.Notified, cleared []int, rows []*userlookup.Row) []models.WorkflowTriggerAlert {
+	byID := map[int]*userlookup.Row{}
+	for _, row := range rows {
+		byID[row.ID] = row
+	}
+
+	var triggers []models.WorkflowTriggerAlert
+	for _, userID := range cleared {
+		row, ok := byID[userID]
+		if !ok || row.Email == nil {
+			continue
+		}
+		channels := row.Preferences().Channels
+		if len(channels) == 0 {
+			continue
+		}
+
+		triggers = append(triggers, models.WorkflowTriggerAlert{
+			Name:  "BEACON",
+			Email: *row.Email,
+			DataFields: models.BeaconFields{
+				PushData: models.BeaconPush{
+					Update:               true,
+					AlertId:              n.Chain,
+					AlertType:            n.Categorization.Text,
+					PreviousOutlookLevel: n.Levels[userID],
+					CurrentOutlookLevel:  models.OutlookLevelDict[string(alertmodel.CLEAR)],
+					WeatherEvent:         n.Categorization.Category,
+					Deeplink:             "emergency",
+				},
+				Channels: channels,
+			},
+		})
+	}
+
+	return triggers
+}
+
+// invokeIterableWorkflows delivers the triggers in bulk, chunks that
+// can not be delivered are dead lettered for replay
+func invokeIterableWorkflows(triggers []models.WorkflowTriggerAlert) error {
+	res := iterableClient.TrackBulk(ctx, triggers)
+	log.WithFields(stdFields).
+		WithFields(log.Fields{"sent": res.Sent, "rejected": res.Rejected, "failed_chunks": len(res.Failed)}).
+		Info("sent triggers to iterable")
+	if len(res.Failed) == 0 {
+		return nil
+	}
+
+	for _, fc := range res.Failed {
+		log.WithFields(stdFields).
+			WithFields(log.Fields{"num_events": len(fc.Chunk.Events), "err": fc.Err}).
+			Warn("dead lettering iterable chunk")
+	}
+
+	return iterableDLQ.Send(ctx, res.Failed)
+}
+
+func setCtxFields(awsCtx context.Context) {
+	lambdaCtx, ok := lambdaContext.FromContext(awsCtx)
+	reqID := ""
+
+	if ok {
+		reqID = lambdaCtx.AwsRequestID
+	}
+	stdFields = log.Fields{"reqID": reqID}
+}
+
+func init() {
+	if os.Getenv("TESTING") == "1" {
+		return
+	}
+
+	log.SetLevel(log.InfoLevel)
+	log.SetFormatter(&log.JSONFormatter{
+		DisableTimestamp: true,
+	})
+	log.SetOutput(os.Stdout)
+
+	opt, _ := redis.ParseURL(redisUrl)
+	redisConn = redis.NewClient(opt)
+
+	d, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		panic(err)
+	}
+	pgDB = d
+	userLookup = &userlookup.Lookup{DB: pgDB}
+
+	// the notifier and ingest own these, the prefixes must match
+	notifyLedger = &ledger.Ledger{Conn: redisConn, Prefix: "notification-ledger"}
+	alertStore = &alertstate.Store{Conn: redisConn, Prefix: os.Getenv("REDIS_ALERT_QUEUE_KEY")}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(awsRegion),
+	}))
+	iterableClient = &iterable.Client{
+		BaseURL:     iterable.DefaultBaseURL,
+		APIKey:      os.Getenv("ITERABLE_API_KEY"),
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+		ChunkSize:   env.Int("ITERABLE_CHUNK_SIZE", 1000),
+		Concurrency: env.Int("ITERABLE_CONCURRENCY", 4),
+		MaxRetries:  env.Int("ITERABLE_MAX_RETRIES", 4),
+		Backoff:     time.Second,
+	}
+	iterableDLQ = &iterable.DeadLetter{
+		SQS:      sqs.New(sess),
+		QueueURL: os.Getenv("ITERABLE_DLQ_URL"),
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/userlookup"
+	"github.com/lib/pq"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func notified() *ledger.Notified {
+	return &ledger.Notified{
+		Chain: "tor.1",
+		Categorization: alertmodel.AlertCategorization{
+			Text:     "Tornado Warning",
+			Category: string(alertmodel.TORNADOES),
+			Code:     "TOR",
+			Level:    string(alertmodel.WARNING),
+		},
+		Levels: map[int]string{
+			1: models.OutlookLevelDict[string(alertmodel.WARNING)],
+			2: models.OutlookLevelDict[string(alertmodel.WATCH)],
+			3: models.OutlookLevelDict[string(alertmodel.WARNING)],
+		},
+	}
+}
+
+func TestPendingClear(t *testing.T) {
+	rows := []*userlookup.Row{
+		{ID: 1, Email: strPtr("one@example.com")},
+		{
+			ID: 2, Email: strPtr("two@example.com"), HasPreferences: true,
+			QuietStart: strPtr("22:00"), QuietEnd: strPtr("07:00"), Timezone: strPtr("America/Chicago"),
+			Channels: pq.StringArray{"push"},
+		},
+	}
+
+	// 23:30 in chicago
+	night := time.Date(2021, 5, 4, 4, 30, 0, 0, time.UTC)
+	ready, deferred := pendingClear(notified(), rows, night)
+	if len(ready) != 2 || deferred != 1 {
+		t.Fatalf("expected user 2 to be deferred, got %v and %d", ready, deferred)
+	}
+	for _, id := range ready {
+		if id == 2 {
+			t.Errorf("expected user 2 to be deferred during quiet hours")
+		}
+	}
+
+	if ready, deferred = pendingClear(notified(), rows, night.Add(time.Hour*10)); len(ready) != 3 || deferred != 0 {
+		t.Errorf("expected every user ready, got %v and %d", ready, deferred)
+	}
+}
+
+func TestCreateClearTriggers(t *testing.T) {
+	rows := []*userlookup.Row{
+		{ID: 1, Email: strPtr("one@example.com")},
+		{ID: 2, Email: strPtr("two@example.com"), HasPreferences: true, Channels: pq.StringArray{"sms", "email"}},
+		{ID: 4, Email: strPtr("four@example.com"), HasPreferences: true},
+	}
+
+	// user 3 is gone and user 4 turned off every channel
+	triggers := createClearTriggers(notified(), []int{1, 2, 3, 4}, rows)
+	if len(triggers) != 2 {
+		t.Fatalf("expected 2 triggers, got %+v", triggers)
+	}
+
+	push := triggers[1].DataFields.PushData
+	if triggers[1].Email != "two@example.com" || push.AlertId != "tor.1" || !push.Update {
+		t.Errorf("unexpected trigger %+v", triggers[1])
+	}
+	if push.PreviousOutlookLevel != models.OutlookLevelDict[string(alertmodel.WATCH)] ||
+		push.CurrentOutlookLevel != models.OutlookLevelDict[string(alertmodel.CLEAR)] {
+		t.Errorf("expected a watch to clear transition, got %+v", push)
+	}
+	if push.AlertType != "Tornado Warning" || push.WeatherEvent != string(alertmodel.TORNADOES) {
+		t.Errorf("expected the chain categorization, got %+v", push)
+	}
+	if len(triggers[1].DataFields.Channels) != 2 || triggers[0].DataFields.Channels[0] != "push" {
+		t.Errorf("expected the user channels, got %v and %v", triggers[0].DataFields.Channels, triggers[1].DataFields.Channels)
+	}
+}
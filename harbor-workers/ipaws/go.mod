@@ -3,12 +3,19 @@ module github.com/helloharbor/harbor-workers/ipaws
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/aws/aws-lambda-go v1.26.0
 	github.com/aws/aws-sdk-go v1.40.44
 	github.com/go-redis/redis/v8 v8.11.3
 	github.com/golang/geo v0.0.0-20210211234256-740aa86cb551
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-workers/alertmodel v1.2.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.3
+	github.com/mmcloughlin/geohash v0.10.0
 	github.com/sirupsen/logrus v1.8.1
 )
+
+// the alert model is built from this checkout rather than the
+// tagged version
+replace github.com/helloharbor/harbor-workers/alertmodel => ../alertmodel
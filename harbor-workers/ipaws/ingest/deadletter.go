@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// deadLetter is an alert that failed processing, kept with the
+// message as received so replay parses it again from scratch
+type deadLetter struct {
+	Identifier string `json:"identifier"`
+	Stage      string `json:"stage"`
+	Error      string `json:"error"`
+	Format     string `json:"format"`
+	Raw        string `json:"raw"`
+	FailedAt   string `json:"failedAt"`
+}
+
+func newDeadLetter(alert alertmodel.AlertMsg, aErr *alertError, now time.Time) deadLetter {
+	return deadLetter{
+		Identifier: alert.Identifier,
+		Stage:      aErr.Stage,
+		Error:      aErr.Err.Error(),
+		Format:     alert.RawFormat,
+		Raw:        alert.Raw,
+		FailedAt:   now.UTC().Format(time.RFC3339),
+	}
+}
+
+func sendDeadLetter(dl deadLetter) error {
+	body, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %s", err)
+	}
+
+	_, err = sqsClient.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(dlqURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"stage": {DataType: aws.String("String"), StringValue: aws.String(dl.Stage)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to dead letter alert(%s): %s", dl.Identifier, err)
+	}
+
+	return nil
+}
+
+// replayDeadLetters reprocesses up to max dead lettered alerts.
+// Replayed and skipped alerts are deleted, alerts that fail again
+// stay on the queue until their visibility timeout expires.
+func replayDeadLetters(max int) (*runSummary, error) {
+	summary := newRunSummary(modeReplay)
+	for summary.total() < max {
+		out, err := sqsClient.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(dlqURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(1),
+		})
+		if err != nil {
+			return summary, fmt.Errorf("failed to receive dead letters: %s", err)
+		}
+		if len(out.Messages) == 0 {
+			return summary, nil
+		}
+
+		for _, msg := range out.Messages {
+			// a malformed message can never be replayed, drop it
+			dl := deadLetter{}
+			if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &dl); err != nil {
+				log.WithFields(stdFields).WithFields(log.Fields{"messageId": aws.StringValue(msg.MessageId), "error": err}).
+					Error("dropping malformed dead letter")
+				summary.record(outcomeMalformed)
+				if err := deleteDeadLetter(msg); err != nil {
+					return summary, err
+				}
+				continue
+			}
+			alert, err := parse.Raw(dl.Format, dl.Raw)
+			if err != nil {
+				log.WithFields(stdFields).WithFields(log.Fields{"alertId": dl.Identifier, "error": err}).
+					Error("failed to parse dead lettered alert")
+				summary.failed(dl.Identifier, &alertError{Stage: stageParse, Err: err})
+				continue
+			}
+
+			// nothing was recorded for alerts that failed caching,
+			// a later poll may have processed them since
+			skipCacheCheck := dl.Stage != stageCache
+			outcome, err := processAlert(*alert, skipCacheCheck)
+			if err != nil {
+				summary.failed(dl.Identifier, err.(*alertError))
+				continue
+			}
+			summary.record(outcome)
+
+			if err := deleteDeadLetter(msg); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	return summary, nil
+}
+
+func deleteDeadLetter(msg *sqs.Message) error {
+	_, err := sqsClient.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(dlqURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter %s: %s", aws.StringValue(msg.MessageId), err)
+	}
+
+	return nil
+}
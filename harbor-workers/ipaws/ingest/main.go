@@ -2,9 +2,9 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
-	"io"
-	"io/ioutil"
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -16,8 +16,18 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/alertstate"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/archive"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/env"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/rules"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/shortform"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/sources"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 
@@ -25,12 +35,17 @@ import (
 )
 
 var (
-	pgDB        *sqlx.DB
-	retryClient *http.Client
-	redisConn   *redis.Client
-	snsClient   *sns.SNS
-	stdFields   map[string]interface{}
-	uploader    *s3manager.Uploader
+	alertArchive  *archive.Archive
+	alertStore    *alertstate.Store
+	categoryRules *rules.Store
+	pgDB          *sqlx.DB
+	retryClient   *http.Client
+	redisConn     *redis.Client
+	shortForms    *shortform.Builder
+	snsClient     snsiface.SNSAPI
+	sqsClient     sqsiface.SQSAPI
+	stdFields     map[string]interface{}
+	uploader      *s3manager.Uploader
 
 	ctx     = context.Background()
 	traceID = ""
@@ -39,106 +54,272 @@ var (
 	redisUrl  = os.Getenv("REDIS_URL")
 	s3bucket  = os.Getenv("BUCKET_NAME")
 	snsArn    = os.Getenv("IPAWS_SNS_ARN")
+	dlqURL    = os.Getenv("INGEST_DLQ_URL")
 
-	ipawsURL = "https://apps.fema.gov/IPAWSOPEN_EAS_SERVICE/rest/public/recent/2012-08-21T11:40:43Z?pin=N3Z3Y3p3MWphajE"
+	alertSources []sources.AlertSource
 )
 
-func handler(awsCtx context.Context) error {
+const (
+	ipawsURL = "https://apps.fema.gov/IPAWSOPEN_EAS_SERVICE/rest/public/recent"
+	nwsURL   = "https://api.weather.gov/alerts/active"
+)
+
+// Request is the invocation payload. The schedule polls the
+// sources, invoke with {"replay": true, "max": 100} to replay dead
+// lettered alerts.
+type Request struct {
+	Replay bool `json:"replay"`
+	Max    int  `json:"max"`
+}
+
+func handler(awsCtx context.Context, req Request) error {
 	setCtxFields(awsCtx)
 
-	resp, err := retryClient.Get(ipawsURL)
-	if err != nil {
-		log.WithFields(stdFields).WithFields(log.Fields{"url": ipawsURL, "error": err}).
-			Fatal("fetch failed")
+	if req.Replay {
+		max := req.Max
+		if max <= 0 {
+			max = 100
+		}
+		summary, err := replayDeadLetters(max)
+		summary.publish()
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"error": err}).Error("replay stopped")
+			return err
+		}
+		return nil
 	}
-	defer resp.Body.Close()
 
-	// get the bytes and refill the buffer for the s3 upload
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	bodyReader := ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		log.WithFields(stdFields).WithFields(log.Fields{"url": ipawsURL, "error": err}).
-			Fatal("io read failed")
+	var batches [][]alertmodel.AlertMsg
+	var fetched []sources.AlertSource
+	for _, source := range alertSources {
+		res, err := source.Fetch(awsCtx)
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"source": source.Name(), "error": err}).
+				Error("fetch failed")
+			continue
+		}
+
+		// TODO If ipaws is data we are going to use, convert to and save csvs to s3 for DW bulk loads
+		if err = uploadToS3(res.Raw, source.Name()+"/raw/", res.FileExt, res.ContentType); err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"source": source.Name(), "error": err}).
+				Error("s3 upload failed")
+		}
+
+		log.WithFields(stdFields).WithFields(log.Fields{"source": source.Name()}).
+			Infof("fetched %v alerts", len(res.Alerts))
+		batches = append(batches, res.Alerts)
+		fetched = append(fetched, source)
+	}
+	if len(batches) == 0 {
+		log.WithFields(stdFields).Fatal("every alert source failed")
 	}
 
-	// TODO Compress xml in memory before sending
-	// TODO If ipaws is data we are going to use, convert to and save csvs to s3 for DW bulk loads
-	if err = uploadToS3(bodyReader, "ipaws/raw/", ".xml"); err != nil {
-		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
-			Fatal("s3 upload failed")
+	// the same alert is often carried by more than one source
+	alerts := sources.Dedupe(batches...)
+
+	log.WithFields(stdFields).Infof("parsing %v alerts", len(alerts))
+	summary := ingestAlerts(alerts)
+
+	// failed alerts are dead lettered, the batch is done either way.
+	// a lost alert has to be fetched again so the watermarks hold.
+	if summary.Lost > 0 {
+		log.WithFields(stdFields).WithFields(log.Fields{"lost": summary.Lost}).
+			Warn("not committing watermarks, alerts could not be dead lettered")
+	} else {
+		commitWatermarks(fetched)
 	}
 
-	alerts, err := getAlerts(bodyBytes)
+	evicted, err := alertStore.Evict(ctx, time.Now())
 	if err != nil {
 		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
-			Fatal("getAlerts failed")
+			Error("failed to evict expired alerts")
+	} else {
+		log.WithFields(stdFields).Infof("evicted %v expired alerts", evicted)
 	}
+	summary.publish()
 
-	log.WithFields(stdFields).Infof("parsing %v alerts", len(alerts.Alert))
-	uniqueAlerts := 0
-	for _, alert := range alerts.Alert {
-		inCache, err := checkCacheForAlert(alert.Identifier)
-		if err != nil {
-			log.WithFields(stdFields).Fatalf("failed alert existence check: %s", err)
+	return nil
+}
+
+func commitWatermarks(fetched []sources.AlertSource) {
+	for _, source := range fetched {
+		inc, ok := source.(sources.Incremental)
+		if !ok {
+			continue
+		}
+		if err := inc.Commit(ctx); err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"source": source.Name(), "error": err}).
+				Error("failed to commit watermark")
+		}
+	}
+}
+
+// ingestAlerts processes every alert of a poll. A failed alert is
+// dead lettered and does not hold up the others, when that fails too
+// it is uncached so the next poll picks it up again.
+func ingestAlerts(alerts []alertmodel.AlertMsg) *runSummary {
+	summary := newRunSummary(modePoll)
+	for _, alert := range alerts {
+		outcome, err := processAlert(alert, false)
+		if err == nil {
+			summary.record(outcome)
+			continue
 		}
 
-		if inCache == false {
-			if err = cacheAlert(alert); err != nil {
-				redisConn.Del(ctx, alert.Identifier)
+		aErr := err.(*alertError)
+		summary.failed(alert.Identifier, aErr)
+		if err = sendDeadLetter(newDeadLetter(alert, aErr, time.Now())); err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "error": err}).
+				Error("failed to dead letter alert")
+			summary.Lost = summary.Lost + 1
+			if err = redisConn.Del(ctx, alert.Identifier).Err(); err != nil {
 				log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "error": err}).
-					Fatal("failed to cache alert")
+					Error("failed to uncache alert")
 			}
+		}
+	}
+
+	return summary
+}
+
+// stages an alert can fail in
+const (
+	stageParse   = "parse"
+	stageCache   = "cache"
+	stageArchive = "archive"
+	stageState   = "state"
+	stagePublish = "publish"
+)
+
+// outcomes of an alert that did not fail
+const (
+	outcomeProcessed  = "processed"
+	outcomeSeen       = "seen"
+	outcomeUnmapped   = "unmapped"
+	outcomeSuperseded = "superseded"
+	// replayed dead letters that could not be read
+	outcomeMalformed = "malformed"
+)
+
+// alertError is a failure processing a single alert
+type alertError struct {
+	Stage string
+	Err   error
+}
+
+func (e *alertError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Stage, e.Err)
+}
+
+// processAlert caches, records and publishes a single alert. A failed
+// alert is left cached so the next poll does not pick it up half
+// processed, it is retried by replaying its dead letter instead.
+// Replay republishes every short form alert, the notification ledger
+// drops the duplicates users were already sent.
+func processAlert(alert alertmodel.AlertMsg, skipCacheCheck bool) (string, error) {
+	if !skipCacheCheck {
+		inCache, err := checkCacheForAlert(alert.Identifier)
+		if err != nil {
+			return "", &alertError{Stage: stageCache, Err: err}
+		}
+		if inCache {
+			return outcomeSeen, nil
+		}
+	}
+
+	// a cancel withdraws its references, there is nothing new to notify
+	isCancel := strings.ToLower(alert.MsgType) == "cancel"
+	if !isCancel {
+		cat := categorize(alert.Info)
+		alert.Categorization = &cat
+	}
+
+	if err := cacheAlert(alert); err != nil {
+		return "", &alertError{Stage: stageCache, Err: err}
+	}
+	log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier}).
+		Info("cached alert")
+
+	// an event can spawn multiple alerts. an alert whose geometry can
+	// not be mapped still withdraws the alerts it references.
+	var shortFormAlerts []*alertmodel.ShortAlertMsg
+	unmapped := false
+	if !isCancel {
+		var err error
+		shortFormAlerts, err = shortForms.Build(alert)
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "error": err}).Warn()
+			shortFormAlerts, unmapped = nil, true
+		} else if len(shortFormAlerts) == 0 {
 			log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier}).
-				Info("cached alert")
+				Warn("alert has not geometries polygon/circle/geocode")
+		}
+	}
 
-			// an event can spawn multiple alerts
-			shortFormAlerts, err := getShortFormAlerts(alert)
-			if err != nil {
-				log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "error": err}).Warn()
-				continue
-			}
+	// the state and history hold the primary language, every
+	// language is published for the notifiers
+	primaryAlerts := shortform.Primary(shortFormAlerts)
 
-			for _, sfAlert := range shortFormAlerts {
-				if err := writeToAlertQueue(*sfAlert); err != nil {
-					redisConn.Del(ctx, alert.Identifier)
-					log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "error": err}).
-						Fatal("failed to write to events queue")
-				}
-				log.WithFields(stdFields).WithFields(log.Fields{"alertId": sfAlert.Identifier}).
-					Info("added alert to all alerts queue")
-
-				if err = sendShortFormAlert(*sfAlert); err != nil {
-					redisConn.Del(ctx, alert.Identifier)
-					log.WithFields(stdFields).WithFields(log.Fields{"alertId": sfAlert.Identifier, "error": err}).
-						Fatal("failed to publish alert")
-				}
-				log.WithFields(stdFields).WithFields(log.Fields{"alertId": sfAlert.Identifier}).
-					Info("published alert")
-			}
+	// archived before the state is applied, superseded and cancelled
+	// alerts still belong in the history
+	if alertArchive != nil {
+		if err := alertArchive.Save(ctx, alert, primaryAlerts); err != nil {
+			return "", &alertError{Stage: stageArchive, Err: err}
+		}
+	}
+
+	applied, err := alertStore.Apply(ctx, alert, primaryAlerts, time.Now())
+	if err != nil {
+		return "", &alertError{Stage: stageState, Err: err}
+	}
+	if !applied {
+		log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier}).
+			Info("alert already superseded, skipping")
+		return outcomeSuperseded, nil
+	}
+	log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "msgType": alert.MsgType}).
+		Info("updated alert state")
+	if unmapped {
+		return outcomeUnmapped, nil
+	}
 
-			uniqueAlerts = uniqueAlerts + 1
+	for _, sfAlert := range shortFormAlerts {
+		if err = sendShortFormAlert(*sfAlert); err != nil {
+			return "", &alertError{Stage: stagePublish, Err: err}
 		}
+		log.WithFields(stdFields).WithFields(log.Fields{"alertId": sfAlert.Identifier}).
+			Info("published alert")
 	}
-	log.WithFields(stdFields).Infof("processed %v unique alerts", uniqueAlerts)
 
-	return nil
+	return outcomeProcessed, nil
 }
 
-func uploadToS3(body io.ReadCloser, prefix string, fileExt string) error {
-	s3Key := prefix + getS3KeyFromDate() + fileExt
+// uploadToS3 archives the raw feed gzipped
+func uploadToS3(raw []byte, prefix string, fileExt string, contentType string) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	s3Key := prefix + getS3KeyFromDate() + fileExt + ".gz"
 	uploadParams := &s3manager.UploadInput{
-		ContentType: aws.String("application/xml"),
-		Key:         aws.String(s3Key),
-		Body:        body,
-		Bucket:      aws.String(s3bucket),
+		ContentType:     aws.String(contentType),
+		ContentEncoding: aws.String("gzip"),
+		Key:             aws.String(s3Key),
+		Body:            &buf,
+		Bucket:          aws.String(s3bucket),
 	}
 
 	_, err := uploader.Upload(uploadParams)
 	if err != nil {
 		return err
 	}
-	log.WithFields(stdFields).WithFields(log.Fields{"bucket": s3bucket, "key": s3Key}).
-		Info("xml event document uploaded to s3")
+	log.WithFields(stdFields).WithFields(log.Fields{"bucket": s3bucket, "key": s3Key, "bytes": len(raw), "gzipped": buf.Len()}).
+		Info("event document uploaded to s3")
 
 	return nil
 }
@@ -164,6 +345,10 @@ func setCtxFields(awsCtx context.Context) {
 }
 
 func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
 	log.SetLevel(log.InfoLevel)
 	log.SetFormatter(&log.JSONFormatter{
 		DisableTimestamp: true,
@@ -176,20 +361,72 @@ func init() {
 	}
 	pgDB = d
 
+	// categorization rules are reloaded while the container is warm
+	categoryRules = &rules.Store{DB: pgDB}
+	if rStr := os.Getenv("CATEGORIZATION_RULES_REFRESH"); rStr != "" {
+		r, err := time.ParseDuration(rStr)
+		if err != nil {
+			panic(fmt.Errorf("CATEGORIZATION_RULES_REFRESH malformed: %s", err))
+		}
+		categoryRules.Refresh = r
+	}
+	shortForms = &shortform.Builder{DB: pgDB, Categorize: categorize}
+
+	// archiving needs the writer, it is skipped when not configured
+	if conn := os.Getenv("ARCHIVE_DB_CONN"); conn != "" {
+		w, err := sqlx.Connect("postgres", conn)
+		if err != nil {
+			panic(err)
+		}
+		alertArchive = &archive.Archive{DB: w}
+	}
+
 	opt, _ := redis.ParseURL(redisUrl)
 	redisConn = redis.NewClient(opt)
+	alertStore = &alertstate.Store{Conn: redisConn, Prefix: os.Getenv("REDIS_ALERT_QUEUE_KEY")}
 
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(awsRegion),
 	}))
 	uploader = s3manager.NewUploader(sess)
 	snsClient = sns.New(sess)
+	sqsClient = sqs.New(sess)
 
 	rC := retryablehttp.NewClient()
 	rC.Logger = nil
 	rC.RetryMax = 3
 	retryClient = rC.StandardClient()
 	retryClient.Timeout = 5 * time.Second
+
+	// comma separated list of sources to poll, ipaws by default
+	sourceNames := os.Getenv("ALERT_SOURCES")
+	if sourceNames == "" {
+		sourceNames = "ipaws"
+	}
+	for _, name := range strings.Split(sourceNames, ",") {
+		switch strings.TrimSpace(name) {
+		case "ipaws":
+			alertSources = append(alertSources, &sources.IPAWSSource{
+				URL:    ipawsURL,
+				Pin:    os.Getenv("IPAWS_PIN"),
+				Client: retryClient,
+				Watermark: &sources.Watermark{
+					Conn:    redisConn,
+					Key:     alertStore.Prefix + ":ipaws:watermark",
+					Overlap: time.Duration(env.Int("IPAWS_OVERLAP_MINUTES", 10)) * time.Minute,
+					MaxAge:  time.Duration(env.Int("IPAWS_MAX_GAP_MINUTES", 60)) * time.Minute,
+				},
+			})
+		case "nws":
+			alertSources = append(alertSources, &sources.NWSSource{
+				URL:       nwsURL,
+				UserAgent: os.Getenv("NWS_USER_AGENT"),
+				Client:    retryClient,
+			})
+		default:
+			panic(fmt.Errorf("unknown alert source %s", name))
+		}
+	}
 }
 
 func main() {
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/alertstate"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/shortform"
+)
+
+// fakeSNS fails to publish the short form alerts of failing
+type fakeSNS struct {
+	snsiface.SNSAPI
+	failing   string
+	published []string
+}
+
+func (f *fakeSNS) Publish(in *sns.PublishInput) (*sns.PublishOutput, error) {
+	sfa := alertmodel.ShortAlertMsg{}
+	json.Unmarshal([]byte(aws.StringValue(in.Message)), &sfa)
+	if sfa.Identifier == f.failing {
+		return nil, errors.New("sns unavailable")
+	}
+	f.published = append(f.published, sfa.Identifier)
+	return &sns.PublishOutput{}, nil
+}
+
+// fakeSQS is an in memory queue, every call fails while down
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	down     bool
+	messages map[string]string
+	nextID   int
+}
+
+func (f *fakeSQS) SendMessageWithContext(ctx aws.Context, in *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	if f.down {
+		return nil, errors.New("sqs unavailable")
+	}
+	f.nextID++
+	id := fmt.Sprintf("%d", f.nextID)
+	f.messages[id] = aws.StringValue(in.MessageBody)
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, in *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	out := &sqs.ReceiveMessageOutput{}
+	for id, body := range f.messages {
+		out.Messages = append(out.Messages, &sqs.Message{
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String(id),
+			Body:          aws.String(body),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(ctx aws.Context, in *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	delete(f.messages, aws.StringValue(in.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func setup(t *testing.T) (*fakeSNS, *fakeSQS) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+
+	redisConn = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	alertStore = &alertstate.Store{Conn: redisConn, Prefix: "alerts"}
+	shortForms = &shortform.Builder{}
+	publisher := &fakeSNS{}
+	queue := &fakeSQS{messages: map[string]string{}}
+	snsClient, sqsClient = publisher, queue
+
+	return publisher, queue
+}
+
+const tornadoCAP = `<alert xmlns="urn:oasis:names:tc:emergency:cap:1.2">
+  <identifier>urn:oid:%[1]s</identifier>
+  <sender>w-nws.webmaster@noaa.gov</sender>
+  <sent>%[2]s</sent>
+  <status>Actual</status>
+  <msgType>Alert</msgType>
+  <scope>Public</scope>
+  <info>
+    <language>en-US</language>
+    <category>Met</category>
+    <event>Tornado Warning</event>
+    <urgency>Immediate</urgency>
+    <severity>Extreme</severity>
+    <certainty>Observed</certainty>
+    <eventCode><valueName>SAME</valueName><value>TOR</value></eventCode>
+    <effective>%[2]s</effective>
+    <onset>%[2]s</onset>
+    <expires>%[3]s</expires>
+    <area>
+      <areaDesc>Cleveland, OK</areaDesc>
+      <polygon>35.17,-97.53 35.24,-97.42 35.30,-97.47 35.17,-97.53</polygon>
+    </area>
+  </info>
+</alert>`
+
+func tornadoAlert(t *testing.T, id string) alertmodel.AlertMsg {
+	now := time.Now().UTC()
+	raw := fmt.Sprintf(tornadoCAP, id, now.Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	alert, err := parse.Raw(parse.FormatCAP, raw)
+	if err != nil {
+		t.Fatalf("failed parsing alert: %s", err)
+	}
+	return *alert
+}
+
+func TestDeadLetterKeepsRawAlert(t *testing.T) {
+	body, err := ioutil.ReadFile("../shared/sources/testdata/ipaws.xml")
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+	alerts, err := parse.CAP(body)
+	if err != nil {
+		t.Fatalf("failed parsing fixture: %s", err)
+	}
+	alert := alerts.Alert[0]
+
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+	dl := newDeadLetter(alert, &alertError{Stage: stagePublish, Err: errors.New("sns unavailable")}, now)
+	b, err := json.Marshal(dl)
+	if err != nil {
+		t.Fatalf("failed marshalling dead letter: %s", err)
+	}
+
+	queued := deadLetter{}
+	if err := json.Unmarshal(b, &queued); err != nil {
+		t.Fatalf("failed unmarshalling dead letter: %s", err)
+	}
+	if queued.Stage != stagePublish || queued.FailedAt != "2021-10-13T15:00:00Z" {
+		t.Errorf("unexpected dead letter %+v", queued)
+	}
+
+	replayed, err := parse.Raw(queued.Format, queued.Raw)
+	if err != nil {
+		t.Fatalf("failed parsing dead lettered alert: %s", err)
+	}
+	if replayed.Identifier != alert.Identifier || len(replayed.Infos) != len(alert.Infos) {
+		t.Errorf("expected %s back, got %+v", alert.Identifier, replayed)
+	}
+}
+
+func TestRunSummaryMetrics(t *testing.T) {
+	summary := newRunSummary(modePoll)
+	summary.record(outcomeProcessed)
+	summary.record(outcomeSeen)
+	summary.record(outcomeSeen)
+	summary.record(outcomeSuperseded)
+	summary.failed("a", &alertError{Stage: stageState, Err: errors.New("redis down")})
+
+	b, err := json.Marshal(summary.fields(time.Now()))
+	if err != nil {
+		t.Fatalf("failed marshalling summary: %s", err)
+	}
+	emf := struct {
+		AWS struct {
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []struct{ Name string }
+			}
+		} `json:"_aws"`
+		Mode      string
+		Processed int
+		Skipped   int
+		Failed    int
+	}{}
+	if err := json.Unmarshal(b, &emf); err != nil {
+		t.Fatalf("failed unmarshalling summary: %s", err)
+	}
+
+	if emf.Mode != modePoll || emf.Processed != 1 || emf.Skipped != 3 || emf.Failed != 1 {
+		t.Errorf("unexpected counts %+v", emf)
+	}
+	if len(emf.AWS.CloudWatchMetrics) != 1 || len(emf.AWS.CloudWatchMetrics[0].Metrics) != 3 {
+		t.Errorf("unexpected metric directive %+v", emf.AWS)
+	}
+	if summary.skipReasons[outcomeSeen] != 2 || summary.failedStages[stageState] != 1 {
+		t.Errorf("unexpected breakdown %v %v", summary.skipReasons, summary.failedStages)
+	}
+}
+
+func TestIngestIsolatesFailedAlerts(t *testing.T) {
+	publisher, queue := setup(t)
+	publisher.failing = "b"
+
+	summary := ingestAlerts([]alertmodel.AlertMsg{tornadoAlert(t, "a"), tornadoAlert(t, "b"), tornadoAlert(t, "c")})
+	if summary.Processed != 2 || summary.Failed != 1 || summary.Lost != 0 || summary.failedStages[stagePublish] != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if strings.Join(publisher.published, ",") != "a,c" {
+		t.Errorf("expected the other alerts published, got %v", publisher.published)
+	}
+	if len(queue.messages) != 1 {
+		t.Fatalf("expected the failed alert dead lettered, got %v", queue.messages)
+	}
+
+	// the dead letter is replayed once sns is back
+	publisher.failing = ""
+	queue.messages["garbled"] = "{"
+	summary, err := replayDeadLetters(10)
+	if err != nil {
+		t.Fatalf("failed replaying: %s", err)
+	}
+	if summary.Processed != 1 || summary.skipReasons[outcomeMalformed] != 1 || len(queue.messages) != 0 {
+		t.Errorf("expected b replayed and the garbled message dropped, got %+v", summary)
+	}
+	if publisher.published[len(publisher.published)-1] != "b" {
+		t.Errorf("expected b published on replay, got %v", publisher.published)
+	}
+}
+
+func TestIngestUncachesAlertsThatCannotBeDeadLettered(t *testing.T) {
+	publisher, queue := setup(t)
+	publisher.failing = "b"
+	queue.down = true
+
+	summary := ingestAlerts([]alertmodel.AlertMsg{tornadoAlert(t, "a"), tornadoAlert(t, "b")})
+	if summary.Processed != 1 || summary.Failed != 1 || summary.Lost != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if cached, _ := checkCacheForAlert("b"); cached {
+		t.Errorf("expected b to be uncached for the next poll")
+	}
+	if cached, _ := checkCacheForAlert("a"); !cached {
+		t.Errorf("expected a to stay cached")
+	}
+
+	// the next poll processes it again
+	publisher.failing = ""
+	if summary = ingestAlerts([]alertmodel.AlertMsg{tornadoAlert(t, "a"), tornadoAlert(t, "b")}); summary.Processed != 1 || summary.skipReasons[outcomeSeen] != 1 {
+		t.Errorf("expected b processed on the next poll, got %+v", summary)
+	}
+}
+
+func TestUnmappedUpdateWithdrawsItsReferences(t *testing.T) {
+	publisher, _ := setup(t)
+
+	if outcome, err := processAlert(tornadoAlert(t, "a"), false); err != nil || outcome != outcomeProcessed {
+		t.Fatalf("failed processing a: %s %v", outcome, err)
+	}
+
+	update := tornadoAlert(t, "b")
+	update.MsgType = "Update"
+	update.References = []string{"a"}
+	update.Infos[0].Areas[0].Polygons = []string{"not a polygon"}
+	outcome, err := processAlert(update, false)
+	if err != nil || outcome != outcomeUnmapped {
+		t.Fatalf("expected b unmapped, got %s %v", outcome, err)
+	}
+
+	if active, _ := redisConn.HExists(ctx, alertStore.AlertsKey(), "a").Result(); active {
+		t.Errorf("expected a withdrawn by its unmapped update")
+	}
+	if superseded, _ := redisConn.Exists(ctx, alertStore.SupersededKey("a")).Result(); superseded != 1 {
+		t.Errorf("expected a marked superseded")
+	}
+	if strings.Join(publisher.published, ",") != "a" {
+		t.Errorf("expected nothing published for b, got %v", publisher.published)
+	}
+}
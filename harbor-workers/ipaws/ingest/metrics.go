@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const metricNamespace = "Harbor/IPAWSIngest"
+
+const (
+	modePoll   = "poll"
+	modeReplay = "replay"
+)
+
+// runSummary counts the outcome of every alert in a run
+type runSummary struct {
+	Mode      string
+	Processed int
+	Skipped   int
+	Failed    int
+	// failed alerts that could not be dead lettered either, only
+	// the next poll will pick them up again
+	Lost int
+	// skipped alerts by outcome, failed alerts by stage
+	skipReasons  map[string]int
+	failedStages map[string]int
+}
+
+func newRunSummary(mode string) *runSummary {
+	return &runSummary{Mode: mode, skipReasons: map[string]int{}, failedStages: map[string]int{}}
+}
+
+func (s *runSummary) total() int {
+	return s.Processed + s.Skipped + s.Failed
+}
+
+func (s *runSummary) record(outcome string) {
+	if outcome == outcomeProcessed {
+		s.Processed = s.Processed + 1
+		return
+	}
+	s.Skipped = s.Skipped + 1
+	s.skipReasons[outcome] = s.skipReasons[outcome] + 1
+}
+
+func (s *runSummary) failed(identifier string, aErr *alertError) {
+	s.Failed = s.Failed + 1
+	s.failedStages[aErr.Stage] = s.failedStages[aErr.Stage] + 1
+	log.WithFields(stdFields).WithFields(log.Fields{"alertId": identifier, "stage": aErr.Stage, "error": aErr.Err}).
+		Error("failed to process alert")
+}
+
+// fields logs the summary in the cloudwatch embedded metric format,
+// the Processed, Skipped and Failed fields become metrics
+func (s *runSummary) fields(now time.Time) log.Fields {
+	return log.Fields{
+		"_aws": map[string]interface{}{
+			"Timestamp": now.UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  metricNamespace,
+				"Dimensions": [][]string{{"Mode"}},
+				"Metrics": []map[string]string{
+					{"Name": "Processed", "Unit": "Count"},
+					{"Name": "Skipped", "Unit": "Count"},
+					{"Name": "Failed", "Unit": "Count"},
+				},
+			}},
+		},
+		"Mode":         s.Mode,
+		"Processed":    s.Processed,
+		"Skipped":      s.Skipped,
+		"Failed":       s.Failed,
+		"Lost":         s.Lost,
+		"skipReasons":  s.skipReasons,
+		"failedStages": s.failedStages,
+	}
+}
+
+func (s *runSummary) publish() {
+	log.WithFields(stdFields).WithFields(s.fields(time.Now())).Info("ingest summary")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	lambdaContext "github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/env"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/iterable"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	iterableClient *iterable.Client
+	iterableDLQ    *iterable.DeadLetter
+	stdFields      map[string]interface{}
+)
+
+// ReplayRequest is the invocation payload, max bounds the number
+// of chunks replayed in one run
+type ReplayRequest struct {
+	Max int `json:"max"`
+}
+
+// handler replays chunks the alert notifier could not deliver to
+// iterable, it is invoked by hand once iterable has recovered
+func handler(awsCtx context.Context, req ReplayRequest) (*iterable.ReplayResult, error) {
+	setCtxFields(awsCtx)
+
+	max := req.Max
+	if max <= 0 {
+		max = 100
+	}
+
+	res, err := iterableDLQ.Replay(awsCtx, iterableClient, max)
+	log.WithFields(stdFields).WithFields(log.Fields{"replayed": res.Replayed, "failed": res.Failed, "skipped": res.Skipped}).
+		Info("replayed dead lettered chunks")
+	if err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"err": err}).Error("replay stopped")
+		return &res, err
+	}
+
+	return &res, nil
+}
+
+func setCtxFields(awsCtx context.Context) {
+	lambdaCtx, ok := lambdaContext.FromContext(awsCtx)
+	reqID := ""
+
+	if ok {
+		reqID = lambdaCtx.AwsRequestID
+	}
+	stdFields = log.Fields{"reqID": reqID}
+}
+
+func init() {
+	log.SetFormatter(&log.JSONFormatter{
+		DisableTimestamp: true,
+	})
+	log.SetOutput(os.Stdout)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(os.Getenv("AWS_REGION")),
+	}))
+
+	iterableClient = &iterable.Client{
+		BaseURL:    iterable.DefaultBaseURL,
+		APIKey:     os.Getenv("ITERABLE_API_KEY"),
+		HTTP:       &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: env.Int("ITERABLE_MAX_RETRIES", 4),
+		Backoff:    time.Second,
+	}
+	iterableDLQ = &iterable.DeadLetter{
+		SQS:      sqs.New(sess),
+		QueueURL: os.Getenv("ITERABLE_DLQ_URL"),
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,232 @@
+package alertstate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+// superseded markers outlive the alerts they point at so that a
+// late copy of an old alert can not be reinstated
+const supersededTTL = time.Hour * 24 * 7
+
+// fallback lifetime for alerts without a usable expiration
+const defaultTTL = time.Hour * 24
+
+// Store tracks the active version of every alert chain in redis.
+//
+//   {prefix}:active           zset of identifier scored by expiration
+//   {prefix}:alerts           hash of identifier to its short form alerts
+//   {prefix}:superseded:{id}  identifier of the alert that replaced id
+//   {prefix}:cell:{token}     set of identifiers whose geometry covers the s2 cell
+//   {prefix}:cells            hash of identifier to its cell tokens
+type Store struct {
+	Conn   *redis.Client
+	Prefix string
+}
+
+func (s *Store) ActiveKey() string {
+	return s.Prefix + ":active"
+}
+
+func (s *Store) AlertsKey() string {
+	return s.Prefix + ":alerts"
+}
+
+func (s *Store) CellKey(token string) string {
+	return s.Prefix + ":cell:" + token
+}
+
+func (s *Store) CellsKey() string {
+	return s.Prefix + ":cells"
+}
+
+func (s *Store) SupersededKey(identifier string) string {
+	return s.Prefix + ":superseded:" + identifier
+}
+
+// Apply records an incoming alert. Alerts referenced by an Update or
+// Cancel are removed, a Cancel is never made active itself. Returns
+// false when the alert was already superseded and has been ignored.
+func (s *Store) Apply(ctx context.Context, alert alertmodel.AlertMsg, shortAlerts []*alertmodel.ShortAlertMsg, now time.Time) (bool, error) {
+	superseded, err := s.Conn.Exists(ctx, s.SupersededKey(alert.Identifier)).Result()
+	if err != nil {
+		return false, fmt.Errorf("superseded check failed: %s", err)
+	}
+	if superseded == 1 {
+		return false, nil
+	}
+
+	var strAlerts []byte
+	var tokens []string
+	isCancel := strings.ToLower(alert.MsgType) == "cancel"
+	if !isCancel {
+		strAlerts, err = json.Marshal(shortAlerts)
+		if err != nil {
+			return false, err
+		}
+		tokens = getCellTokens(shortAlerts)
+	}
+
+	refCells, err := s.getCells(ctx, alert.References)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.Conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, ref := range alert.References {
+			pipe.Set(ctx, s.SupersededKey(ref), alert.Identifier, supersededTTL)
+		}
+		s.remove(ctx, pipe, alert.References, refCells)
+		if !isCancel {
+			pipe.ZAdd(ctx, s.ActiveKey(), &redis.Z{
+				Score:  float64(getExpiration(alert, now).Unix()),
+				Member: alert.Identifier,
+			})
+			pipe.HSet(ctx, s.AlertsKey(), alert.Identifier, strAlerts)
+			pipe.HSet(ctx, s.CellsKey(), alert.Identifier, strings.Join(tokens, ","))
+			for _, token := range tokens {
+				pipe.SAdd(ctx, s.CellKey(token), alert.Identifier)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed updating alert state: %s", err)
+	}
+
+	return true, nil
+}
+
+// Evict removes every alert that expired before now.
+func (s *Store) Evict(ctx context.Context, now time.Time) (int, error) {
+	max := strconv.FormatInt(now.Unix(), 10)
+	expired, err := s.Conn.ZRangeByScore(ctx, s.ActiveKey(), &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed fetching expired alerts: %s", err)
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	cells, err := s.getCells(ctx, expired)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.Conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.remove(ctx, pipe, expired, cells)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed evicting expired alerts: %s", err)
+	}
+
+	return len(expired), nil
+}
+
+// alerts are rarely updated more than a handful of times
+const maxSupersedeDepth = 20
+
+// Active reports whether any of the alerts, or an alert that
+// superseded one of them, is active at now. A cancelled alert is
+// superseded by the cancel, which is never active.
+func (s *Store) Active(ctx context.Context, identifiers []string, now time.Time) (bool, error) {
+	seen := map[string]bool{}
+	ids := identifiers
+	for depth := 0; len(ids) > 0 && depth < maxSupersedeDepth; depth++ {
+		pipe := s.Conn.Pipeline()
+		scores := make([]*redis.FloatCmd, len(ids))
+		supersededBy := make([]*redis.StringCmd, len(ids))
+		for i, id := range ids {
+			seen[id] = true
+			scores[i] = pipe.ZScore(ctx, s.ActiveKey(), id)
+			supersededBy[i] = pipe.Get(ctx, s.SupersededKey(id))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return false, fmt.Errorf("failed fetching alert state: %s", err)
+		}
+
+		var next []string
+		for i := range ids {
+			if expires, err := scores[i].Result(); err == nil && expires > float64(now.Unix()) {
+				return true, nil
+			}
+			if by := supersededBy[i].Val(); by != "" && !seen[by] {
+				next = append(next, by)
+			}
+		}
+		ids = next
+	}
+
+	return false, nil
+}
+
+func (s *Store) getCells(ctx context.Context, identifiers []string) (map[string][]string, error) {
+	cells := map[string][]string{}
+	if len(identifiers) == 0 {
+		return cells, nil
+	}
+
+	vals, err := s.Conn.HMGet(ctx, s.CellsKey(), identifiers...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching alert cells: %s", err)
+	}
+	for i, val := range vals {
+		if str, ok := val.(string); ok && str != "" {
+			cells[identifiers[i]] = strings.Split(str, ",")
+		}
+	}
+
+	return cells, nil
+}
+
+func (s *Store) remove(ctx context.Context, pipe redis.Pipeliner, identifiers []string, cells map[string][]string) {
+	if len(identifiers) == 0 {
+		return
+	}
+
+	members := make([]interface{}, len(identifiers))
+	for i, id := range identifiers {
+		members[i] = id
+		for _, token := range cells[id] {
+			pipe.SRem(ctx, s.CellKey(token), id)
+		}
+	}
+	pipe.ZRem(ctx, s.ActiveKey(), members...)
+	pipe.HDel(ctx, s.AlertsKey(), identifiers...)
+	pipe.HDel(ctx, s.CellsKey(), identifiers...)
+}
+
+func getCellTokens(shortAlerts []*alertmodel.ShortAlertMsg) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	for _, sfa := range shortAlerts {
+		if sfa.Geometry == nil {
+			continue
+		}
+		for _, token := range sfa.Geometry.CellTokens() {
+			if !seen[token] {
+				seen[token] = true
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
+
+func getExpiration(alert alertmodel.AlertMsg, now time.Time) time.Time {
+	expires, err := time.Parse(time.RFC3339, alert.Info.Expires)
+	if err != nil {
+		return now.Add(defaultTTL)
+	}
+
+	return expires
+}
@@ -0,0 +1,138 @@
+package alertstate_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/alertstate"
+)
+
+var ctx = context.Background()
+
+func alert(id string, msgType string, expires time.Time, refs ...string) alertmodel.AlertMsg {
+	a := alertmodel.AlertMsg{Identifier: id, MsgType: msgType, References: refs}
+	a.Info.Expires = expires.Format(time.RFC3339)
+	return a
+}
+
+func setup(t *testing.T) *alertstate.Store {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+	return &alertstate.Store{Conn: redis.NewClient(&redis.Options{Addr: mr.Addr()}), Prefix: "alerts"}
+}
+
+func isActive(t *testing.T, s *alertstate.Store, id string) bool {
+	_, err := s.Conn.ZScore(ctx, s.ActiveKey(), id).Result()
+	if err != nil && err != redis.Nil {
+		t.Fatalf("failed checking %s: %s", id, err)
+	}
+	stored, _ := s.Conn.HExists(ctx, s.AlertsKey(), id).Result()
+	if (err == nil) != stored {
+		t.Fatalf("expected %s to be both active and stored, or neither", id)
+	}
+	return err == nil
+}
+
+func TestApply(t *testing.T) {
+	s := setup(t)
+	now := time.Date(2021, 5, 3, 18, 0, 0, 0, time.UTC)
+	apply := func(a alertmodel.AlertMsg) bool {
+		applied, err := s.Apply(ctx, a, []*alertmodel.ShortAlertMsg{{Identifier: a.Identifier}}, now)
+		if err != nil {
+			t.Fatalf("failed applying %s: %s", a.Identifier, err)
+		}
+		return applied
+	}
+
+	apply(alert("a", "Alert", now.Add(time.Hour)))
+	if !isActive(t, s, "a") {
+		t.Fatalf("expected a to be active")
+	}
+
+	if !apply(alert("b", "Update", now.Add(time.Hour), "a")) {
+		t.Fatalf("expected the update to be applied")
+	}
+	if isActive(t, s, "a") || !isActive(t, s, "b") {
+		t.Errorf("expected the update to replace a")
+	}
+
+	// a late copy of a can not be reinstated
+	if apply(alert("a", "Alert", now.Add(time.Hour))) || isActive(t, s, "a") {
+		t.Errorf("expected the superseded alert to be ignored")
+	}
+
+	apply(alert("c", "Cancel", now.Add(time.Hour), "b"))
+	if isActive(t, s, "b") || isActive(t, s, "c") {
+		t.Errorf("expected the cancel to remove b without becoming active")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	s := setup(t)
+	now := time.Date(2021, 5, 3, 18, 0, 0, 0, time.UTC)
+
+	for _, a := range []alertmodel.AlertMsg{
+		alert("expired", "Alert", now.Add(-time.Minute)),
+		alert("active", "Alert", now.Add(time.Hour)),
+		// without a usable expiration the alert lasts a day
+		{Identifier: "undated", MsgType: "Alert"},
+	} {
+		if _, err := s.Apply(ctx, a, nil, now); err != nil {
+			t.Fatalf("failed applying %s: %s", a.Identifier, err)
+		}
+	}
+
+	if n, err := s.Evict(ctx, now); err != nil || n != 1 {
+		t.Fatalf("expected one alert to be evicted, got %d: %v", n, err)
+	}
+	if isActive(t, s, "expired") || !isActive(t, s, "active") || !isActive(t, s, "undated") {
+		t.Errorf("expected only the expired alert to be evicted")
+	}
+
+	if n, _ := s.Evict(ctx, now.Add(time.Hour*25)); n != 2 {
+		t.Errorf("expected the remaining alerts to expire, got %d", n)
+	}
+}
+
+func TestActive(t *testing.T) {
+	s := setup(t)
+	now := time.Date(2021, 5, 3, 18, 0, 0, 0, time.UTC)
+	apply := func(a alertmodel.AlertMsg) {
+		if _, err := s.Apply(ctx, a, nil, now); err != nil {
+			t.Fatalf("failed applying %s: %s", a.Identifier, err)
+		}
+	}
+	active := func(at time.Time, ids ...string) bool {
+		ok, err := s.Active(ctx, ids, at)
+		if err != nil {
+			t.Fatalf("failed checking %v: %s", ids, err)
+		}
+		return ok
+	}
+
+	apply(alert("a", "Alert", now.Add(time.Hour)))
+	if !active(now, "a") || active(now.Add(time.Hour*2), "a") {
+		t.Errorf("expected a to be active until it expires")
+	}
+
+	// the chain follows the update even when only a is known
+	apply(alert("b", "Update", now.Add(time.Hour*3), "a"))
+	if !active(now.Add(time.Hour*2), "a") {
+		t.Errorf("expected a to stay active through its update")
+	}
+
+	apply(alert("c", "Cancel", now.Add(time.Hour*3), "b"))
+	if active(now, "a", "b", "c") {
+		t.Errorf("expected the cancelled chain to be inactive")
+	}
+	if active(now, "unknown") {
+		t.Errorf("expected an unknown alert to be inactive")
+	}
+}
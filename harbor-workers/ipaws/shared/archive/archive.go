@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Archive keeps every alert in postgres, the redis cache only holds
+// them for a day.
+//
+//	create table weather_alerts (
+//		id text primary key,
+//		sent timestamptz,
+//		status text not null,
+//		msg_type text not null,
+//		refs text[] not null default '{}',
+//		-- the first alert of the reference chain
+//		chain_id text not null,
+//		superseded_by text,
+//		event_code text,
+//		category text,
+//		level text,
+//		text text,
+//		headline text,
+//		area_desc text,
+//		onset timestamptz,
+//		expires timestamptz,
+//		-- cap "lat,lng lat,lng ..." strings, one per short form alert
+//		polygons text[] not null default '{}',
+//		bb_lat_lo double precision,
+//		bb_lat_hi double precision,
+//		bb_lng_lo double precision,
+//		bb_lng_hi double precision,
+//		-- the cached alertmodel.AlertMsg
+//		alert jsonb not null,
+//		created_at timestamptz not null default now()
+//	);
+//	create index weather_alerts_chain_id_idx on weather_alerts (chain_id);
+//	create index weather_alerts_onset_idx on weather_alerts (onset);
+//	create index weather_alerts_bb_idx on weather_alerts (bb_lat_lo, bb_lat_hi, bb_lng_lo, bb_lng_hi);
+type Archive struct {
+	DB *sqlx.DB
+}
+
+const upsertQuery = `
+insert into weather_alerts (
+	id, sent, status, msg_type, refs, chain_id,
+	event_code, category, level, text, headline, area_desc, onset, expires,
+	polygons, bb_lat_lo, bb_lat_hi, bb_lng_lo, bb_lng_hi, alert
+)
+values (
+	$1, $2, $3, $4, $5,
+	coalesce((select chain_id from weather_alerts where id = any($5) order by sent limit 1), $5[1], $1),
+	$6, $7, $8, $9, $10, $11, $12, $13,
+	$14, $15, $16, $17, $18, $19
+)
+on conflict (id) do update set
+	status = excluded.status,
+	event_code = excluded.event_code,
+	category = excluded.category,
+	level = excluded.level,
+	text = excluded.text,
+	headline = excluded.headline,
+	area_desc = excluded.area_desc,
+	onset = excluded.onset,
+	expires = excluded.expires,
+	polygons = excluded.polygons,
+	bb_lat_lo = excluded.bb_lat_lo,
+	bb_lat_hi = excluded.bb_lat_hi,
+	bb_lng_lo = excluded.bb_lng_lo,
+	bb_lng_hi = excluded.bb_lng_hi,
+	alert = excluded.alert`
+
+const supersedeQuery = `
+update weather_alerts
+set superseded_by = $1
+where id = any($2) and id != $1`
+
+// Save upserts the alert and marks the alerts it references as
+// superseded. shortForm carries the resolved geometry, it is empty
+// for cancels.
+func (a *Archive) Save(ctx context.Context, alert alertmodel.AlertMsg, shortForm []*alertmodel.ShortAlertMsg) error {
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	cat := categorization(alert, shortForm)
+	var polygons []string
+	areaDesc := ""
+	for _, sfa := range shortForm {
+		polygons = append(polygons, sfa.Polygon)
+		if areaDesc == "" {
+			areaDesc = sfa.AreaDesc
+		}
+	}
+	var bbLatLo, bbLatHi, bbLngLo, bbLngHi *float64
+	if bb := bounds(shortForm); bb != nil {
+		bbLatLo, bbLatHi, bbLngLo, bbLngHi = &bb.LatLo, &bb.LatHi, &bb.LngLo, &bb.LngHi
+	}
+	if polygons == nil {
+		polygons = []string{}
+	}
+	refs := alert.References
+	if refs == nil {
+		refs = []string{}
+	}
+
+	tx, err := a.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed starting archive transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, upsertQuery,
+		alert.Identifier, null(alert.Sent), alert.Status, alert.MsgType, pq.Array(refs),
+		null(cat.Code), null(cat.Category), null(cat.Level), null(cat.Text),
+		null(alert.Info.Headline), null(areaDesc), null(alert.Info.Onset), null(alert.Info.Expires),
+		pq.Array(polygons), bbLatLo, bbLatHi, bbLngLo, bbLngHi, string(b),
+	)
+	if err != nil {
+		return fmt.Errorf("failed archiving alert(%s): %s", alert.Identifier, err)
+	}
+	if len(refs) > 0 {
+		if _, err = tx.ExecContext(ctx, supersedeQuery, alert.Identifier, pq.Array(refs)); err != nil {
+			return fmt.Errorf("failed superseding references of alert(%s): %s", alert.Identifier, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ingest categorizes everything but cancels, those fall back to the
+// event code mapping
+func categorization(alert alertmodel.AlertMsg, shortForm []*alertmodel.ShortAlertMsg) alertmodel.AlertCategorization {
+	if alert.Categorization != nil {
+		return *alert.Categorization
+	}
+	if len(shortForm) > 0 {
+		return shortForm[0].Categorization
+	}
+
+	cat, _ := alertmodel.Categorize(alert.Info.EventCode.Value)
+	return cat
+}
+
+// bounds is the box around every short form geometry, nil when
+// none have one
+func bounds(shortForm []*alertmodel.ShortAlertMsg) *geo.BBRect {
+	var bb *geo.BBRect
+	for _, sfa := range shortForm {
+		if sfa.Geometry == nil {
+			continue
+		}
+		r := sfa.Geometry.BoundingBox(0)
+		if bb == nil {
+			bb = &geo.BBRect{LatLo: r.LatLo, LatHi: r.LatHi, LngLo: r.LngLo, LngHi: r.LngHi}
+			continue
+		}
+		bb.LatLo, bb.LatHi = math.Min(bb.LatLo, r.LatLo), math.Max(bb.LatHi, r.LatHi)
+		bb.LngLo, bb.LngHi = math.Min(bb.LngLo, r.LngLo), math.Max(bb.LngHi, r.LngHi)
+	}
+
+	return bb
+}
+
+func null(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,69 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+)
+
+func shortForm(t *testing.T, polygon string) *alertmodel.ShortAlertMsg {
+	geom, err := geo.GetPolygonFromString(polygon)
+	if err != nil {
+		t.Fatalf("failed parsing polygon: %s", err)
+	}
+	return &alertmodel.ShortAlertMsg{
+		Polygon:  polygon,
+		Geometry: geom,
+		Categorization: alertmodel.AlertCategorization{
+			Text:     "Tornado Warning",
+			Category: "Tornadoes",
+			Code:     "TOR",
+			Level:    string(alertmodel.WARNING),
+		},
+	}
+}
+
+func TestBounds(t *testing.T) {
+	if bounds(nil) != nil {
+		t.Errorf("expected no bounds without geometry")
+	}
+
+	bb := bounds([]*alertmodel.ShortAlertMsg{
+		shortForm(t, "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.23,-97.59 35.17,-97.53"),
+		{Polygon: ""},
+		shortForm(t, "35.5,-97.2 35.6,-97.1 35.7,-97.2 35.5,-97.2"),
+	})
+	if bb == nil {
+		t.Fatalf("expected bounds")
+	}
+	if bb.LatLo > 35.17 || bb.LatHi < 35.7 || bb.LngLo > -97.59 || bb.LngHi < -97.1 {
+		t.Errorf("expected bounds to cover both polygons, got %+v", bb)
+	}
+}
+
+func TestCategorization(t *testing.T) {
+	alert := alertmodel.AlertMsg{}
+	alert.Info.EventCode.Value = "TOR"
+
+	cat := categorization(alert, []*alertmodel.ShortAlertMsg{shortForm(t, "1,1 1,2 2,2 1,1")})
+	if cat.Category != "Tornadoes" {
+		t.Errorf("expected the short form categorization, got %+v", cat)
+	}
+
+	// a cancel has no short form alerts
+	if cat = categorization(alert, nil); cat.Code != "TOR" || cat.Category == "" {
+		t.Errorf("expected the event code mapping, got %+v", cat)
+	}
+
+	alert.Info.EventCode.Value = "XYZ"
+	if cat = categorization(alert, nil); cat.Code != "XYZ" || cat.Category != "" {
+		t.Errorf("expected an unknown code to be kept uncategorized, got %+v", cat)
+	}
+
+	// the rules ingest applied win
+	alert.Categorization = &alertmodel.AlertCategorization{Text: "Air Quality Alert", Code: "XYZ", Level: string(alertmodel.AWARE)}
+	if cat = categorization(alert, nil); cat != *alert.Categorization {
+		t.Errorf("expected the alert categorization, got %+v", cat)
+	}
+}
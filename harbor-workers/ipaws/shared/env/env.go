@@ -0,0 +1,36 @@
+// Package env reads the lambda configuration from the environment.
+package env
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Int returns the integer value of key, def when it is unset. A
+// malformed value panics, the lambdas read their config in init.
+func Int(key string, def int) int {
+	str := os.Getenv(key)
+	if str == "" {
+		return def
+	}
+	v, err := strconv.Atoi(str)
+	if err != nil {
+		panic(fmt.Errorf("%s malformed: %s", key, err))
+	}
+	return v
+}
+
+// Float returns the float value of key, def when it is unset. A
+// malformed value panics like Int.
+func Float(key string, def float64) float64 {
+	str := os.Getenv(key)
+	if str == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		panic(fmt.Errorf("%s malformed: %s", key, err))
+	}
+	return v
+}
@@ -0,0 +1,227 @@
+package iterable
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+const DefaultBaseURL = "https://api.iterable.com/api"
+
+// Client delivers workflow events with events/trackBulk. Events are
+// sent in chunks of ChunkSize with at most Concurrency requests in
+// flight, each chunk is retried up to MaxRetries times.
+type Client struct {
+	BaseURL     string
+	APIKey      string
+	HTTP        *http.Client
+	ChunkSize   int
+	Concurrency int
+	MaxRetries  int
+	// first retry delay, doubled on every attempt unless a
+	// 429 says how long to wait
+	Backoff time.Duration
+}
+
+// Chunk is a single trackBulk request body
+type Chunk struct {
+	Events []models.WorkflowTriggerAlert `json:"events"`
+}
+
+// FailedChunk is a chunk that could not be delivered
+type FailedChunk struct {
+	Chunk Chunk
+	Err   error
+}
+
+// Result summarizes a TrackBulk delivery
+type Result struct {
+	Sent int
+	// events iterable refused, like malformed emails, they
+	// would fail again on replay so they are only counted
+	Rejected int
+	Failed   []FailedChunk
+}
+
+// FailedEmails are the emails of the events of the failed chunks
+func (r Result) FailedEmails() map[string]bool {
+	emails := map[string]bool{}
+	for _, fc := range r.Failed {
+		for _, e := range fc.Chunk.Events {
+			emails[e.Email] = true
+		}
+	}
+	return emails
+}
+
+type bulkResponse struct {
+	SuccessCount int `json:"successCount"`
+	FailCount    int `json:"failCount"`
+}
+
+// statusError is a non 200 response from iterable
+type statusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("trackBulk failed (%d: %s)", e.StatusCode, e.Body)
+}
+
+func (c *Client) TrackBulkURL() string {
+	return c.BaseURL + "/events/trackBulk"
+}
+
+// TrackBulk delivers every event and reports the chunks that still
+// failed after retrying. A failed chunk does not stop the others.
+func (c *Client) TrackBulk(ctx context.Context, triggers []models.WorkflowTriggerAlert) Result {
+	chunks := Split(triggers, c.ChunkSize)
+
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	res := Result{}
+	for _, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chunk Chunk) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			rejected, err := c.SendChunk(ctx, chunk)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Failed = append(res.Failed, FailedChunk{Chunk: chunk, Err: err})
+				return
+			}
+			res.Sent = res.Sent + len(chunk.Events) - rejected
+			res.Rejected = res.Rejected + rejected
+		}(chunk)
+	}
+	wg.Wait()
+
+	return res
+}
+
+// SendChunk posts a single chunk, retrying 429s, 5xx responses and
+// transport errors with backoff. Returns the number of events
+// iterable rejected.
+func (c *Client) SendChunk(ctx context.Context, chunk Chunk) (int, error) {
+	body, err := json.Marshal(chunk)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal chunk: %s", err)
+	}
+
+	delay := c.Backoff
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := delay
+			if se, ok := lastErr.(*statusError); ok && se.RetryAfter > 0 {
+				wait = se.RetryAfter
+			}
+			select {
+			case <-ctx.Done():
+				return 0, fmt.Errorf("gave up after %d attempts: %s", attempt, lastErr)
+			case <-time.After(wait):
+			}
+			delay = delay * 2
+		}
+
+		var rejected int
+		rejected, lastErr = c.post(ctx, body)
+		if lastErr == nil {
+			return rejected, nil
+		}
+		if se, ok := lastErr.(*statusError); ok && !retryable(se.StatusCode) {
+			return 0, lastErr
+		}
+	}
+
+	return 0, fmt.Errorf("gave up after %d attempts: %s", c.MaxRetries+1, lastErr)
+}
+
+func (c *Client) post(ctx context.Context, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TrackBulkURL(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", c.APIKey)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, &statusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(b),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	// the events may have been accepted, the chunk is not retried
+	// and goes to the dead letter queue to be looked at
+	br := bulkResponse{}
+	if err := json.Unmarshal(b, &br); err != nil {
+		return 0, &statusError{StatusCode: resp.StatusCode, Body: fmt.Sprintf("unparseable response %q: %s", b, err)}
+	}
+
+	return br.FailCount, nil
+}
+
+// Split breaks triggers into chunks of at most size events
+func Split(triggers []models.WorkflowTriggerAlert, size int) []Chunk {
+	if size < 1 {
+		size = len(triggers)
+	}
+
+	var chunks []Chunk
+	for start := 0; start < len(triggers); start += size {
+		end := start + size
+		if end > len(triggers) {
+			end = len(triggers)
+		}
+		chunks = append(chunks, Chunk{Events: triggers[start:end]})
+	}
+
+	return chunks
+}
+
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,266 @@
+package iterable_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/iterable"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+const stubAPIKey = "test-key"
+
+// iterableStub stands in for events/trackBulk. respond picks the
+// status for each request given the request number, starting at 1.
+type iterableStub struct {
+	*httptest.Server
+	requests    int32
+	inFlight    int32
+	maxInFlight int32
+	mu          sync.Mutex
+	received    []string
+}
+
+func newIterableStub(t *testing.T, respond func(n int32, chunk iterable.Chunk) int) *iterableStub {
+	stub := &iterableStub{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&stub.requests, 1)
+		cur := atomic.AddInt32(&stub.inFlight, 1)
+		defer atomic.AddInt32(&stub.inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&stub.maxInFlight)
+			if cur <= max || atomic.CompareAndSwapInt32(&stub.maxInFlight, max, cur) {
+				break
+			}
+		}
+		// hold the request so concurrent chunks overlap
+		time.Sleep(time.Millisecond * 5)
+
+		if r.URL.Path != "/events/trackBulk" || r.Header.Get("Api-Key") != stubAPIKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		chunk := iterable.Chunk{}
+		if err := json.NewDecoder(r.Body).Decode(&chunk); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		status := respond(n, chunk)
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			return
+		}
+
+		stub.mu.Lock()
+		for _, e := range chunk.Events {
+			stub.received = append(stub.received, e.Email)
+		}
+		stub.mu.Unlock()
+		fmt.Fprintf(w, `{"successCount": %d, "failCount": 0}`, len(chunk.Events))
+	}))
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func newClient(url string) *iterable.Client {
+	return &iterable.Client{
+		BaseURL:     url,
+		APIKey:      stubAPIKey,
+		HTTP:        &http.Client{Timeout: time.Second},
+		ChunkSize:   10,
+		Concurrency: 3,
+		MaxRetries:  2,
+		Backoff:     time.Millisecond,
+	}
+}
+
+func triggers(n int) []models.WorkflowTriggerAlert {
+	var arr []models.WorkflowTriggerAlert
+	for i := 0; i < n; i++ {
+		arr = append(arr, models.WorkflowTriggerAlert{Name: "BEACON", Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	return arr
+}
+
+func TestTrackBulkChunksWithBoundedConcurrency(t *testing.T) {
+	stub := newIterableStub(t, func(n int32, chunk iterable.Chunk) int { return http.StatusOK })
+
+	res := newClient(stub.URL).TrackBulk(context.Background(), triggers(95))
+	if len(res.Failed) != 0 || res.Sent != 95 {
+		t.Fatalf("expected 95 sent, got %+v", res)
+	}
+	if stub.requests != 10 {
+		t.Errorf("expected 10 chunks, got %d requests", stub.requests)
+	}
+	if stub.maxInFlight > 3 {
+		t.Errorf("expected at most 3 requests in flight, saw %d", stub.maxInFlight)
+	}
+	if len(stub.received) != 95 {
+		t.Errorf("expected 95 events delivered, got %d", len(stub.received))
+	}
+}
+
+func TestTrackBulkRetries429(t *testing.T) {
+	stub := newIterableStub(t, func(n int32, chunk iterable.Chunk) int {
+		if n <= 2 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	})
+
+	c := newClient(stub.URL)
+	c.Concurrency = 1
+	res := c.TrackBulk(context.Background(), triggers(5))
+	if len(res.Failed) != 0 || res.Sent != 5 {
+		t.Fatalf("expected the chunk to be delivered after retrying, got %+v", res)
+	}
+	if stub.requests != 3 {
+		t.Errorf("expected 3 attempts, got %d", stub.requests)
+	}
+}
+
+func TestTrackBulkIsolatesFailedChunks(t *testing.T) {
+	// the chunk holding user10 always fails
+	stub := newIterableStub(t, func(n int32, chunk iterable.Chunk) int {
+		if chunk.Events[0].Email == "user10@example.com" {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+
+	res := newClient(stub.URL).TrackBulk(context.Background(), triggers(30))
+	if len(res.Failed) != 1 || res.Sent != 20 {
+		t.Fatalf("expected one failed chunk and 20 sent, got %d failed %d sent", len(res.Failed), res.Sent)
+	}
+	if res.Failed[0].Chunk.Events[0].Email != "user10@example.com" {
+		t.Errorf("unexpected failed chunk %+v", res.Failed[0].Chunk.Events[0])
+	}
+	// 3 attempts for the failing chunk, 1 for the others
+	if stub.requests != 5 {
+		t.Errorf("expected 5 requests, got %d", stub.requests)
+	}
+}
+
+func TestTrackBulkDoesNotRetryClientErrors(t *testing.T) {
+	stub := newIterableStub(t, func(n int32, chunk iterable.Chunk) int { return http.StatusBadRequest })
+
+	res := newClient(stub.URL).TrackBulk(context.Background(), triggers(5))
+	if len(res.Failed) != 1 || stub.requests != 1 {
+		t.Errorf("expected a single attempt, got %d requests", stub.requests)
+	}
+}
+
+// fakeSQS is an in memory queue
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	messages map[string]string
+	nextID   int
+}
+
+func (f *fakeSQS) SendMessageWithContext(ctx aws.Context, in *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	f.nextID++
+	id := fmt.Sprintf("%d", f.nextID)
+	f.messages[id] = aws.StringValue(in.MessageBody)
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, in *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	out := &sqs.ReceiveMessageOutput{}
+	for id, body := range f.messages {
+		out.Messages = append(out.Messages, &sqs.Message{
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String(id),
+			Body:          aws.String(body),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(ctx aws.Context, in *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	delete(f.messages, aws.StringValue(in.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	var down int32 = 1
+	stub := newIterableStub(t, func(n int32, chunk iterable.Chunk) int {
+		if atomic.LoadInt32(&down) == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	c := newClient(stub.URL)
+	dl := &iterable.DeadLetter{SQS: &fakeSQS{messages: map[string]string{}}, QueueURL: "dlq"}
+
+	res := c.TrackBulk(context.Background(), triggers(25))
+	if len(res.Failed) != 3 {
+		t.Fatalf("expected 3 failed chunks, got %d", len(res.Failed))
+	}
+	if err := dl.Send(context.Background(), res.Failed); err != nil {
+		t.Fatalf("failed dead lettering: %s", err)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	replay, err := dl.Replay(context.Background(), c, 100)
+	if err != nil {
+		t.Fatalf("failed replaying: %s", err)
+	}
+	if replay.Replayed != 3 || replay.Failed != 0 {
+		t.Errorf("expected 3 replayed chunks, got %+v", replay)
+	}
+	if len(stub.received) != 25 {
+		t.Errorf("expected 25 events delivered on replay, got %d", len(stub.received))
+	}
+}
+
+func TestTrackBulkFailsOnUnparseableResponse(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>maintenance</html>")
+	}))
+	defer stub.Close()
+
+	res := newClient(stub.URL).TrackBulk(context.Background(), triggers(5))
+	if len(res.Failed) != 1 || res.Sent != 0 {
+		t.Errorf("expected the chunk to fail, got %+v", res)
+	}
+}
+
+func TestDeadLetterReplaySkipsMalformed(t *testing.T) {
+	stub := newIterableStub(t, func(n int32, chunk iterable.Chunk) int { return http.StatusOK })
+	queue := &fakeSQS{messages: map[string]string{}}
+	dl := &iterable.DeadLetter{SQS: queue, QueueURL: "dlq"}
+
+	queue.messages["bad"] = "{"
+	if err := dl.Send(context.Background(), []iterable.FailedChunk{
+		{Chunk: iterable.Split(triggers(5), 5)[0], Err: fmt.Errorf("down")},
+	}); err != nil {
+		t.Fatalf("failed dead lettering: %s", err)
+	}
+
+	replay, err := dl.Replay(context.Background(), newClient(stub.URL), 100)
+	if err != nil {
+		t.Fatalf("failed replaying: %s", err)
+	}
+	if replay.Replayed != 1 || len(replay.Skipped) != 1 || replay.Skipped[0] != "bad" {
+		t.Errorf("expected the malformed message skipped and the chunk replayed, got %+v", replay)
+	}
+	if len(queue.messages) != 0 || len(stub.received) != 5 {
+		t.Errorf("expected the queue drained, %d left and %d delivered", len(queue.messages), len(stub.received))
+	}
+}
@@ -0,0 +1,104 @@
+package iterable
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// DeadLetter parks undeliverable chunks on an sqs queue, one
+// message per chunk, so they can be replayed later.
+type DeadLetter struct {
+	SQS      sqsiface.SQSAPI
+	QueueURL string
+}
+
+// Send queues every failed chunk and returns the first error.
+func (d *DeadLetter) Send(ctx context.Context, failed []FailedChunk) error {
+	for _, fc := range failed {
+		body, err := json.Marshal(fc.Chunk)
+		if err != nil {
+			return fmt.Errorf("failed to marshal chunk: %s", err)
+		}
+
+		_, err = d.SQS.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+			QueueUrl:    aws.String(d.QueueURL),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"error": {DataType: aws.String("String"), StringValue: aws.String(fc.Err.Error())},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to dead letter chunk of %d events: %s", len(fc.Chunk.Events), err)
+		}
+	}
+
+	return nil
+}
+
+// ReplayResult summarizes a Replay run
+type ReplayResult struct {
+	Replayed int
+	Failed   int
+	// malformed messages, they can never be replayed and are deleted
+	Skipped []string
+}
+
+// Replay resends up to max queued chunks. Delivered chunks are
+// deleted, chunks that fail again stay on the queue and become
+// visible once their visibility timeout expires.
+func (d *DeadLetter) Replay(ctx context.Context, client *Client, max int) (ReplayResult, error) {
+	res := ReplayResult{}
+	for res.Replayed+res.Failed+len(res.Skipped) < max {
+		out, err := d.SQS.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(d.QueueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(1),
+		})
+		if err != nil {
+			return res, fmt.Errorf("failed to receive dead letters: %s", err)
+		}
+		if len(out.Messages) == 0 {
+			return res, nil
+		}
+
+		for _, msg := range out.Messages {
+			chunk := Chunk{}
+			if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &chunk); err != nil {
+				if err := d.delete(ctx, msg); err != nil {
+					return res, err
+				}
+				res.Skipped = append(res.Skipped, aws.StringValue(msg.MessageId))
+				continue
+			}
+
+			if _, err := client.SendChunk(ctx, chunk); err != nil {
+				res.Failed = res.Failed + 1
+				continue
+			}
+
+			if err := d.delete(ctx, msg); err != nil {
+				return res, err
+			}
+			res.Replayed = res.Replayed + 1
+		}
+	}
+
+	return res, nil
+}
+
+func (d *DeadLetter) delete(ctx context.Context, msg *sqs.Message) error {
+	_, err := d.SQS.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(d.QueueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter %s: %s", aws.StringValue(msg.MessageId), err)
+	}
+
+	return nil
+}
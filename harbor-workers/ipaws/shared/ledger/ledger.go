@@ -0,0 +1,456 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+// reasons a notification is suppressed
+const (
+	ReasonDuplicate   = "duplicate"
+	ReasonRateLimited = "rate_limited"
+)
+
+// chains are followed for as long as an alert can be referenced
+const chainTTL = time.Hour * 24 * 7
+
+// the audit stream is trimmed to roughly this many entries
+const auditMaxLen = 100000
+
+// Ledger records what was sent to whom so that a user is notified
+// once per alert chain, again only when the outlook level escalates,
+// and no more than Limit times per Window. Chains stay open until
+// every notified user was sent the all clear.
+//
+//   {prefix}:chain:{id}               chain an alert identifier belongs to
+//   {prefix}:alerts:{chain}           set of alert identifiers in the chain
+//   {prefix}:user:{user}:chain:{id}   outlook level last sent for the chain
+//   {prefix}:user:{user}:sends        zset of sends scored by unix time
+//   {prefix}:notified:{chain}         set of users sent the chain
+//   {prefix}:sent:{chain}             categorization last sent for the chain
+//   {prefix}:open                     zset of notified chains scored by last send
+//   {prefix}:audit                    stream of suppressed sends
+type Ledger struct {
+	Conn   *redis.Client
+	Prefix string
+	Limit  int
+	Window time.Duration
+}
+
+// Decision is the outcome for a single user
+type Decision struct {
+	Send   bool
+	Reason string
+	// outlook level previously sent for the chain, empty if none
+	PreviousLevel string
+}
+
+// atomically compares the level against the last one sent for the
+// chain, counts the sends in the window and reserves the send.
+// concurrent notifiers handling fanned out alerts must not both send.
+var reserveScript = redis.NewScript(`
+local sent = redis.call('GET', KEYS[1])
+local level = tonumber(ARGV[1])
+if sent and level <= tonumber(sent) then
+	return {'duplicate', sent}
+end
+local now = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now - window)
+if ARGV[5] ~= '1' and redis.call('ZCARD', KEYS[2]) >= tonumber(ARGV[4]) then
+	return {'rate_limited', sent or ''}
+end
+redis.call('SET', KEYS[1], level, 'EX', ARGV[6])
+redis.call('ZADD', KEYS[2], now, ARGV[7])
+redis.call('EXPIRE', KEYS[2], window)
+return {'', sent or ''}
+`)
+
+// puts back the level a reservation replaced, unless a newer send
+// was reserved meanwhile, and drops the send from the window
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	if ARGV[2] == '' then
+		redis.call('DEL', KEYS[1])
+	else
+		redis.call('SET', KEYS[1], ARGV[2], 'EX', ARGV[3])
+	end
+end
+redis.call('ZREM', KEYS[2], ARGV[4])
+return 1
+`)
+
+// sets the users still at the level they were last sent to CLEAR, a
+// user sent a newer alert of the chain meanwhile stays notified. The
+// chain is closed once no notified users are left.
+var clearScript = redis.NewScript(`
+local cleared = {}
+for i = 4, #KEYS do
+	local user = ARGV[2 * i - 4]
+	local expected = ARGV[2 * i - 3]
+	local sent = redis.call('GET', KEYS[i])
+	if sent == expected then
+		redis.call('SET', KEYS[i], ARGV[1], 'EX', ARGV[2])
+		redis.call('SREM', KEYS[1], user)
+		table.insert(cleared, user)
+	elseif not sent then
+		redis.call('SREM', KEYS[1], user)
+	end
+end
+if redis.call('SCARD', KEYS[1]) == 0 then
+	redis.call('ZREM', KEYS[2], ARGV[3])
+	redis.call('DEL', KEYS[1], KEYS[3])
+end
+return cleared
+`)
+
+func (l *Ledger) chainKey(identifier string) string {
+	return l.Prefix + ":chain:" + identifier
+}
+
+func (l *Ledger) userChainKey(userID int, chain string) string {
+	return fmt.Sprintf("%s:user:%d:chain:%s", l.Prefix, userID, chain)
+}
+
+func (l *Ledger) userSendsKey(userID int) string {
+	return fmt.Sprintf("%s:user:%d:sends", l.Prefix, userID)
+}
+
+func (l *Ledger) alertsKey(chain string) string {
+	return l.Prefix + ":alerts:" + chain
+}
+
+func (l *Ledger) notifiedKey(chain string) string {
+	return l.Prefix + ":notified:" + chain
+}
+
+func (l *Ledger) sentKey(chain string) string {
+	return l.Prefix + ":sent:" + chain
+}
+
+func (l *Ledger) OpenKey() string {
+	return l.Prefix + ":open"
+}
+
+func (l *Ledger) AuditKey() string {
+	return l.Prefix + ":audit"
+}
+
+// Chain returns the identifier of the first alert in the reference
+// chain of alert and records alert as part of it.
+func (l *Ledger) Chain(ctx context.Context, alert alertmodel.ShortAlertMsg) (string, error) {
+	chain, err := l.ChainOf(ctx, alert)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = l.Conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, l.chainKey(alert.Identifier), chain, chainTTL)
+		pipe.SAdd(ctx, l.alertsKey(chain), alert.Identifier)
+		pipe.Expire(ctx, l.alertsKey(chain), chainTTL)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed recording chain(%s): %s", alert.Identifier, err)
+	}
+
+	return chain, nil
+}
+
+// ChainOf returns the chain of alert without recording it
+func (l *Ledger) ChainOf(ctx context.Context, alert alertmodel.ShortAlertMsg) (string, error) {
+	chain := ""
+	for _, ref := range alert.RefIds {
+		val, err := l.Conn.Get(ctx, l.chainKey(ref)).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("failed fetching chain(%s): %s", ref, err)
+		}
+		chain = val
+		break
+	}
+	if chain == "" && len(alert.RefIds) > 0 {
+		// the referenced alert predates the ledger
+		chain = alert.RefIds[0]
+	}
+	if chain == "" {
+		chain = alert.Identifier
+	}
+
+	return chain, nil
+}
+
+// Reserve returns whether each user should be sent the alert and
+// reserves the sends, they count as sent until they are released.
+// Reserved sends are only notified, and sent the all clear, once
+// they are committed. DANGEROUS alerts are not rate limited.
+func (l *Ledger) Reserve(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, userIDs []int, now time.Time) ([]Decision, error) {
+	level := models.OutlookLevelDict[alert.Categorization.Level]
+	if level == "" {
+		return nil, fmt.Errorf("unknown outlook level %s", alert.Categorization.Level)
+	}
+	bypass := "0"
+	if alert.Categorization.Level == string(alertmodel.DANGEROUS) {
+		bypass = "1"
+	}
+	ttl := strconv.Itoa(int(chainTTL.Seconds()))
+	window := strconv.Itoa(int(l.Window.Seconds()))
+
+	pipe := l.Conn.Pipeline()
+	cmds := make([]*redis.Cmd, len(userIDs))
+	for i, userID := range userIDs {
+		keys := []string{l.userChainKey(userID, chain), l.userSendsKey(userID)}
+		cmds[i] = reserveScript.Eval(ctx, pipe, keys, level, now.Unix(), window, l.Limit, bypass, ttl,
+			sendMember(alert, chain))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed reserving sends: %s", err)
+	}
+
+	decisions := make([]Decision, len(userIDs))
+	for i, cmd := range cmds {
+		res, ok := cmd.Val().([]interface{})
+		if err := cmd.Err(); err != nil || !ok || len(res) != 2 {
+			return nil, fmt.Errorf("unexpected ledger result %v: %v", cmd.Val(), err)
+		}
+		reason, _ := res[0].(string)
+		prev, _ := res[1].(string)
+		decisions[i] = Decision{Send: reason == "", Reason: reason, PreviousLevel: prev}
+	}
+
+	return decisions, nil
+}
+
+// Commit records the reserved sends that were delivered, the users
+// are sent the all clear of the chain
+func (l *Ledger) Commit(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, userIDs []int, now time.Time) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	sent, err := json.Marshal(alert.Categorization)
+	if err != nil {
+		return err
+	}
+	members := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		members[i] = userID
+	}
+
+	_, err = l.Conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, l.notifiedKey(chain), members...)
+		pipe.Expire(ctx, l.notifiedKey(chain), chainTTL)
+		pipe.Set(ctx, l.sentKey(chain), string(sent), chainTTL)
+		pipe.ZAdd(ctx, l.OpenKey(), &redis.Z{Score: float64(now.Unix()), Member: chain})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed committing sends of chain(%s): %s", chain, err)
+	}
+
+	return nil
+}
+
+// Release gives back the reserved sends that could not be delivered,
+// keyed by user with the level they were previously sent, so that a
+// retry sends them again
+func (l *Ledger) Release(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, previous map[int]string) error {
+	if len(previous) == 0 {
+		return nil
+	}
+	level := models.OutlookLevelDict[alert.Categorization.Level]
+	ttl := strconv.Itoa(int(chainTTL.Seconds()))
+
+	pipe := l.Conn.Pipeline()
+	for userID, prev := range previous {
+		keys := []string{l.userChainKey(userID, chain), l.userSendsKey(userID)}
+		releaseScript.Eval(ctx, pipe, keys, level, prev, ttl, sendMember(alert, chain))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed releasing sends of chain(%s): %s", chain, err)
+	}
+
+	return nil
+}
+
+// the sends of a user are counted per alert
+func sendMember(alert alertmodel.ShortAlertMsg, chain string) string {
+	return chain + ":" + alert.Identifier
+}
+
+// Preview returns the decisions Reserve would make without reserving
+// the sends
+func (l *Ledger) Preview(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, userIDs []int, now time.Time) ([]Decision, error) {
+	level, err := strconv.Atoi(models.OutlookLevelDict[alert.Categorization.Level])
+	if err != nil {
+		return nil, fmt.Errorf("unknown outlook level %s", alert.Categorization.Level)
+	}
+	bypass := alert.Categorization.Level == string(alertmodel.DANGEROUS)
+	windowStart := "(" + strconv.FormatInt(now.Add(-l.Window).Unix(), 10)
+
+	pipe := l.Conn.Pipeline()
+	sent := make([]*redis.StringCmd, len(userIDs))
+	sends := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		sent[i] = pipe.Get(ctx, l.userChainKey(userID, chain))
+		sends[i] = pipe.ZCount(ctx, l.userSendsKey(userID), windowStart, "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed previewing sends: %s", err)
+	}
+
+	decisions := make([]Decision, len(userIDs))
+	for i := range userIDs {
+		prev := sent[i].Val()
+		d := Decision{Send: true, PreviousLevel: prev}
+		if prevLevel, err := strconv.Atoi(prev); err == nil && level <= prevLevel {
+			d = Decision{Reason: ReasonDuplicate, PreviousLevel: prev}
+		} else if !bypass && sends[i].Val() >= int64(l.Limit) {
+			d = Decision{Reason: ReasonRateLimited, PreviousLevel: prev}
+		}
+		decisions[i] = d
+	}
+
+	return decisions, nil
+}
+
+// Audit records suppressed sends, keyed by user with the reason.
+func (l *Ledger) Audit(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, suppressed map[int]string, now time.Time) error {
+	if len(suppressed) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(alert.Categorization)
+	if err != nil {
+		return err
+	}
+
+	pipe := l.Conn.Pipeline()
+	for userID, reason := range suppressed {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream:       l.AuditKey(),
+			MaxLenApprox: auditMaxLen,
+			Values: map[string]interface{}{
+				"userID":         userID,
+				"alertID":        alert.Identifier,
+				"chain":          chain,
+				"reason":         reason,
+				"categorization": string(b),
+				"time":           now.Format(time.RFC3339),
+			},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed recording suppressed sends: %s", err)
+	}
+
+	return nil
+}
+
+// Notified is what the users of a chain were last sent
+type Notified struct {
+	Chain  string
+	Alerts []string
+	// categorization of the last alert sent for the chain
+	Categorization alertmodel.AlertCategorization
+	// outlook level last sent keyed by user, empty once it expired
+	Levels map[int]string
+}
+
+// OpenChains returns the chains with users that were not sent the all
+// clear, oldest send first. Chains without a send for as long as
+// alerts are followed are dropped.
+func (l *Ledger) OpenChains(ctx context.Context, now time.Time) ([]string, error) {
+	stale := strconv.FormatInt(now.Add(-chainTTL).Unix(), 10)
+	if err := l.Conn.ZRemRangeByScore(ctx, l.OpenKey(), "-inf", "("+stale).Err(); err != nil {
+		return nil, fmt.Errorf("failed dropping stale chains: %s", err)
+	}
+
+	chains, err := l.Conn.ZRange(ctx, l.OpenKey(), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching open chains: %s", err)
+	}
+
+	return chains, nil
+}
+
+// Notified returns the alerts of the chain and the users notified
+// of it with the level they were last sent
+func (l *Ledger) Notified(ctx context.Context, chain string) (*Notified, error) {
+	pipe := l.Conn.Pipeline()
+	alerts := pipe.SMembers(ctx, l.alertsKey(chain))
+	users := pipe.SMembers(ctx, l.notifiedKey(chain))
+	sent := pipe.Get(ctx, l.sentKey(chain))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed fetching chain(%s): %s", chain, err)
+	}
+
+	n := &Notified{Chain: chain, Alerts: alerts.Val(), Levels: map[int]string{}}
+	if str := sent.Val(); str != "" {
+		if err := json.Unmarshal([]byte(str), &n.Categorization); err != nil {
+			return nil, fmt.Errorf("malformed categorization for chain(%s): %s", chain, err)
+		}
+	}
+
+	var userIDs []int
+	for _, u := range users.Val() {
+		userID, err := strconv.Atoi(u)
+		if err != nil {
+			return nil, fmt.Errorf("malformed user(%s) for chain(%s)", u, chain)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if len(userIDs) == 0 {
+		return n, nil
+	}
+
+	pipe = l.Conn.Pipeline()
+	levels := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		levels[i] = pipe.Get(ctx, l.userChainKey(userID, chain))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed fetching levels for chain(%s): %s", chain, err)
+	}
+	for i, userID := range userIDs {
+		n.Levels[userID] = levels[i].Val()
+	}
+
+	return n, nil
+}
+
+// Clear records the all clear for the users still at the level in
+// n.Levels and returns them, the chain is closed once every notified
+// user is cleared. Users without a level are forgotten.
+func (l *Ledger) Clear(ctx context.Context, n *Notified, userIDs []int) ([]int, error) {
+	keys := []string{l.notifiedKey(n.Chain), l.OpenKey(), l.sentKey(n.Chain)}
+	args := []interface{}{models.OutlookLevelDict[string(alertmodel.CLEAR)], int(chainTTL.Seconds()), n.Chain}
+	for _, userID := range userIDs {
+		keys = append(keys, l.userChainKey(userID, n.Chain))
+		args = append(args, userID, n.Levels[userID])
+	}
+
+	res, err := clearScript.Run(ctx, l.Conn, keys, args...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed clearing chain(%s): %s", n.Chain, err)
+	}
+
+	vals, _ := res.([]interface{})
+	cleared := make([]int, 0, len(vals))
+	for _, v := range vals {
+		str, _ := v.(string)
+		userID, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected clear result %v", v)
+		}
+		cleared = append(cleared, userID)
+	}
+
+	return cleared, nil
+}
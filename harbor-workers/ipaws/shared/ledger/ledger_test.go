@@ -0,0 +1,283 @@
+package ledger_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+var ctx = context.Background()
+
+func newLedger(t *testing.T) (*ledger.Ledger, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+
+	return &ledger.Ledger{
+		Conn:   redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Prefix: "ledger",
+		Limit:  2,
+		Window: time.Hour,
+	}, mr
+}
+
+func alert(id string, level alertmodel.AlertLevel, refs ...string) alertmodel.ShortAlertMsg {
+	return alertmodel.ShortAlertMsg{
+		Identifier:     id,
+		RefIds:         refs,
+		IsUpdate:       len(refs) > 0,
+		Categorization: alertmodel.AlertCategorization{Level: string(level), Category: string(alertmodel.TORNADOES)},
+	}
+}
+
+// decide reserves the sends and commits them, as if delivered
+func decide(t *testing.T, l *ledger.Ledger, a alertmodel.ShortAlertMsg, now time.Time, userIDs ...int) []ledger.Decision {
+	chain, err := l.Chain(ctx, a)
+	if err != nil {
+		t.Fatalf("failed resolving chain: %s", err)
+	}
+	decisions, err := l.Reserve(ctx, a, chain, userIDs, now)
+	if err != nil {
+		t.Fatalf("failed reserving: %s", err)
+	}
+
+	var sent []int
+	for i, d := range decisions {
+		if d.Send {
+			sent = append(sent, userIDs[i])
+		}
+	}
+	if err := l.Commit(ctx, a, chain, sent, now); err != nil {
+		t.Fatalf("failed committing: %s", err)
+	}
+	return decisions
+}
+
+func TestChainFollowsReferences(t *testing.T) {
+	l, _ := newLedger(t)
+
+	for _, a := range []alertmodel.ShortAlertMsg{
+		alert("watch", alertmodel.WATCH),
+		alert("update", alertmodel.WATCH, "watch"),
+		alert("statement", alertmodel.AWARE, "update"),
+	} {
+		chain, err := l.Chain(ctx, a)
+		if err != nil {
+			t.Fatalf("failed resolving chain: %s", err)
+		}
+		if chain != "watch" {
+			t.Errorf("expected %s in chain watch, got %s", a.Identifier, chain)
+		}
+	}
+}
+
+func TestDuplicatesAndEscalation(t *testing.T) {
+	l, _ := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	if d := decide(t, l, alert("a", alertmodel.WATCH), now, 1)[0]; !d.Send {
+		t.Fatalf("expected first alert to send, got %+v", d)
+	}
+
+	// fanned out geocode alerts share the identifier
+	if d := decide(t, l, alert("a", alertmodel.WATCH), now, 1)[0]; d.Send || d.Reason != ledger.ReasonDuplicate {
+		t.Errorf("expected duplicate, got %+v", d)
+	}
+
+	if d := decide(t, l, alert("b", alertmodel.AWARE, "a"), now, 1)[0]; d.Send || d.Reason != ledger.ReasonDuplicate {
+		t.Errorf("expected de-escalation to be suppressed, got %+v", d)
+	}
+
+	d := decide(t, l, alert("c", alertmodel.WARNING, "b"), now, 1)[0]
+	if !d.Send || d.PreviousLevel != models.OutlookLevelDict[string(alertmodel.WATCH)] {
+		t.Errorf("expected escalation from watch to send, got %+v", d)
+	}
+}
+
+func TestReleaseAllowsRetry(t *testing.T) {
+	l, _ := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	decide(t, l, alert("a", alertmodel.WATCH), now, 1)
+
+	// the escalation is reserved but never delivered
+	next := alert("b", alertmodel.WARNING, "a")
+	decisions, err := l.Reserve(ctx, next, "a", []int{1, 2}, now)
+	if err != nil || !decisions[0].Send || !decisions[1].Send {
+		t.Fatalf("expected both users reserved, got %+v: %v", decisions, err)
+	}
+	if d, _ := l.Reserve(ctx, next, "a", []int{1}, now); d[0].Send {
+		t.Fatalf("expected a concurrent send to be held off, got %+v", d[0])
+	}
+
+	n, _ := l.Notified(ctx, "a")
+	if _, ok := n.Levels[2]; ok || n.Categorization.Level != string(alertmodel.WATCH) {
+		t.Errorf("expected the reservation not to be notified, got %+v", n)
+	}
+
+	previous := map[int]string{1: decisions[0].PreviousLevel, 2: decisions[1].PreviousLevel}
+	if err := l.Release(ctx, next, "a", previous); err != nil {
+		t.Fatalf("failed releasing: %s", err)
+	}
+
+	// released sends do not count towards the rate limit
+	for _, id := range []string{"c", "d"} {
+		if d := decide(t, l, alert(id, alertmodel.WATCH), now, 2)[0]; !d.Send {
+			t.Errorf("expected user 2 under the cap, got %+v", d)
+		}
+	}
+
+	// the retry sends again, user 1 still escalates from watch
+	retry := decide(t, l, next, now.Add(time.Minute), 1)
+	if !retry[0].Send || retry[0].PreviousLevel != models.OutlookLevelDict[string(alertmodel.WATCH)] {
+		t.Errorf("expected the retry to send user 1 again, got %+v", retry[0])
+	}
+	if n, _ = l.Notified(ctx, "a"); n.Levels[1] != models.OutlookLevelDict[string(alertmodel.WARNING)] {
+		t.Errorf("expected the delivered retry to be notified, got %v", n.Levels)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	l, _ := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	decide(t, l, alert("a", alertmodel.WATCH), now, 1, 2)
+	decide(t, l, alert("b", alertmodel.WATCH), now.Add(time.Minute), 1)
+
+	decisions := decide(t, l, alert("c", alertmodel.WARNING), now.Add(time.Minute*2), 1, 2)
+	if decisions[0].Send || decisions[0].Reason != ledger.ReasonRateLimited {
+		t.Errorf("expected user 1 to be rate limited, got %+v", decisions[0])
+	}
+	if !decisions[1].Send {
+		t.Errorf("expected user 2 under the cap to send, got %+v", decisions[1])
+	}
+
+	if d := decide(t, l, alert("d", alertmodel.DANGEROUS), now.Add(time.Minute*3), 1)[0]; !d.Send {
+		t.Errorf("expected dangerous alerts to bypass the cap, got %+v", d)
+	}
+
+	// sends age out of the window
+	if d := decide(t, l, alert("e", alertmodel.WATCH), now.Add(time.Hour*2), 1)[0]; !d.Send {
+		t.Errorf("expected sends outside the window to be forgotten, got %+v", d)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	l, _ := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	err := l.Audit(ctx, alert("a", alertmodel.WATCH), "a", map[int]string{
+		1: ledger.ReasonDuplicate,
+		2: ledger.ReasonRateLimited,
+	}, now)
+	if err != nil {
+		t.Fatalf("failed auditing: %s", err)
+	}
+
+	n, err := l.Conn.XLen(ctx, l.AuditKey()).Result()
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 audit entries, got %d: %v", n, err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	l, _ := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	decide(t, l, alert("a", alertmodel.WATCH), now, 1, 2)
+	decide(t, l, alert("b", alertmodel.WARNING, "a"), now, 1)
+
+	chains, err := l.OpenChains(ctx, now)
+	if err != nil || len(chains) != 1 || chains[0] != "a" {
+		t.Fatalf("expected chain a to be open, got %v: %v", chains, err)
+	}
+	n, err := l.Notified(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed fetching notified: %s", err)
+	}
+	if len(n.Alerts) != 2 || n.Categorization.Level != string(alertmodel.WARNING) {
+		t.Errorf("expected both alerts and the last categorization, got %+v", n)
+	}
+	if n.Levels[1] != models.OutlookLevelDict[string(alertmodel.WARNING)] || n.Levels[2] != models.OutlookLevelDict[string(alertmodel.WATCH)] {
+		t.Errorf("expected the levels last sent, got %v", n.Levels)
+	}
+
+	// user 2 is sent an escalation after the levels were read
+	decide(t, l, alert("c", alertmodel.DANGEROUS, "b"), now, 2)
+	cleared, err := l.Clear(ctx, n, []int{1, 2})
+	if err != nil || len(cleared) != 1 || cleared[0] != 1 {
+		t.Fatalf("expected only user 1 cleared, got %v: %v", cleared, err)
+	}
+	if chains, _ = l.OpenChains(ctx, now); len(chains) != 1 {
+		t.Fatalf("expected the chain to stay open for user 2, got %v", chains)
+	}
+
+	n, _ = l.Notified(ctx, "a")
+	if cleared, _ = l.Clear(ctx, n, []int{2}); len(cleared) != 1 || cleared[0] != 2 {
+		t.Fatalf("expected user 2 cleared, got %v", cleared)
+	}
+	if chains, _ = l.OpenChains(ctx, now); len(chains) != 0 {
+		t.Errorf("expected the chain to be closed, got %v", chains)
+	}
+
+	// a cleared chain notifies again when it is revived
+	d := decide(t, l, alert("d", alertmodel.WATCH, "c"), now.Add(time.Hour*2), 1)[0]
+	if !d.Send || d.PreviousLevel != models.OutlookLevelDict[string(alertmodel.CLEAR)] {
+		t.Errorf("expected a send from clear, got %+v", d)
+	}
+}
+
+func TestOpenChainsDropsStale(t *testing.T) {
+	l, _ := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	decide(t, l, alert("a", alertmodel.WATCH), now, 1)
+	if chains, err := l.OpenChains(ctx, now.Add(time.Hour*24*8)); err != nil || len(chains) != 0 {
+		t.Errorf("expected the stale chain to be dropped, got %v: %v", chains, err)
+	}
+}
+
+func TestPreviewRecordsNothing(t *testing.T) {
+	l, mr := newLedger(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+
+	decide(t, l, alert("a", alertmodel.WATCH), now, 1)
+	decide(t, l, alert("b", alertmodel.WATCH), now, 1)
+	keys := len(mr.Keys())
+
+	next := alert("c", alertmodel.WARNING, "a")
+	chain, err := l.ChainOf(ctx, next)
+	if err != nil || chain != "a" {
+		t.Fatalf("expected chain a, got %s: %v", chain, err)
+	}
+	preview, err := l.Preview(ctx, next, chain, []int{1, 2}, now)
+	if err != nil {
+		t.Fatalf("failed previewing: %s", err)
+	}
+	if len(mr.Keys()) != keys {
+		t.Errorf("expected the preview to record nothing")
+	}
+
+	// the preview matches the decisions made afterwards
+	decisions := decide(t, l, next, now, 1, 2)
+	for i := range decisions {
+		if preview[i] != decisions[i] {
+			t.Errorf("user %d previewed %+v, decided %+v", i+1, preview[i], decisions[i])
+		}
+	}
+	if preview[0].Reason != ledger.ReasonRateLimited || !preview[1].Send {
+		t.Errorf("expected user 1 rate limited and user 2 sent, got %+v", preview)
+	}
+
+	if d, _ := l.Preview(ctx, alert("d", alertmodel.WATCH, "c"), "a", []int{2}, now); d[0].Reason != ledger.ReasonDuplicate {
+		t.Errorf("expected a duplicate, got %+v", d[0])
+	}
+}
@@ -3,11 +3,13 @@ package models
 type WorkflowTriggerAlert struct {
 	Name       string       `json:"eventName"`
 	Email      string       `json:"email"`
-	DataFields BeaconFields `json:"dataFields""`
+	DataFields BeaconFields `json:"dataFields"`
 }
 
 type BeaconFields struct {
 	PushData BeaconPush `json:"beaconPush"`
+	// push, email and/or sms, the workflow only sends on these
+	Channels []string `json:"beaconChannels"`
 }
 
 type BeaconPush struct {
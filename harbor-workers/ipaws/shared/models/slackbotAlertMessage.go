@@ -1,37 +1,37 @@
 package models
 
 type SlackRequestBody struct {
+	// shown in notifications where blocks are not rendered
+	Text   string  `json:"text"`
 	Blocks []Block `json:"blocks"`
 }
 
+// Block is a block kit layout block, sections carry either text or
+// up to ten fields, context blocks carry elements
 type Block struct {
-	Type     string  `json:"type"`
-	Sections Section `json:"text"`
+	Type     string       `json:"type"`
+	Text     *TextObject  `json:"text,omitempty"`
+	Fields   []TextObject `json:"fields,omitempty"`
+	Elements []TextObject `json:"elements,omitempty"`
 }
 
-type Section struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
-	Emoji bool `json:"emoji,omitempty"`
+type TextObject struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
 }
 
+// CityData is a slack channel alerts are routed to. A channel
+// matches every alert, alerts intersecting Bounds, or alerts within
+// RadiusKm of Lat/Long.
 type CityData struct {
-	Name string
-	URL string
-	Lat float64
-	Long float64
-}
+	Name string  `json:"name"`
+	URL  string  `json:"url"`
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
 
-type UserRow struct {
-	Email     *string `db:"email"`
-	Address   *string `db:"address"`
-	Latitude  *string `db:"latitude"`
-	Longitude *string `db:"longitude"`
+	RadiusKm float64 `json:"radiusKm"`
+	// "latLo latHi lngLo lngHi", for state channels
+	Bounds string `json:"bounds"`
+	All    bool   `json:"all"`
 }
-
-type User struct {
-	Email string
-	Address string
-	Latitude string
-	Longitude string
-}
\ No newline at end of file
@@ -0,0 +1,241 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/preferences"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/userlookup"
+)
+
+type User struct {
+	ID          int
+	Email       string
+	Address     string
+	Latitude    string
+	Longitude   string
+	Preferences preferences.Preferences
+	// outlook level last sent to the user for the alert chain
+	PreviousOutlookLevel string
+}
+
+// Pipeline picks the users a short form alert is sent to and builds
+// their BEACON workflow triggers
+type Pipeline struct {
+	Lookup *userlookup.Lookup
+	Ledger *ledger.Ledger
+	// the ingest cache, updates read the level of the alerts they
+	// reference from it
+	Cache *redis.Client
+	// previews the ledger decisions without recording anything
+	DryRun bool
+}
+
+// LedgerResult are the users left after the ledger, with the reason
+// every other user was suppressed
+type LedgerResult struct {
+	Chain      string
+	Users      []User
+	Suppressed map[int]string
+}
+
+// UsersInRange returns the users located in the alert geometry, nil
+// result when the alert has no bounding box
+func (p *Pipeline) UsersInRange(alert alertmodel.ShortAlertMsg) ([]User, *userlookup.Result, error) {
+	res, err := p.Lookup.UsersInAlert(alert)
+	if err != nil || res == nil {
+		return nil, res, err
+	}
+
+	var users []User
+	for _, v := range res.Users {
+		users = append(users, User{
+			ID:          v.ID,
+			Email:       *v.Email,
+			Address:     *v.Address,
+			Latitude:    *v.Latitude,
+			Longitude:   *v.Longitude,
+			Preferences: v.Preferences()})
+	}
+
+	return users, res, nil
+}
+
+// FilterByPreferences drops users whose preferences withhold the
+// alert, with the reason by user id
+func FilterByPreferences(alert alertmodel.ShortAlertMsg, users []User, now time.Time) ([]User, map[int]string) {
+	var allowed []User
+	withheld := map[int]string{}
+	for _, user := range users {
+		ok, reason := user.Preferences.Allows(alert, now)
+		if !ok {
+			withheld[user.ID] = reason
+			continue
+		}
+		allowed = append(allowed, user)
+	}
+
+	return allowed, withheld
+}
+
+// FilterByLedger drops users already notified of the alert chain at
+// the same or a higher level, or notified too often recently. The
+// sends are reserved unless DryRun is set, Commit them once they are
+// delivered or Release them.
+func (p *Pipeline) FilterByLedger(ctx context.Context, alert alertmodel.ShortAlertMsg, users []User, now time.Time) (*LedgerResult, error) {
+	chainOf, decide := p.Ledger.Chain, p.Ledger.Reserve
+	if p.DryRun {
+		chainOf, decide = p.Ledger.ChainOf, p.Ledger.Preview
+	}
+
+	chain, err := chainOf(ctx, alert)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	decisions, err := decide(ctx, alert, chain, userIDs, now)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &LedgerResult{Chain: chain, Suppressed: map[int]string{}}
+	for i, d := range decisions {
+		if !d.Send {
+			res.Suppressed[users[i].ID] = d.Reason
+			continue
+		}
+		users[i].PreviousOutlookLevel = d.PreviousLevel
+		res.Users = append(res.Users, users[i])
+	}
+
+	return res, nil
+}
+
+// Commit records the users of the ledger result that were delivered
+func (p *Pipeline) Commit(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, users []User, now time.Time) error {
+	if p.DryRun || len(users) == 0 {
+		return nil
+	}
+
+	userIDs := make([]int, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	return p.Ledger.Commit(ctx, alert, chain, userIDs, now)
+}
+
+// Release gives back the sends of the users of the ledger result that
+// could not be delivered, a retry of the alert sends them again
+func (p *Pipeline) Release(ctx context.Context, alert alertmodel.ShortAlertMsg, chain string, users []User) error {
+	if p.DryRun || len(users) == 0 {
+		return nil
+	}
+
+	previous := map[int]string{}
+	for _, user := range users {
+		previous[user.ID] = user.PreviousOutlookLevel
+	}
+	return p.Ledger.Release(ctx, alert, chain, previous)
+}
+
+// Undelivered splits the users by whether their email is in failed
+func Undelivered(users []User, failed map[string]bool) (delivered, undelivered []User) {
+	for _, user := range users {
+		if failed[user.Email] {
+			undelivered = append(undelivered, user)
+			continue
+		}
+		delivered = append(delivered, user)
+	}
+
+	return delivered, undelivered
+}
+
+// Triggers returns the BEACON workflow trigger of every user
+func (p *Pipeline) Triggers(ctx context.Context, msg alertmodel.ShortAlertMsg, users []User) ([]models.WorkflowTriggerAlert, error) {
+	var triggerAlerts []models.WorkflowTriggerAlert
+	currOutLvl, found := models.OutlookLevelDict[msg.Categorization.Level]
+	if !found {
+		return nil, fmt.Errorf("failed to lookup outlook level: %s", msg.Categorization.Level)
+	}
+	var prevOutLvl = "0"
+	if msg.IsUpdate && len(msg.RefIds) > 0 {
+		var pErr error
+		prevOutLvl, pErr = p.previousOutlookLevel(ctx, msg)
+		if pErr != nil {
+			return nil, pErr
+		}
+	}
+
+	for _, user := range users {
+		userPrevOutLvl := prevOutLvl
+		if user.PreviousOutlookLevel != "" {
+			userPrevOutLvl = user.PreviousOutlookLevel
+		}
+		ta := models.WorkflowTriggerAlert{
+			Name:  "BEACON",
+			Email: user.Email,
+			DataFields: models.BeaconFields{
+				PushData: models.BeaconPush{
+					Update:               msg.IsUpdate,
+					AlertId:              msg.Identifier,
+					AlertType:            msg.Categorization.Text,
+					CurrentOutlookLevel:  currOutLvl,
+					PreviousOutlookLevel: userPrevOutLvl,
+					WeatherEvent:         msg.Categorization.Category,
+					Deeplink:             "emergency",
+				},
+				Channels: user.Preferences.Channels,
+			},
+		}
+
+		triggerAlerts = append(triggerAlerts, ta)
+	}
+
+	return triggerAlerts, nil
+}
+
+// previousOutlookLevel is the level of the referenced alerts, empty
+// when one of them is no longer cached
+func (p *Pipeline) previousOutlookLevel(ctx context.Context, msg alertmodel.ShortAlertMsg) (string, error) {
+	var prevOutLvl = "0"
+	lastStamp := time.Date(1971, time.November, 1, 1, 1, 0, 0, time.UTC)
+	for _, refId := range msg.RefIds {
+		fullAlert, err := p.Cache.Get(ctx, refId).Result()
+		if err == redis.Nil {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("unexpected error during redis fetch(%s), %s", refId, err)
+		}
+
+		fullObj := alertmodel.AlertMsg{}
+		if err = json.Unmarshal([]byte(fullAlert), &fullObj); err != nil {
+			return "", fmt.Errorf("failed parsing cached alert(%s): %s", refId, err)
+		}
+
+		msgDate, err := time.Parse(time.RFC3339, msg.OnsetTime)
+		if err != nil {
+			return "", fmt.Errorf("failed parsing %s: %s", msg.OnsetTime, err)
+		}
+		if msgDate.After(lastStamp) {
+			lastStamp = msgDate
+			level := string(alertmodel.AlertCodeToCategorization[fullObj.Info.EventCode.Value].Level)
+			if fullObj.Categorization != nil {
+				level = fullObj.Categorization.Level
+			}
+			prevOutLvl = models.OutlookLevelDict[level]
+		}
+	}
+
+	return prevOutLvl, nil
+}
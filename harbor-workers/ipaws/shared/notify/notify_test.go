@@ -0,0 +1,139 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/notify"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/preferences"
+)
+
+var ctx = context.Background()
+
+func newPipeline(t *testing.T) (*notify.Pipeline, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+	conn := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return &notify.Pipeline{
+		Ledger: &ledger.Ledger{Conn: conn, Prefix: "ledger", Limit: 3, Window: time.Hour},
+		Cache:  conn,
+	}, mr
+}
+
+func warning(id string, refs ...string) alertmodel.ShortAlertMsg {
+	return alertmodel.ShortAlertMsg{
+		Identifier: id,
+		RefIds:     refs,
+		IsUpdate:   len(refs) > 0,
+		OnsetTime:  "2021-10-13T15:00:00-05:00",
+		Categorization: alertmodel.AlertCategorization{
+			Text:     "Tornado Warning",
+			Level:    string(alertmodel.WARNING),
+			Category: string(alertmodel.TORNADOES),
+		},
+	}
+}
+
+func TestFilterByPreferences(t *testing.T) {
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+	users := []notify.User{
+		{ID: 1, Preferences: preferences.Default},
+		{ID: 2, Preferences: preferences.Preferences{MinLevel: alertmodel.DANGEROUS, Channels: []string{preferences.ChannelPush}}},
+		{ID: 3},
+	}
+
+	allowed, withheld := notify.FilterByPreferences(warning("a"), users, now)
+	if len(allowed) != 1 || allowed[0].ID != 1 {
+		t.Errorf("expected only user 1, got %+v", allowed)
+	}
+	if withheld[2] != preferences.ReasonBelowMinLevel || withheld[3] != preferences.ReasonNoChannels {
+		t.Errorf("unexpected withheld reasons %v", withheld)
+	}
+}
+
+func TestFilterByLedgerDryRun(t *testing.T) {
+	p, mr := newPipeline(t)
+	now := time.Date(2021, 10, 13, 15, 0, 0, 0, time.UTC)
+	users := func() []notify.User {
+		return []notify.User{{ID: 1, Email: "a@example.com"}}
+	}
+
+	p.DryRun = true
+	res, err := p.FilterByLedger(ctx, warning("a"), users(), now)
+	if err != nil {
+		t.Fatalf("failed filtering: %s", err)
+	}
+	if len(res.Users) != 1 || res.Chain != "a" {
+		t.Errorf("expected user 1 on chain a, got %+v", res)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected a dry run to record nothing, got %v", keys)
+	}
+
+	p.DryRun = false
+	if _, err = p.FilterByLedger(ctx, warning("a"), users(), now); err != nil {
+		t.Fatalf("failed filtering: %s", err)
+	}
+
+	// the update is previewed as a duplicate of the recorded send
+	p.DryRun = true
+	res, err = p.FilterByLedger(ctx, warning("b", "a"), users(), now)
+	if err != nil {
+		t.Fatalf("failed filtering: %s", err)
+	}
+	if len(res.Users) != 0 || res.Suppressed[1] != ledger.ReasonDuplicate || res.Chain != "a" {
+		t.Errorf("expected user 1 suppressed as a duplicate, got %+v", res)
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	p, mr := newPipeline(t)
+
+	watch, _ := json.Marshal(alertmodel.AlertMsg{
+		Identifier:     "a",
+		Categorization: &alertmodel.AlertCategorization{Level: string(alertmodel.WATCH)},
+	})
+	mr.Set("a", string(watch))
+
+	users := []notify.User{
+		{ID: 1, Email: "a@example.com", Preferences: preferences.Default},
+		{ID: 2, Email: "b@example.com", Preferences: preferences.Default, PreviousOutlookLevel: "1"},
+	}
+	triggers, err := p.Triggers(ctx, warning("b", "a"), users)
+	if err != nil {
+		t.Fatalf("failed creating triggers: %s", err)
+	}
+	if len(triggers) != 2 {
+		t.Fatalf("expected a trigger per user, got %d", len(triggers))
+	}
+
+	push := triggers[0].DataFields.PushData
+	if !push.Update || push.CurrentOutlookLevel != "3" || push.PreviousOutlookLevel != "2" || push.AlertType != "Tornado Warning" {
+		t.Errorf("unexpected push data %+v", push)
+	}
+	// the level the ledger last sent wins over the cached alert
+	if prev := triggers[1].DataFields.PushData.PreviousOutlookLevel; prev != "1" {
+		t.Errorf("expected the ledger level, got %s", prev)
+	}
+
+	mr.Set("garbled", "{")
+	if _, err = p.Triggers(ctx, warning("d", "garbled"), users); err == nil {
+		t.Errorf("expected a garbled cached alert to fail")
+	}
+
+	bad := warning("c")
+	bad.Categorization.Level = "SEVERE"
+	if _, err = p.Triggers(ctx, bad, users); err == nil {
+		t.Errorf("expected an unknown level to fail")
+	}
+}
@@ -0,0 +1,131 @@
+package preferences
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+// channels a user can be notified on
+const (
+	ChannelPush  = "push"
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// reasons a notification is withheld
+const (
+	ReasonBelowMinLevel = "below_min_level"
+	ReasonUnsubscribed  = "unsubscribed"
+	ReasonQuietHours    = "quiet_hours"
+	ReasonNoChannels    = "no_channels"
+	ReasonOtherLanguage = "other_language"
+)
+
+// Preferences are a user's notification settings. Users that never
+// saved preferences get Default, which notifies on everything.
+type Preferences struct {
+	MinLevel alertmodel.AlertLevel
+	// event names from events_subscriptions, they match
+	// AlertCategory for the risks alerts are sent for. nil
+	// allows every category.
+	Categories []string
+	// local "15:04" times, quiet hours wrap past midnight
+	// when the end is before the start
+	QuietStart string
+	QuietEnd   string
+	Timezone   string
+	Channels   []string
+	// language tag like "es" or "es-US", alerts sent in several
+	// languages are published once per language. empty gets the
+	// primary language.
+	Language string
+}
+
+var Default = Preferences{
+	MinLevel: alertmodel.AWARE,
+	Channels: []string{ChannelPush},
+}
+
+// Allows reports whether the alert should be sent to the user at
+// now, with the reason when it should not. DANGEROUS alerts are
+// always sent during quiet hours.
+func (p Preferences) Allows(alert alertmodel.ShortAlertMsg, now time.Time) (bool, string) {
+	if len(p.Channels) == 0 {
+		return false, ReasonNoChannels
+	}
+
+	if !alert.ForLanguage(p.Language) {
+		return false, ReasonOtherLanguage
+	}
+
+	if levelRank(alert.Categorization.Level) < levelRank(string(p.MinLevel)) {
+		return false, ReasonBelowMinLevel
+	}
+
+	// alerts outside the risk categories, like thunderstorms,
+	// are not tied to a subscription
+	category := alert.Categorization.Category
+	if p.Categories != nil && category != string(alertmodel.NONE) && !contains(p.Categories, category) {
+		return false, ReasonUnsubscribed
+	}
+
+	if alert.Categorization.Level != string(alertmodel.DANGEROUS) && p.InQuietHours(now) {
+		return false, ReasonQuietHours
+	}
+
+	return true, ""
+}
+
+// InQuietHours reports whether now falls in the user's quiet hours
+func (p Preferences) InQuietHours(now time.Time) bool {
+	if p.QuietStart == "" || p.QuietEnd == "" {
+		return false
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return false
+	}
+	start, errStart := parseClock(p.QuietStart)
+	end, errEnd := parseClock(p.QuietEnd)
+	if errStart != nil || errEnd != nil || start == end {
+		return false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// parseClock returns minutes past midnight for a "15:04" time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("malformed time %s: %s", clock, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func levelRank(level string) int {
+	rank, err := strconv.Atoi(models.OutlookLevelDict[level])
+	if err != nil {
+		return 0
+	}
+	return rank
+}
+
+func contains(arr []string, val string) bool {
+	for _, v := range arr {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
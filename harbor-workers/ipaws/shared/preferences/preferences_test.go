@@ -0,0 +1,98 @@
+package preferences_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/preferences"
+)
+
+func alert(level alertmodel.AlertLevel, category alertmodel.AlertCategory) alertmodel.ShortAlertMsg {
+	return alertmodel.ShortAlertMsg{
+		Categorization: alertmodel.AlertCategorization{Level: string(level), Category: string(category)},
+	}
+}
+
+func TestDefaultAllowsEverything(t *testing.T) {
+	now := time.Date(2021, 10, 13, 7, 0, 0, 0, time.UTC)
+	for _, a := range []alertmodel.ShortAlertMsg{
+		alert(alertmodel.AWARE, alertmodel.FLOODS),
+		alert(alertmodel.WARNING, alertmodel.NONE),
+		alert(alertmodel.DANGEROUS, alertmodel.TORNADOES),
+	} {
+		if ok, reason := preferences.Default.Allows(a, now); !ok {
+			t.Errorf("expected %+v to be allowed, withheld for %s", a.Categorization, reason)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	p := preferences.Preferences{
+		MinLevel:   alertmodel.WATCH,
+		Categories: []string{string(alertmodel.TORNADOES), string(alertmodel.FLOODS)},
+		QuietStart: "22:00",
+		QuietEnd:   "07:00",
+		Timezone:   "America/Chicago",
+		Channels:   []string{preferences.ChannelPush},
+	}
+
+	// 20:00 and 23:30 in chicago
+	evening := time.Date(2021, 10, 14, 1, 0, 0, 0, time.UTC)
+	night := time.Date(2021, 10, 14, 4, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		alert  alertmodel.ShortAlertMsg
+		now    time.Time
+		reason string
+	}{
+		{"subscribed warning", alert(alertmodel.WARNING, alertmodel.TORNADOES), evening, ""},
+		{"uncategorized warning", alert(alertmodel.WARNING, alertmodel.NONE), evening, ""},
+		{"below min level", alert(alertmodel.AWARE, alertmodel.FLOODS), evening, preferences.ReasonBelowMinLevel},
+		{"unsubscribed", alert(alertmodel.WARNING, alertmodel.WILDFIRE), evening, preferences.ReasonUnsubscribed},
+		{"quiet hours", alert(alertmodel.WARNING, alertmodel.TORNADOES), night, preferences.ReasonQuietHours},
+		{"dangerous overrides quiet hours", alert(alertmodel.DANGEROUS, alertmodel.TORNADOES), night, ""},
+	}
+
+	for _, tt := range tests {
+		ok, reason := p.Allows(tt.alert, tt.now)
+		if ok != (tt.reason == "") || reason != tt.reason {
+			t.Errorf("%s: expected reason %q, got %v %q", tt.name, tt.reason, ok, reason)
+		}
+	}
+}
+
+func TestNoChannels(t *testing.T) {
+	p := preferences.Default
+	p.Channels = []string{}
+
+	now := time.Date(2021, 10, 13, 12, 0, 0, 0, time.UTC)
+	if ok, reason := p.Allows(alert(alertmodel.DANGEROUS, alertmodel.TORNADOES), now); ok || reason != preferences.ReasonNoChannels {
+		t.Errorf("expected no channels to withhold, got %v %q", ok, reason)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	now := time.Date(2021, 10, 13, 12, 0, 0, 0, time.UTC)
+	en := alert(alertmodel.WARNING, alertmodel.TORNADOES)
+	en.Language, en.Languages = "en-US", []string{"en-US", "es-US"}
+	es := en
+	es.Language = "es-US"
+
+	p := preferences.Default
+	if ok, _ := p.Allows(en, now); !ok {
+		t.Errorf("expected users without a language to get the primary language")
+	}
+	if ok, reason := p.Allows(es, now); ok || reason != preferences.ReasonOtherLanguage {
+		t.Errorf("expected the translation to be withheld, got %v %q", ok, reason)
+	}
+
+	p.Language = "es"
+	if ok, reason := p.Allows(en, now); ok || reason != preferences.ReasonOtherLanguage {
+		t.Errorf("expected the primary language to be withheld, got %v %q", ok, reason)
+	}
+	if ok, _ := p.Allows(es, now); !ok {
+		t.Errorf("expected spanish users to get the translation")
+	}
+}
@@ -0,0 +1,121 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/jmoiron/sqlx"
+)
+
+// Store loads the categorization rules from postgres and reloads them
+// once they are older than Refresh, rules change without a deploy.
+//
+//	create table alert_categorization_rules (
+//		id serial primary key,
+//		-- empty matches anything
+//		event_code text not null default '',
+//		urgency text not null default '',
+//		severity text not null default '',
+//		certainty text not null default '',
+//		response_type text not null default '',
+//		-- empty keeps the categorization of the event code
+//		level text not null default '',
+//		category text not null default '',
+//		text text not null default '',
+//		enabled boolean not null default true,
+//		updated_at timestamptz not null default now()
+//	);
+type Store struct {
+	DB      *sqlx.DB
+	Refresh time.Duration
+
+	mu       sync.Mutex
+	current  *alertmodel.RuleSet
+	loadedAt time.Time
+
+	// overridden by tests
+	load func(ctx context.Context) ([]alertmodel.Rule, error)
+	now  func() time.Time
+}
+
+const defaultRefresh = time.Minute * 5
+
+const rulesQuery = `
+select event_code, urgency, severity, certainty, response_type, level, category, text
+from alert_categorization_rules
+where enabled
+order by id`
+
+type ruleRow struct {
+	EventCode    string `db:"event_code"`
+	Urgency      string `db:"urgency"`
+	Severity     string `db:"severity"`
+	Certainty    string `db:"certainty"`
+	ResponseType string `db:"response_type"`
+	Level        string `db:"level"`
+	Category     string `db:"category"`
+	Text         string `db:"text"`
+}
+
+// Current returns the rules, reloading them when stale. A failed
+// reload keeps the previous rules, nil before the first successful
+// load, and is retried after Refresh.
+func (s *Store) Current(ctx context.Context) (*alertmodel.RuleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	refresh := s.Refresh
+	if refresh <= 0 {
+		refresh = defaultRefresh
+	}
+	if !s.loadedAt.IsZero() && now.Sub(s.loadedAt) < refresh {
+		return s.current, nil
+	}
+	s.loadedAt = now
+
+	load := s.load
+	if load == nil {
+		load = s.query
+	}
+	rules, err := load(ctx)
+	if err != nil {
+		return s.current, fmt.Errorf("failed loading categorization rules: %s", err)
+	}
+	rs, err := alertmodel.NewRuleSet(rules)
+	if err != nil {
+		return s.current, fmt.Errorf("invalid categorization rules: %s", err)
+	}
+	s.current = rs
+
+	return s.current, nil
+}
+
+func (s *Store) query(ctx context.Context) ([]alertmodel.Rule, error) {
+	var rows []ruleRow
+	if err := s.DB.SelectContext(ctx, &rows, rulesQuery); err != nil {
+		return nil, err
+	}
+
+	rules := make([]alertmodel.Rule, len(rows))
+	for i, row := range rows {
+		rules[i] = alertmodel.Rule{
+			EventCode:    row.EventCode,
+			Urgency:      row.Urgency,
+			Severity:     row.Severity,
+			Certainty:    row.Certainty,
+			ResponseType: row.ResponseType,
+			Level:        alertmodel.AlertLevel(row.Level),
+			Category:     alertmodel.AlertCategory(row.Category),
+			Text:         row.Text,
+		}
+	}
+
+	return rules, nil
+}
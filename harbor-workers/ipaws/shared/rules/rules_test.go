@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+func TestCurrentReloads(t *testing.T) {
+	now := time.Date(2021, 5, 3, 18, 0, 0, 0, time.UTC)
+	loads := 0
+	var loadErr error
+	level := alertmodel.WATCH
+
+	s := &Store{
+		Refresh: time.Minute,
+		now:     func() time.Time { return now },
+		load: func(ctx context.Context) ([]alertmodel.Rule, error) {
+			loads++
+			if loadErr != nil {
+				return nil, loadErr
+			}
+			return []alertmodel.Rule{{EventCode: "SVR", Level: level}}, nil
+		},
+	}
+	svr := alertmodel.InfoMsg{EventCode: alertmodel.EventCodeMsg{Value: "SVR"}}
+
+	levelOf := func() string {
+		rs, err := s.Current(context.Background())
+		if err != nil {
+			t.Logf("reload failed: %s", err)
+		}
+		return rs.Categorize(svr).Level
+	}
+
+	if got := levelOf(); got != string(alertmodel.WATCH) || loads != 1 {
+		t.Fatalf("expected the loaded rule, got %s after %d loads", got, loads)
+	}
+
+	// fresh rules are not reloaded
+	level = alertmodel.DANGEROUS
+	now = now.Add(time.Second * 30)
+	if got := levelOf(); got != string(alertmodel.WATCH) || loads != 1 {
+		t.Fatalf("expected the cached rule, got %s after %d loads", got, loads)
+	}
+
+	now = now.Add(time.Minute)
+	if got := levelOf(); got != string(alertmodel.DANGEROUS) || loads != 2 {
+		t.Fatalf("expected the reloaded rule, got %s after %d loads", got, loads)
+	}
+
+	// a failed reload keeps the previous rules until the next refresh
+	loadErr = fmt.Errorf("connection refused")
+	now = now.Add(time.Minute)
+	if _, err := s.Current(context.Background()); err == nil {
+		t.Errorf("expected the reload error")
+	}
+	if got := levelOf(); got != string(alertmodel.DANGEROUS) || loads != 3 {
+		t.Fatalf("expected the previous rule, got %s after %d loads", got, loads)
+	}
+}
+
+func TestCurrentRejectsInvalidRules(t *testing.T) {
+	s := &Store{
+		load: func(ctx context.Context) ([]alertmodel.Rule, error) {
+			return []alertmodel.Rule{{EventCode: "SVR", Level: "SEVERE"}}, nil
+		},
+	}
+
+	rs, err := s.Current(context.Background())
+	if err == nil {
+		t.Fatalf("expected invalid rules to be rejected")
+	}
+	// the event code table still applies
+	svr := alertmodel.InfoMsg{EventCode: alertmodel.EventCodeMsg{Value: "SVR"}}
+	if got := rs.Categorize(svr).Level; got != string(alertmodel.WARNING) {
+		t.Errorf("expected the table level, got %s", got)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package shortform
 
 const ugcQuery = `
 select
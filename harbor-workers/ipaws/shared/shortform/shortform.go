@@ -0,0 +1,166 @@
+package shortform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/jmoiron/sqlx"
+)
+
+// Builder turns an alert into the short form alerts published to the
+// notifiers, one per polygon, circle or geocode of its areas
+type Builder struct {
+	// geocode_ugc holds the convex hulls of the ugc geocodes
+	DB *sqlx.DB
+	// categorizes every info block, the event code table and the
+	// cap severity apply when nil
+	Categorize func(info alertmodel.InfoMsg) alertmodel.AlertCategorization
+}
+
+// Build returns the short form alerts of the areas of every info
+// block, none when the alert has no geometry. Translated blocks repeat
+// the areas of the primary block, so an alert sent in several
+// languages is published once per language and the notifiers pick
+// the variant matching the user, see ShortAlertMsg.ForLanguage.
+func (b *Builder) Build(alert alertmodel.AlertMsg) ([]*alertmodel.ShortAlertMsg, error) {
+	alerts := []*alertmodel.ShortAlertMsg{}
+
+	languages := Languages(alert)
+	for _, info := range alert.Infos {
+		cat := b.categorize(info)
+		for _, area := range info.Areas {
+			areaAlerts, err := b.areaAlerts(alert, info, area, cat)
+			if err != nil {
+				return nil, err
+			}
+			for _, sfa := range areaAlerts {
+				sfa.Languages = languages
+			}
+			alerts = append(alerts, areaAlerts...)
+		}
+	}
+
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+
+	return alerts, nil
+}
+
+// Languages are the languages of the info blocks, primary first
+func Languages(alert alertmodel.AlertMsg) []string {
+	languages := []string{alert.Info.Language}
+	for _, info := range alert.Infos {
+		if !contains(languages, info.Language) {
+			languages = append(languages, info.Language)
+		}
+	}
+	return languages
+}
+
+// Primary filters the short form alerts down to the primary language
+// variants, the ones kept in the alert state and the archive
+func Primary(alerts []*alertmodel.ShortAlertMsg) []*alertmodel.ShortAlertMsg {
+	var primary []*alertmodel.ShortAlertMsg
+	for _, sfa := range alerts {
+		if sfa.Primary() {
+			primary = append(primary, sfa)
+		}
+	}
+	return primary
+}
+
+func contains(arr []string, val string) bool {
+	for _, v := range arr {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Builder) categorize(info alertmodel.InfoMsg) alertmodel.AlertCategorization {
+	if b.Categorize != nil {
+		return b.Categorize(info)
+	}
+
+	var rs *alertmodel.RuleSet
+	return rs.Categorize(info)
+}
+
+// an area can carry any number of polygons and circles, each is
+// sent as its own short form alert. geocodes are only used when
+// the area has no explicit geometry.
+func (b *Builder) areaAlerts(alert alertmodel.AlertMsg, info alertmodel.InfoMsg, area alertmodel.AreaMsg,
+	cat alertmodel.AlertCategorization) ([]*alertmodel.ShortAlertMsg, error) {
+	alerts := []*alertmodel.ShortAlertMsg{}
+
+	for _, polygonStr := range area.Polygons {
+		geom, err := geo.GetPolygonFromString(polygonStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing polygon geometry: %s", err)
+		}
+		alerts = append(alerts, Create(alert, info, area, polygonStr, BoundingBox(geom), geom, cat))
+	}
+
+	for _, circleStr := range area.Circles {
+		geom, err := geo.GetPolygonFromCircleString(circleStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing circle geometry: %s", err)
+		}
+		alerts = append(alerts, Create(alert, info, area, geom.String(), BoundingBox(geom), geom, cat))
+	}
+
+	if len(alerts) != 0 {
+		return alerts, nil
+	}
+
+	for _, gc := range area.Geocodes {
+		var rows []*GeocodeUgcRow
+		gcStr := fmt.Sprintf("%s%s", gc[0:2], gc[3:])
+		if err := b.DB.Select(&rows, ugcQuery, gcStr); err != nil {
+			return nil, fmt.Errorf("geocode_ugc query failed: %s", err)
+		}
+		for _, row := range rows {
+			bbStr := fmt.Sprintf("%f %f %f %f",
+				row.BBLatLo, row.BBLatHi, row.BBLngLo, row.BBLngHi)
+			geom, err := geo.GetPolygonFromString(row.ConvexHull)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing convex hull geometry for %s: %s", gcStr, err)
+			}
+			alerts = append(alerts, Create(alert, info, area, row.ConvexHull, bbStr, geom, cat))
+		}
+	}
+
+	return alerts, nil
+}
+
+// Create is the short form alert of a single geometry of an area
+func Create(alert alertmodel.AlertMsg, info alertmodel.InfoMsg, area alertmodel.AreaMsg,
+	polygon string, boundingBox string, geometry *geo.Polygon, cat alertmodel.AlertCategorization) *alertmodel.ShortAlertMsg {
+	return &alertmodel.ShortAlertMsg{
+		Identifier:     alert.Identifier,
+		IsUpdate:       strings.ToLower(alert.MsgType) == "update",
+		RefIds:         alert.References,
+		Language:       info.Language,
+		AreaDesc:       area.AreaDesc,
+		Polygon:        polygon,
+		BoundingBox:    boundingBox,
+		Geometry:       geometry,
+		Categorization: cat,
+		OnsetTime:      info.Onset,
+		ExpirationTime: info.Expires,
+	}
+}
+
+// BoundingBox is the geometry bounds inflated by 16km, as the
+// "latLo latHi lngLo lngHi" string of the short form
+func BoundingBox(geometry *geo.Polygon) string {
+	rRect := geometry.BoundingBox(16.0)
+
+	return fmt.Sprintf("%f %f %f %f",
+		rRect.LatLo, rRect.LatHi,
+		rRect.LngLo, rRect.LngHi)
+}
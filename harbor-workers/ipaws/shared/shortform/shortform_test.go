@@ -0,0 +1,86 @@
+package shortform_test
+
+import (
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/shortform"
+)
+
+func TestBuild(t *testing.T) {
+	info := alertmodel.InfoMsg{
+		Language:  "en-US",
+		Event:     "Air Quality Alert",
+		Severity:  "Severe",
+		EventCode: alertmodel.EventCodeMsg{Value: "AQA"},
+		Areas: []alertmodel.AreaMsg{{
+			AreaDesc: "Cleveland, OK",
+			Polygons: []string{"35.17,-97.53 35.24,-97.42 35.3,-97.47 35.17,-97.53"},
+			Circles:  []string{"35.2,-97.5 5"},
+		}},
+	}
+	translated := info
+	translated.Language = "es-US"
+	alert := alertmodel.AlertMsg{
+		Identifier: "aqa.1",
+		MsgType:    "Update",
+		References: []string{"aqa.0"},
+		Info:       info,
+		Infos:      []alertmodel.InfoMsg{info, translated},
+	}
+
+	b := &shortform.Builder{}
+	alerts, err := b.Build(alert)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	// the translated block repeats the areas
+	if len(alerts) != 4 {
+		t.Fatalf("expected a short form alert per geometry and language, got %d", len(alerts))
+	}
+	for i, sfa := range alerts {
+		if len(sfa.Languages) != 2 || sfa.Languages[0] != "en-US" || sfa.Languages[1] != "es-US" {
+			t.Errorf("expected both languages on every variant, got %v", sfa.Languages)
+		}
+		if want := i < 2; sfa.Primary() != want {
+			t.Errorf("expected %s primary %v", sfa.Language, want)
+		}
+	}
+	if primary := shortform.Primary(alerts); len(primary) != 2 || primary[0].Language != "en-US" {
+		t.Errorf("expected the english variants, got %v", primary)
+	}
+	if alerts[2].Language != "es-US" || !alerts[2].ForLanguage("es") || alerts[0].ForLanguage("es") {
+		t.Errorf("expected spanish users to get the translated variant")
+	}
+
+	sfa := alerts[0]
+	if !sfa.IsUpdate || sfa.RefIds[0] != "aqa.0" || sfa.AreaDesc != "Cleveland, OK" || sfa.Geometry == nil {
+		t.Errorf("unexpected short form alert %+v", sfa)
+	}
+	// codes outside the table are published on their severity
+	want := alertmodel.AlertCategorization{
+		Text:     "Air Quality Alert",
+		Category: string(alertmodel.NONE),
+		Code:     "AQA",
+		Level:    string(alertmodel.WARNING),
+	}
+	if sfa.Categorization != want {
+		t.Errorf("expected %+v, got %+v", want, sfa.Categorization)
+	}
+	if alerts[1].Geometry == nil || len(alerts[1].Geometry.Vertices) < 3 {
+		t.Errorf("expected the circle to be approximated, got %+v", alerts[1])
+	}
+
+	b.Categorize = func(info alertmodel.InfoMsg) alertmodel.AlertCategorization {
+		return alertmodel.AlertCategorization{Code: info.EventCode.Value, Level: string(alertmodel.DANGEROUS)}
+	}
+	if alerts, _ = b.Build(alert); alerts[0].Categorization.Level != string(alertmodel.DANGEROUS) {
+		t.Errorf("expected the builder categorization, got %+v", alerts[0].Categorization)
+	}
+
+	alert.Info.Areas = nil
+	alert.Infos = []alertmodel.InfoMsg{alert.Info}
+	if alerts, err = b.Build(alert); err != nil || alerts != nil {
+		t.Errorf("expected no short form alerts without geometry, got %v: %v", alerts, err)
+	}
+}
@@ -0,0 +1,100 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the start of the feed, requesting recent alerts from here returns
+// everything IPAWS still holds
+const ipawsFullWindow = "2012-08-21T11:40:43Z"
+
+// IPAWSSource reads the FEMA IPAWS-OPEN public CAP feed. With a
+// Watermark only alerts sent since the last processed batch are
+// requested.
+type IPAWSSource struct {
+	// the recent endpoint, the timestamp and pin are appended
+	URL       string
+	Pin       string
+	Client    *http.Client
+	Watermark *Watermark
+
+	// alerts and time of the last fetch, the watermark advances
+	// past them on Commit
+	fetched   []alertmodel.AlertMsg
+	fetchedAt time.Time
+}
+
+func (s *IPAWSSource) Name() string {
+	return "ipaws"
+}
+
+func (s *IPAWSSource) Fetch(ctx context.Context) (*FetchResult, error) {
+	s.fetched, s.fetchedAt = nil, time.Time{}
+
+	now := time.Now()
+	since := ipawsFullWindow
+	if s.Watermark != nil {
+		mark, recovering, err := s.Watermark.Since(ctx, now)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("watermark unavailable, polling the full window")
+		} else if recovering {
+			log.WithFields(log.Fields{"watermark": mark}).Warn("watermark missing or stale, polling the full window")
+		} else {
+			since = mark.UTC().Format(time.RFC3339)
+		}
+	}
+
+	url := fmt.Sprintf("%s/%s", s.URL, since)
+	if s.Pin != "" {
+		url = url + "?pin=" + s.Pin
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io read failed: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	alerts, err := parse.CAP(bodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.fetched, s.fetchedAt = alerts.Alert, now
+
+	return &FetchResult{
+		Alerts:      alerts.Alert,
+		Raw:         bodyBytes,
+		ContentType: "application/xml",
+		FileExt:     ".xml",
+	}, nil
+}
+
+// Commit advances the watermark past the last fetch, call it once
+// the fetched alerts are processed or dead lettered
+func (s *IPAWSSource) Commit(ctx context.Context) error {
+	if s.Watermark == nil || s.fetchedAt.IsZero() {
+		return nil
+	}
+
+	return s.Watermark.Advance(ctx, s.fetched, s.fetchedAt)
+}
@@ -0,0 +1,58 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+)
+
+// NWSSource reads active alerts from the api.weather.gov GeoJSON feed.
+// The feed carries the same CAP identifiers the NWS sends to IPAWS.
+type NWSSource struct {
+	URL string
+	// api.weather.gov rejects requests without a user agent
+	UserAgent string
+	Client    *http.Client
+}
+
+func (s *NWSSource) Name() string {
+	return "nws"
+}
+
+func (s *NWSSource) Fetch(ctx context.Context) (*FetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/geo+json")
+	req.Header.Set("User-Agent", s.UserAgent)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io read failed: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	alerts, err := parse.NWS(bodyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FetchResult{
+		Alerts:      alerts.Alert,
+		Raw:         bodyBytes,
+		ContentType: "application/geo+json",
+		FileExt:     ".json",
+	}, nil
+}
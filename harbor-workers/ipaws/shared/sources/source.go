@@ -0,0 +1,57 @@
+package sources
+
+import (
+	"context"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+// AlertSource is a feed of CAP alerts normalized to alertmodel.AlertMsg.
+type AlertSource interface {
+	// Name is used for logging and as the s3 prefix of the raw feed
+	Name() string
+	Fetch(ctx context.Context) (*FetchResult, error)
+}
+
+// Incremental sources only fetch what is new since their last
+// Commit. Nothing fetched is asked for again once committed.
+type Incremental interface {
+	Commit(ctx context.Context) error
+}
+
+type FetchResult struct {
+	Alerts []alertmodel.AlertMsg
+	// the feed as received, archived to s3
+	Raw         []byte
+	ContentType string
+	FileExt     string
+}
+
+// Dedupe merges alerts from several sources. The same CAP message is
+// relayed by more than one feed under the same identifier, only the
+// first copy is kept. Alerts referenced by another alert in the batch
+// have already been superseded and are dropped as well.
+func Dedupe(batches ...[]alertmodel.AlertMsg) []alertmodel.AlertMsg {
+	referenced := map[string]bool{}
+	for _, batch := range batches {
+		for _, alert := range batch {
+			for _, ref := range alert.References {
+				referenced[ref] = true
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	var alerts []alertmodel.AlertMsg
+	for _, batch := range batches {
+		for _, alert := range batch {
+			if seen[alert.Identifier] || referenced[alert.Identifier] {
+				continue
+			}
+			seen[alert.Identifier] = true
+			alerts = append(alerts, alert)
+		}
+	}
+
+	return alerts
+}
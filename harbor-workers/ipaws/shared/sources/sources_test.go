@@ -0,0 +1,269 @@
+package sources_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/sources"
+)
+
+const (
+	torID    = "2.49.0.1.840.0.5c0f1e4d3d2a1b0c9e8f7a6b5c4d3e2f1a0b9c8d.001.1"
+	svrID    = "2.49.0.1.840.0.1f2e3d4c5b6a79880706f5e4d3c2b1a09f8e7d6c.001.1"
+	svsID    = "2.49.0.1.840.0.77e1b6a3c1d94f0b8a2e5d6c7b8a9f0e1d2c3b4a.002.1"
+	heatID   = "2.49.0.1.840.0.0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c.001.1"
+	stubUA   = "harbor-test"
+	ipawsXML = "testdata/ipaws.xml"
+	nwsJSON  = "testdata/nws.json"
+)
+
+// serves the recorded feeds the way the live endpoints do
+func newFeedStub(t *testing.T) *httptest.Server {
+	ipawsBody, err := ioutil.ReadFile(ipawsXML)
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+	nwsBody, err := ioutil.ReadFile(nwsJSON)
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipaws/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(ipawsBody)
+	})
+	mux.HandleFunc("/alerts/active", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != stubUA {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Write(nwsBody)
+	})
+	mux.HandleFunc("/broken/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func findAlert(alerts []alertmodel.AlertMsg, id string) *alertmodel.AlertMsg {
+	for i := range alerts {
+		if alerts[i].Identifier == id {
+			return &alerts[i]
+		}
+	}
+	return nil
+}
+
+func TestIPAWSSource(t *testing.T) {
+	stub := newFeedStub(t)
+	defer stub.Close()
+
+	src := &sources.IPAWSSource{URL: stub.URL + "/ipaws", Client: stub.Client()}
+	res, err := src.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(res.Raw) == 0 || res.FileExt != ".xml" {
+		t.Fatalf("expected raw xml, got %d bytes %s", len(res.Raw), res.FileExt)
+	}
+	// the alert without a cap oid is skipped
+	if len(res.Alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %d", len(res.Alerts))
+	}
+
+	tor := findAlert(res.Alerts, torID)
+	if tor == nil {
+		t.Fatalf("missing %s", torID)
+	}
+	t.Run("primary info is english", func(t *testing.T) {
+		if tor.Info.Language != "en-US" || tor.Info.Event != "Tornado Warning" {
+			t.Fatalf("expected english tornado warning, got %s %s", tor.Info.Language, tor.Info.Event)
+		}
+		if len(tor.Infos) != 2 || tor.Infos[1].Language != "es-US" {
+			t.Fatalf("expected spanish translation, got %+v", tor.Infos)
+		}
+	})
+	t.Run("nws placeholder event code is skipped", func(t *testing.T) {
+		if tor.Info.EventCode.Value != "TOR" {
+			t.Fatalf("expected TOR, got %s", tor.Info.EventCode.Value)
+		}
+	})
+	t.Run("every area is kept", func(t *testing.T) {
+		areas := tor.Info.Areas
+		if len(areas) != 2 {
+			t.Fatalf("expected 2 areas, got %d", len(areas))
+		}
+		if len(areas[0].Polygons) != 1 || len(areas[0].Geocodes) != 2 {
+			t.Fatalf("unexpected first area %+v", areas[0])
+		}
+		if len(areas[1].Circles) != 1 || areas[1].Circles[0] != "35.41,-97.39 8" {
+			t.Fatalf("unexpected second area %+v", areas[1])
+		}
+	})
+	t.Run("missing language defaults to english", func(t *testing.T) {
+		svr := findAlert(res.Alerts, svrID)
+		if svr == nil || svr.Info.Language != "en-US" {
+			t.Fatalf("expected en-US default, got %+v", svr)
+		}
+	})
+}
+
+func TestNWSSource(t *testing.T) {
+	stub := newFeedStub(t)
+	defer stub.Close()
+
+	src := &sources.NWSSource{URL: stub.URL + "/alerts/active", UserAgent: stubUA, Client: stub.Client()}
+	res, err := src.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(res.Alerts) != 3 {
+		t.Fatalf("expected 3 alerts, got %d", len(res.Alerts))
+	}
+
+	t.Run("geojson polygon is converted to cap lat,long order", func(t *testing.T) {
+		tor := findAlert(res.Alerts, torID)
+		if tor == nil {
+			t.Fatalf("missing %s", torID)
+		}
+		want := "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.23,-97.59 35.17,-97.53"
+		if got := tor.Info.Areas[0].Polygons[0]; got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+		if tor.Info.EventCode.Value != "TOR" {
+			t.Fatalf("expected TOR, got %s", tor.Info.EventCode.Value)
+		}
+	})
+	t.Run("references and message type are normalized", func(t *testing.T) {
+		svs := findAlert(res.Alerts, svsID)
+		if svs == nil {
+			t.Fatalf("missing %s", svsID)
+		}
+		if svs.MsgType != "Update" || len(svs.References) != 1 || svs.References[0] != svrID {
+			t.Fatalf("unexpected update %+v", svs)
+		}
+		// onset is null in the feed
+		if svs.Info.Onset != svs.Info.Effective {
+			t.Fatalf("expected onset %s, got %s", svs.Info.Effective, svs.Info.Onset)
+		}
+	})
+	t.Run("zone alerts keep their ugc geocodes", func(t *testing.T) {
+		heat := findAlert(res.Alerts, heatID)
+		if heat == nil {
+			t.Fatalf("missing %s", heatID)
+		}
+		if len(heat.Info.Areas[0].Polygons) != 0 || len(heat.Info.Areas[0].Geocodes) != 2 {
+			t.Fatalf("unexpected area %+v", heat.Info.Areas[0])
+		}
+	})
+	t.Run("user agent is sent", func(t *testing.T) {
+		noUA := &sources.NWSSource{URL: stub.URL + "/alerts/active", Client: stub.Client()}
+		if _, err := noUA.Fetch(context.Background()); err == nil {
+			t.Fatalf("expected error without user agent")
+		}
+	})
+}
+
+func TestParseRawRoundTrip(t *testing.T) {
+	stub := newFeedStub(t)
+	defer stub.Close()
+
+	for _, src := range []sources.AlertSource{
+		&sources.IPAWSSource{URL: stub.URL + "/ipaws", Client: stub.Client()},
+		&sources.NWSSource{URL: stub.URL + "/alerts/active", UserAgent: stubUA, Client: stub.Client()},
+	} {
+		res, err := src.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for _, alert := range res.Alerts {
+			if alert.Raw == "" {
+				t.Fatalf("%s alert %s kept no raw message", src.Name(), alert.Identifier)
+			}
+			parsed, err := parse.Raw(alert.RawFormat, alert.Raw)
+			if err != nil {
+				t.Fatalf("failed reparsing %s alert %s: %s", src.Name(), alert.Identifier, err)
+			}
+			if !reflect.DeepEqual(*parsed, alert) {
+				t.Errorf("%s alert %s changed on reparse", src.Name(), alert.Identifier)
+			}
+		}
+	}
+
+	if _, err := parse.Raw("csv", "a,b"); err == nil {
+		t.Errorf("expected unknown formats to fail")
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	stub := newFeedStub(t)
+	defer stub.Close()
+
+	for _, src := range []sources.AlertSource{
+		&sources.IPAWSSource{URL: stub.URL + "/broken", Client: stub.Client()},
+		&sources.NWSSource{URL: stub.URL + "/broken", UserAgent: stubUA, Client: stub.Client()},
+	} {
+		if _, err := src.Fetch(context.Background()); err == nil {
+			t.Fatalf("%s: expected error on 503", src.Name())
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	stub := newFeedStub(t)
+	defer stub.Close()
+
+	ipaws, err := (&sources.IPAWSSource{URL: stub.URL + "/ipaws", Client: stub.Client()}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	nws, err := (&sources.NWSSource{URL: stub.URL + "/alerts/active", UserAgent: stubUA, Client: stub.Client()}).
+		Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	alerts := sources.Dedupe(ipaws.Alerts, nws.Alerts)
+
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"alert carried by both feeds is kept once", torID, true},
+		{"alert superseded by an update in another feed is dropped", svrID, false},
+		{"update is kept", svsID, true},
+		{"alert only in one feed is kept", heatID, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			count := 0
+			for _, alert := range alerts {
+				if alert.Identifier == tc.id {
+					count++
+				}
+			}
+			if tc.want && count != 1 {
+				t.Fatalf("expected %s once, found %d", tc.id, count)
+			}
+			if !tc.want && count != 0 {
+				t.Fatalf("expected %s dropped, found %d", tc.id, count)
+			}
+		})
+	}
+
+	// the first source wins, keeping the ipaws translations
+	tor := findAlert(alerts, torID)
+	if len(tor.Infos) != 2 {
+		t.Fatalf("expected ipaws copy with 2 infos, got %d", len(tor.Infos))
+	}
+}
@@ -0,0 +1,114 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+// Watermark is the latest sent time an incremental source has
+// processed, stored as unix seconds under Key. The time of the last
+// committed poll is kept alongside it under Key:polled.
+type Watermark struct {
+	Conn *redis.Client
+	Key  string
+	// alerts are requested again from this far before the mark,
+	// senders publish late and clocks are not in step
+	Overlap time.Duration
+	// a last poll older than this means polls were missed, the
+	// full window is requested instead to recover the gap. quiet
+	// periods without new alerts do not age the mark.
+	MaxAge time.Duration
+}
+
+// only moves the mark and the poll time forward, a replayed or out
+// of order batch must not rewind them
+var advanceScript = redis.NewScript(`
+for i, key in ipairs(KEYS) do
+	local cur = tonumber(redis.call('GET', key) or '0')
+	if tonumber(ARGV[i]) > cur then
+		redis.call('SET', key, ARGV[i])
+	end
+end
+return 1
+`)
+
+func (w *Watermark) polledKey() string {
+	return w.Key + ":polled"
+}
+
+// Since returns the time to poll from. recovering is true when there
+// is no usable mark and the full window should be polled.
+func (w *Watermark) Since(ctx context.Context, now time.Time) (since time.Time, recovering bool, err error) {
+	vals, err := w.Conn.MGet(ctx, w.Key, w.polledKey()).Result()
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("failed fetching watermark(%s): %s", w.Key, err)
+	}
+	if vals[0] == nil {
+		return time.Time{}, true, nil
+	}
+
+	mark, err := parseUnix(vals[0])
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("malformed watermark(%s) %v", w.Key, vals[0])
+	}
+	if w.MaxAge > 0 {
+		// without a poll time there is no telling how stale the mark is
+		polled, err := parseUnix(vals[1])
+		if err != nil || now.Sub(polled) > w.MaxAge {
+			return mark, true, nil
+		}
+	}
+
+	return mark.Add(-w.Overlap), false, nil
+}
+
+// Advance moves the mark to the latest sent time in alerts and
+// records polledAt as the last successful poll
+func (w *Watermark) Advance(ctx context.Context, alerts []alertmodel.AlertMsg, polledAt time.Time) error {
+	keys := []string{w.polledKey()}
+	args := []interface{}{polledAt.Unix()}
+	if latest := LatestSent(alerts); !latest.IsZero() {
+		keys = append(keys, w.Key)
+		args = append(args, latest.Unix())
+	}
+	if err := advanceScript.Run(ctx, w.Conn, keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed advancing watermark(%s): %s", w.Key, err)
+	}
+
+	return nil
+}
+
+func parseUnix(val interface{}) (time.Time, error) {
+	str, ok := val.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("missing")
+	}
+	secs, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(secs, 0), nil
+}
+
+// LatestSent returns the latest sent time in alerts, zero if none
+// carry one
+func LatestSent(alerts []alertmodel.AlertMsg) time.Time {
+	var latest time.Time
+	for _, alert := range alerts {
+		sent, err := time.Parse(time.RFC3339, alert.Sent)
+		if err != nil {
+			continue
+		}
+		if sent.After(latest) {
+			latest = sent
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,123 @@
+package sources_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/sources"
+)
+
+func newWatermark(t *testing.T) *sources.Watermark {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+
+	return &sources.Watermark{
+		Conn:    redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Key:     "ipaws:watermark",
+		Overlap: time.Minute * 5,
+		MaxAge:  time.Hour,
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	ctx := context.Background()
+	w := newWatermark(t)
+	now := time.Date(2021, 6, 10, 21, 0, 0, 0, time.UTC)
+
+	if _, recovering, err := w.Since(ctx, now); err != nil || !recovering {
+		t.Fatalf("expected recovery without a mark, got %v %v", recovering, err)
+	}
+
+	sent := []alertmodel.AlertMsg{
+		{Identifier: "a", Sent: "2021-06-10T20:20:00.000Z"},
+		{Identifier: "b", Sent: "2021-06-10T20:38:00.000Z"},
+		{Identifier: "c"},
+	}
+	if err := w.Advance(ctx, sent, now); err != nil {
+		t.Fatalf("failed advancing: %s", err)
+	}
+	since, recovering, err := w.Since(ctx, now)
+	if err != nil || recovering {
+		t.Fatalf("expected a usable mark, got %v %v", recovering, err)
+	}
+	if want := time.Date(2021, 6, 10, 20, 33, 0, 0, time.UTC); !since.Equal(want) {
+		t.Errorf("expected %s with overlap, got %s", want, since)
+	}
+
+	// an older batch does not rewind the mark
+	if err := w.Advance(ctx, sent[:1], now.Add(-time.Minute)); err != nil {
+		t.Fatalf("failed advancing: %s", err)
+	}
+	if since, _, _ = w.Since(ctx, now); !since.Equal(time.Date(2021, 6, 10, 20, 33, 0, 0, time.UTC)) {
+		t.Errorf("expected the mark to hold, got %s", since)
+	}
+
+	// polls were missed, recover the gap with the full window
+	if _, recovering, _ = w.Since(ctx, now.Add(time.Hour*2)); !recovering {
+		t.Errorf("expected a stale mark to recover")
+	}
+
+	// polls without new alerts keep a quiet feed's mark usable
+	later := now.Add(time.Hour * 3)
+	if err := w.Advance(ctx, nil, later); err != nil {
+		t.Fatalf("failed advancing: %s", err)
+	}
+	since, recovering, err = w.Since(ctx, later.Add(time.Minute))
+	if err != nil || recovering {
+		t.Errorf("expected the mark usable after a quiet poll, got %v %v", recovering, err)
+	}
+	if !since.Equal(time.Date(2021, 6, 10, 20, 33, 0, 0, time.UTC)) {
+		t.Errorf("expected the mark to hold through a quiet poll, got %s", since)
+	}
+}
+
+func TestIPAWSSourceIncremental(t *testing.T) {
+	body, err := ioutil.ReadFile(ipawsXML)
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+	var requested []string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Write(body)
+	}))
+	defer stub.Close()
+
+	ctx := context.Background()
+	src := &sources.IPAWSSource{
+		URL:       stub.URL + "/recent",
+		Pin:       "1234",
+		Client:    stub.Client(),
+		Watermark: newWatermark(t),
+	}
+	src.Watermark.MaxAge = 0
+
+	if _, err := src.Fetch(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := src.Commit(ctx); err != nil {
+		t.Fatalf("failed committing: %s", err)
+	}
+	if _, err := src.Fetch(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{
+		"/recent/2012-08-21T11:40:43Z?pin=1234",
+		// the latest sent in the fixture less the overlap
+		"/recent/2021-06-10T20:15:00Z?pin=1234",
+	}
+	if len(requested) != 2 || requested[0] != want[0] || requested[1] != want[1] {
+		t.Errorf("expected requests %v, got %v", want, requested)
+	}
+}
@@ -0,0 +1,183 @@
+package userlookup
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/preferences"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// where a user was matched
+const (
+	LocationHome         = "home"
+	LocationHousehold    = "household"
+	LocationSafeLocation = "safe_location"
+)
+
+// enough cells to hug a warning polygon without a huge query
+const defaultMaxCells = 32
+
+// Lookup finds the users with a location inside an alert
+type Lookup struct {
+	DB *sqlx.DB
+	// locations this close to the alert boundary are included
+	BufferKm float64
+	// geohash cells used to cover the alert, defaults to 32
+	MaxCells int
+}
+
+// Row is a user matched at one of their locations
+type Row struct {
+	ID             int            `db:"id"`
+	Email          *string        `db:"email"`
+	Address        *string        `db:"address"`
+	Latitude       *string        `db:"latitude"`
+	Longitude      *string        `db:"longitude"`
+	LocationType   string         `db:"location_type"`
+	HasPreferences bool           `db:"has_preferences"`
+	MinLevel       *string        `db:"min_level"`
+	QuietStart     *string        `db:"quiet_start"`
+	QuietEnd       *string        `db:"quiet_end"`
+	Timezone       *string        `db:"timezone"`
+	Channels       pq.StringArray `db:"channels"`
+	Language       *string        `db:"language"`
+	Categories     pq.StringArray `db:"categories"`
+}
+
+// Result holds the matched users and the number of candidate rows
+// the geohash query returned before the exact match
+type Result struct {
+	Users      []*Row
+	Candidates int
+	Bounds     *geo.BBRect
+}
+
+// UsersInAlert returns every user with a location inside the alert
+// geometry, or its bounding box for geocode only alerts. A user is
+// returned once, at their highest priority matched location: home,
+// then household members, then safe locations. Returns nil when the
+// alert has no area.
+func (l *Lookup) UsersInAlert(alert alertmodel.ShortAlertMsg) (*Result, error) {
+	var bbr *geo.BBRect
+	if alert.Geometry != nil {
+		bbr = alert.Geometry.BoundingBox(l.BufferKm)
+	} else {
+		var err error
+		bbr, err = geo.GetBoundingBoxFromString(alert.BoundingBox)
+		if err != nil {
+			return nil, fmt.Errorf("get bounding box failed: %s", err)
+		}
+	}
+	if bbr == nil {
+		return nil, nil
+	}
+
+	maxCells := l.MaxCells
+	if maxCells < 1 {
+		maxCells = defaultMaxCells
+	}
+	ranges := CoveringRanges(bbr, maxCells)
+	los := make([]int64, len(ranges))
+	his := make([]int64, len(ranges))
+	for i, r := range ranges {
+		los[i] = r.Lo
+		his[i] = r.Hi
+	}
+
+	var rows []*Row
+	if err := l.DB.Select(&rows, query, pq.Array(los), pq.Array(his)); err != nil {
+		return nil, fmt.Errorf("user lookup query failed: %s", err)
+	}
+
+	return &Result{
+		Users:      Match(rows, alert.Geometry, bbr, l.BufferKm),
+		Candidates: len(rows),
+		Bounds:     bbr,
+	}, nil
+}
+
+// Match keeps the rows inside the geometry, or the bounding box when
+// there is none, and the first match of every user. Rows must be
+// ordered by user and location priority.
+func Match(rows []*Row, geometry *geo.Polygon, bbr *geo.BBRect, bufferKm float64) []*Row {
+	var matched []*Row
+	seen := map[int]bool{}
+	for _, row := range rows {
+		if seen[row.ID] || row.Email == nil || row.Latitude == nil || row.Longitude == nil {
+			continue
+		}
+		lat, errLat := strconv.ParseFloat(*row.Latitude, 64)
+		long, errLong := strconv.ParseFloat(*row.Longitude, 64)
+		if errLat != nil || errLong != nil {
+			continue
+		}
+
+		if geometry != nil {
+			if !geometry.ContainsPoint(lat, long, bufferKm) {
+				continue
+			}
+		} else if !bbr.ContainsLatLng(lat, long) {
+			continue
+		}
+
+		seen[row.ID] = true
+		matched = append(matched, row)
+	}
+
+	return matched
+}
+
+// Preferences maps the notification_preferences columns of the row,
+// users that never saved preferences get preferences.Default
+func (row *Row) Preferences() preferences.Preferences {
+	if !row.HasPreferences {
+		return preferences.Default
+	}
+
+	p := preferences.Preferences{
+		MinLevel: alertmodel.AWARE,
+		// an empty subscription list withholds every risk category
+		Categories: []string(row.Categories),
+		Channels:   []string(row.Channels),
+	}
+	if p.Categories == nil {
+		p.Categories = []string{}
+	}
+	if row.MinLevel != nil {
+		p.MinLevel = alertmodel.AlertLevel(*row.MinLevel)
+	}
+	if row.Language != nil {
+		p.Language = *row.Language
+	}
+	if row.QuietStart != nil && row.QuietEnd != nil && row.Timezone != nil {
+		p.QuietStart = *row.QuietStart
+		p.QuietEnd = *row.QuietEnd
+		p.Timezone = *row.Timezone
+	}
+
+	return p
+}
+
+// UsersByID returns the users with an email and their preferences,
+// locations are left empty
+func (l *Lookup) UsersByID(userIDs []int) ([]*Row, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = int64(id)
+	}
+
+	var rows []*Row
+	if err := l.DB.Select(&rows, byIDQuery, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("user by id query failed: %s", err)
+	}
+
+	return rows, nil
+}
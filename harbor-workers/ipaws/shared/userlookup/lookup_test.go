@@ -0,0 +1,115 @@
+package userlookup_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/userlookup"
+	"github.com/mmcloughlin/geohash"
+)
+
+func inRanges(ranges []userlookup.HashRange, hash uint64) bool {
+	for _, r := range ranges {
+		if int64(hash) >= r.Lo && int64(hash) <= r.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCoveringRangesContainBoundingBox(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	boxes := []*geo.BBRect{
+		// a county sized warning
+		{LatLo: 35.1, LatHi: 35.6, LngLo: -97.9, LngHi: -97.2},
+		// a state sized watch
+		{LatLo: 31.0, LatHi: 37.0, LngLo: -103.0, LngHi: -94.4},
+		// a small circle straddling the equator and prime meridian
+		{LatLo: -0.05, LatHi: 0.05, LngLo: -0.05, LngHi: 0.05},
+		// the aleutians, across the antimeridian
+		{LatLo: 51.0, LatHi: 53.0, LngLo: 178.0, LngHi: -178.0},
+	}
+
+	for _, bb := range boxes {
+		ranges := userlookup.CoveringRanges(bb, 32)
+		if len(ranges) == 0 || len(ranges) > 64 {
+			t.Errorf("unexpected number of ranges %d for %+v", len(ranges), *bb)
+		}
+
+		width := bb.LngHi - bb.LngLo
+		if width < 0 {
+			width = width + 360
+		}
+		for i := 0; i < 1000; i++ {
+			lat := bb.LatLo + r.Float64()*(bb.LatHi-bb.LatLo)
+			lng := bb.LngLo + r.Float64()*width
+			if lng > 180 {
+				lng = lng - 360
+			}
+			// the same hash addresses/patch stores as risk_profile_id
+			hash := geohash.EncodeIntWithPrecision(lat, lng, 64)
+			if hash > 1<<63-1 {
+				continue
+			}
+			if !inRanges(ranges, hash) {
+				t.Fatalf("point %f,%f in %+v not covered", lat, lng, *bb)
+			}
+		}
+	}
+}
+
+func TestCoveringRangesAreSelective(t *testing.T) {
+	bb := &geo.BBRect{LatLo: 35.1, LatHi: 35.6, LngLo: -97.9, LngHi: -97.2}
+	ranges := userlookup.CoveringRanges(bb, 32)
+
+	// new york is nowhere near oklahoma city
+	if inRanges(ranges, geohash.EncodeIntWithPrecision(40.71, -74.0, 64)) {
+		t.Errorf("expected ranges %+v to exclude new york", ranges)
+	}
+}
+
+func row(id int, locationType string, lat float64, lng float64) *userlookup.Row {
+	email := "user" + strconv.Itoa(id) + "@example.com"
+	address := "Oklahoma City, OK"
+	latStr := strconv.FormatFloat(lat, 'f', 4, 64)
+	lngStr := strconv.FormatFloat(lng, 'f', 4, 64)
+	return &userlookup.Row{ID: id, Email: &email, Address: &address, Latitude: &latStr, Longitude: &lngStr, LocationType: locationType}
+}
+
+func TestMatchKeepsFirstLocationInsideGeometry(t *testing.T) {
+	polygon, err := geo.GetPolygonFromString("35.2,-97.8 35.5,-97.8 35.5,-97.3 35.2,-97.3 35.2,-97.8")
+	if err != nil {
+		t.Fatalf("failed parsing polygon: %s", err)
+	}
+	bb := polygon.BoundingBox(0)
+
+	rows := []*userlookup.Row{
+		// home outside the polygon, a household member inside
+		row(1, userlookup.LocationHome, 35.0, -98.5),
+		row(1, userlookup.LocationHousehold, 35.3, -97.5),
+		row(1, userlookup.LocationSafeLocation, 35.4, -97.4),
+		// inside at home
+		row(2, userlookup.LocationHome, 35.3, -97.6),
+		// only a safe location, outside
+		row(3, userlookup.LocationSafeLocation, 36.0, -97.5),
+	}
+
+	matched := userlookup.Match(rows, polygon, bb, 0)
+	if len(matched) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(matched))
+	}
+	if matched[0].ID != 1 || matched[0].LocationType != userlookup.LocationHousehold {
+		t.Errorf("expected user 1 matched by household, got %+v", *matched[0])
+	}
+	if matched[1].ID != 2 || matched[1].LocationType != userlookup.LocationHome {
+		t.Errorf("expected user 2 matched at home, got %+v", *matched[1])
+	}
+
+	// geocode only alerts match on the bounding box
+	matched = userlookup.Match(rows, nil, &geo.BBRect{LatLo: 35.9, LatHi: 36.1, LngLo: -97.6, LngHi: -97.4}, 0)
+	if len(matched) != 1 || matched[0].ID != 3 {
+		t.Errorf("expected only user 3 in the bounding box, got %d users", len(matched))
+	}
+}
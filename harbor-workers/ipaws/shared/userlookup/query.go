@@ -0,0 +1,89 @@
+package userlookup
+
+// candidate addresses are found through the geohash in
+// addresses.risk_profile_id, which needs
+//
+//	create index addresses_risk_profile_id_idx on addresses (risk_profile_id);
+//
+// a user is matched by their own address, the address of any member
+// of a household they belong to and the household safe locations
+//
+//	create table safe_locations (
+//		id serial primary key,
+//		household_id int not null references households (id),
+//		address_id int not null references addresses (id),
+//		name text,
+//		created_at timestamptz not null default now()
+//	);
+//
+// rows come back once per matched location ordered by user and
+// location priority, users without a notification_preferences row
+// have has_preferences false
+const query = `
+with located as (
+	select a.id, a.latitude, a.longitude, a.zipcode
+	from unnest($1::bigint[], $2::bigint[]) as r(lo, hi)
+	join addresses a on a.risk_profile_id between r.lo and r.hi
+), memberships as (
+	select distinct hu.user_id, hu.household_id
+	from household_users hu
+	where hu.user_id is not null
+), candidates as (
+	select u.id as user_id, 1 as priority, 'home' as location_type, l.*
+	from users u
+	join located l on l.id = u.address_id
+	union all
+	select m.user_id, 2, 'household', l.*
+	from memberships m
+	join household_users member on member.household_id = m.household_id and member.user_id != m.user_id
+	join users mu on mu.id = member.user_id
+	join located l on l.id = mu.address_id
+	union all
+	select m.user_id, 3, 'safe_location', l.*
+	from memberships m
+	join safe_locations sl on sl.household_id = m.household_id
+	join located l on l.id = sl.address_id
+)
+select
+	u.id,
+	coalesce(u.email, u.social_email) as email,
+	concat(zl.city, ', ', zl.state_abbr)  as address,
+	c.latitude,
+	c.longitude,
+	c.location_type,
+	np.user_id is not null as has_preferences,
+	np.min_level,
+	to_char(np.quiet_start, 'HH24:MI') as quiet_start,
+	to_char(np.quiet_end, 'HH24:MI') as quiet_end,
+	np.timezone,
+	np.channels,
+	np.language,
+	array(
+		select e.name
+		from events_subscriptions es
+		join events e on e.id = es.event_id
+		where es.user_id = u.id
+	) as categories
+from candidates c
+join users u on u.id = c.user_id
+left join zipcode_locations zl on c.zipcode::int = zl.id
+left join notification_preferences np on np.user_id = u.id
+where u.email is not null or u.social_email is not null
+order by u.id, c.priority`
+
+const byIDQuery = `
+select
+	u.id,
+	coalesce(u.email, u.social_email) as email,
+	np.user_id is not null as has_preferences,
+	np.min_level,
+	to_char(np.quiet_start, 'HH24:MI') as quiet_start,
+	to_char(np.quiet_end, 'HH24:MI') as quiet_end,
+	np.timezone,
+	np.channels,
+	np.language
+from users u
+left join notification_preferences np on np.user_id = u.id
+where u.id = any($1::bigint[])
+and (u.email is not null or u.social_email is not null)
+order by u.id`
@@ -0,0 +1,108 @@
+package userlookup
+
+import (
+	"math"
+	"sort"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/mmcloughlin/geohash"
+)
+
+// addresses are keyed by the 64 bit integer geohash addresses/patch
+// stores as risk_profile_id
+const hashBits = 64
+
+// HashRange is an inclusive range of 64 bit geohashes, every point
+// in a geohash cell shares its prefix so a cell is a single range
+type HashRange struct {
+	Lo int64
+	Hi int64
+}
+
+// CoveringRanges returns geohash ranges covering the bounding box
+// using the finest precision that needs at most maxCells cells.
+// Adjacent cells are merged so the query stays small.
+func CoveringRanges(bb *geo.BBRect, maxCells int) []HashRange {
+	if bb.LngLo > bb.LngHi {
+		// crosses the antimeridian
+		west := *bb
+		west.LngHi = 180
+		east := *bb
+		east.LngLo = -180
+		return merge(append(CoveringRanges(&west, maxCells), CoveringRanges(&east, maxCells)...))
+	}
+
+	var bits uint = hashBits
+	for ; bits > 1; bits-- {
+		latStep, lngStep := cellSize(bits)
+		n := (math.Floor(bb.LatHi/latStep) - math.Floor(bb.LatLo/latStep) + 1) *
+			(math.Floor(bb.LngHi/lngStep) - math.Floor(bb.LngLo/lngStep) + 1)
+		if n <= float64(maxCells) {
+			break
+		}
+	}
+
+	latStep, lngStep := cellSize(bits)
+	cells := map[uint64]bool{}
+	for _, lat := range steps(bb.LatLo, bb.LatHi, latStep) {
+		for _, lng := range steps(bb.LngLo, bb.LngHi, lngStep) {
+			cells[geohash.EncodeIntWithPrecision(lat, lng, bits)] = true
+		}
+	}
+
+	var ranges []HashRange
+	shift := hashBits - bits
+	for cell := range cells {
+		lo := cell << shift
+		hi := lo | (1<<shift - 1)
+		// postgres bigints are signed, hashes with the high bit set
+		// are never stored
+		if lo > math.MaxInt64 {
+			continue
+		}
+		if hi > math.MaxInt64 {
+			hi = math.MaxInt64
+		}
+		ranges = append(ranges, HashRange{Lo: int64(lo), Hi: int64(hi)})
+	}
+
+	return merge(ranges)
+}
+
+// cellSize returns the degrees of latitude and longitude spanned by
+// a geohash cell, longitude takes the extra bit for odd precisions
+func cellSize(bits uint) (float64, float64) {
+	latBits := bits / 2
+	lngBits := bits - latBits
+
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lngBits))
+}
+
+// steps samples lo to hi at most step apart so every cell the span
+// touches is sampled
+func steps(lo float64, hi float64, step float64) []float64 {
+	var vals []float64
+	for v := lo; v < hi; v += step {
+		vals = append(vals, v)
+	}
+
+	return append(vals, hi)
+}
+
+func merge(ranges []HashRange) []HashRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Lo < ranges[j].Lo })
+
+	var merged []HashRange
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && r.Lo <= merged[last].Hi+1 {
+			if r.Hi > merged[last].Hi {
+				merged[last].Hi = r.Hi
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	lambdaContext "github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/alertmodel/parse"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/env"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/iterable"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/notify"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/rules"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/shortform"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/userlookup"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	allowlist      map[string]bool
+	categoryRules  *rules.Store
+	dryRun         *notify.Pipeline
+	iterableClient *iterable.Client
+	sendRun        *notify.Pipeline
+	shortForms     *shortform.Builder
+	stdFields      map[string]interface{}
+
+	ctx = context.Background()
+
+	environment = os.Getenv("LAMBDA_ENV")
+)
+
+// decision of a user that is notified
+const decisionNotify = "notify"
+
+// SimulateRequest is the invocation payload, either a cap message or
+// a synthetic alert of Polygon and EventCode
+type SimulateRequest struct {
+	CAP       string `json:"cap"`
+	Polygon   string `json:"polygon"`
+	EventCode string `json:"eventCode"`
+	// cap severity of the synthetic alert, Severe when empty
+	Severity string `json:"severity"`
+	// delivers the triggers of allowlisted users, and records them in
+	// the simulation's own ledger. Everyone else is only reported.
+	Send bool `json:"send"`
+}
+
+type Report struct {
+	Alerts []AlertReport `json:"alerts"`
+	Sent   int           `json:"sent"`
+}
+
+// AlertReport is the outcome of one short form alert
+type AlertReport struct {
+	Identifier     string                         `json:"identifier"`
+	AreaDesc       string                         `json:"areaDesc"`
+	Categorization alertmodel.AlertCategorization `json:"categorization"`
+	Chain          string                         `json:"chain"`
+	// number of users by decision
+	Decisions map[string]int `json:"decisions"`
+	Users     []UserReport   `json:"users"`
+}
+
+// UserReport identifies users by id alone, the report is read by
+// people who have no business seeing contact details
+type UserReport struct {
+	ID int `json:"id"`
+	// notify, or the preference or ledger reason the user is skipped
+	Decision string `json:"decision"`
+	// the workflow data fields the user would be sent
+	DataFields *models.BeaconFields `json:"dataFields,omitempty"`
+	Send       bool                 `json:"send"`
+
+	// sent as is, it carries the email
+	trigger *models.WorkflowTriggerAlert
+}
+
+func (ar *AlertReport) add(ur UserReport) {
+	ar.Users = append(ar.Users, ur)
+	ar.Decisions[ur.Decision] = ar.Decisions[ur.Decision] + 1
+}
+
+// handler runs an alert through the ingest and notifier pipeline
+// against the users of the environment, nothing is sent or recorded
+// unless the request opts in for the allowlisted test accounts
+func handler(awsCtx context.Context, req SimulateRequest) (*Report, error) {
+	setCtxFields(awsCtx)
+
+	report, err := simulate(req, time.Now())
+	if err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"err": err}).Error("simulation failed")
+		return report, err
+	}
+
+	return report, nil
+}
+
+func simulate(req SimulateRequest, now time.Time) (*Report, error) {
+	if req.Send && environment == "production" {
+		return nil, fmt.Errorf("simulated alerts are not sent in production")
+	}
+	if req.Send && len(allowlist) == 0 {
+		return nil, fmt.Errorf("SIMULATE_ALLOWLIST is empty, nothing can be sent")
+	}
+
+	alerts, err := requestAlerts(req, now)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Alerts: []AlertReport{}}
+	var triggers []models.WorkflowTriggerAlert
+	var pending []reservation
+	for _, alert := range alerts {
+		sfas, err := shortForms.Build(alert)
+		if err != nil {
+			return nil, fmt.Errorf("failed building short form alerts(%s): %s", alert.Identifier, err)
+		}
+		if sfas == nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier}).
+				Warn("alert has no geometry")
+		}
+
+		for _, sfa := range sfas {
+			users, _, err := dryRun.UsersInRange(*sfa)
+			if err != nil {
+				return nil, err
+			}
+			ar, reserved, err := simulateShortForm(*sfa, users, req.Send, now)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, reservation{alert: *sfa, chain: ar.Chain, users: reserved})
+			for _, ur := range ar.Users {
+				if ur.Send {
+					triggers = append(triggers, *ur.trigger)
+				}
+			}
+			report.Alerts = append(report.Alerts, *ar)
+		}
+	}
+
+	if len(triggers) > 0 {
+		res := iterableClient.TrackBulk(ctx, triggers)
+		report.Sent = res.Sent
+		if err := settle(pending, res.FailedEmails(), now); err != nil {
+			return report, err
+		}
+		if len(res.Failed) > 0 {
+			return report, fmt.Errorf("failed sending %d of %d triggers", len(triggers)-res.Sent, len(triggers))
+		}
+	}
+
+	return report, nil
+}
+
+// reservation are the ledger sends reserved for a short form alert
+type reservation struct {
+	alert alertmodel.ShortAlertMsg
+	chain string
+	users []notify.User
+}
+
+// settle records the delivered sends in the ledger and gives back
+// the ones that failed
+func settle(pending []reservation, failed map[string]bool, now time.Time) error {
+	for _, r := range pending {
+		delivered, undelivered := notify.Undelivered(r.users, failed)
+		if err := sendRun.Commit(ctx, r.alert, r.chain, delivered, now); err != nil {
+			return err
+		}
+		if err := sendRun.Release(ctx, r.alert, r.chain, undelivered); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requestAlerts parses the cap message of the request, or builds the
+// synthetic alert
+func requestAlerts(req SimulateRequest, now time.Time) ([]alertmodel.AlertMsg, error) {
+	if req.CAP != "" {
+		alerts, err := parse.CAP([]byte(req.CAP))
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing cap: %s", err)
+		}
+		return alerts.Alert, nil
+	}
+
+	if req.Polygon == "" || req.EventCode == "" {
+		return nil, fmt.Errorf("either a cap message or a polygon and event code are required")
+	}
+
+	return []alertmodel.AlertMsg{syntheticAlert(req, now)}, nil
+}
+
+// syntheticAlert is a new alert of the event code over the polygon,
+// effective now for an hour
+func syntheticAlert(req SimulateRequest, now time.Time) alertmodel.AlertMsg {
+	severity := req.Severity
+	if severity == "" {
+		severity = "Severe"
+	}
+	event := req.EventCode
+	if cat, ok := alertmodel.AlertCodeToCategorization[req.EventCode]; ok {
+		event = cat.Text
+	}
+
+	info := alertmodel.InfoMsg{
+		Language:  "en-US",
+		Category:  "Met",
+		Event:     event,
+		Urgency:   "Immediate",
+		Severity:  severity,
+		Certainty: "Observed",
+		EventCode: alertmodel.EventCodeMsg{ValueName: "SAME", Value: req.EventCode},
+		Effective: now.UTC().Format(time.RFC3339),
+		Onset:     now.UTC().Format(time.RFC3339),
+		Expires:   now.Add(time.Hour).UTC().Format(time.RFC3339),
+		Headline:  fmt.Sprintf("Simulated %s", event),
+		Areas: []alertmodel.AreaMsg{{
+			AreaDesc: "Simulated area",
+			Polygons: []string{req.Polygon},
+		}},
+	}
+
+	return alertmodel.AlertMsg{
+		Identifier: fmt.Sprintf("simulation-%s-%d", req.EventCode, now.UnixNano()),
+		Sent:       now.UTC().Format(time.RFC3339),
+		Status:     "Exercise",
+		MsgType:    "Alert",
+		Scope:      "Public",
+		Info:       info,
+		Infos:      []alertmodel.InfoMsg{info},
+	}
+}
+
+// simulateShortForm runs the users in range of the alert through the
+// preferences and the ledger. Allowlisted users go through the
+// recording pipeline when sending, their sends are reserved and
+// returned. Everyone else is only previewed.
+func simulateShortForm(sfa alertmodel.ShortAlertMsg, users []notify.User, send bool, now time.Time) (*AlertReport, []notify.User, error) {
+	ar := &AlertReport{
+		Identifier:     sfa.Identifier,
+		AreaDesc:       sfa.AreaDesc,
+		Categorization: sfa.Categorization,
+		Decisions:      map[string]int{},
+		Users:          []UserReport{},
+	}
+
+	allowed, withheld := notify.FilterByPreferences(sfa, users, now)
+	for _, user := range users {
+		if reason, ok := withheld[user.ID]; ok {
+			ar.add(UserReport{ID: user.ID, Decision: reason})
+		}
+	}
+
+	var previewed, sent []notify.User
+	for _, user := range allowed {
+		if send && allowlist[strings.ToLower(user.Email)] {
+			sent = append(sent, user)
+			continue
+		}
+		previewed = append(previewed, user)
+	}
+
+	var reserved []notify.User
+	for _, run := range []struct {
+		pipeline *notify.Pipeline
+		users    []notify.User
+	}{{dryRun, previewed}, {sendRun, sent}} {
+		if len(run.users) == 0 {
+			continue
+		}
+		res, err := run.pipeline.FilterByLedger(ctx, sfa, run.users, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		ar.Chain = res.Chain
+		for _, user := range run.users {
+			if reason, ok := res.Suppressed[user.ID]; ok {
+				ar.add(UserReport{ID: user.ID, Decision: reason})
+			}
+		}
+
+		triggers, err := run.pipeline.Triggers(ctx, sfa, res.Users)
+		if err != nil {
+			run.pipeline.Release(ctx, sfa, res.Chain, res.Users)
+			return nil, nil, err
+		}
+		for i, user := range res.Users {
+			ar.add(UserReport{
+				ID:         user.ID,
+				Decision:   decisionNotify,
+				DataFields: &triggers[i].DataFields,
+				Send:       !run.pipeline.DryRun,
+				trigger:    &triggers[i],
+			})
+		}
+		if !run.pipeline.DryRun {
+			reserved = res.Users
+		}
+	}
+
+	return ar, reserved, nil
+}
+
+func setCtxFields(awsCtx context.Context) {
+	lambdaCtx, ok := lambdaContext.FromContext(awsCtx)
+	reqID := ""
+
+	if ok {
+		reqID = lambdaCtx.AwsRequestID
+	}
+	stdFields = log.Fields{"reqID": reqID}
+}
+
+func init() {
+	if os.Getenv("TESTING") == "1" {
+		return
+	}
+
+	log.SetLevel(log.InfoLevel)
+	log.SetFormatter(&log.JSONFormatter{
+		DisableTimestamp: true,
+	})
+	// the cli prints the report on stdout
+	log.SetOutput(os.Stderr)
+
+	opt, _ := redis.ParseURL(os.Getenv("REDIS_URL"))
+	redisConn := redis.NewClient(opt)
+
+	d, err := sqlx.Connect("postgres", os.Getenv("DB_CONN"))
+	if err != nil {
+		panic(err)
+	}
+	categoryRules = &rules.Store{DB: d}
+	shortForms = &shortform.Builder{DB: d, Categorize: categorize}
+
+	userLookup := &userlookup.Lookup{
+		DB:       d,
+		BufferKm: env.Float("ALERT_BUFFER_KM", 0),
+		MaxCells: env.Int("USER_LOOKUP_MAX_CELLS", 32),
+	}
+
+	// previews decide against the notifier's ledger, the limits must
+	// match it. sends are recorded in a ledger of their own so they
+	// neither count against the users' real notifications nor get
+	// all clears.
+	notifyLedger := &ledger.Ledger{
+		Conn:   redisConn,
+		Prefix: "notification-ledger",
+		Limit:  env.Int("NOTIFY_RATE_LIMIT", 3),
+		Window: time.Duration(env.Int("NOTIFY_RATE_WINDOW_MINUTES", 60)) * time.Minute,
+	}
+	simulateLedger := *notifyLedger
+	simulateLedger.Prefix = "simulate-notification-ledger"
+	dryRun = &notify.Pipeline{Lookup: userLookup, Ledger: notifyLedger, Cache: redisConn, DryRun: true}
+	sendRun = &notify.Pipeline{Lookup: userLookup, Ledger: &simulateLedger, Cache: redisConn}
+
+	allowlist = map[string]bool{}
+	for _, email := range strings.Split(os.Getenv("SIMULATE_ALLOWLIST"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			allowlist[strings.ToLower(email)] = true
+		}
+	}
+
+	iterableClient = &iterable.Client{
+		BaseURL:     iterable.DefaultBaseURL,
+		APIKey:      os.Getenv("ITERABLE_API_KEY"),
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+		ChunkSize:   env.Int("ITERABLE_CHUNK_SIZE", 1000),
+		Concurrency: env.Int("ITERABLE_CONCURRENCY", 4),
+		MaxRetries:  env.Int("ITERABLE_MAX_RETRIES", 4),
+		Backoff:     time.Second,
+	}
+}
+
+func categorize(info alertmodel.InfoMsg) alertmodel.AlertCategorization {
+	rs, err := categoryRules.Current(ctx)
+	if err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).Warn("using previous categorization rules")
+	}
+
+	return rs.Categorize(info)
+}
+
+// main runs as a lambda when deployed, otherwise as a cli printing
+// the report:
+//
+//	simulate -cap alert.xml
+//	simulate -polygon "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.17,-97.53" -code TOR
+func main() {
+	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		lambda.Start(handler)
+		return
+	}
+
+	capFile := flag.String("cap", "", "cap xml file to replay")
+	req := SimulateRequest{}
+	flag.StringVar(&req.Polygon, "polygon", "", "polygon of a synthetic alert, \"lat,lng lat,lng ...\"")
+	flag.StringVar(&req.EventCode, "code", "", "event code of a synthetic alert")
+	flag.StringVar(&req.Severity, "severity", "", "cap severity of a synthetic alert")
+	flag.BoolVar(&req.Send, "send", false, "send to the SIMULATE_ALLOWLIST accounts")
+	flag.Parse()
+
+	if *capFile != "" {
+		body, err := ioutil.ReadFile(*capFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		req.CAP = string(body)
+	}
+
+	report, err := handler(ctx, req)
+	if report != nil {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
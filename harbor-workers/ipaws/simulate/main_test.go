@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/ledger"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/notify"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/preferences"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/shortform"
+)
+
+var now = time.Date(2021, 10, 13, 20, 0, 0, 0, time.UTC)
+
+func setup(t *testing.T) *miniredis.Miniredis {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed starting redis: %s", err)
+	}
+	t.Cleanup(mr.Close)
+	conn := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	l := &ledger.Ledger{Conn: conn, Prefix: "notification-ledger", Limit: 3, Window: time.Hour}
+	sl := &ledger.Ledger{Conn: conn, Prefix: "simulate-notification-ledger", Limit: 3, Window: time.Hour}
+	dryRun = &notify.Pipeline{Ledger: l, Cache: conn, DryRun: true}
+	sendRun = &notify.Pipeline{Ledger: sl, Cache: conn}
+	shortForms = &shortform.Builder{}
+	allowlist = map[string]bool{"tester@example.com": true}
+
+	return mr
+}
+
+func tornado(t *testing.T) alertmodel.ShortAlertMsg {
+	alerts, err := requestAlerts(SimulateRequest{
+		Polygon:   "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.17,-97.53",
+		EventCode: "TOR",
+	}, now)
+	if err != nil {
+		t.Fatalf("failed building the synthetic alert: %s", err)
+	}
+	sfas, err := shortForms.Build(alerts[0])
+	if err != nil || len(sfas) != 1 {
+		t.Fatalf("expected a short form alert, got %v: %v", sfas, err)
+	}
+	return *sfas[0]
+}
+
+func users() []notify.User {
+	return []notify.User{
+		{ID: 1, Email: "Tester@example.com", Preferences: preferences.Default},
+		{ID: 2, Email: "user@example.com", Preferences: preferences.Default},
+		{ID: 3, Email: "muted@example.com"},
+	}
+}
+
+func TestSyntheticAlert(t *testing.T) {
+	setup(t)
+	sfa := tornado(t)
+
+	if sfa.Categorization.Code != "TOR" || sfa.Categorization.Level != string(alertmodel.WARNING) {
+		t.Errorf("expected the tornado warning categorization, got %+v", sfa.Categorization)
+	}
+	if sfa.OnsetTime != "2021-10-13T20:00:00Z" || sfa.Geometry == nil {
+		t.Errorf("unexpected short form alert %+v", sfa)
+	}
+
+	if _, err := requestAlerts(SimulateRequest{EventCode: "TOR"}, now); err == nil {
+		t.Errorf("expected a polygon to be required")
+	}
+}
+
+func TestSimulateDryRun(t *testing.T) {
+	mr := setup(t)
+
+	ar, _, err := simulateShortForm(tornado(t), users(), false, now)
+	if err != nil {
+		t.Fatalf("failed simulating: %s", err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected a dry run to record nothing, got %v", keys)
+	}
+
+	decisions := map[int]string{}
+	for _, ur := range ar.Users {
+		decisions[ur.ID] = ur.Decision
+		if ur.Send {
+			t.Errorf("expected nothing to be sent, got %+v", ur)
+		}
+	}
+	if decisions[1] != decisionNotify || decisions[2] != decisionNotify || decisions[3] != preferences.ReasonNoChannels {
+		t.Errorf("unexpected decisions %v", decisions)
+	}
+	if ar.Decisions[decisionNotify] != 2 || ar.Decisions[preferences.ReasonNoChannels] != 1 {
+		t.Errorf("unexpected decision counts %v", ar.Decisions)
+	}
+	for _, ur := range ar.Users {
+		if ur.Decision == decisionNotify && (ur.DataFields == nil || ur.DataFields.PushData.CurrentOutlookLevel != "3") {
+			t.Errorf("expected the warning trigger, got %+v", ur.DataFields)
+		}
+	}
+
+	// users are only identified by id
+	body, err := json.Marshal(ar)
+	if err != nil {
+		t.Fatalf("failed marshalling report: %s", err)
+	}
+	if strings.Contains(string(body), "@example.com") {
+		t.Errorf("expected no emails in the report, got %s", body)
+	}
+}
+
+func TestSimulateSendsToAllowlist(t *testing.T) {
+	mr := setup(t)
+	sfa := tornado(t)
+
+	ar, reserved, err := simulateShortForm(sfa, users(), true, now)
+	if err != nil {
+		t.Fatalf("failed simulating: %s", err)
+	}
+	for _, ur := range ar.Users {
+		if ur.Send != (ur.ID == 1) {
+			t.Errorf("expected only the allowlisted user to be sent, got %+v", ur)
+		}
+	}
+	if len(reserved) != 1 || reserved[0].ID != 1 {
+		t.Fatalf("expected the allowlisted user to be reserved, got %+v", reserved)
+	}
+	if err := settle([]reservation{{alert: sfa, chain: ar.Chain, users: reserved}}, nil, now); err != nil {
+		t.Fatalf("failed settling: %s", err)
+	}
+
+	// the send was recorded in the simulation's ledger, the previewed
+	// user was not
+	ar, _, err = simulateShortForm(sfa, users(), true, now)
+	if err != nil {
+		t.Fatalf("failed simulating: %s", err)
+	}
+	for _, ur := range ar.Users {
+		if ur.ID == 1 && ur.Decision != ledger.ReasonDuplicate || ur.ID == 2 && ur.Decision != decisionNotify {
+			t.Errorf("unexpected decision %+v", ur)
+		}
+	}
+
+	// the notifier's ledger never saw it
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, "notification-ledger:") {
+			t.Errorf("expected nothing recorded in the notifier's ledger, found %s", key)
+		}
+	}
+	ar, _, err = simulateShortForm(sfa, users(), false, now)
+	if err != nil {
+		t.Fatalf("failed simulating: %s", err)
+	}
+	for _, ur := range ar.Users {
+		if ur.ID == 1 && ur.Decision != decisionNotify {
+			t.Errorf("expected the preview to still notify the tester, got %+v", ur)
+		}
+	}
+}
+
+func TestSimulateReleasesFailedSends(t *testing.T) {
+	setup(t)
+	sfa := tornado(t)
+
+	ar, reserved, err := simulateShortForm(sfa, users(), true, now)
+	if err != nil {
+		t.Fatalf("failed simulating: %s", err)
+	}
+	failed := map[string]bool{"Tester@example.com": true}
+	if err := settle([]reservation{{alert: sfa, chain: ar.Chain, users: reserved}}, failed, now); err != nil {
+		t.Fatalf("failed settling: %s", err)
+	}
+
+	// the failed send can be retried
+	ar, _, err = simulateShortForm(sfa, users(), true, now)
+	if err != nil {
+		t.Fatalf("failed simulating: %s", err)
+	}
+	for _, ur := range ar.Users {
+		if ur.ID == 1 && (ur.Decision != decisionNotify || !ur.Send) {
+			t.Errorf("expected the allowlisted user to be sent again, got %+v", ur)
+		}
+	}
+}
+
+func TestSimulateRefusesSends(t *testing.T) {
+	setup(t)
+	req := SimulateRequest{Polygon: "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.17,-97.53", EventCode: "TOR", Send: true}
+
+	environment = "production"
+	defer func() { environment = "" }()
+	if _, err := simulate(req, now); err == nil {
+		t.Errorf("expected sends to be refused in production")
+	}
+
+	environment = "staging"
+	allowlist = map[string]bool{}
+	if _, err := simulate(req, now); err == nil {
+		t.Errorf("expected sends to be refused without an allowlist")
+	}
+}
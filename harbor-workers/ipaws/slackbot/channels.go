@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+// parseChannels reads the SLACK_CHANNELS json array
+func parseChannels(config string) ([]models.CityData, error) {
+	var channels []models.CityData
+	if err := json.Unmarshal([]byte(config), &channels); err != nil {
+		return nil, fmt.Errorf("SLACK_CHANNELS malformed: %s", err)
+	}
+
+	for _, c := range channels {
+		if c.URL == "" {
+			return nil, fmt.Errorf("channel %s has no webhook url", c.Name)
+		}
+		if c.Bounds != "" {
+			if _, err := geo.GetBoundingBoxFromString(c.Bounds); err != nil {
+				return nil, fmt.Errorf("channel %s bounds malformed: %s", c.Name, err)
+			}
+		} else if !c.All && c.RadiusKm <= 0 {
+			return nil, fmt.Errorf("channel %s matches nothing, set all, bounds or radiusKm", c.Name)
+		}
+	}
+
+	return channels, nil
+}
+
+// matchChannels returns the channels the alert is routed to
+func matchChannels(alert alertmodel.ShortAlertMsg, channels []models.CityData) []models.CityData {
+	var alertBB *geo.BBRect
+	if alert.Geometry != nil {
+		alertBB = alert.Geometry.BoundingBox(0)
+	} else if bb, err := geo.GetBoundingBoxFromString(alert.BoundingBox); err == nil {
+		alertBB = bb
+	}
+
+	var matched []models.CityData
+	for _, c := range channels {
+		if c.All {
+			matched = append(matched, c)
+			continue
+		}
+		if alertBB == nil {
+			continue
+		}
+
+		if c.Bounds != "" {
+			bounds, err := geo.GetBoundingBoxFromString(c.Bounds)
+			if err == nil && bounds != nil && intersects(alertBB, bounds) {
+				matched = append(matched, c)
+			}
+			continue
+		}
+
+		if alert.Geometry != nil {
+			if alert.Geometry.ContainsPoint(c.Lat, c.Long, c.RadiusKm) {
+				matched = append(matched, c)
+			}
+			continue
+		}
+		bb := *alertBB
+		bb.ScaleBoundingBox(c.RadiusKm)
+		if c.Lat >= bb.LatLo && c.Lat <= bb.LatHi && c.Long >= bb.LngLo && c.Long <= bb.LngHi {
+			matched = append(matched, c)
+		}
+	}
+
+	return matched
+}
+
+func intersects(a *geo.BBRect, b *geo.BBRect) bool {
+	return a.LatLo <= b.LatHi && b.LatLo <= a.LatHi && a.LngLo <= b.LngHi && b.LngLo <= a.LngHi
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+const testChannels = `[
+	{"name": "All", "url": "https://hooks.slack.test/all", "all": true},
+	{"name": "Oklahoma City", "url": "https://hooks.slack.test/okc", "lat": 35.4676, "long": -97.5164, "radiusKm": 40},
+	{"name": "Dallas", "url": "https://hooks.slack.test/dfw", "lat": 32.7767, "long": -96.797, "radiusKm": 40},
+	{"name": "Oklahoma", "url": "https://hooks.slack.test/ok", "bounds": "33.6 37.0 -103.0 -94.4"}
+]`
+
+func tornadoWarning(t *testing.T) alertmodel.ShortAlertMsg {
+	polygon := "35.17,-97.53 35.24,-97.42 35.3,-97.47 35.23,-97.59 35.17,-97.53"
+	geom, err := geo.GetPolygonFromString(polygon)
+	if err != nil {
+		t.Fatalf("failed parsing polygon: %s", err)
+	}
+	return alertmodel.ShortAlertMsg{
+		Identifier: "tor.1",
+		AreaDesc:   "Cleveland, OK",
+		Polygon:    polygon,
+		Geometry:   geom,
+		Categorization: alertmodel.AlertCategorization{
+			Text:     "Tornado Warning",
+			Category: "Tornadoes",
+			Code:     "TOR",
+			Level:    string(alertmodel.WARNING),
+		},
+		OnsetTime:      "2021-05-03T23:42:00.000Z",
+		ExpirationTime: "2021-05-04T00:15:00.000Z",
+	}
+}
+
+func channelNames(channels []models.CityData) string {
+	var names []string
+	for _, c := range channels {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestParseChannels(t *testing.T) {
+	channels, err := parseChannels(testChannels)
+	if err != nil || len(channels) != 4 {
+		t.Fatalf("expected 4 channels, got %d: %v", len(channels), err)
+	}
+
+	for _, config := range []string{
+		`[{"name": "no url", "all": true}]`,
+		`[{"name": "no match", "url": "https://hooks.slack.test/x"}]`,
+		`[{"name": "bad bounds", "url": "https://hooks.slack.test/x", "bounds": "1 2 3"}]`,
+		`not json`,
+	} {
+		if _, err := parseChannels(config); err == nil {
+			t.Errorf("expected %s to be rejected", config)
+		}
+	}
+}
+
+func TestMatchChannels(t *testing.T) {
+	channels, _ := parseChannels(testChannels)
+
+	alert := tornadoWarning(t)
+	if got := channelNames(matchChannels(alert, channels)); got != "All,Oklahoma City,Oklahoma" {
+		t.Errorf("unexpected channels for a norman warning: %s", got)
+	}
+
+	// geocode only alerts are routed on their bounding box
+	zone := alertmodel.ShortAlertMsg{Identifier: "heat.1", BoundingBox: "32.5 33.0 -97.0 -96.5"}
+	if got := channelNames(matchChannels(zone, channels)); got != "All,Dallas" {
+		t.Errorf("unexpected channels for a dallas zone: %s", got)
+	}
+
+	if got := channelNames(matchChannels(alertmodel.ShortAlertMsg{Identifier: "none"}, channels)); got != "All" {
+		t.Errorf("expected alerts without an area to reach only All, got %s", got)
+	}
+}
+
+// marshal the way slack reads it, without escaping <links>
+func marshal(t *testing.T, msg *models.SlackRequestBody) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(msg); err != nil {
+		t.Fatalf("failed marshalling message: %s", err)
+	}
+	return buf.String()
+}
+
+func TestBuildMessage(t *testing.T) {
+	maps, err := newStaticMap("map-key", "dGVzdC1zZWNyZXQ=")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body := marshal(t, buildMessage(tornadoWarning(t), "Tornado Warning issued for Cleveland County", 12, maps))
+
+	for _, want := range []string{
+		`"type":"header"`,
+		`*Affected users*\n12`,
+		`*Area*\nCleveland, OK`,
+		`<!date^1620085320^{date_short_pretty} {time}|`,
+		`https://maps.googleapis.com/maps/api/staticmap?`,
+		`&signature=`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected message to contain %s\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "35.17,-97.53 35.24") {
+		t.Errorf("expected the raw polygon to be left out")
+	}
+
+	// no map without a signing key
+	body = marshal(t, buildMessage(tornadoWarning(t), "", -1, nil))
+	if strings.Contains(body, "staticmap") || !strings.Contains(body, `*Affected users*\nunknown`) {
+		t.Errorf("unexpected message without a key %s", body)
+	}
+}
+
+func TestStaticMapSignature(t *testing.T) {
+	// the example from the google maps url signing docs
+	maps, err := newStaticMap("clientID", "vNIXE0xscrmjlyV-12Nj_BvUPaw=")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := maps.sign("/maps/api/geocode/json?address=New+York&client=clientID"); got != "chaRF2hTJKOScPr-RQCEhZbSzIE=" {
+		t.Errorf("unexpected signature %s", got)
+	}
+
+	if _, err := newStaticMap("map-key", "not base64!"); err == nil {
+		t.Errorf("expected a malformed secret to be rejected")
+	}
+	if maps, _ := newStaticMap("map-key", ""); maps != nil {
+		t.Errorf("expected no maps without a secret")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+const (
+	staticMapHost = "https://maps.googleapis.com"
+	staticMapPath = "/maps/api/staticmap"
+)
+
+// static map urls are capped at 8192 characters
+const maxMapVertices = 100
+
+var levelEmoji = map[string]string{
+	string(alertmodel.CLEAR):     ":white_check_mark:",
+	string(alertmodel.AWARE):     ":information_source:",
+	string(alertmodel.WATCH):     ":eyes:",
+	string(alertmodel.WARNING):   ":warning:",
+	string(alertmodel.DANGEROUS): ":rotating_light:",
+}
+
+// buildMessage lays the alert out with block kit. numUsers is the
+// number of users inside the alert, negative when unknown.
+func buildMessage(alert alertmodel.ShortAlertMsg, headline string, numUsers int, maps *staticMap) *models.SlackRequestBody {
+	title := fmt.Sprintf("%s %s - %s", levelEmoji[alert.Categorization.Level],
+		alert.Categorization.Level, alert.Categorization.Text)
+	if alert.IsUpdate {
+		title = title + " (update)"
+	}
+
+	affected := "unknown"
+	if numUsers >= 0 {
+		affected = fmt.Sprintf("%d", numUsers)
+	}
+
+	blocks := []models.Block{
+		{
+			Type: "header",
+			Text: &models.TextObject{Type: "plain_text", Text: strings.TrimSpace(title), Emoji: true},
+		},
+	}
+	if headline != "" {
+		blocks = append(blocks, models.Block{
+			Type: "section",
+			Text: &models.TextObject{Type: "plain_text", Text: headline},
+		})
+	}
+	blocks = append(blocks, models.Block{
+		Type: "section",
+		Fields: []models.TextObject{
+			{Type: "mrkdwn", Text: "*Level*\n" + alert.Categorization.Level},
+			{Type: "mrkdwn", Text: "*Category*\n" + alert.Categorization.Category},
+			{Type: "mrkdwn", Text: "*Onset*\n" + slackDate(alert.OnsetTime)},
+			{Type: "mrkdwn", Text: "*Expires*\n" + slackDate(alert.ExpirationTime)},
+			{Type: "mrkdwn", Text: "*Area*\n" + orNone(alert.AreaDesc)},
+			{Type: "mrkdwn", Text: "*Affected users*\n" + affected},
+		},
+	})
+	if link := maps.link(alert.Geometry); link != "" {
+		blocks = append(blocks, models.Block{
+			Type: "section",
+			Text: &models.TextObject{Type: "mrkdwn", Text: fmt.Sprintf("<%s|View map>", link)},
+		})
+	}
+
+	ctxText := alert.Identifier
+	if len(alert.RefIds) > 0 {
+		ctxText = ctxText + " updates " + strings.Join(alert.RefIds, ", ")
+	}
+	blocks = append(blocks, models.Block{
+		Type:     "context",
+		Elements: []models.TextObject{{Type: "mrkdwn", Text: ctxText}},
+	})
+
+	return &models.SlackRequestBody{
+		Text:   strings.TrimSpace(title),
+		Blocks: blocks,
+	}
+}
+
+// slackDate renders in the reader's timezone, falling back to the
+// raw time for clients that can not
+func slackDate(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return orNone(ts)
+	}
+
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", t.Unix(), t.UTC().Format(time.RFC1123))
+}
+
+// staticMap builds signed static map urls. Links posted to slack are
+// public, the key is restricted to signed requests so it can't be
+// reused for anything but the maps we sign.
+type staticMap struct {
+	Key    string
+	secret []byte
+}
+
+// newStaticMap takes the url signing secret as the console hands it
+// out, url safe base64
+func newStaticMap(key, secret string) (*staticMap, error) {
+	if key == "" || secret == "" {
+		return nil, nil
+	}
+	decoded, err := base64.URLEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("malformed static map secret: %s", err)
+	}
+
+	return &staticMap{Key: key, secret: decoded}, nil
+}
+
+// link outlines the alert on a static map, empty without a signing
+// key or a geometry
+func (m *staticMap) link(geometry *geo.Polygon) string {
+	if m == nil || geometry == nil || len(geometry.Vertices) < 3 {
+		return ""
+	}
+
+	step := 1
+	if len(geometry.Vertices) > maxMapVertices {
+		step = len(geometry.Vertices)/maxMapVertices + 1
+	}
+	path := []string{"color:0xff0000ff", "weight:2", "fillcolor:0xff000033"}
+	for i := 0; i < len(geometry.Vertices); i += step {
+		v := geometry.Vertices[i]
+		path = append(path, fmt.Sprintf("%.4f,%.4f", v.Lat, v.Lng))
+	}
+	// close the loop
+	first := geometry.Vertices[0]
+	path = append(path, fmt.Sprintf("%.4f,%.4f", first.Lat, first.Lng))
+
+	q := url.Values{}
+	q.Set("size", "600x400")
+	q.Set("path", strings.Join(path, "|"))
+	q.Set("key", m.Key)
+	resource := staticMapPath + "?" + q.Encode()
+
+	return staticMapHost + resource + "&signature=" + m.sign(resource)
+}
+
+// sign covers the path and query, see
+// https://developers.google.com/maps/documentation/maps-static/digital-signature
+func (m *staticMap) sign(resource string) string {
+	mac := hmac.New(sha1.New, m.secret)
+	mac.Write([]byte(resource))
+
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
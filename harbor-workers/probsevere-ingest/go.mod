@@ -4,9 +4,18 @@ go 1.15
 
 require (
 	github.com/PuerkitoBio/goquery v1.6.1
-	github.com/aws/aws-lambda-go v1.24.0
-	github.com/aws/aws-sdk-go v1.38.47
-	github.com/go-redis/redis/v8 v8.8.3
+	github.com/aws/aws-lambda-go v1.26.0
+	github.com/aws/aws-sdk-go v1.40.44
+	github.com/go-redis/redis/v8 v8.11.3
 	github.com/hashicorp/go-retryablehttp v0.7.0
-	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb
+	github.com/helloharbor/harbor-workers/alertmodel v1.2.0
+	github.com/helloharbor/harbor-workers/ipaws v0.0.0
+	github.com/stretchr/testify v1.7.0 // indirect
+)
+
+// the ipaws shared packages and the alert model, built from this
+// checkout rather than the tagged versions
+replace (
+	github.com/helloharbor/harbor-workers/alertmodel => ../alertmodel
+	github.com/helloharbor/harbor-workers/ipaws => ../ipaws
 )
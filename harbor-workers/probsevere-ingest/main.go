@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,8 +19,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/alertstate"
 )
 
 const probSevereURL = "https://mrms.ncep.noaa.gov/data/ProbSevere/PROBSEVERE"
@@ -23,17 +31,29 @@ const probSevereURL = "https://mrms.ncep.noaa.gov/data/ProbSevere/PROBSEVERE"
 // sort the page by "Last Modified" desc for convenience
 const indexURL = probSevereURL + "/?C=M;O=D"
 
+// default nowcast thresholds in percent, per hazard
+var defaultThresholds = map[string]int{
+	tornadoHazard.Name: 50,
+	hailHazard.Name:    70,
+	windHazard.Name:    70,
+}
+
 var (
 	ctx         = context.Background()
+	alertStore  *alertstate.Store
 	redisConn   *redis.Client
 	retryClient *http.Client
+	snsClient   *sns.SNS
 	uploader    *s3manager.Uploader
 	s3bucket    = os.Getenv("BUCKET_NAME")
+	snsArn      = os.Getenv("IPAWS_SNS_ARN")
+	thresholds  = map[string]int{}
 	// decrease redis traffic with ephemeral local cache
 	localCache = map[string]bool{}
 )
 
-func shouldBreakForHref(href string) bool {
+// isSeen reports whether the scan was already processed
+func isSeen(href string) bool {
 	if localCache[href] {
 		return true
 	}
@@ -45,6 +65,30 @@ func shouldBreakForHref(href string) bool {
 		return true
 	}
 
+	return false
+}
+
+// pendingHrefs returns the scans newer than the last processed one,
+// oldest first. the index is sorted newest first but nowcasts only
+// escalate when the scans are sent in the order they were made.
+func pendingHrefs(hrefs []string, seen func(href string) bool) []string {
+	var pending []string
+	for _, href := range hrefs {
+		if filepath.Ext(href) != ".json" {
+			continue
+		}
+		if seen(href) {
+			break
+		}
+		pending = append(pending, href)
+	}
+
+	// scans are named MRMS_PROBSEVERE_YYYYMMDD_HHMMSS.json
+	sort.Strings(pending)
+	return pending
+}
+
+func processHref(href string) {
 	url := probSevereURL + "/" + href
 	resp, err := retryClient.Get(url)
 	if err != nil {
@@ -62,10 +106,15 @@ func shouldBreakForHref(href string) bool {
 		panic(fmt.Errorf("malformed href key parts: %s", href))
 	}
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(fmt.Errorf("unable to read(%s): %s", url, err))
+	}
+
 	upParams := &s3manager.UploadInput{
 		ContentType: aws.String("application/json"),
 		Key:         aws.String(keyParts[0]),
-		Body:        resp.Body,
+		Body:        bytes.NewReader(body),
 		Bucket:      aws.String(s3bucket),
 	}
 
@@ -74,14 +123,101 @@ func shouldBreakForHref(href string) bool {
 		panic(fmt.Errorf("unable to upload %s: %s", keyParts[0], err))
 	}
 
+	if err := sendNowcasts(body); err != nil {
+		panic(fmt.Errorf("unable to send nowcasts for %s: %s", keyParts[0], err))
+	}
+
 	localCache[href] = true
 	// don't expire key for two days should we need to recover
 	twoDays := time.Hour * 24 * 2
 	if err := redisConn.Set(ctx, href, 1, twoDays).Err(); err != nil {
 		panic(fmt.Errorf("unable to cache %s: %s", keyParts[0], err))
 	}
+}
 
-	return false
+// sendNowcasts publishes a nowcast the first time a storm crosses
+// a hazard threshold and again if it strengthens to a watch.
+func sendNowcasts(body []byte) error {
+	ps, validTime, err := parseProbSevere(body)
+	if err != nil {
+		return err
+	}
+
+	// a backlog of scans after an outage adds nothing
+	if validTime.Add(nowcastTTL).Before(time.Now()) {
+		return nil
+	}
+
+	nowcasts, err := getNowcasts(ps, validTime, thresholds)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nowcasts {
+		key := alertStore.Prefix + ":probsevere:" + n.StormID + ":" + n.Hazard.Name
+		prev, err := redisConn.HGetAll(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("unable to get redis(%s): %s", key, err)
+		}
+		if !isNewer(prev, n) {
+			continue
+		}
+		var refs []string
+		if prev["identifier"] != "" {
+			if prev["level"] == string(n.AlertLevel()) || n.AlertLevel() == alertmodel.AWARE {
+				continue
+			}
+			refs = []string{prev["identifier"]}
+		}
+
+		alert := n.toAlertMsg(refs)
+		sfAlert := n.toShortAlertMsg(alert)
+
+		fullMsg, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+		if err := redisConn.Set(ctx, alert.Identifier, fullMsg, time.Hour*24).Err(); err != nil {
+			return fmt.Errorf("unable to cache %s: %s", alert.Identifier, err)
+		}
+		if _, err := alertStore.Apply(ctx, alert, []*alertmodel.ShortAlertMsg{sfAlert}, time.Now()); err != nil {
+			return err
+		}
+
+		sfMsg, err := json.Marshal(sfAlert)
+		if err != nil {
+			return err
+		}
+		sfMsgStr := string(sfMsg)
+		_, err = snsClient.Publish(&sns.PublishInput{
+			Message:  &sfMsgStr,
+			TopicArn: &snsArn,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to publish %s: %s", alert.Identifier, err)
+		}
+		fmt.Printf("published %s nowcast %s at %d%%\n", n.Hazard.Name, alert.Identifier, n.Prob)
+
+		// storms keep their id for their lifetime, a few hours at most
+		if err := redisConn.HSet(ctx, key, "identifier", alert.Identifier, "level", string(n.AlertLevel()),
+			"validTime", n.ValidTime.Unix()).Err(); err != nil {
+			return fmt.Errorf("unable to cache %s: %s", key, err)
+		}
+		redisConn.Expire(ctx, key, time.Hour*6)
+	}
+
+	return nil
+}
+
+// isNewer reports whether the nowcast is from a later scan than the
+// one last published for the storm, a scan that is retried after a
+// newer one must not downgrade or re-send it
+func isNewer(prev map[string]string, n Nowcast) bool {
+	validTime, err := strconv.ParseInt(prev["validTime"], 10, 64)
+	if err != nil {
+		return true
+	}
+	return n.ValidTime.Unix() > validTime
 }
 
 func handler() error {
@@ -96,34 +232,59 @@ func handler() error {
 		panic(fmt.Errorf("unable to parse response: %s", err))
 	}
 
-	doc.Find("a[href]").EachWithBreak(func(i int, item *goquery.Selection) bool {
+	var hrefs []string
+	doc.Find("a[href]").Each(func(i int, item *goquery.Selection) {
 		href, _ := item.Attr("href")
-		if filepath.Ext(href) == ".json" && shouldBreakForHref(href) {
-			return false
-		}
-		return true
+		hrefs = append(hrefs, href)
 	})
 
+	for _, href := range pendingHrefs(hrefs, isSeen) {
+		processHref(href)
+	}
+
 	return nil
 }
 
 func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
 	opt, err := redis.ParseURL(os.Getenv("REDIS_URL"))
 	if err != nil {
 		panic(fmt.Errorf("unable to connect to redis: %s", err))
 	}
 	redisConn = redis.NewClient(opt)
+	alertStore = &alertstate.Store{Conn: redisConn, Prefix: os.Getenv("REDIS_ALERT_QUEUE_KEY")}
 
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(os.Getenv("AWS_REGION")),
 	}))
 	uploader = s3manager.NewUploader(sess)
+	snsClient = sns.New(sess)
 
 	rC := retryablehttp.NewClient()
 	rC.Logger = nil
 	rC.RetryMax = 3
 	retryClient = rC.StandardClient()
 	retryClient.Timeout = 5 * time.Second
+
+	// PROBTOR_THRESHOLD, PROBHAIL_THRESHOLD and PROBWIND_THRESHOLD
+	// override the defaults, a negative value disables the hazard
+	for name, def := range defaultThresholds {
+		envKey := "PROB" + strings.ToUpper(name) + "_THRESHOLD"
+		threshold := def
+		if val := os.Getenv(envKey); val != "" {
+			t, err := strconv.Atoi(val)
+			if err != nil {
+				panic(fmt.Errorf("malformed %s: %s", envKey, err))
+			}
+			threshold = t
+		}
+		if threshold >= 0 {
+			thresholds[name] = threshold
+		}
+	}
 }
 
 func main() {
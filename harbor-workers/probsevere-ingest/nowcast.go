@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+)
+
+// nowcasts are superseded by the next scan or an official warning
+const nowcastTTL = time.Minute * 30
+
+// storms are swept along their motion for this long so users in
+// their path are included, not only those under them now
+const leadTime = time.Minute * 20
+
+// probabilities at or above this are sent as a watch
+const watchProb = 80
+
+const kmPerDegree = 111.32
+
+type hazard struct {
+	Name     string
+	Code     string
+	Text     string
+	Category alertmodel.AlertCategory
+}
+
+var (
+	tornadoHazard = hazard{Name: "tor", Code: "PSTOR", Text: "Tornado Nowcast", Category: alertmodel.TORNADOES}
+	hailHazard    = hazard{Name: "hail", Code: "PSHAIL", Text: "Hail Nowcast", Category: alertmodel.NONE}
+	windHazard    = hazard{Name: "wind", Code: "PSWIND", Text: "Damaging Wind Nowcast", Category: alertmodel.NONE}
+)
+
+// ProbSevere is a single MRMS ProbSevere scan
+type ProbSevere struct {
+	ValidTime string  `json:"validTime"`
+	Features  []Storm `json:"features"`
+}
+
+type Storm struct {
+	Geometry struct {
+		// rings of longitude, latitude pairs
+		Coordinates [][][]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties StormProperties `json:"properties"`
+	// v3 files carry probabilities per model, v2 files
+	// carry them as properties
+	Models map[string]struct {
+		Prob string `json:"PROB"`
+	} `json:"models"`
+}
+
+type StormProperties struct {
+	ID          string `json:"ID"`
+	ProbHail    string `json:"ProbHail"`
+	ProbWind    string `json:"ProbWind"`
+	ProbTor     string `json:"ProbTor"`
+	MotionEast  string `json:"MOTION_EAST"`
+	MotionSouth string `json:"MOTION_SOUTH"`
+}
+
+// Nowcast is a storm that crossed the threshold for a hazard
+type Nowcast struct {
+	StormID   string
+	Hazard    hazard
+	Prob      int
+	ValidTime time.Time
+	Geometry  *geo.Polygon
+}
+
+func parseProbSevere(body []byte) (*ProbSevere, time.Time, error) {
+	ps := ProbSevere{}
+	if err := json.Unmarshal(body, &ps); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to parse probsevere: %s", err)
+	}
+
+	validTime, err := time.Parse("20060102_150405 MST", ps.ValidTime)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("malformed validTime %s: %s", ps.ValidTime, err)
+	}
+
+	return &ps, validTime.UTC(), nil
+}
+
+// getNowcasts returns a nowcast for every storm and hazard whose
+// probability is at or above the hazard threshold.
+func getNowcasts(ps *ProbSevere, validTime time.Time, thresholds map[string]int) ([]Nowcast, error) {
+	var nowcasts []Nowcast
+	for _, storm := range ps.Features {
+		if len(storm.Geometry.Coordinates) == 0 {
+			continue
+		}
+
+		var geom *geo.Polygon
+		for _, hz := range []hazard{tornadoHazard, hailHazard, windHazard} {
+			threshold, ok := thresholds[hz.Name]
+			if !ok {
+				continue
+			}
+			prob := storm.prob(hz)
+			if prob < threshold {
+				continue
+			}
+
+			if geom == nil {
+				var err error
+				geom, err = storm.sweptGeometry(leadTime)
+				if err != nil {
+					return nil, fmt.Errorf("storm %s: %s", storm.Properties.ID, err)
+				}
+			}
+			nowcasts = append(nowcasts, Nowcast{
+				StormID:   storm.Properties.ID,
+				Hazard:    hz,
+				Prob:      prob,
+				ValidTime: validTime,
+				Geometry:  geom,
+			})
+		}
+	}
+
+	return nowcasts, nil
+}
+
+func (s Storm) prob(hz hazard) int {
+	var val string
+	switch hz {
+	case tornadoHazard:
+		val = s.Properties.ProbTor
+	case hailHazard:
+		val = s.Properties.ProbHail
+	case windHazard:
+		val = s.Properties.ProbWind
+	}
+	if m, ok := s.Models["prob"+hz.Name]; ok {
+		val = m.Prob
+	}
+
+	prob, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		return 0
+	}
+	return prob
+}
+
+// sweptGeometry is the convex hull of the storm now and where
+// it will be after lead, assuming it keeps its current motion.
+func (s Storm) sweptGeometry(lead time.Duration) (*geo.Polygon, error) {
+	east, _ := strconv.ParseFloat(s.Properties.MotionEast, 64)
+	south, _ := strconv.ParseFloat(s.Properties.MotionSouth, 64)
+
+	var points [][2]float64
+	for _, coord := range s.Geometry.Coordinates[0] {
+		if len(coord) < 2 {
+			continue
+		}
+		lng, lat := coord[0], coord[1]
+		points = append(points, [2]float64{lat, lng})
+
+		// motion is in m/s
+		dLat := -south * lead.Seconds() / 1000 / kmPerDegree
+		dLng := east * lead.Seconds() / 1000 / (kmPerDegree * math.Cos(lat*math.Pi/180))
+		points = append(points, [2]float64{lat + dLat, lng + dLng})
+	}
+
+	hull := convexHull(points)
+	var strVertexArr []string
+	for _, pt := range hull {
+		strVertexArr = append(strVertexArr, fmt.Sprintf("%.4f,%.4f", pt[0], pt[1]))
+	}
+
+	return geo.GetPolygonFromString(strings.Join(strVertexArr, " "))
+}
+
+// Identifier changes with every scan so a stronger nowcast
+// can reference the one it replaces.
+func (n Nowcast) Identifier() string {
+	return fmt.Sprintf("probsevere.%s.%s.%d", n.StormID, n.Hazard.Name, n.ValidTime.Unix())
+}
+
+func (n Nowcast) AlertLevel() alertmodel.AlertLevel {
+	if n.Prob >= watchProb {
+		return alertmodel.WATCH
+	}
+	return alertmodel.AWARE
+}
+
+func (n Nowcast) toAlertMsg(references []string) alertmodel.AlertMsg {
+	msgType := "Alert"
+	if len(references) > 0 {
+		msgType = "Update"
+	}
+
+	info := alertmodel.InfoMsg{
+		Language:     "en-US",
+		Category:     "Met",
+		Event:        n.Hazard.Text,
+		ResponseType: "Monitor",
+		Urgency:      "Immediate",
+		Severity:     "Moderate",
+		Certainty:    "Possible",
+		EventCode:    alertmodel.EventCodeMsg{ValueName: "HARBOR", Value: n.Hazard.Code},
+		Effective:    n.ValidTime.Format(time.RFC3339),
+		Onset:        n.ValidTime.Format(time.RFC3339),
+		Expires:      n.ValidTime.Add(nowcastTTL).Format(time.RFC3339),
+		Headline:     fmt.Sprintf("%s, %d%% chance", n.Hazard.Text, n.Prob),
+		Description: fmt.Sprintf("NOAA ProbSevere gives a developing storm a %d%% chance of producing "+
+			"severe %s in the next hour. No official warning has been issued yet.", n.Prob, n.Hazard.Name),
+		Instruction: "Stay alert and be ready to take shelter if a warning is issued.",
+		Areas: []alertmodel.AreaMsg{
+			{AreaDesc: "Storm " + n.StormID, Polygons: []string{n.Geometry.String()}},
+		},
+	}
+
+	return alertmodel.AlertMsg{
+		Identifier: n.Identifier(),
+		Status:     "Actual",
+		MsgType:    msgType,
+		Scope:      "Public",
+		References: references,
+		Info:       info,
+		Infos:      []alertmodel.InfoMsg{info},
+	}
+}
+
+func (n Nowcast) toShortAlertMsg(alert alertmodel.AlertMsg) *alertmodel.ShortAlertMsg {
+	rRect := n.Geometry.BoundingBox(16.0)
+
+	return &alertmodel.ShortAlertMsg{
+		Identifier: alert.Identifier,
+		IsUpdate:   len(alert.References) > 0,
+		RefIds:     alert.References,
+		Language:   alert.Info.Language,
+		AreaDesc:   alert.Info.Areas[0].AreaDesc,
+		Polygon:    n.Geometry.String(),
+		BoundingBox: fmt.Sprintf("%f %f %f %f",
+			rRect.LatLo, rRect.LatHi,
+			rRect.LngLo, rRect.LngHi),
+		Geometry: n.Geometry,
+		Categorization: alertmodel.AlertCategorization{
+			Text:     n.Hazard.Text,
+			Category: string(n.Hazard.Category),
+			Code:     n.Hazard.Code,
+			Level:    string(n.AlertLevel()),
+		},
+		OnsetTime:      alert.Info.Onset,
+		ExpirationTime: alert.Info.Expires,
+	}
+}
+
+// convexHull of lat,lng points using a monotone chain, storms are
+// small enough to treat coordinates as planar.
+func convexHull(points [][2]float64) [][2]float64 {
+	if len(points) < 3 {
+		return points
+	}
+
+	pts := make([][2]float64, len(points))
+	copy(pts, points)
+	sort.Slice(pts, func(i, j int) bool {
+		if pts[i][0] == pts[j][0] {
+			return pts[i][1] < pts[j][1]
+		}
+		return pts[i][0] < pts[j][0]
+	})
+
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+
+	var hull [][2]float64
+	for _, p := range pts {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(pts) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], pts[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, pts[i])
+	}
+
+	return hull[:len(hull)-1]
+}
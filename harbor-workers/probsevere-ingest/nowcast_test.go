@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+)
+
+// run with TESTING=1 to skip connecting to redis, s3 and sns
+const scanJSON = "testdata/MRMS_PROBSEVERE_20211013_151040.json"
+
+func loadNowcasts(t *testing.T) []Nowcast {
+	body, err := ioutil.ReadFile(scanJSON)
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+	ps, validTime, err := parseProbSevere(body)
+	if err != nil {
+		t.Fatalf("failed parsing fixture: %s", err)
+	}
+	if validTime.Format("2006-01-02T15:04:05Z") != "2021-10-13T15:10:40Z" {
+		t.Fatalf("unexpected valid time %s", validTime)
+	}
+
+	nowcasts, err := getNowcasts(ps, validTime, defaultThresholds)
+	if err != nil {
+		t.Fatalf("failed getting nowcasts: %s", err)
+	}
+	return nowcasts
+}
+
+func TestNowcastThresholds(t *testing.T) {
+	nowcasts := loadNowcasts(t)
+
+	found := map[string]int{}
+	for _, n := range nowcasts {
+		found[n.StormID+":"+n.Hazard.Name] = n.Prob
+	}
+
+	want := map[string]int{"284511:tor": 86, "284530:hail": 74}
+	if len(found) != len(want) {
+		t.Fatalf("expected nowcasts %v, found %v", want, found)
+	}
+	for k, prob := range want {
+		if found[k] != prob {
+			t.Errorf("expected %s at %d%%, found %d%%", k, prob, found[k])
+		}
+	}
+}
+
+func TestNowcastShortAlertMsg(t *testing.T) {
+	var tor Nowcast
+	for _, n := range loadNowcasts(t) {
+		if n.Hazard == tornadoHazard {
+			tor = n
+		}
+	}
+
+	sfa := tor.toShortAlertMsg(tor.toAlertMsg(nil))
+	if sfa.Identifier != "probsevere.284511.tor.1634137840" {
+		t.Errorf("unexpected identifier %s", sfa.Identifier)
+	}
+	if sfa.Categorization.Text != "Tornado Nowcast" ||
+		sfa.Categorization.Category != string(alertmodel.TORNADOES) ||
+		sfa.Categorization.Level != string(alertmodel.WATCH) {
+		t.Errorf("unexpected categorization %+v", sfa.Categorization)
+	}
+	if sfa.ExpirationTime != "2021-10-13T15:40:40Z" {
+		t.Errorf("unexpected expiration %s", sfa.ExpirationTime)
+	}
+
+	// under the storm now and in its path, moving east north east
+	if !sfa.Geometry.ContainsPoint(35.42, -97.48, 0) {
+		t.Error("expected the storm to be in the nowcast area")
+	}
+	if !sfa.Geometry.ContainsPoint(35.46, -97.30, 0) {
+		t.Error("expected the storm path to be in the nowcast area")
+	}
+	if sfa.Geometry.ContainsPoint(35.38, -97.70, 0) {
+		t.Error("expected behind the storm to be outside the nowcast area")
+	}
+}
+
+func TestNowcastEscalation(t *testing.T) {
+	n := Nowcast{StormID: "1", Hazard: hailHazard, Prob: 70}
+	if n.AlertLevel() != alertmodel.AWARE {
+		t.Errorf("expected AWARE, got %s", n.AlertLevel())
+	}
+
+	n.Prob = watchProb
+	if n.AlertLevel() != alertmodel.WATCH {
+		t.Errorf("expected WATCH, got %s", n.AlertLevel())
+	}
+}
+
+func TestPendingHrefs(t *testing.T) {
+	// the index is sorted newest first
+	hrefs := []string{
+		"?C=N;O=A",
+		"MRMS_PROBSEVERE_20211013_151440.json",
+		"MRMS_PROBSEVERE_20211013_151240.json",
+		"MRMS_PROBSEVERE_20211013_151040.json",
+		"MRMS_PROBSEVERE_20211013_150840.json",
+	}
+	seen := func(href string) bool { return href == "MRMS_PROBSEVERE_20211013_151040.json" }
+
+	got := pendingHrefs(hrefs, seen)
+	want := []string{"MRMS_PROBSEVERE_20211013_151240.json", "MRMS_PROBSEVERE_20211013_151440.json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the new scans oldest first %v, got %v", want, got)
+	}
+}
+
+func TestNowcastIsNewer(t *testing.T) {
+	n := Nowcast{StormID: "1", Hazard: hailHazard, Prob: watchProb, ValidTime: time.Unix(1634137840, 0)}
+
+	if !isNewer(map[string]string{}, n) {
+		t.Errorf("expected the first nowcast of a storm to be sent")
+	}
+	if !isNewer(map[string]string{"validTime": "1634137720"}, n) {
+		t.Errorf("expected a later scan to be sent")
+	}
+	if isNewer(map[string]string{"validTime": "1634137960"}, n) {
+		t.Errorf("expected an earlier scan to be ignored")
+	}
+}
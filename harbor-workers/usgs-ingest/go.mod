@@ -0,0 +1,20 @@
+module github.com/helloharbor/harbor-workers/usgs-ingest
+
+go 1.15
+
+require (
+	github.com/aws/aws-lambda-go v1.26.0
+	github.com/aws/aws-sdk-go v1.40.44
+	github.com/go-redis/redis/v8 v8.11.3
+	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/helloharbor/harbor-workers/alertmodel v1.2.0
+	github.com/helloharbor/harbor-workers/ipaws v0.0.0
+	github.com/sirupsen/logrus v1.8.1
+)
+
+// the ipaws shared packages and the alert model, built from this
+// checkout rather than the tagged versions
+replace (
+	github.com/helloharbor/harbor-workers/alertmodel => ../alertmodel
+	github.com/helloharbor/harbor-workers/ipaws => ../ipaws
+)
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/go-redis/redis/v8"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/alertstate"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// all quakes of magnitude 2.5 and up in the past day. quakes are
+// alerted for alertTTL, the hour feed would drop them, and their
+// revisions, long before that.
+const defaultFeedURL = "https://earthquake.usgs.gov/earthquakes/feed/v1.0/summary/2.5_day.geojson"
+
+var (
+	alertStore  *alertstate.Store
+	redisConn   *redis.Client
+	retryClient *http.Client
+	snsClient   *sns.SNS
+	stdFields   map[string]interface{}
+
+	ctx     = context.Background()
+	traceID = ""
+
+	feedURL      = os.Getenv("USGS_FEED_URL")
+	minMagnitude = defaultMinMagnitude
+	snsArn       = os.Getenv("IPAWS_SNS_ARN")
+)
+
+// lastRevision is the most recent revision of a quake that was sent
+type lastRevision struct {
+	Identifier string            `json:"identifier"`
+	Level      alertmodel.AlertLevel `json:"level"`
+}
+
+func handler(awsCtx context.Context) error {
+	setCtxFields(awsCtx)
+
+	body, err := fetchFeed()
+	if err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).Fatal("fetch failed")
+	}
+
+	quakes, err := parseFeed(body)
+	if err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).Fatal("parse failed")
+	}
+	log.WithFields(stdFields).Infof("parsing %v quakes", len(quakes))
+
+	published := 0
+	now := time.Now()
+	for _, q := range quakes {
+		sent, err := processQuake(q, now)
+		if err != nil {
+			log.WithFields(stdFields).WithFields(log.Fields{"quakeId": q.ID, "error": err}).
+				Fatal("failed to process quake")
+		}
+		if sent {
+			published = published + 1
+		}
+	}
+	log.WithFields(stdFields).Infof("published %v quake alerts", published)
+
+	evicted, err := alertStore.Evict(ctx, now)
+	if err != nil {
+		log.WithFields(stdFields).WithFields(log.Fields{"error": err}).
+			Fatal("failed to evict expired alerts")
+	}
+	log.WithFields(stdFields).Infof("evicted %v expired alerts", evicted)
+
+	return nil
+}
+
+// processQuake sends a new alert for a quake the first time it is
+// seen and again whenever a revision changes its level. Returns
+// true when an alert was published.
+func processQuake(q Quake, now time.Time) (bool, error) {
+	prev, err := getLastRevision(q.ID)
+	if err != nil {
+		return false, err
+	}
+	if prev != nil && prev.Identifier == q.Identifier() {
+		return false, nil
+	}
+
+	lvl := q.AlertLevel()
+	if q.Deleted || q.Magnitude < minMagnitude {
+		// a quake can be deleted or downgraded below the threshold
+		// after it was alerted on, withdraw what was sent
+		if prev == nil {
+			return false, nil
+		}
+		cancel := q.toAlertMsg("Cancel", []string{prev.Identifier})
+		if _, err = alertStore.Apply(ctx, cancel, nil, now); err != nil {
+			return false, err
+		}
+		log.WithFields(stdFields).WithFields(log.Fields{"alertId": prev.Identifier}).
+			Info("withdrew quake alert")
+		return false, redisConn.Del(ctx, revisionKey(q.ID)).Err()
+	}
+
+	if q.Time.Add(alertTTL).Before(now) {
+		return false, nil
+	}
+
+	// minor revisions are common in the first hour, only re-alert
+	// when the level changes
+	if prev != nil && prev.Level == lvl {
+		return false, nil
+	}
+
+	msgType := "Alert"
+	var refs []string
+	if prev != nil {
+		msgType = "Update"
+		refs = []string{prev.Identifier}
+	}
+	alert := q.toAlertMsg(msgType, refs)
+
+	sfAlert, err := q.toShortAlertMsg(alert)
+	if err != nil {
+		return false, err
+	}
+
+	if err = cacheAlert(alert); err != nil {
+		return false, fmt.Errorf("failed to cache alert: %s", err)
+	}
+
+	applied, err := alertStore.Apply(ctx, alert, []*alertmodel.ShortAlertMsg{sfAlert}, now)
+	if err != nil {
+		redisConn.Del(ctx, alert.Identifier)
+		return false, err
+	}
+	if !applied {
+		return false, nil
+	}
+
+	if err = sendShortFormAlert(*sfAlert); err != nil {
+		redisConn.Del(ctx, alert.Identifier)
+		return false, fmt.Errorf("failed to publish alert: %s", err)
+	}
+	log.WithFields(stdFields).WithFields(log.Fields{"alertId": alert.Identifier, "level": lvl}).
+		Info("published alert")
+
+	return true, setLastRevision(q.ID, lastRevision{Identifier: alert.Identifier, Level: lvl})
+}
+
+func fetchFeed() ([]byte, error) {
+	resp, err := retryClient.Get(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get(%s): %s", feedURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from %s: %d", feedURL, resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func revisionKey(quakeID string) string {
+	return alertStore.Prefix + ":usgs:" + quakeID
+}
+
+func getLastRevision(quakeID string) (*lastRevision, error) {
+	val, err := redisConn.Get(ctx, revisionKey(quakeID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get last revision(%s): %s", quakeID, err)
+	}
+
+	rev := lastRevision{}
+	if err = json.Unmarshal([]byte(val), &rev); err != nil {
+		return nil, fmt.Errorf("malformed last revision(%s): %s", quakeID, err)
+	}
+
+	return &rev, nil
+}
+
+func setLastRevision(quakeID string, rev lastRevision) error {
+	b, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	// keep the revision around past the alert so late
+	// revisions of an expired quake are not re-alerted
+	return redisConn.Set(ctx, revisionKey(quakeID), b, alertTTL+time.Hour*24).Err()
+}
+
+// the full alert is read back by slackbot and weather-events/get
+func cacheAlert(alert alertmodel.AlertMsg) error {
+	alertMsg, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	oneDay := time.Hour * 24
+	return redisConn.Set(ctx, alert.Identifier, alertMsg, oneDay).Err()
+}
+
+func sendShortFormAlert(alert alertmodel.ShortAlertMsg) error {
+	sfMsg, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	sfMsgStr := string(sfMsg)
+
+	_, err = snsClient.Publish(&sns.PublishInput{
+		Message:  &sfMsgStr,
+		TopicArn: &snsArn,
+	})
+
+	return err
+}
+
+func setCtxFields(awsCtx context.Context) {
+	lCtx, ok := lambdacontext.FromContext(awsCtx)
+
+	if ok {
+		traceID = lCtx.AwsRequestID
+	}
+	stdFields = log.Fields{"traceID": traceID}
+}
+
+func init() {
+	if os.Getenv("TESTING") == ("1") {
+		return
+	}
+
+	log.SetLevel(log.InfoLevel)
+	log.SetFormatter(&log.JSONFormatter{
+		DisableTimestamp: true,
+	})
+	log.SetOutput(os.Stdout)
+
+	opt, err := redis.ParseURL(os.Getenv("REDIS_URL"))
+	if err != nil {
+		panic(fmt.Errorf("unable to connect to redis: %s", err))
+	}
+	redisConn = redis.NewClient(opt)
+	alertStore = &alertstate.Store{Conn: redisConn, Prefix: os.Getenv("REDIS_ALERT_QUEUE_KEY")}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(os.Getenv("AWS_REGION")),
+	}))
+	snsClient = sns.New(sess)
+
+	rC := retryablehttp.NewClient()
+	rC.Logger = nil
+	rC.RetryMax = 3
+	retryClient = rC.StandardClient()
+	retryClient.Timeout = 5 * time.Second
+
+	if feedURL == "" {
+		feedURL = defaultFeedURL
+	}
+	if minMag := os.Getenv("MIN_MAGNITUDE"); minMag != "" {
+		m, err := strconv.ParseFloat(minMag, 64)
+		if err != nil {
+			panic(fmt.Errorf("malformed MIN_MAGNITUDE: %s", err))
+		}
+		minMagnitude = m
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
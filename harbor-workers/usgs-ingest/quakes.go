@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+
+	geo "github.com/helloharbor/harbor-workers/alertmodel/geometries"
+)
+
+// eventCode is the SAME code for earthquakes, levels are computed
+// per quake rather than taken from the categorization table
+const eventCode = "EQW"
+
+// quakes smaller than this are not alerted on
+const defaultMinMagnitude = 4.0
+
+// how long a quake stays active, covers the bulk of early aftershocks
+const alertTTL = time.Hour * 12
+
+// the format cap times are cached in, see alertmodel/parse
+const timeFormat = "2006-01-02T15:04:05.000Z0700"
+
+// felt radius bounds in km
+const (
+	minRadiusKm = 10.0
+	maxRadiusKm = 1000.0
+)
+
+// FeatureCollection is the USGS GeoJSON summary feed, see
+// https://earthquake.usgs.gov/earthquakes/feed/v1.0/geojson.php
+type FeatureCollection struct {
+	Features []Feature `json:"features"`
+}
+
+type Feature struct {
+	ID         string          `json:"id"`
+	Properties QuakeProperties `json:"properties"`
+	Geometry   struct {
+		// longitude, latitude, depth in km
+		Coordinates []float64 `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+type QuakeProperties struct {
+	Mag     *float64 `json:"mag"`
+	Place   string   `json:"place"`
+	Time    int64    `json:"time"`
+	Updated int64    `json:"updated"`
+	URL     string   `json:"url"`
+	// maximum shakemap intensity, null without a shakemap
+	MMI *float64 `json:"mmi"`
+	// pager alert level, green, yellow, orange or red
+	Alert   *string `json:"alert"`
+	Status  string  `json:"status"`
+	Tsunami int     `json:"tsunami"`
+	Type    string  `json:"type"`
+	Title   string  `json:"title"`
+}
+
+// Quake is a feature reduced to what is needed to alert on it.
+type Quake struct {
+	ID        string
+	Lat       float64
+	Lng       float64
+	DepthKm   float64
+	Magnitude float64
+	MMI       float64
+	Pager     string
+	Tsunami   bool
+	Deleted   bool
+	Place     string
+	Title     string
+	URL       string
+	Time      time.Time
+	Updated   time.Time
+}
+
+func parseFeed(body []byte) ([]Quake, error) {
+	fc := FeatureCollection{}
+	if err := json.Unmarshal(body, &fc); err != nil {
+		return nil, fmt.Errorf("failed parsing feed: %s", err)
+	}
+
+	var quakes []Quake
+	for _, f := range fc.Features {
+		p := f.Properties
+		// the feed also carries quarry blasts and explosions
+		if p.Type != "earthquake" || p.Mag == nil || len(f.Geometry.Coordinates) < 3 {
+			continue
+		}
+
+		q := Quake{
+			ID:        f.ID,
+			Lng:       f.Geometry.Coordinates[0],
+			Lat:       f.Geometry.Coordinates[1],
+			DepthKm:   f.Geometry.Coordinates[2],
+			Magnitude: *p.Mag,
+			Tsunami:   p.Tsunami == 1,
+			Deleted:   p.Status == "deleted",
+			Place:     p.Place,
+			Title:     p.Title,
+			URL:       p.URL,
+			Time:      time.Unix(0, p.Time*int64(time.Millisecond)).UTC(),
+			Updated:   time.Unix(0, p.Updated*int64(time.Millisecond)).UTC(),
+		}
+		if p.MMI != nil {
+			q.MMI = *p.MMI
+		}
+		if p.Alert != nil {
+			q.Pager = *p.Alert
+		}
+		quakes = append(quakes, q)
+	}
+
+	return quakes, nil
+}
+
+// Identifier changes with every revision of the quake so that a
+// revision can reference the one it replaces.
+func (q Quake) Identifier() string {
+	return fmt.Sprintf("usgs.%s.%d", q.ID, q.Updated.Unix())
+}
+
+// EffectiveMagnitude discounts deep quakes, which are felt far less
+// at the surface than shallow ones of the same size.
+func (q Quake) EffectiveMagnitude() float64 {
+	switch {
+	case q.DepthKm > 300:
+		return q.Magnitude - 1.0
+	case q.DepthKm > 70:
+		return q.Magnitude - 0.5
+	}
+	return q.Magnitude
+}
+
+// AlertLevel is the highest level implied by the magnitude, the
+// shakemap intensity, the pager alert and the tsunami flag. Small
+// quakes are filtered out by magnitude before they get here.
+func (q Quake) AlertLevel() alertmodel.AlertLevel {
+	lvl := alertmodel.AWARE
+	mag := q.EffectiveMagnitude()
+	switch {
+	case mag >= 7.0:
+		lvl = alertmodel.DANGEROUS
+	case mag >= 6.0:
+		lvl = alertmodel.WARNING
+	case mag >= 5.0:
+		lvl = alertmodel.WATCH
+	}
+
+	// shakemap intensity, VI is strong shaking and VIII severe
+	switch {
+	case q.MMI >= 8:
+		lvl = maxLevel(lvl, alertmodel.DANGEROUS)
+	case q.MMI >= 6:
+		lvl = maxLevel(lvl, alertmodel.WARNING)
+	case q.MMI >= 5:
+		lvl = maxLevel(lvl, alertmodel.WATCH)
+	}
+
+	switch q.Pager {
+	case "red":
+		lvl = maxLevel(lvl, alertmodel.DANGEROUS)
+	case "orange":
+		lvl = maxLevel(lvl, alertmodel.WARNING)
+	case "yellow":
+		lvl = maxLevel(lvl, alertmodel.WATCH)
+	}
+
+	if q.Tsunami {
+		lvl = maxLevel(lvl, alertmodel.WARNING)
+	}
+
+	return lvl
+}
+
+// RadiusKm approximates the shakemap area where shaking is felt,
+// intensity IV and up, from the magnitude and depth.
+func (q Quake) RadiusKm() float64 {
+	hypocentral := math.Pow(10, 0.45*q.Magnitude-0.55)
+	radius := hypocentral
+	if q.DepthKm > 0 && q.DepthKm < hypocentral {
+		radius = math.Sqrt(hypocentral*hypocentral - q.DepthKm*q.DepthKm)
+	} else if q.DepthKm >= hypocentral {
+		radius = minRadiusKm
+	}
+
+	return math.Min(math.Max(radius, minRadiusKm), maxRadiusKm)
+}
+
+// Circle formats the affected area the way cap does.
+func (q Quake) Circle() string {
+	return fmt.Sprintf("%.4f,%.4f %.1f", q.Lat, q.Lng, q.RadiusKm())
+}
+
+func (q Quake) toAlertMsg(msgType string, references []string) alertmodel.AlertMsg {
+	title := q.Title
+	if title == "" {
+		title = fmt.Sprintf("M %.1f - %s", q.Magnitude, q.Place)
+	}
+
+	info := alertmodel.InfoMsg{
+		Language:     "en-US",
+		Category:     "Geo",
+		Event:        "Earthquake",
+		ResponseType: "Monitor",
+		Urgency:      "Past",
+		Severity:     getSeverity(q.AlertLevel()),
+		Certainty:    "Observed",
+		EventCode:    alertmodel.EventCodeMsg{ValueName: "SAME", Value: eventCode},
+		Effective:    q.Updated.Format(timeFormat),
+		Onset:        q.Time.Format(timeFormat),
+		Expires:      q.Time.Add(alertTTL).Format(timeFormat),
+		Headline:     title,
+		Description: fmt.Sprintf("A magnitude %.1f earthquake occurred %s at a depth of %.1f km. %s",
+			q.Magnitude, q.Place, q.DepthKm, q.URL),
+		Instruction: "Expect aftershocks. If you feel shaking, drop, cover and hold on.",
+		Areas: []alertmodel.AreaMsg{
+			{AreaDesc: q.Place, Circles: []string{q.Circle()}},
+		},
+	}
+
+	return alertmodel.AlertMsg{
+		Identifier: q.Identifier(),
+		Status:     "Actual",
+		MsgType:    msgType,
+		Scope:      "Public",
+		References: references,
+		Info:       info,
+		Infos:      []alertmodel.InfoMsg{info},
+	}
+}
+
+func (q Quake) toShortAlertMsg(alert alertmodel.AlertMsg) (*alertmodel.ShortAlertMsg, error) {
+	geom, err := geo.GetPolygonFromCircleString(q.Circle())
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing circle geometry: %s", err)
+	}
+	rRect := geom.BoundingBox(16.0)
+
+	return &alertmodel.ShortAlertMsg{
+		Identifier: alert.Identifier,
+		IsUpdate:   strings.ToLower(alert.MsgType) == "update",
+		RefIds:     alert.References,
+		Language:   alert.Info.Language,
+		AreaDesc:   q.Place,
+		Polygon:    geom.String(),
+		BoundingBox: fmt.Sprintf("%f %f %f %f",
+			rRect.LatLo, rRect.LatHi,
+			rRect.LngLo, rRect.LngHi),
+		Geometry: geom,
+		Categorization: alertmodel.AlertCategorization{
+			Text:     fmt.Sprintf("M%.1f Earthquake", q.Magnitude),
+			Category: string(alertmodel.EARTHQUAKE),
+			Code:     eventCode,
+			Level:    string(q.AlertLevel()),
+		},
+		OnsetTime:      alert.Info.Onset,
+		ExpirationTime: alert.Info.Expires,
+	}, nil
+}
+
+var levelRank = map[alertmodel.AlertLevel]int{
+	alertmodel.CLEAR:     0,
+	alertmodel.AWARE:     1,
+	alertmodel.WATCH:     2,
+	alertmodel.WARNING:   3,
+	alertmodel.DANGEROUS: 4,
+}
+
+func maxLevel(a alertmodel.AlertLevel, b alertmodel.AlertLevel) alertmodel.AlertLevel {
+	if levelRank[b] > levelRank[a] {
+		return b
+	}
+	return a
+}
+
+func getSeverity(lvl alertmodel.AlertLevel) string {
+	switch lvl {
+	case alertmodel.DANGEROUS:
+		return "Extreme"
+	case alertmodel.WARNING:
+		return "Severe"
+	case alertmodel.WATCH:
+		return "Moderate"
+	}
+	return "Minor"
+}
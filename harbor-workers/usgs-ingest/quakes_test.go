@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/helloharbor/harbor-workers/alertmodel"
+	"github.com/helloharbor/harbor-workers/ipaws/shared/models"
+)
+
+// run with TESTING=1 to skip connecting to redis and sns
+const summaryJSON = "testdata/summary.geojson"
+
+func loadQuakes(t *testing.T) map[string]Quake {
+	body, err := ioutil.ReadFile(summaryJSON)
+	if err != nil {
+		t.Fatalf("failed reading fixture: %s", err)
+	}
+	quakes, err := parseFeed(body)
+	if err != nil {
+		t.Fatalf("failed parsing fixture: %s", err)
+	}
+
+	byID := map[string]Quake{}
+	for _, q := range quakes {
+		byID[q.ID] = q
+	}
+	return byID
+}
+
+func TestParseFeedSkipsNonQuakes(t *testing.T) {
+	quakes := loadQuakes(t)
+
+	if len(quakes) != 3 {
+		t.Fatalf("expected 3 quakes, found %d", len(quakes))
+	}
+	if _, ok := quakes["uu60462222"]; ok {
+		t.Error("quarry blast should be skipped")
+	}
+	if _, ok := quakes["ak021d2mzqlk"]; ok {
+		t.Error("quake without a magnitude should be skipped")
+	}
+	if !quakes["ci39838928"].Deleted {
+		t.Error("deleted status should be kept")
+	}
+
+	q := quakes["nc73584926"]
+	if q.Lat != 40.3246 || q.Lng != -124.4263 || q.DepthKm != 17.9 {
+		t.Errorf("unexpected coordinates %f,%f %f", q.Lat, q.Lng, q.DepthKm)
+	}
+	if q.Identifier() != "usgs.nc73584926.1634139900" {
+		t.Errorf("unexpected identifier %s", q.Identifier())
+	}
+}
+
+func TestAlertLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		quake Quake
+		want  alertmodel.AlertLevel
+	}{
+		{"small shallow", Quake{Magnitude: 4.2, DepthKm: 10}, alertmodel.AWARE},
+		{"moderate", Quake{Magnitude: 5.4, DepthKm: 10}, alertmodel.WATCH},
+		{"strong", Quake{Magnitude: 6.1, DepthKm: 10}, alertmodel.WARNING},
+		{"major", Quake{Magnitude: 7.2, DepthKm: 10}, alertmodel.DANGEROUS},
+		{"deep strong", Quake{Magnitude: 6.1, DepthKm: 120}, alertmodel.WATCH},
+		{"very deep major", Quake{Magnitude: 7.2, DepthKm: 560}, alertmodel.WARNING},
+		{"severe shaking", Quake{Magnitude: 5.2, DepthKm: 5, MMI: 8.1}, alertmodel.DANGEROUS},
+		{"pager orange", Quake{Magnitude: 5.2, DepthKm: 5, Pager: "orange"}, alertmodel.WARNING},
+		{"pager green", Quake{Magnitude: 5.2, DepthKm: 5, Pager: "green"}, alertmodel.WATCH},
+		{"tsunami", Quake{Magnitude: 5.2, DepthKm: 5, Tsunami: true}, alertmodel.WARNING},
+	}
+
+	for _, tt := range tests {
+		if got := tt.quake.AlertLevel(); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRadiusKm(t *testing.T) {
+	small := Quake{Magnitude: 4.0, DepthKm: 5}
+	large := Quake{Magnitude: 7.0, DepthKm: 5}
+	deep := Quake{Magnitude: 7.0, DepthKm: 350}
+	huge := Quake{Magnitude: 9.1, DepthKm: 20}
+
+	if small.RadiusKm() >= large.RadiusKm() {
+		t.Errorf("larger quakes should be felt further, %f >= %f", small.RadiusKm(), large.RadiusKm())
+	}
+	if deep.RadiusKm() >= large.RadiusKm() {
+		t.Errorf("deep quakes should be felt less, %f >= %f", deep.RadiusKm(), large.RadiusKm())
+	}
+	if small.RadiusKm() < minRadiusKm || huge.RadiusKm() > maxRadiusKm {
+		t.Errorf("radius should be bounded, got %f and %f", small.RadiusKm(), huge.RadiusKm())
+	}
+}
+
+func TestShortAlertMsg(t *testing.T) {
+	q := loadQuakes(t)["nc73584926"]
+
+	alert := q.toAlertMsg("Update", []string{"usgs.nc73584926.1634138200"})
+	sfa, err := q.toShortAlertMsg(alert)
+	if err != nil {
+		t.Fatalf("failed creating short alert: %s", err)
+	}
+
+	if !sfa.IsUpdate || len(sfa.RefIds) != 1 {
+		t.Errorf("expected an update with one reference, got %v %v", sfa.IsUpdate, sfa.RefIds)
+	}
+	if sfa.Categorization.Category != string(alertmodel.EARTHQUAKE) ||
+		sfa.Categorization.Level != string(alertmodel.WARNING) {
+		t.Errorf("unexpected categorization %+v", sfa.Categorization)
+	}
+	if _, ok := models.OutlookLevelDict[sfa.Categorization.Level]; !ok {
+		t.Errorf("level %s is not known to the notifier", sfa.Categorization.Level)
+	}
+	if sfa.OnsetTime != "2021-10-13T15:15:00.000Z" || sfa.ExpirationTime != "2021-10-14T03:15:00.000Z" {
+		t.Errorf("unexpected times %s %s", sfa.OnsetTime, sfa.ExpirationTime)
+	}
+
+	// felt at the coast near the epicenter, not in san francisco
+	if !sfa.Geometry.ContainsPoint(40.3265, -124.2870, 0) {
+		t.Error("expected petrolia to be in the affected area")
+	}
+	if sfa.Geometry.ContainsPoint(37.7749, -122.4194, 0) {
+		t.Error("expected san francisco to be outside the affected area")
+	}
+}